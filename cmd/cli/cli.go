@@ -7,14 +7,20 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Quikmove/blockchain-uzd2/internal/api"
 	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
 	"github.com/Quikmove/blockchain-uzd2/internal/config"
 	"github.com/Quikmove/blockchain-uzd2/internal/crypto"
 	"github.com/Quikmove/blockchain-uzd2/internal/domain"
 	"github.com/Quikmove/blockchain-uzd2/internal/filetolist"
+	"github.com/Quikmove/blockchain-uzd2/internal/storage"
 	"github.com/joho/godotenv"
 	"github.com/urfave/cli/v3"
 )
@@ -96,15 +102,28 @@ func readCommand() (string, error) {
 	return command, nil
 }
 
-func getBlockByIndex(bch *blockchain.Blockchain, prompt string) (domain.Block, int, error) {
-	index, err := readInt(prompt, func(idx int) error {
-		maxHeight := bch.Len()
-		return validateBlockIndex(idx, maxHeight)
-	})
+// getBlockByIndexOrHash reads a block index or a main chain block hash and returns
+// the block together with its index.
+func getBlockByIndexOrHash(bch *blockchain.Blockchain, prompt string) (domain.Block, int, error) {
+	input, err := readString(prompt)
 	if err != nil {
 		return domain.Block{}, 0, err
 	}
-	block, err := bch.GetBlockByIndex(index)
+	index, err := strconv.Atoi(input)
+	if err == nil {
+		if err := validateBlockIndex(index, bch.Len()); err != nil {
+			return domain.Block{}, 0, err
+		}
+	} else {
+		hash, err := domain.ParseHash32(input)
+		if err != nil {
+			return domain.Block{}, 0, errors.New("input is neither a block index nor a block hash (64 hex chars)")
+		}
+		if index, err = bch.GetHeightByHash(hash); err != nil {
+			return domain.Block{}, 0, err
+		}
+	}
+	block, err := bch.GetBlock(index)
 	if err != nil {
 		return domain.Block{}, 0, fmt.Errorf("error retrieving block: %w", err)
 	}
@@ -163,27 +182,34 @@ func printMenu() {
 	fmt.Println("╠═══════════════════════════════════════════════════════════════════════╣")
 	fmt.Println("║ MINING:                                                               ║")
 	fmt.Println("║   mineblocks          - Mine new blocks with random transactions      ║")
-	fmt.Println("║   simulatedecentralizedmining - Simulate decentralized mining         ║")
 	fmt.Println("║                                                                       ║")
 	fmt.Println("║ BLOCKCHAIN INFO:                                                      ║")
 	fmt.Println("║   height              - Show current blockchain height                ║")
-	fmt.Println("║   stats               - Show blockchain statistics                    ║")
+	fmt.Println("║   stats               - Show blockchain and mining statistics         ║")
 	fmt.Println("║   validatechain       - Validate entire blockchain integrity          ║")
 	fmt.Println("║                                                                       ║")
 	fmt.Println("║ BLOCK QUERIES:                                                        ║")
-	fmt.Println("║   getblock            - Get full block details by index               ║")
-	fmt.Println("║   getblockheader      - Get block header by index                     ║")
+	fmt.Println("║   getblock            - Get full block details by index or hash       ║")
+	fmt.Println("║   getblockheader      - Get block header by index or hash             ║")
 	fmt.Println("║   getblockhash        - Get block hash by index                       ║")
-	fmt.Println("║   getblocktransactions- Get block transactions by index               ║")
+	fmt.Println("║   getblocktransactions- Get block transactions by index or hash       ║")
 	fmt.Println("║   getallheaders       - Get all block headers                         ║")
+	fmt.Println("║   gettransaction      - Find a transaction and its confirmations      ║")
 	fmt.Println("║                                                                       ║")
 	fmt.Println("║ USER & BALANCE:                                                       ║")
 	fmt.Println("║   balance             - Show all user balances (table)                ║")
 	fmt.Println("║   getuserbalance      - Get balance by name, public key, or address   ║")
 	fmt.Println("║   richlist            - Show top users by balance                     ║")
 	fmt.Println("║   getutxos            - Get UTXOs by name, public key, or address     ║")
+	fmt.Println("║   getaddresshistory   - Page through an address's transactions        ║")
+	fmt.Println("║   dumpprivkey         - Show a user's private key for offline signing ║")
+	fmt.Println("║                                                                       ║")
+	fmt.Println("║ MEMPOOL:                                                              ║")
+	fmt.Println("║   getmempool          - List transactions waiting in the mempool      ║")
 	fmt.Println("║                                                                       ║")
 	fmt.Println("║ OTHER:                                                                ║")
+	fmt.Println("║   export              - Write the chain to a JSON or binary file      ║")
+	fmt.Println("║   dumputxoset         - Write the UTXO set at a height to a file      ║")
 	fmt.Println("║   help                - Show detailed help                            ║")
 	fmt.Println("║   exit                - Exit the program                              ║")
 	fmt.Println("╚═══════════════════════════════════════════════════════════════════════╝")
@@ -207,6 +233,250 @@ func calculateStats(bch *blockchain.Blockchain, users []domain.User, cfg *config
 	return
 }
 
+// printMiningStats prints the mining telemetry rows of the stats table.
+func printMiningStats(stats blockchain.MiningStats) {
+	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
+	fmt.Printf("║ Blocks Mined:              %34d ║\n", stats.Blocks)
+	fmt.Printf("║ Stale / Cancelled Rounds:  %34s ║\n", fmt.Sprintf("%d / %d", stats.StaleRounds, stats.CancelledRounds))
+	fmt.Printf("║ Hashes Tried:              %34d ║\n", stats.Hashes)
+	fmt.Printf("║ Wasted Hashes:             %34d ║\n", stats.WastedHashes)
+	fmt.Printf("║ Hashrate (H/s):            %34.0f ║\n", stats.Hashrate())
+	fmt.Printf("║ Avg Time/Block:            %34s ║\n", stats.AverageBlockTime().Round(time.Millisecond))
+	for i, w := range stats.Workers {
+		fmt.Printf("║   Worker %-3d (H/s):        %34.0f ║\n", i, w.Hashrate())
+	}
+}
+
+// txIndexFlag enables the transaction index behind gettransaction.
+func txIndexFlag() cli.Flag {
+	return &cli.BoolFlag{Name: "txindex", Usage: "index transactions by id so that gettransaction finds confirmed ones"}
+}
+
+// printTransactionStatus prints whether the transaction with the given hex id is
+// confirmed, and the transaction itself.
+func printTransactionStatus(bch *blockchain.Blockchain, txIDHex string) {
+	txID, err := domain.ParseHash32(txIDHex)
+	if err != nil {
+		fmt.Println("Invalid transaction id:", err)
+		return
+	}
+	st, err := bch.GetTransaction(txID)
+	if errors.Is(err, blockchain.ErrTxIndexDisabled) {
+		fmt.Println("Transaction not in the mempool; restart with --txindex to look up confirmed transactions")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if loc := st.Block; loc != nil {
+		fmt.Printf("Confirmed in block %d (%s) at position %d, %d confirmation(s)\n",
+			loc.Height, loc.BlockHash.String(), loc.Position, st.Confirmations)
+	} else {
+		fmt.Println("Waiting in the mempool, 0 confirmations")
+	}
+	txBytes, err := marshalJSON(st.Tx, func() {
+		fmt.Printf("Transaction: %+v\n", st.Tx)
+	})
+	if err != nil {
+		return
+	}
+	fmt.Printf("Transaction:\n%s\n", string(txBytes))
+}
+
+// addressIndexFlag enables the address index behind getaddresshistory.
+func addressIndexFlag() cli.Flag {
+	return &cli.BoolFlag{Name: "addressindex", Usage: "index the credits and debits of every address for getaddresshistory"}
+}
+
+// historyPageSize is the number of entries getaddresshistory prints per page.
+const historyPageSize = 20
+
+// printAddressHistory prints the given page, counted from 1, of the address's history.
+func printAddressHistory(bch *blockchain.Blockchain, address domain.PublicAddress, displayName string, page int) {
+	entries, total, err := bch.AddressHistory(address, (page-1)*historyPageSize, historyPageSize)
+	if errors.Is(err, blockchain.ErrAddressIndexDisabled) {
+		fmt.Println("Address history is not kept; restart with --addressindex")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	pages := max((total+historyPageSize-1)/historyPageSize, 1)
+
+	fmt.Printf("\n╔══════════════════════════════════════════════════════════════════════════════════════════╗\n")
+	fmt.Printf("║                       History of %-42s %12s ║\n", displayName, fmt.Sprintf("page %d/%d", page, pages))
+	fmt.Println("╠════════╦══════════════════════════════════════════╦════════════╦════════════╦════════════╣")
+	fmt.Println("║ HEIGHT ║              TRANSACTION ID              ║   CREDIT   ║   DEBIT    ║  BALANCE   ║")
+	fmt.Println("╠════════╬══════════════════════════════════════════╬════════════╬════════════╬════════════╣")
+	if len(entries) == 0 {
+		fmt.Println("║        ║ No transactions on this page             ║            ║            ║            ║")
+	}
+	for _, e := range entries {
+		txIDHex := fmt.Sprintf("%x", e.TxID)
+		fmt.Printf("║ %6d ║ %-40s ║ %10d ║ %10d ║ %10d ║\n", e.Height, txIDHex[:40], e.Credit, e.Debit, e.Balance)
+	}
+	fmt.Println("╠════════╩══════════════════════════════════════════╩════════════╩════════════╩════════════╣")
+	fmt.Printf("║ Total transactions: %-68d ║\n", total)
+	fmt.Println("╚══════════════════════════════════════════════════════════════════════════════════════════╝")
+}
+
+// pruneFlag bounds the blocks whose bodies and undo data a session keeps.
+func pruneFlag() cli.Flag {
+	return &cli.IntFlag{Name: "prune", Usage: "keep the bodies and undo data of only the last N blocks, which also bounds reorganizations to N blocks; 0 keeps all"}
+}
+
+// pruneOption returns the option for --prune, which like in bitcoind cannot be combined
+// with --txindex: the index would point into pruned blocks.
+func pruneOption(c *cli.Command) (blockchain.Option, error) {
+	n := c.Int("prune")
+	if n < 0 {
+		return nil, errors.New("--prune must not be negative")
+	}
+	if n > 0 && c.Bool("txindex") {
+		return nil, errors.New("--prune is incompatible with --txindex")
+	}
+	return blockchain.WithPrune(n), nil
+}
+
+// dataDirFlag keeps the chain in a database so that a session resumes where the last one stopped.
+func dataDirFlag() cli.Flag {
+	return &cli.StringFlag{Name: "datadir", Usage: "directory of the chain database; a session resumes the chain stored there and writes every block it connects"}
+}
+
+// openDataDir opens the chain database in dir, creating both if needed, and returns the
+// chain it holds, which is empty for a new database, together with opts extended to
+// write to it. A new chain must be created with those options to be stored.
+func openDataDir(dir string, hasher crypto.Hasher, txSigner crypto.TransactionSigner, opts ...blockchain.Option) (*blockchain.Blockchain, *storage.BoltStore, []blockchain.Option, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, nil, err
+	}
+	path := filepath.Join(dir, "chain.db")
+	store, err := storage.OpenBolt(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	opts = append(opts, blockchain.WithStore(store))
+	bch, err := blockchain.OpenBlockchain(hasher, txSigner, opts...)
+	if err != nil {
+		store.Close()
+		return nil, nil, nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	slog.Info("Opened chain database", "path", path, "blocks", bch.Len(), "prune_height", bch.PruneHeight())
+	return bch, store, opts, nil
+}
+
+// importFlag starts a session from a chain file written by the export command.
+func importFlag() cli.Flag {
+	return &cli.StringFlag{Name: "import", Usage: "start from a chain file written by export (json or binary) instead of a new genesis block"}
+}
+
+// importChain reads and fully validates the chain file at path.
+func importChain(path string, hasher crypto.Hasher, txSigner crypto.TransactionSigner, opts ...blockchain.Option) (*blockchain.Blockchain, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	start := time.Now()
+	bch, err := blockchain.ImportChain(f, hasher, txSigner, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("import %s: %w", path, err)
+	}
+	tipHash, _ := bch.GetLatestBlockHash()
+	slog.Info("Imported chain", "path", path, "blocks", bch.Len(), "tip", tipHash.String(), "elapsed", time.Since(start))
+	return bch, nil
+}
+
+// loadUTXOSetFlag starts a session from a UTXO snapshot written by dumputxoset.
+func loadUTXOSetFlag() cli.Flag {
+	return &cli.StringFlag{Name: "loadutxoset", Usage: "start from a UTXO snapshot written by dumputxoset; needs --import for the headers, and validates the history below the snapshot in the background"}
+}
+
+// loadSnapshotChain starts a chain from the UTXO snapshot at snapshotPath. The chain file
+// at chainPath supplies the headers up to the snapshot and the blocks above it, which
+// are validated right away; the blocks below it are validated in the background.
+func loadSnapshotChain(ctx context.Context, snapshotPath, chainPath string, hasher crypto.Hasher, txSigner crypto.TransactionSigner, opts ...blockchain.Option) (*blockchain.Blockchain, error) {
+	chainFile, err := os.Open(chainPath)
+	if err != nil {
+		return nil, err
+	}
+	users, blocks, err := blockchain.ReadChain(chainFile, hasher)
+	chainFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", chainPath, err)
+	}
+	headers := make([]domain.Header, len(blocks))
+	for i, b := range blocks {
+		headers[i] = b.Header
+	}
+
+	snapshotFile, err := os.Open(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshotFile.Close()
+	bch, err := blockchain.LoadUTXOSet(snapshotFile, headers, hasher, txSigner, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", snapshotPath, err)
+	}
+	bch.RegisterUsers(users)
+	snap, _ := bch.Snapshot()
+	for height := snap.Height + 1; height < len(blocks); height++ {
+		status, err := bch.ProcessBlock(blocks[height])
+		if err == nil && status != blockchain.BlockConnected {
+			err = fmt.Errorf("block does not extend the chain")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", height, err)
+		}
+	}
+	go func() {
+		err := bch.ValidateSnapshot(ctx, blocks)
+		if err != nil && !errors.Is(err, blockchain.ErrSnapshotMismatch) && ctx.Err() == nil {
+			slog.Error("Could not validate UTXO snapshot", "err", err)
+		}
+	}()
+	return bch, nil
+}
+
+// dumpUTXOSet writes the UTXO set after the block at height to path.
+func dumpUTXOSet(bch *blockchain.Blockchain, path string, height int) (blockchain.UTXOSnapshot, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return blockchain.UTXOSnapshot{}, err
+	}
+	snap, err := bch.DumpUTXOSet(f, height)
+	if err != nil {
+		f.Close()
+		return blockchain.UTXOSnapshot{}, err
+	}
+	return snap, f.Close()
+}
+
+// exportFormatFor picks the export format from the file extension: JSON for .json
+// files and the binary bootstrap format for everything else.
+func exportFormatFor(path string) blockchain.ExportFormat {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return blockchain.FormatJSON
+	}
+	return blockchain.FormatBinary
+}
+
+// exportChain writes the main chain to path.
+func exportChain(bch *blockchain.Blockchain, path string, format blockchain.ExportFormat) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := bch.Export(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func validateChain(bch *blockchain.Blockchain) bool {
 	fmt.Println("Validating blockchain...")
 	valid := true
@@ -223,8 +493,7 @@ func validateChain(bch *blockchain.Blockchain) bool {
 			valid = false
 		}
 
-		currentHash := bch.CalculateHash(currentBlock)
-		if !blockchain.IsHashValid(currentHash, currentHeader.Difficulty) {
+		if !bch.CheckProofOfWork(currentHeader) {
 			fmt.Printf("❌ Block %d: Hash doesn't meet difficulty requirements!\n", i)
 			valid = false
 		}
@@ -245,43 +514,115 @@ func main() {
 		log.Fatal(err)
 	}
 	app := &cli.Command{
-		Name:  "blockchain-cli",
-		Usage: "Interact with blockchain (local or via HTTP API)",
+		Name:   "blockchain-cli",
+		Usage:  "Interact with blockchain (local or via HTTP API)",
+		Flags:  loggingFlags(),
+		Before: setupLogging,
 		Commands: []*cli.Command{
+			txCommand(),
+			nodeCommand(),
+			simulateCommand(),
+			minerCommand(),
 			{
 				Name:  "local",
 				Usage: "Start an interactive blockchain session",
+				Flags: []cli.Flag{
+					&cli.Int64Flag{Name: "seed", Usage: "seed for users, funds, transactions and timestamps; the same seed and difficulty repeat the same chain"},
+					txIndexFlag(),
+					addressIndexFlag(),
+					importFlag(),
+					loadUTXOSetFlag(),
+					pruneFlag(),
+					dataDirFlag(),
+				},
 				Action: func(ctx context.Context, c *cli.Command) error {
 					cfg := config.LoadConfig()
 					hasher := crypto.NewArchasHasher()
-					log.Println("Version:", cfg.Version)
-					log.Println("Difficulty:", cfg.Difficulty)
+					slog.Info("Starting local session", "version", cfg.Version, "difficulty", cfg.Difficulty)
 					names := filetolist.FileToList(cfg.NameListPath)
+					var opts []blockchain.Option
+					if c.IsSet("seed") {
+						slog.Info("Using seed", "seed", c.Int64("seed"))
+						opts = append(opts, blockchain.WithSeed(c.Int64("seed")))
+					}
 					keyGen := crypto.NewKeyGenerator()
-					userGen := blockchain.NewUserGeneratorService(keyGen)
+					userGen := blockchain.NewUserGeneratorService(keyGen, opts...)
 					users := userGen.GenerateUsers(names, cfg.UserCount)
-					log.Println("User count:", len(users))
-					log.Println("Generating genesis block...")
 					txSigner := crypto.NewTransactionSigner()
-					bch := blockchain.InitBlockchainWithFunds(100, 1000000, users, cfg, hasher, txSigner)
-					bch.RegisterUsers(users)
-					genesis, _ := bch.GetLatestBlock()
-					genesisHeader := genesis.Header
-					log.Println("Found a POW hash successfully with nonce:", genesisHeader.Nonce)
-					log.Println("Added genesis block successfully")
-
-					txsSize := 100
-					config := blockchain.DefaultDecentralizedMiningConfig()
-					config.BlockCount = 5
-					config.TxCount = txsSize
-					config.Low = 10
-					config.High = 50
-					config.Version = cfg.Version
-					config.Difficulty = cfg.Difficulty
-					err := bch.MineBlocksDecentralized(ctx, users, config)
+					opts = append(opts, blockchain.WithMinDifficulty(cfg.Difficulty), blockchain.WithBlockReward(cfg.BlockReward))
+					if c.Bool("txindex") {
+						opts = append(opts, blockchain.WithTxIndex())
+					}
+					if c.Bool("addressindex") {
+						opts = append(opts, blockchain.WithAddressIndex())
+					}
+					prune, err := pruneOption(c)
 					if err != nil {
-						log.Println("Error mining initial blocks:", err)
+						return err
+					}
+					opts = append(opts, prune)
+					var bch *blockchain.Blockchain
+					if c.String("loadutxoset") != "" && c.String("import") == "" {
+						return errors.New("--loadutxoset needs --import for the headers and the history below the snapshot")
 					}
+					if dir := c.String("datadir"); dir != "" {
+						if c.String("loadutxoset") != "" {
+							return errors.New("--loadutxoset cannot be combined with --datadir")
+						}
+						stored, store, storeOpts, err := openDataDir(dir, hasher, txSigner, opts...)
+						if err != nil {
+							return err
+						}
+						defer store.Close()
+						opts = storeOpts
+						if stored.Len() > 0 {
+							if c.String("import") != "" {
+								return fmt.Errorf("--import needs an empty --datadir, %s holds %d blocks", dir, stored.Len())
+							}
+							bch = stored
+							bch.RegisterUsers(users)
+						}
+					}
+					if bch != nil {
+						tipHash, _ := bch.GetLatestBlockHash()
+						slog.Info("Resuming stored chain", "height", bch.Len()-1, "tip", tipHash.String())
+					} else if path := c.String("loadutxoset"); path != "" {
+						loaded, err := loadSnapshotChain(ctx, path, c.String("import"), hasher, txSigner, opts...)
+						if err != nil {
+							return err
+						}
+						bch = loaded
+						bch.RegisterUsers(users)
+					} else if path := c.String("import"); path != "" {
+						imported, err := importChain(path, hasher, txSigner, opts...)
+						if err != nil {
+							return err
+						}
+						bch = imported
+						bch.RegisterUsers(users)
+					} else {
+						slog.Info("Generating genesis block", "users", len(users))
+						bch = blockchain.InitBlockchainWithFunds(100, 1000000, users, cfg, hasher, txSigner, opts...)
+						bch.RegisterUsers(users)
+						genesis, _ := bch.GetLatestBlock()
+						genesisHeader := genesis.Header
+						genesisHash, _ := bch.GetLatestBlockHash()
+						slog.Info("Added genesis block", "hash", genesisHash.String(), "nonce", genesisHeader.Nonce)
+
+						txsSize := 100
+						err := bch.MineBlocks(ctx, 5, txsSize, 10, 50, users, cfg.Version, cfg.Difficulty)
+						if err != nil {
+							slog.Error("Could not mine initial blocks", "err", err)
+						}
+					}
+
+					server := api.NewServer(bch)
+					go func() {
+						slog.Info("HTTP API listening", "port", cfg.Port)
+						if err := server.ListenAndServe(ctx, ":"+cfg.Port); err != nil {
+							slog.Error("HTTP API stopped", "err", err)
+						}
+					}()
 					for {
 						printMenu()
 						command, err := readCommand()
@@ -291,7 +632,7 @@ func main() {
 						}
 						switch command {
 						case "getblockheader":
-							block, index, err := getBlockByIndex(bch, "Please enter block index:")
+							block, index, err := getBlockByIndexOrHash(bch, "Please enter block index or hash:")
 							if err != nil {
 								fmt.Printf("Invalid block index: %v\n", err)
 								continue
@@ -317,11 +658,15 @@ func main() {
 							fmt.Printf("║ Total Users:               %34d ║\n", totalUsers)
 							fmt.Printf("║ Current Version:           %34d ║\n", version)
 							fmt.Printf("║ Current Difficulty:        %34d ║\n", difficulty)
+							if pruneHeight := bch.PruneHeight(); pruneHeight > 0 {
+								fmt.Printf("║ Block Bodies Kept From:    %34d ║\n", pruneHeight)
+							}
+							printMiningStats(bch.MiningStats())
 							fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
 						case "validatechain":
 							validateChain(bch)
 						case "getblock":
-							block, index, err := getBlockByIndex(bch, "Please enter block index:")
+							block, index, err := getBlockByIndexOrHash(bch, "Please enter block index or hash:")
 							if err != nil {
 								fmt.Printf("Invalid block index: %v\n", err)
 								continue
@@ -334,12 +679,16 @@ func main() {
 							}
 							fmt.Printf("Block at index %d:\n%s\n", index, string(blockBytes))
 						case "getblockhash":
-							block, index, err := getBlockByIndex(bch, "Please enter block index:")
+							_, index, err := getBlockByIndexOrHash(bch, "Please enter block index or hash:")
+							if err != nil {
+								fmt.Printf("Invalid block index: %v\n", err)
+								continue
+							}
+							hash, err := bch.GetBlockHash(index)
 							if err != nil {
 								fmt.Printf("Invalid block index: %v\n", err)
 								continue
 							}
-							hash := bch.CalculateHash(block)
 							fmt.Printf("Block Hash at index %d: %x\n", index, hash)
 						case "mineblocks":
 							numBlocks, err := readInt("Please enter number of blocks to mine concurrently:", func(v int) error {
@@ -376,54 +725,8 @@ func main() {
 							if err != nil {
 								fmt.Println("Error mining blocks:", err)
 							}
-						case "simulatedecentralizedmining":
-							config := blockchain.DefaultDecentralizedMiningConfig()
-							config.Version = cfg.Version
-							config.Difficulty = cfg.Difficulty
-
-							numBlocks, _ := readIntWithDefault("Please enter number of blocks to mine:", 1, func(v int) error {
-								return validatePositiveInt(v, "number of blocks")
-							})
-							config.BlockCount = numBlocks
-
-							numTxs, _ := readIntWithDefault("Please enter number of transactions per candidate block (default: 100):", 100, func(v int) error {
-								return validatePositiveInt(v, "number of transactions")
-							})
-							config.TxCount = numTxs
-
-							candidateCount, _ := readIntWithDefault("Please enter number of candidate blocks to generate (default: 5):", 5, func(v int) error {
-								return validatePositiveInt(v, "number of candidates")
-							})
-							config.CandidateCount = candidateCount
-
-							timeLimitSeconds, _ := readIntWithDefault("Please enter initial time limit in seconds (default: 5):", 5, func(v int) error {
-								return validatePositiveInt(v, "time limit")
-							})
-							config.InitialTimeLimit = time.Duration(timeLimitSeconds) * time.Second
-
-							minTxValue, _ := readIntWithDefault("Please enter minimum transaction value (default: 1):", 1, nil)
-							maxTxValue, _ := readIntWithDefault("Please enter maximum transaction value (default: 1000):", 1000, nil)
-							if err := validateTransactionValueRange(minTxValue, maxTxValue); err != nil {
-								fmt.Printf("Invalid transaction value range: %v, using defaults\n", err)
-								minTxValue = 1
-								maxTxValue = 1000
-							}
-							config.Low = minTxValue
-							config.High = maxTxValue
-
-							fmt.Println("\nStarting decentralized mining simulation...")
-							fmt.Printf("Configuration: %d blocks, %d candidates per round, %d tx per candidate, %v initial time limit\n",
-								config.BlockCount, config.CandidateCount, config.TxCount, config.InitialTimeLimit)
-
-							ctx := context.Background()
-							err := bch.MineBlocksDecentralized(ctx, users, config)
-							if err != nil {
-								fmt.Println("Error in decentralized mining:", err)
-							} else {
-								fmt.Println("Decentralized mining completed successfully!")
-							}
 						case "getblocktransactions":
-							block, index, err := getBlockByIndex(bch, "Please enter block index:")
+							block, index, err := getBlockByIndexOrHash(bch, "Please enter block index or hash:")
 							if err != nil {
 								fmt.Printf("Invalid block index: %v\n", err)
 								continue
@@ -435,6 +738,13 @@ func main() {
 								continue
 							}
 							fmt.Printf("Block Transactions at index %d:\n%s\n", index, string(bodyBytes))
+						case "gettransaction":
+							input, err := readString("Please enter transaction id (hex):")
+							if err != nil {
+								fmt.Println(err)
+								continue
+							}
+							printTransactionStatus(bch, input)
 						case "getuserbalance":
 							input, err := readString("Please enter user name, public key (hex), or public address (hex):")
 							if err != nil {
@@ -588,6 +898,94 @@ func main() {
 							fmt.Printf("║ Total UTXOs: %-10d                            Total Value: %-24d ║\n",
 								len(utxos), totalValue)
 							fmt.Println("╚══════════════════════════════════════════════════════════════════════════════════════════╝")
+						case "getaddresshistory":
+							input, err := readString("Please enter user name, public key (hex), or public address (hex):")
+							if err != nil {
+								fmt.Println(err)
+								continue
+							}
+
+							user, address, found, err := findUserByInput(input, users)
+							if err != nil {
+								fmt.Println("Error:", err)
+								fmt.Println("Hint: Public address = 40 hex chars, Public key = 66 hex chars")
+								continue
+							}
+							page, err := readIntWithDefault("Which page?", 1, func(v int) error {
+								if v < 1 {
+									return fmt.Errorf("page must be at least 1")
+								}
+								return nil
+							})
+							if err != nil {
+								fmt.Println(err)
+								continue
+							}
+							displayName := fmt.Sprintf("%x", address)
+							if found {
+								displayName = user.Name
+							}
+							printAddressHistory(bch, address, displayName, page)
+						case "dumpprivkey":
+							input, err := readString("Please enter user name, public key (hex), or public address (hex):")
+							if err != nil {
+								fmt.Println(err)
+								continue
+							}
+
+							user, _, found, err := findUserByInput(input, users)
+							if err != nil || !found {
+								fmt.Println("Error: user not found")
+								continue
+							}
+							fmt.Printf("Private key of %s: %x\n", user.Name, user.PrivateKey)
+							fmt.Printf("Public address:     %x\n", user.PublicAddress)
+						case "getmempool":
+							txs := bch.Mempool().Transactions(0)
+							if len(txs) == 0 {
+								fmt.Println("Mempool is empty")
+								continue
+							}
+							fmt.Printf("Mempool transactions (%d):\n", len(txs))
+							for _, tx := range txs {
+								var total uint32
+								for _, out := range tx.Outputs {
+									total += out.Value
+								}
+								fmt.Printf("  %x  inputs: %d  outputs: %d  value: %d\n", tx.TxID, len(tx.Inputs), len(tx.Outputs), total)
+							}
+						case "export":
+							path, err := readString("Please enter the file to write the chain to (.json for JSON, anything else for binary):")
+							if err != nil {
+								fmt.Println(err)
+								continue
+							}
+							format := exportFormatFor(path)
+							if err := exportChain(bch, path, format); err != nil {
+								fmt.Println("Error:", err)
+								continue
+							}
+							fmt.Printf("Exported %d blocks to %s (%s); start a session from it with --import %s\n", bch.Len(), path, format, path)
+						case "dumputxoset":
+							height, err := readIntWithDefault(fmt.Sprintf("Which height? (default tip %d)", bch.Len()-1), bch.Len()-1, func(v int) error {
+								return validateBlockIndex(v, bch.Len())
+							})
+							if err != nil {
+								fmt.Println(err)
+								continue
+							}
+							path, err := readString("Please enter the file to write the UTXO set to:")
+							if err != nil {
+								fmt.Println(err)
+								continue
+							}
+							snap, err := dumpUTXOSet(bch, path, height)
+							if err != nil {
+								fmt.Println("Error:", err)
+								continue
+							}
+							fmt.Printf("Wrote %d UTXOs worth %d at height %d (block %x) to %s\n", snap.UTXOs, snap.Amount, snap.Height, snap.BlockHash, path)
+							fmt.Printf("Commitment: %x\n", snap.Commitment)
 						case "help":
 							fmt.Println("\n╔═══════════════════════════════════════════════════════════════════════════════════════════╗")
 							fmt.Println("║                              BLOCKCHAIN CLI - HELP                                        ║")
@@ -596,8 +994,7 @@ func main() {
 							fmt.Println("║ MINING COMMANDS:                                                                          ║")
 							fmt.Println("║   mineblocks - Mines new blocks with random transactions between users                    ║")
 							fmt.Println("║                Prompts for: number of blocks, transactions per block, min/max tx value    ║")
-							fmt.Println("║   simulatedecentralizedmining - Simulates decentralized mining with multiple candidates   ║")
-							fmt.Println("║                Generates multiple candidate blocks and mines them with time limits        ║")
+							fmt.Println("║   Competing miners on a network are simulated by the separate 'simulate' command          ║")
 							fmt.Println("║                                                                                           ║")
 							fmt.Println("║ BLOCKCHAIN INFO:                                                                          ║")
 							fmt.Println("║   height     - Displays the current height (number of blocks) in the chain                ║")
@@ -605,18 +1002,29 @@ func main() {
 							fmt.Println("║   validatechain - Validates the entire blockchain integrity (checks hashes & PoW)         ║")
 							fmt.Println("║                                                                                           ║")
 							fmt.Println("║ BLOCK QUERIES:                                                                            ║")
-							fmt.Println("║   getblock   - Get complete block data (header + transactions) by index                   ║")
-							fmt.Println("║   getblockheader - Get only the block header by index                                     ║")
-							fmt.Println("║   getblockhash - Get the hash of a block by index                                         ║")
-							fmt.Println("║   getblocktransactions - Get all transactions in a block by index                         ║")
+							fmt.Println("║   getblock   - Get complete block data (header + transactions) by index or hash           ║")
+							fmt.Println("║   getblockheader - Get only the block header by index or hash                             ║")
+							fmt.Println("║   getblockhash - Get the hash of a block by index (or check that a hash is on the chain)  ║")
+							fmt.Println("║   getblocktransactions - Get all transactions in a block by index or hash                 ║")
 							fmt.Println("║   getallheaders - Get all block headers in the chain                                      ║")
+							fmt.Println("║   gettransaction - Find a transaction by id in the mempool or, with --txindex, the chain  ║")
 							fmt.Println("║                                                                                           ║")
 							fmt.Println("║ USER & BALANCE:                                                                           ║")
 							fmt.Println("║   balance    - Show all users with their balances in a formatted table                    ║")
 							fmt.Println("║   getuserbalance - Get balance (by name, public key, or public address)                   ║")
 							fmt.Println("║   richlist   - Show top N users ranked by balance                                         ║")
 							fmt.Println("║   getutxos   - Show all UTXOs (by name, public key, or public address)                    ║")
+							fmt.Println("║   getaddresshistory - Page through the credits and debits of an address (--addressindex)  ║")
+							fmt.Println("║   dumpprivkey - Show a user's private key (hex) for use with 'tx sign'                    ║")
+							fmt.Println("║                                                                                           ║")
+							fmt.Println("║ MEMPOOL:                                                                                  ║")
+							fmt.Println("║   getmempool - List transactions submitted with 'tx broadcast' that await mining          ║")
 							fmt.Println("║                                                                                           ║")
+							fmt.Println("║ OTHER:                                                                                    ║")
+							fmt.Println("║   export     - Write the chain and users' public keys to a file: JSON for .json files,    ║")
+							fmt.Println("║                the compact binary bootstrap format otherwise; read back with --import     ║")
+							fmt.Println("║   dumputxoset - Write the UTXO set after a block with its commitment hash; a session      ║")
+							fmt.Println("║                started with --loadutxoset and --import uses it before validating history  ║")
 							fmt.Println("║                                                                                           ║")
 							fmt.Println("╚═══════════════════════════════════════════════════════════════════════════════════════════╝")
 						case "exit":
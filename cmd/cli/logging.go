@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+func loggingFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "log-level", Value: "info", Usage: "minimum level of log messages: debug, info, warn or error"},
+		&cli.BoolFlag{Name: "log-json", Usage: "write log messages as JSON lines instead of key=value text"},
+	}
+}
+
+// setupLogging installs the logger the flags describe as slog's default, which the
+// standard log package writes through as well.
+func setupLogging(ctx context.Context, c *cli.Command) (context.Context, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.String("log-level"))); err != nil {
+		return ctx, fmt.Errorf("invalid --log-level %q: want debug, info, warn or error", c.String("log-level"))
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if c.Bool("log-json") {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	return ctx, nil
+}
+
+// joinAddrs lists addresses in a single log field.
+func joinAddrs(addrs []string) string {
+	return strings.Join(addrs, ",")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	"github.com/Quikmove/blockchain-uzd2/internal/stratum"
+	"github.com/urfave/cli/v3"
+)
+
+func minerCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "miner",
+		Usage: "Mine for a node's stratum server as an external miner",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "connect", Value: "localhost:3333", Usage: "stratum server address as <host>:<port>"},
+			&cli.StringFlag{Name: "name", Value: "worker", Usage: "worker name to authorize as"},
+			&cli.IntFlag{Name: "threads", Value: runtime.NumCPU(), Usage: "number of hashing goroutines"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+			defer stop()
+
+			miner := stratum.NewMiner(c.String("connect"), c.String("name"), crypto.NewArchasHasher(), int(c.Int("threads")))
+			done := make(chan error, 1)
+			go func() { done <- miner.Run(ctx) }()
+			slog.Info("Mining", "server", c.String("connect"), "worker", c.String("name"), "threads", c.Int("threads"))
+
+			ticker := time.NewTicker(10 * time.Second)
+			defer ticker.Stop()
+			start := time.Now()
+			for {
+				select {
+				case err := <-done:
+					return err
+				case <-ticker.C:
+					st := miner.Stats()
+					slog.Info("Miner", "worker", c.String("name"), "jobs", st.Jobs, "accepted", st.Accepted, "rejected", st.Rejected,
+						"hashrate", float64(st.Hashes)/time.Since(start).Seconds())
+				}
+			}
+		},
+	}
+}
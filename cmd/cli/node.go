@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/api"
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	"github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	"github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/Quikmove/blockchain-uzd2/internal/filetolist"
+	"github.com/Quikmove/blockchain-uzd2/internal/p2p"
+	"github.com/Quikmove/blockchain-uzd2/internal/pool"
+	"github.com/Quikmove/blockchain-uzd2/internal/stratum"
+	"github.com/urfave/cli/v3"
+)
+
+func hasFunds(bch *blockchain.Blockchain, users []domain.User) bool {
+	for _, user := range users {
+		if bch.GetUserBalance(user.PublicAddress) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeCommand() *cli.Command {
+	cfg := config.LoadConfig()
+
+	return &cli.Command{
+		Name:  "node",
+		Usage: "Run a networked node that syncs blocks and transactions with its peers",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "listen", Value: ":" + cfg.P2PPort, Usage: "address to accept peers on"},
+			&cli.StringSliceFlag{Name: "peer", Usage: "peer address as <host>:<port>, repeat for several; without peers a new chain is created"},
+			&cli.StringFlag{Name: "api", Value: ":" + cfg.Port, Usage: "HTTP API address, empty to disable"},
+			&cli.BoolFlag{Name: "mine", Usage: "mine blocks with random transactions between this node's users"},
+			&cli.IntFlag{Name: "txs", Value: 20, Usage: "transactions per mined block"},
+			&cli.StringFlag{Name: "stratum", Usage: "address to hand out mining jobs to external miners on, e.g. :3333; empty to disable"},
+			&cli.StringFlag{Name: "payout", Usage: "hex address receiving the rewards of blocks found by external miners, default the first user"},
+			&cli.StringFlag{Name: "pool", Usage: "split block rewards between external miners by their shares: pplns or proportional; empty pays --payout"},
+			&cli.FloatFlag{Name: "pool-window", Value: pool.DefaultConfig().Window, Usage: "PPLNS window in blocks of work"},
+			&cli.FloatFlag{Name: "pool-fee", Value: pool.DefaultConfig().Fee, Usage: "fraction of every reward paid to --payout"},
+			txIndexFlag(),
+			addressIndexFlag(),
+			importFlag(),
+			loadUTXOSetFlag(),
+			pruneFlag(),
+			dataDirFlag(),
+			&cli.UintFlag{Name: "share-difficulty", Value: uint(stratum.DefaultConfig().ShareDifficulty), Usage: "difficulty of a share submitted by an external miner"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+			defer stop()
+
+			hasher := crypto.NewArchasHasher()
+			txSigner := crypto.NewTransactionSigner()
+			names := filetolist.FileToList(cfg.NameListPath)
+			users := blockchain.NewUserGeneratorService(crypto.NewKeyGenerator()).GenerateUsers(names, cfg.UserCount)
+
+			opts := []blockchain.Option{blockchain.WithMinDifficulty(cfg.Difficulty), blockchain.WithBlockReward(cfg.BlockReward)}
+			if c.Bool("txindex") {
+				opts = append(opts, blockchain.WithTxIndex())
+			}
+			if c.Bool("addressindex") {
+				opts = append(opts, blockchain.WithAddressIndex())
+			}
+			prune, err := pruneOption(c)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, prune)
+			peers := c.StringSlice("peer")
+			var bch *blockchain.Blockchain
+			if c.String("loadutxoset") != "" && c.String("import") == "" {
+				return errors.New("--loadutxoset needs --import for the headers and the history below the snapshot")
+			}
+			if dir := c.String("datadir"); dir != "" {
+				if c.String("loadutxoset") != "" {
+					return errors.New("--loadutxoset cannot be combined with --datadir")
+				}
+				stored, store, storeOpts, err := openDataDir(dir, hasher, txSigner, opts...)
+				if err != nil {
+					return err
+				}
+				defer store.Close()
+				opts = storeOpts
+				if stored.Len() > 0 {
+					if c.String("import") != "" {
+						return fmt.Errorf("--import needs an empty --datadir, %s holds %d blocks", dir, stored.Len())
+					}
+					bch = stored
+					bch.RegisterUsers(users)
+				}
+			}
+			if bch != nil {
+				tipHash, _ := bch.GetLatestBlockHash()
+				slog.Info("Resuming stored chain", "height", bch.Len()-1, "tip", tipHash.String())
+			} else if path := c.String("loadutxoset"); path != "" {
+				loaded, err := loadSnapshotChain(ctx, path, c.String("import"), hasher, txSigner, opts...)
+				if err != nil {
+					return err
+				}
+				bch = loaded
+				bch.RegisterUsers(users)
+			} else if path := c.String("import"); path != "" {
+				imported, err := importChain(path, hasher, txSigner, opts...)
+				if err != nil {
+					return err
+				}
+				bch = imported
+				bch.RegisterUsers(users)
+			} else if len(peers) == 0 {
+				slog.Info("No peers given, generating a new genesis block")
+				bch = blockchain.InitBlockchainWithFunds(100, 1000000, users, cfg, hasher, txSigner, opts...)
+			} else {
+				slog.Info("Joining the network", "peers", joinAddrs(peers))
+				bch = blockchain.NewBlockchain(hasher, txSigner, opts...)
+				bch.RegisterUsers(users)
+			}
+
+			p2pCfg := p2p.DefaultConfig()
+			p2pCfg.ListenAddr = c.String("listen")
+			p2pCfg.Seeds = peers
+			node := p2p.NewNode(bch, p2pCfg)
+			if err := node.Start(ctx); err != nil {
+				return err
+			}
+			slog.Info("P2P listening", "addr", node.Addr())
+
+			if addr := c.String("api"); addr != "" {
+				server := api.NewServer(bch)
+				node.RegisterMetrics(server.Registry())
+				go func() {
+					slog.Info("HTTP API listening", "addr", addr)
+					if err := server.ListenAndServe(ctx, addr); err != nil {
+						slog.Error("HTTP API stopped", "err", err)
+					}
+				}()
+			}
+
+			var stratumServer *stratum.Server
+			var miningPool *pool.Pool
+			if addr := c.String("stratum"); addr != "" {
+				stratumCfg := stratum.DefaultConfig()
+				stratumCfg.ListenAddr = addr
+				stratumCfg.Version = cfg.Version
+				stratumCfg.Difficulty = cfg.Difficulty
+				stratumCfg.ShareDifficulty = uint32(c.Uint("share-difficulty"))
+				stratumCfg.Payout = users[0].PublicAddress
+				if payout := c.String("payout"); payout != "" {
+					address, err := domain.ParsePublicAddress(payout)
+					if err != nil {
+						return err
+					}
+					stratumCfg.Payout = address
+				}
+				if name := c.String("pool"); name != "" {
+					scheme, err := pool.ParseScheme(name)
+					if err != nil {
+						return err
+					}
+					poolCfg := pool.Config{Scheme: scheme, Window: c.Float("pool-window"), Fee: c.Float("pool-fee"), Operator: stratumCfg.Payout}
+					if miningPool, err = pool.New(poolCfg); err != nil {
+						return err
+					}
+					stratumCfg.Pool = miningPool
+				}
+				stratumServer = stratum.NewServer(bch, hasher, stratumCfg)
+				if err := stratumServer.Start(ctx); err != nil {
+					return err
+				}
+				slog.Info("Stratum listening", "addr", stratumServer.Addr())
+			}
+
+			if c.Bool("mine") {
+				go func() {
+					for ctx.Err() == nil {
+						if bch.Len() == 0 || !hasFunds(bch, users) {
+							time.Sleep(time.Second)
+							continue
+						}
+						if err := bch.MineBlocks(ctx, 1, int(c.Int("txs")), 10, 50, users, cfg.Version, cfg.Difficulty); err != nil && ctx.Err() == nil {
+							slog.Error("Could not mine block", "height", bch.Len(), "err", err)
+							time.Sleep(time.Second)
+						}
+					}
+				}()
+			}
+
+			ticker := time.NewTicker(10 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					node.Wait()
+					if stratumServer != nil {
+						stratumServer.Wait()
+					}
+					return nil
+				case <-ticker.C:
+					if st := node.SyncStatus(); st.Syncing {
+						slog.Info("Syncing", "height", bch.Len()-1, "peers", len(node.Peers()), "sync_peer", st.SyncPeer, "connected", st.Connected, "headers", st.Headers)
+						continue
+					}
+					slog.Info("Status", "height", bch.Len()-1, "peers", len(node.Peers()), "mempool", bch.Mempool().Len())
+					if c.Bool("mine") {
+						st := bch.MiningStats()
+						slog.Info("Mining", "blocks", st.Blocks, "stale_rounds", st.StaleRounds, "hashrate", st.Hashrate(),
+							"block_time", st.AverageBlockTime().Round(time.Millisecond), "wasted_hashes", st.WastedHashes, "hashes", st.Hashes)
+					}
+					if stratumServer != nil {
+						for _, w := range stratumServer.Workers() {
+							slog.Info("Stratum worker", "worker", w.Name, "connections", w.Connections, "accepted", w.Accepted,
+								"rejected", w.Rejected, "blocks", w.Blocks, "hashrate", w.Hashrate)
+						}
+						if miningPool != nil {
+							if rounds := miningPool.Rounds(); len(rounds) > 0 {
+								last := rounds[len(rounds)-1]
+								slog.Info("Pool", "blocks", len(rounds), "height", last.Height, "payouts", len(last.Payouts), "shares", last.Shares)
+							}
+						}
+					}
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/pool"
+	"github.com/Quikmove/blockchain-uzd2/internal/simulation"
+	"github.com/urfave/cli/v3"
+)
+
+func simulateCommand() *cli.Command {
+	defaults := simulation.DefaultConfig()
+
+	return &cli.Command{
+		Name:  "simulate",
+		Usage: "Simulate competing miners on a virtual network and report orphan rates, reorgs and finality",
+		// Slice flags are repeated instead of comma separated, since partitions contain commas.
+		DisableSliceFlagSeparator: true,
+		Commands:                  []*cli.Command{simulatePoolCommand()},
+		Flags: []cli.Flag{
+			&cli.Int64Flag{Name: "seed", Value: defaults.Seed, Usage: "seed for every random choice; the same seed repeats the same run"},
+			&cli.IntFlag{Name: "nodes", Value: defaults.Nodes, Usage: "number of mining nodes"},
+			&cli.IntFlag{Name: "degree", Value: defaults.Degree, Usage: "peers per node, 0 connects every pair"},
+			&cli.IntFlag{Name: "blocks", Value: defaults.Blocks, Usage: "stop mining after this many blocks, 0 for no limit"},
+			&cli.DurationFlag{Name: "duration", Usage: "stop mining after this much simulated time, 0 for no limit"},
+			&cli.DurationFlag{Name: "interval", Value: defaults.BlockInterval, Usage: "expected time between blocks of the whole network"},
+			&cli.FloatSliceFlag{Name: "hash-share", Usage: "relative hash rate of each node, repeat once per node"},
+			&cli.StringSliceFlag{Name: "strategy", Usage: "strategy of the next node: honest, selfish, withholding or double-spend; repeat from node 0, the rest are honest"},
+			&cli.IntFlag{Name: "withholding-victim", Value: defaults.WithholdingVictim, Usage: "node whose pool withholding miners join"},
+			&cli.IntFlag{Name: "confirmations", Value: defaults.Confirmations, Usage: "confirmations a merchant waits for before a double-spend attacker can reverse the payment"},
+			&cli.IntFlag{Name: "give-up", Value: defaults.GiveUpDeficit, Usage: "blocks a double-spend attacker may fall behind before abandoning the attack"},
+			&cli.DurationFlag{Name: "latency", Value: defaults.Latency, Usage: "one-way link latency"},
+			&cli.DurationFlag{Name: "jitter", Value: defaults.Jitter, Usage: "maximum random delay added to each message"},
+			&cli.IntFlag{Name: "bandwidth", Value: defaults.Bandwidth, Usage: "link bandwidth in bytes per second, 0 for unlimited"},
+			&cli.FloatFlag{Name: "loss", Value: defaults.Loss, Usage: "probability that a message is lost"},
+			&cli.StringSliceFlag{Name: "partition", Usage: "partition as <start>-<end>:<nodes>|<nodes>, e.g. 1m-5m:0,1,2|3,4; repeatable"},
+			&cli.FloatFlag{Name: "tx-rate", Value: defaults.TxRate, Usage: "transactions per simulated second"},
+			&cli.IntFlag{Name: "finality", Value: defaults.FinalityDepth, Usage: "confirmations after which a block counts as final"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			cfg := defaults
+			cfg.Seed = c.Int64("seed")
+			cfg.Nodes = int(c.Int("nodes"))
+			cfg.Degree = int(c.Int("degree"))
+			cfg.Blocks = int(c.Int("blocks"))
+			cfg.Duration = c.Duration("duration")
+			cfg.BlockInterval = c.Duration("interval")
+			cfg.HashShares = c.FloatSlice("hash-share")
+			for _, name := range c.StringSlice("strategy") {
+				strategy, err := simulation.ParseStrategy(name)
+				if err != nil {
+					return err
+				}
+				cfg.Strategies = append(cfg.Strategies, strategy)
+			}
+			cfg.WithholdingVictim = int(c.Int("withholding-victim"))
+			cfg.Confirmations = int(c.Int("confirmations"))
+			cfg.GiveUpDeficit = int(c.Int("give-up"))
+			cfg.Latency = c.Duration("latency")
+			cfg.Jitter = c.Duration("jitter")
+			cfg.Bandwidth = int(c.Int("bandwidth"))
+			cfg.Loss = c.Float("loss")
+			cfg.TxRate = c.Float("tx-rate")
+			cfg.FinalityDepth = int(c.Int("finality"))
+			for _, s := range c.StringSlice("partition") {
+				p, err := simulation.ParsePartition(s)
+				if err != nil {
+					return err
+				}
+				cfg.Partitions = append(cfg.Partitions, p)
+			}
+
+			sim, err := simulation.New(cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Simulating %d nodes...\n", cfg.Nodes)
+			report, err := sim.Run(ctx)
+			if err != nil {
+				return err
+			}
+			return report.Print(os.Stdout)
+		},
+	}
+}
+
+func simulatePoolCommand() *cli.Command {
+	defaults := pool.DefaultSimConfig()
+
+	return &cli.Command{
+		Name:  "pool",
+		Usage: "Simulate a mining pool's payouts and report how fairly and steadily each worker is paid",
+		Flags: []cli.Flag{
+			&cli.Int64Flag{Name: "seed", Value: defaults.Seed, Usage: "seed for every random choice; the same seed repeats the same run"},
+			&cli.StringFlag{Name: "scheme", Value: defaults.Pool.Scheme.String(), Usage: "payout scheme: pplns or proportional"},
+			&cli.FloatFlag{Name: "window", Value: defaults.Pool.Window, Usage: "PPLNS window in blocks of work"},
+			&cli.FloatFlag{Name: "fee", Value: defaults.Pool.Fee, Usage: "fraction of every reward the pool keeps"},
+			&cli.FloatSliceFlag{Name: "hash-share", Value: defaults.HashShares, Usage: "relative hash rate of each worker, repeat once per worker"},
+			&cli.IntFlag{Name: "blocks", Value: defaults.Blocks, Usage: "number of blocks the pool finds"},
+			&cli.FloatFlag{Name: "shares-per-block", Value: defaults.SharesPerBlock, Usage: "expected shares per block, the block work over the share work"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			cfg := defaults
+			scheme, err := pool.ParseScheme(c.String("scheme"))
+			if err != nil {
+				return err
+			}
+			cfg.Pool.Scheme = scheme
+			cfg.Pool.Window = c.Float("window")
+			cfg.Pool.Fee = c.Float("fee")
+			cfg.Seed = c.Int64("seed")
+			cfg.HashShares = c.FloatSlice("hash-share")
+			cfg.Blocks = int(c.Int("blocks"))
+			cfg.SharesPerBlock = c.Float("shares-per-block")
+
+			report, err := pool.Simulate(cfg)
+			if err != nil {
+				return err
+			}
+			return report.Print(os.Stdout)
+		},
+	}
+}
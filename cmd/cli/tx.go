@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/api"
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	"github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	"github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/Quikmove/blockchain-uzd2/internal/psbt"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/urfave/cli/v3"
+)
+
+func defaultNodeURL() string {
+	return "http://localhost:" + config.LoadConfig().Port
+}
+
+func parseOutpoint(s string) (domain.Outpoint, error) {
+	txIDHex, indexStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return domain.Outpoint{}, fmt.Errorf("input %q must have the form <txid>:<index>", s)
+	}
+	txID, err := domain.ParseHash32(txIDHex)
+	if err != nil {
+		return domain.Outpoint{}, fmt.Errorf("invalid txid in %q: %w", s, err)
+	}
+	index, err := strconv.ParseUint(indexStr, 10, 32)
+	if err != nil {
+		return domain.Outpoint{}, fmt.Errorf("invalid index in %q: %w", s, err)
+	}
+	return domain.Outpoint{TxID: txID, Index: uint32(index)}, nil
+}
+
+func parseOutput(s string) (domain.TxOutput, error) {
+	addressHex, valueStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return domain.TxOutput{}, fmt.Errorf("output %q must have the form <address>:<value>", s)
+	}
+	address, err := domain.ParsePublicAddress(addressHex)
+	if err != nil {
+		return domain.TxOutput{}, fmt.Errorf("invalid address in %q: %w", s, err)
+	}
+	value, err := strconv.ParseUint(valueStr, 10, 32)
+	if err != nil || value == 0 {
+		return domain.TxOutput{}, fmt.Errorf("invalid value in %q", s)
+	}
+	return domain.TxOutput{To: address, Value: uint32(value)}, nil
+}
+
+func fetchUTXO(ctx context.Context, nodeURL string, outpoint domain.Outpoint) (domain.UTXO, error) {
+	url := fmt.Sprintf("%s/api/utxo/%s/%d", strings.TrimRight(nodeURL, "/"), outpoint.TxID.String(), outpoint.Index)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return domain.UTXO{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return domain.UTXO{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return domain.UTXO{}, fmt.Errorf("node returned %s for %s:%d", resp.Status, outpoint.TxID.String(), outpoint.Index)
+	}
+	var body api.UTXOResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return domain.UTXO{}, err
+	}
+	address, err := domain.ParsePublicAddress(body.Address)
+	if err != nil {
+		return domain.UTXO{}, err
+	}
+	return domain.UTXO{Outpoint: outpoint, To: address, Value: body.Value}, nil
+}
+
+func readPSBT(path string, hasher crypto.Hasher) (*psbt.PSBT, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return psbt.Decode(string(data), hasher)
+}
+
+// writeOutput writes s to path, or to stdout when path is empty.
+func writeOutput(path, s string) error {
+	if path == "" {
+		fmt.Println(s)
+		return nil
+	}
+	return os.WriteFile(path, []byte(s+"\n"), 0o600)
+}
+
+func parsePrivateKey(keyHex string) (*secp256k1.PrivateKey, error) {
+	keyBytes, err := hex.DecodeString(strings.TrimSpace(keyHex))
+	if err != nil || len(keyBytes) != 32 {
+		return nil, errors.New("private key must be 64 hex characters")
+	}
+	return secp256k1.PrivKeyFromBytes(keyBytes), nil
+}
+
+func txCommand() *cli.Command {
+	hasher := crypto.NewArchasHasher()
+	outFlag := &cli.StringFlag{Name: "out", Usage: "write the result to this file instead of stdout"}
+
+	return &cli.Command{
+		Name:  "tx",
+		Usage: "Build, sign and broadcast partially signed transactions",
+		Commands: []*cli.Command{
+			{
+				Name:  "create",
+				Usage: "Create an unsigned PSBT, looking up the spent outputs on a node",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "input", Usage: "outpoint to spend as <txid>:<index>", Required: true},
+					&cli.StringSliceFlag{Name: "output", Usage: "payment as <address>:<value>", Required: true},
+					&cli.StringFlag{Name: "sighash", Value: "ALL", Usage: "sighash type requested for every input, e.g. ALL or SINGLE|ANYONECANPAY"},
+					&cli.StringFlag{Name: "node", Value: defaultNodeURL(), Usage: "node HTTP API address"},
+					outFlag,
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					hashType, err := domain.ParseSigHashType(c.String("sighash"))
+					if err != nil {
+						return err
+					}
+					var utxos []domain.UTXO
+					for _, in := range c.StringSlice("input") {
+						outpoint, err := parseOutpoint(in)
+						if err != nil {
+							return err
+						}
+						utxo, err := fetchUTXO(ctx, c.String("node"), outpoint)
+						if err != nil {
+							return err
+						}
+						utxos = append(utxos, utxo)
+					}
+					var outputs []domain.TxOutput
+					for _, out := range c.StringSlice("output") {
+						output, err := parseOutput(out)
+						if err != nil {
+							return err
+						}
+						outputs = append(outputs, output)
+					}
+					p, err := psbt.New(utxos, outputs, hasher)
+					if err != nil {
+						return err
+					}
+					for i := range p.Inputs {
+						p.Inputs[i].SigHashType = hashType
+					}
+					return writeOutput(c.String("out"), p.Encode())
+				},
+			},
+			{
+				Name:  "sign",
+				Usage: "Sign the PSBT inputs owned by a private key",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "psbt", Usage: "PSBT file", Required: true},
+					&cli.StringFlag{Name: "key", Usage: "private key as 64 hex characters", Required: true},
+					outFlag,
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					p, err := readPSBT(c.String("psbt"), hasher)
+					if err != nil {
+						return err
+					}
+					key, err := parsePrivateKey(c.String("key"))
+					if err != nil {
+						return err
+					}
+					signed, err := p.Sign(key, crypto.NewTransactionSigner(), hasher)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(os.Stderr, "Signed %d input(s)\n", signed)
+					return writeOutput(c.String("out"), p.Encode())
+				},
+			},
+			{
+				Name:  "combine",
+				Usage: "Merge the signatures of several PSBTs for the same transaction",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "psbt", Usage: "PSBT file, repeat for each signer", Required: true},
+					outFlag,
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					var parts []*psbt.PSBT
+					for _, path := range c.StringSlice("psbt") {
+						p, err := readPSBT(path, hasher)
+						if err != nil {
+							return fmt.Errorf("%s: %w", path, err)
+						}
+						parts = append(parts, p)
+					}
+					combined, err := psbt.Combine(parts...)
+					if err != nil {
+						return err
+					}
+					return writeOutput(c.String("out"), combined.Encode())
+				},
+			},
+			{
+				Name:  "finalize",
+				Usage: "Turn a fully signed PSBT into a raw transaction (hex)",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "psbt", Usage: "PSBT file", Required: true},
+					outFlag,
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					p, err := readPSBT(c.String("psbt"), hasher)
+					if err != nil {
+						return err
+					}
+					tx, err := p.Finalize(hasher)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(os.Stderr, "Transaction ID: %x\n", tx.TxID)
+					return writeOutput(c.String("out"), hex.EncodeToString(tx.Serialize()))
+				},
+			},
+			{
+				Name:  "broadcast",
+				Usage: "Submit a raw transaction to a node's mempool",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "tx", Usage: "file holding the raw transaction hex", Required: true},
+					&cli.StringFlag{Name: "node", Value: defaultNodeURL(), Usage: "node HTTP API address"},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					rawHex, err := os.ReadFile(c.String("tx"))
+					if err != nil {
+						return err
+					}
+					body, err := json.Marshal(api.SendTransactionRequest{Hex: strings.TrimSpace(string(rawHex))})
+					if err != nil {
+						return err
+					}
+					url := strings.TrimRight(c.String("node"), "/") + "/api/tx"
+					req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+					if err != nil {
+						return err
+					}
+					req.Header.Set("Content-Type", "application/json")
+					resp, err := http.DefaultClient.Do(req)
+					if err != nil {
+						return err
+					}
+					defer resp.Body.Close()
+					if resp.StatusCode != http.StatusOK {
+						var failure struct {
+							Error string `json:"error"`
+						}
+						_ = json.NewDecoder(resp.Body).Decode(&failure)
+						return fmt.Errorf("node rejected transaction: %s", failure.Error)
+					}
+					var accepted api.SendTransactionResponse
+					if err := json.NewDecoder(resp.Body).Decode(&accepted); err != nil {
+						return err
+					}
+					fmt.Println("✅ Transaction accepted into mempool:", accepted.TxID)
+					return nil
+				},
+			},
+		},
+	}
+}
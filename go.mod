@@ -11,4 +11,10 @@ require (
 
 require golang.org/x/crypto v0.44.0
 
-require github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0 // indirect
+require (
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0
+	github.com/gorilla/websocket v1.5.3
+	go.etcd.io/bbolt v1.4.3
+)
+
+require golang.org/x/sys v0.38.0 // indirect
@@ -0,0 +1,523 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/Quikmove/blockchain-uzd2/internal/merkletree"
+)
+
+// Sizes of the explorer pages.
+const (
+	explorerRecentBlocks  = 25
+	explorerHistoryLength = 25
+	explorerRichListSize  = 100
+)
+
+//go:embed explorer
+var explorerFiles embed.FS
+
+// explorerPages holds one template per explorer page, each made of the shared layout
+// and the page's content.
+var explorerPages = parseExplorerPages("index", "block", "tx", "address", "richlist", "error")
+
+func parseExplorerPages(names ...string) map[string]*template.Template {
+	funcs := template.FuncMap{
+		"short": short,
+		"time": func(ts uint32) string {
+			return time.Unix(int64(ts), 0).UTC().Format("2006-01-02 15:04:05 UTC")
+		},
+	}
+	pages := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		pages[name] = template.Must(template.New("layout.html").Funcs(funcs).ParseFS(explorerFiles, "explorer/layout.html", "explorer/"+name+".html"))
+	}
+	return pages
+}
+
+// registerExplorer adds the read-only block explorer pages to the server's mux.
+func (s *Server) registerExplorer() {
+	s.mux.HandleFunc("GET /{$}", s.handleExplorerIndex)
+	s.mux.HandleFunc("GET /block/{id}", s.handleExplorerBlock)
+	s.mux.HandleFunc("GET /tx/{txid}", s.handleExplorerTx)
+	s.mux.HandleFunc("GET /address/{address}", s.handleExplorerAddress)
+	s.mux.HandleFunc("GET /richlist", s.handleExplorerRichList)
+	s.mux.HandleFunc("GET /search", s.handleExplorerSearch)
+}
+
+// renderPage executes the named explorer page with data.
+func renderPage(w http.ResponseWriter, status int, name, title string, data any) {
+	var buf bytes.Buffer
+	if err := explorerPages[name].Execute(&buf, struct {
+		Title string
+		Data  any
+	}{title, data}); err != nil {
+		slog.Error("Could not render explorer page", "page", name, "err", err)
+		http.Error(w, "could not render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
+// renderError renders the explorer's error page.
+func renderError(w http.ResponseWriter, status int, message string) {
+	renderPage(w, status, "error", http.StatusText(status), message)
+}
+
+// explorerBlockRow is a block in the explorer's lists.
+type explorerBlockRow struct {
+	Height       int
+	Hash         string
+	Time         uint32
+	Transactions int
+	Difficulty   uint32
+	Size         int
+}
+
+func (s *Server) handleExplorerIndex(w http.ResponseWriter, r *http.Request) {
+	tipHeight := s.bch.Len() - 1
+	var blocks []explorerBlockRow
+	for height := tipHeight; height >= 0 && len(blocks) < explorerRecentBlocks; height-- {
+		hash, err := s.bch.GetBlockHash(height)
+		if err != nil {
+			continue
+		}
+		b, err := s.bch.GetBlockByHash(hash)
+		if err != nil {
+			continue
+		}
+		blocks = append(blocks, explorerBlockRow{
+			Height:       height,
+			Hash:         hash.String(),
+			Time:         b.Header.Timestamp,
+			Transactions: len(b.Body.Transactions),
+			Difficulty:   b.Header.Difficulty,
+			Size:         len(b.Serialize()),
+		})
+	}
+	mempool := s.bch.Mempool()
+	renderPage(w, http.StatusOK, "index", "Recent blocks", struct {
+		Height       int
+		MempoolSize  int
+		MempoolBytes int
+		Blocks       []explorerBlockRow
+	}{tipHeight, mempool.Len(), mempool.Bytes(), blocks})
+}
+
+// explorerMerkleNode is a node of a rendered Merkle tree. Duplicate marks the copy of
+// the last node that pads a level with an odd number of nodes.
+type explorerMerkleNode struct {
+	Hash      string
+	TxID      string
+	Duplicate bool
+}
+
+// merkleLevels returns the levels of the block's Merkle tree from the root down to
+// the transaction ids. Padding copies are shown but not expanded again.
+func merkleLevels(txs []d.Transaction) [][]explorerMerkleNode {
+	ids := make([]d.Hash32, len(txs))
+	for i, tx := range txs {
+		ids[i] = tx.TxID
+	}
+	tree := merkletree.NewMerkleTree(ids)
+	if tree.Root == nil {
+		return nil
+	}
+	var levels [][]explorerMerkleNode
+	for level := []*merkletree.Node{tree.Root}; len(level) > 0; {
+		var row []explorerMerkleNode
+		var next []*merkletree.Node
+		for i, n := range level {
+			duplicate := i > 0 && level[i-1] == n
+			row = append(row, explorerMerkleNode{Hash: hex.EncodeToString(n.Val[:]), Duplicate: duplicate})
+			if !duplicate && n.Left != nil {
+				next = append(next, n.Left, n.Right)
+			}
+		}
+		levels = append(levels, row)
+		level = next
+	}
+	leaves := levels[len(levels)-1]
+	for i := range leaves {
+		if i < len(txs) && !leaves[i].Duplicate {
+			leaves[i].TxID = leaves[i].Hash
+		}
+	}
+	return levels
+}
+
+// explorerTxRow is a transaction in a block's transaction list.
+type explorerTxRow struct {
+	TxID     string
+	Coinbase bool
+	Inputs   int
+	Outputs  int
+	Value    uint64
+}
+
+func (s *Server) handleExplorerBlock(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var hash d.Hash32
+	if height, err := strconv.Atoi(id); err == nil {
+		if hash, err = s.bch.GetBlockHash(height); err != nil {
+			renderError(w, http.StatusNotFound, "There is no block at height "+id+".")
+			return
+		}
+	} else if hash, err = d.ParseHash32(id); err != nil {
+		renderError(w, http.StatusBadRequest, "A block is identified by its height or its 64 character hex hash.")
+		return
+	}
+	b, err := s.bch.GetBlockByHash(hash)
+	if errors.Is(err, d.ErrBlockPruned) {
+		renderError(w, http.StatusNotFound, "Block "+hash.String()+" has been pruned; the node keeps only its header.")
+		return
+	}
+	if errors.Is(err, d.ErrBlockDataUnavailable) {
+		renderError(w, http.StatusNotFound, "The node keeps only the header of block "+hash.String()+".")
+		return
+	}
+	if err != nil {
+		renderError(w, http.StatusNotFound, "No block has hash "+hash.String()+".")
+		return
+	}
+
+	type page struct {
+		Hash          string
+		Height        int
+		Main          bool
+		Confirmations int
+		PrevHash      string
+		NextHash      string
+		Header        d.Header
+		MerkleRoot    string
+		Size          int
+		Transactions  []explorerTxRow
+		Merkle        [][]explorerMerkleNode
+	}
+	p := page{
+		Hash:       hash.String(),
+		Height:     -1,
+		PrevHash:   b.Header.PrevHash.String(),
+		Header:     b.Header,
+		MerkleRoot: b.Header.MerkleRoot.String(),
+		Size:       len(b.Serialize()),
+		Merkle:     merkleLevels(b.Body.Transactions),
+	}
+	if b.Header.PrevHash.IsZero() {
+		p.PrevHash = ""
+	}
+	if height, err := s.bch.GetHeightByHash(hash); err == nil {
+		p.Height, p.Main = height, true
+		p.Confirmations = s.bch.Len() - height
+		if next, err := s.bch.GetBlockHash(height + 1); err == nil {
+			p.NextHash = next.String()
+		}
+	}
+	for _, tx := range b.Body.Transactions {
+		row := explorerTxRow{TxID: tx.TxID.String(), Coinbase: tx.IsCoinbase(), Inputs: len(tx.Inputs), Outputs: len(tx.Outputs)}
+		for _, out := range tx.Outputs {
+			row.Value += uint64(out.Value)
+		}
+		p.Transactions = append(p.Transactions, row)
+	}
+	title := "Block " + p.Hash
+	if p.Main {
+		title = "Block " + strconv.Itoa(p.Height)
+	}
+	renderPage(w, http.StatusOK, "block", title, p)
+}
+
+// explorerGraphNode is an input or output box of a transaction graph. Known is false
+// for inputs whose spent output the node cannot look up.
+type explorerGraphNode struct {
+	// Y is the top of the box and Mid its vertical middle, where the edge meets it.
+	Y, Mid  int
+	Label   string
+	Address string
+	Value   uint32
+	Known   bool
+	Spent   bool
+}
+
+// Layout of the transaction graph, an SVG with the inputs on the left, the
+// transaction in the middle and the outputs on the right.
+const (
+	graphWidth   = 760
+	graphRow     = 48
+	graphBox     = 36
+	graphPadding = 12
+)
+
+// explorerGraph is a transaction graph. Edges run from every input to the middle at
+// CenterY and from there to every output.
+type explorerGraph struct {
+	Width, Height, CenterY int
+	Inputs, Outputs        []explorerGraphNode
+}
+
+// newExplorerGraph lays out the given inputs and outputs one row apart.
+func newExplorerGraph(inputs, outputs []explorerGraphNode) explorerGraph {
+	rows := max(len(inputs), len(outputs), 1)
+	g := explorerGraph{Width: graphWidth, Height: rows*graphRow + graphPadding}
+	g.CenterY = g.Height / 2
+	place := func(nodes []explorerGraphNode) []explorerGraphNode {
+		top := (g.Height - len(nodes)*graphRow) / 2
+		for i := range nodes {
+			nodes[i].Y = top + i*graphRow + (graphRow-graphBox)/2
+			nodes[i].Mid = nodes[i].Y + graphBox/2
+		}
+		return nodes
+	}
+	g.Inputs, g.Outputs = place(inputs), place(outputs)
+	return g
+}
+
+func (s *Server) handleExplorerTx(w http.ResponseWriter, r *http.Request) {
+	txID, err := d.ParseHash32(r.PathValue("txid"))
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "A transaction is identified by its 64 character hex id.")
+		return
+	}
+
+	var tx d.Transaction
+	var blockHash string
+	height, confirmations := -1, 0
+	if raw := r.URL.Query().Get("block"); raw != "" {
+		tx, blockHash, height, confirmations, err = s.transactionInBlock(txID, raw)
+	} else {
+		var st blockchain.TxStatus
+		st, err = s.bch.GetTransaction(txID)
+		tx, confirmations = st.Tx, st.Confirmations
+		if loc := st.Block; loc != nil {
+			blockHash, height = loc.BlockHash.String(), loc.Height
+		}
+	}
+	switch {
+	case errors.Is(err, blockchain.ErrTxIndexDisabled):
+		renderError(w, http.StatusNotFound, "The transaction is not in the mempool. Confirmed transactions are only found from their block's page unless the node runs with --txindex.")
+		return
+	case err != nil:
+		renderError(w, http.StatusNotFound, "No transaction has id "+txID.String()+".")
+		return
+	}
+
+	spent := make(map[d.Outpoint]d.UTXO)
+	if height >= 0 {
+		outputs, _ := s.bch.SpentOutputs(height)
+		for _, utxo := range outputs {
+			spent[utxo.Outpoint] = utxo
+		}
+	}
+	var inputs, outputs []explorerGraphNode
+	var in, out uint64
+	feeKnown := !tx.IsCoinbase()
+	if tx.IsCoinbase() {
+		inputs = append(inputs, explorerGraphNode{Label: "Coinbase (new coins)"})
+	} else {
+		for _, input := range tx.Inputs {
+			node := explorerGraphNode{Label: short(input.Prev.TxID.String()) + ":" + strconv.FormatUint(uint64(input.Prev.Index), 10)}
+			utxo, ok := spent[input.Prev]
+			if !ok && height < 0 {
+				utxo, ok = s.bch.GetUTXO(input.Prev)
+			}
+			if ok {
+				node.Address, node.Value, node.Known = hex.EncodeToString(utxo.To[:]), utxo.Value, true
+				in += uint64(utxo.Value)
+			} else {
+				feeKnown = false
+			}
+			inputs = append(inputs, node)
+		}
+	}
+	outpointTxID := s.bch.OutpointTxID(tx)
+	for i, output := range tx.Outputs {
+		_, unspent := s.bch.GetUTXO(d.Outpoint{TxID: outpointTxID, Index: uint32(i)})
+		outputs = append(outputs, explorerGraphNode{
+			Label:   "#" + strconv.Itoa(i),
+			Address: hex.EncodeToString(output.To[:]),
+			Value:   output.Value,
+			Known:   true,
+			Spent:   height >= 0 && !unspent,
+		})
+		out += uint64(output.Value)
+	}
+	var fee uint64
+	if feeKnown && in >= out {
+		fee = in - out
+	} else {
+		feeKnown = false
+	}
+
+	renderPage(w, http.StatusOK, "tx", "Transaction "+txID.String(), struct {
+		TxID          string
+		OutpointTxID  string
+		BlockHash     string
+		Height        int
+		Confirmations int
+		Size          int
+		Coinbase      bool
+		Output        uint64
+		Fee           uint64
+		FeeKnown      bool
+		Graph         explorerGraph
+	}{
+		TxID:          txID.String(),
+		OutpointTxID:  outpointTxID.String(),
+		BlockHash:     blockHash,
+		Height:        height,
+		Confirmations: confirmations,
+		Size:          len(tx.Serialize()),
+		Coinbase:      tx.IsCoinbase(),
+		Output:        out,
+		Fee:           fee,
+		FeeKnown:      feeKnown,
+		Graph:         newExplorerGraph(inputs, outputs),
+	})
+}
+
+// transactionInBlock finds a transaction in the block with the given hex hash, which
+// works without a transaction index. The height is -1 for a side branch block.
+func (s *Server) transactionInBlock(txID d.Hash32, rawHash string) (d.Transaction, string, int, int, error) {
+	hash, err := d.ParseHash32(rawHash)
+	if err != nil {
+		return d.Transaction{}, "", -1, 0, err
+	}
+	b, err := s.bch.GetBlockByHash(hash)
+	if err != nil {
+		return d.Transaction{}, "", -1, 0, err
+	}
+	for _, tx := range b.Body.Transactions {
+		if tx.TxID != txID {
+			continue
+		}
+		height, err := s.bch.GetHeightByHash(hash)
+		if err != nil {
+			return tx, hash.String(), -1, 0, nil
+		}
+		return tx, hash.String(), height, s.bch.Len() - height, nil
+	}
+	return d.Transaction{}, "", -1, 0, d.ErrTxNotFound
+}
+
+// short abbreviates a hex hash for tables.
+func short(s string) string {
+	if len(s) <= 16 {
+		return s
+	}
+	return s[:16] + "…"
+}
+
+// explorerUTXO is an unspent output in an address page.
+type explorerUTXO struct {
+	TxID  string
+	Index uint32
+	Value uint32
+}
+
+// explorerHistoryRow is an entry of an address page's history.
+type explorerHistoryRow struct {
+	Height    int
+	BlockHash string
+	TxID      string
+	Credit    uint64
+	Debit     uint64
+	Balance   uint64
+}
+
+func (s *Server) handleExplorerAddress(w http.ResponseWriter, r *http.Request) {
+	address, err := d.ParsePublicAddress(r.PathValue("address"))
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "An address is 40 hex characters long.")
+		return
+	}
+	var utxos []explorerUTXO
+	for _, utxo := range s.bch.GetUTXOsForAddress(address) {
+		utxos = append(utxos, explorerUTXO{TxID: utxo.Outpoint.TxID.String(), Index: utxo.Outpoint.Index, Value: utxo.Value})
+	}
+	var history []explorerHistoryRow
+	entries, total, err := s.bch.AddressHistory(address, 0, explorerHistoryLength)
+	for _, e := range entries {
+		history = append(history, explorerHistoryRow{
+			Height:    e.Height,
+			BlockHash: e.BlockHash.String(),
+			TxID:      e.TxID.String(),
+			Credit:    e.Credit,
+			Debit:     e.Debit,
+			Balance:   e.Balance,
+		})
+	}
+	addressHex := hex.EncodeToString(address[:])
+	renderPage(w, http.StatusOK, "address", "Address "+addressHex, struct {
+		Address        string
+		Balance        uint32
+		UTXOs          []explorerUTXO
+		HistoryEnabled bool
+		History        []explorerHistoryRow
+		HistoryTotal   int
+	}{addressHex, s.bch.GetUserBalance(address), utxos, err == nil, history, total})
+}
+
+func (s *Server) handleExplorerRichList(w http.ResponseWriter, r *http.Request) {
+	type row struct {
+		Rank    int
+		Address string
+		Balance uint64
+		UTXOs   int
+		Share   float64
+	}
+	all := s.bch.RichList(0)
+	var supply uint64
+	for _, b := range all {
+		supply += b.Balance
+	}
+	var rows []row
+	for i, b := range all[:min(len(all), explorerRichListSize)] {
+		rows = append(rows, row{
+			Rank:    i + 1,
+			Address: hex.EncodeToString(b.Address[:]),
+			Balance: b.Balance,
+			UTXOs:   b.UTXOs,
+			Share:   100 * float64(b.Balance) / float64(supply),
+		})
+	}
+	renderPage(w, http.StatusOK, "richlist", "Rich list", struct {
+		Supply    uint64
+		Addresses int
+		Rows      []row
+	}{supply, len(all), rows})
+}
+
+// handleExplorerSearch redirects to the page of a block height, block hash,
+// transaction id or address.
+func (s *Server) handleExplorerSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	target := ""
+	if _, err := strconv.Atoi(q); err == nil {
+		target = "/block/" + q
+	} else if hash, err := d.ParseHash32(q); err == nil {
+		target = "/tx/" + q
+		if s.bch.HaveBlock(hash) {
+			target = "/block/" + q
+		}
+	} else if _, err := d.ParsePublicAddress(q); err == nil {
+		target = "/address/" + q
+	}
+	if target == "" {
+		renderError(w, http.StatusNotFound, "Search for a block height, a block hash, a transaction id or an address.")
+		return
+	}
+	http.Redirect(w, r, (&url.URL{Path: target}).String(), http.StatusSeeOther)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+func TestExplorer(t *testing.T) {
+	server, bch, users := setupServer(t, blockchain.WithAddressIndex())
+	tx := signedTransfer(t, bch, users)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	if err := bch.MineBlocks(context.Background(), 1, 0, 1, 10, users, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	tipHash, _ := bch.GetLatestBlockHash()
+	tip, _ := bch.GetLatestBlock()
+	coinbase := tip.Body.Transactions[0]
+	spent := hex.EncodeToString(users[0].PublicAddress[:])
+	missing := d.Hash32{1}
+
+	tests := []struct {
+		path   string
+		status int
+		want   []string
+	}{
+		{"/", http.StatusOK, []string{"Recent blocks", tipHash.String()}},
+		{"/block/1", http.StatusOK, []string{"Block 1", tx.TxID.String(), "Merkle tree", tip.Header.MerkleRoot.String()}},
+		{"/block/" + tipHash.String(), http.StatusOK, []string{"Block 1", "coinbase"}},
+		{"/block/2", http.StatusNotFound, []string{"no block at height 2"}},
+		{"/block/" + missing.String(), http.StatusNotFound, nil},
+		{"/block/xyz", http.StatusBadRequest, nil},
+		{"/tx/" + tx.TxID.String() + "?block=" + tipHash.String(), http.StatusOK, []string{"1 confirmations", "Fee", "/address/" + spent, "<svg"}},
+		{"/tx/" + coinbase.TxID.String() + "?block=" + tipHash.String(), http.StatusOK, []string{"Coinbase (new coins)"}},
+		{"/tx/" + tx.TxID.String(), http.StatusNotFound, []string{"--txindex"}},
+		{"/address/" + spent, http.StatusOK, []string{"Unspent outputs", tx.TxID.String()}},
+		{"/address/zz", http.StatusBadRequest, nil},
+		{"/richlist", http.StatusOK, []string{"Top ", spent}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.path, rec.Code, tt.status)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("GET %s Content-Type = %q, want HTML", tt.path, ct)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("GET %s does not contain %q", tt.path, want)
+			}
+		}
+	}
+
+	for query, want := range map[string]string{
+		"1":              "/block/1",
+		tipHash.String(): "/block/" + tipHash.String(),
+		tx.TxID.String(): "/tx/" + tx.TxID.String(),
+		spent:            "/address/" + spent,
+	} {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q="+query, nil))
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+			t.Errorf("search %q = %d %q, want a redirect to %s", query, rec.Code, rec.Header().Get("Location"), want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+// Error codes bitcoind returns for failures of the methods themselves.
+const (
+	RPCMiscError            = -1
+	RPCTypeError            = -3
+	RPCInvalidAddressOrKey  = -5
+	RPCInvalidParameter     = -8
+	RPCDeserializationError = -22
+	RPCVerifyError          = -25
+	RPCVerifyRejected       = -26
+)
+
+// RPCRequest is one JSON-RPC call. Params is an array of positional parameters or an
+// object of named ones. A JSON-RPC 2.0 request without an ID is a notification and
+// gets no response.
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// RPCError is the error object of a failed call.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func rpcErrorf(code int, format string, args ...any) *RPCError {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// RPCResponse is the reply to a JSON-RPC 2.0 request: exactly one of Result and Error is set.
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// legacyRPCResponse is the reply to a request that does not ask for JSON-RPC 2.0,
+// which, like bitcoind's JSON-RPC 1.0 replies, always carries result and error.
+type legacyRPCResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// rpcMethod describes a method: the names of its parameters, of which the first
+// required ones must be given, and the handler that runs it.
+type rpcMethod struct {
+	params   []string
+	required int
+	handler  func(s *Server, p rpcParams) (any, *RPCError)
+}
+
+// handleRPC serves JSON-RPC 2.0 requests and batches of them, along with the
+// JSON-RPC 1.0 requests older bitcoind clients send.
+func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, rpcReply(true, nil, nil, rpcErrorf(RPCParseError, "%v", err)))
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(w, http.StatusOK, rpcReply(true, nil, nil, rpcErrorf(RPCParseError, "Parse error")))
+			return
+		}
+		if len(batch) == 0 {
+			writeJSON(w, http.StatusOK, rpcReply(true, nil, nil, rpcErrorf(RPCInvalidRequest, "Empty batch")))
+			return
+		}
+		replies := make([]any, 0, len(batch))
+		for _, raw := range batch {
+			if reply, _ := s.serveRPC(raw); reply != nil {
+				replies = append(replies, reply)
+			}
+		}
+		if len(replies) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, replies)
+		return
+	}
+	reply, status := s.serveRPC(body)
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, status, reply)
+}
+
+// serveRPC runs one request and returns its reply, or nil for a notification, along
+// with the HTTP status the reply gets when it is not part of a batch.
+func (s *Server) serveRPC(raw json.RawMessage) (any, int) {
+	if !json.Valid(raw) {
+		return rpcReply(true, nil, nil, rpcErrorf(RPCParseError, "Parse error")), http.StatusOK
+	}
+	var req RPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return rpcReply(true, nil, nil, rpcErrorf(RPCInvalidRequest, "Invalid Request object")), http.StatusOK
+	}
+	v2 := req.JSONRPC == "2.0"
+	if v2 && req.ID == nil {
+		// Notifications are run for their effect only.
+		_, _ = s.callRPC(req)
+		return nil, http.StatusNoContent
+	}
+	if req.JSONRPC != "" && req.JSONRPC != "1.0" && !v2 {
+		return rpcReply(true, req.ID, nil, rpcErrorf(RPCInvalidRequest, "jsonrpc field must be \"1.0\" or \"2.0\"")), http.StatusOK
+	}
+	result, rpcErr := s.callRPC(req)
+	status := http.StatusOK
+	if !v2 && rpcErr != nil {
+		// bitcoind answers failed JSON-RPC 1.0 calls with an error status.
+		status = http.StatusInternalServerError
+		if rpcErr.Code == RPCMethodNotFound {
+			status = http.StatusNotFound
+		}
+	}
+	return rpcReply(v2, req.ID, result, rpcErr), status
+}
+
+func (s *Server) callRPC(req RPCRequest) (any, *RPCError) {
+	if req.Method == "" {
+		return nil, rpcErrorf(RPCInvalidRequest, "Missing method")
+	}
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		return nil, rpcErrorf(RPCMethodNotFound, "Method not found")
+	}
+	params, rpcErr := parseParams(method, req.Params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return method.handler(s, params)
+}
+
+func rpcReply(v2 bool, id json.RawMessage, result any, rpcErr *RPCError) any {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	var raw json.RawMessage
+	if rpcErr == nil {
+		var err error
+		if raw, err = json.Marshal(result); err != nil {
+			rpcErr = rpcErrorf(RPCInternalError, "%v", err)
+			raw = nil
+		}
+	}
+	if !v2 {
+		return legacyRPCResponse{Result: raw, Error: rpcErr, ID: id}
+	}
+	return RPCResponse{JSONRPC: "2.0", Result: raw, Error: rpcErr, ID: id}
+}
+
+// rpcParams holds a method's parameters in the order of its parameter names.
+type rpcParams struct {
+	names  []string
+	values []json.RawMessage
+}
+
+func parseParams(method rpcMethod, raw json.RawMessage) (rpcParams, *RPCError) {
+	p := rpcParams{names: method.params}
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+	case raw[0] == '[':
+		if err := json.Unmarshal(raw, &p.values); err != nil {
+			return p, rpcErrorf(RPCInvalidRequest, "Params must be an array or object")
+		}
+		if len(p.values) > len(method.params) {
+			return p, rpcErrorf(RPCInvalidParams, "Too many parameters: got %d, expected at most %d", len(p.values), len(method.params))
+		}
+	case raw[0] == '{':
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &named); err != nil {
+			return p, rpcErrorf(RPCInvalidRequest, "Params must be an array or object")
+		}
+		p.values = make([]json.RawMessage, len(method.params))
+		for i, name := range method.params {
+			if v, ok := named[name]; ok {
+				p.values[i] = v
+				delete(named, name)
+			}
+		}
+		for name := range named {
+			return p, rpcErrorf(RPCInvalidParameter, "Unknown named parameter %s", name)
+		}
+	default:
+		return p, rpcErrorf(RPCInvalidRequest, "Params must be an array or object")
+	}
+	for i := range method.required {
+		if !p.has(i) {
+			return p, rpcErrorf(RPCInvalidParams, "Missing required parameter %s", method.params[i])
+		}
+	}
+	return p, nil
+}
+
+// has reports whether parameter i was given a non-null value.
+func (p rpcParams) has(i int) bool {
+	return i < len(p.values) && p.values[i] != nil && !bytes.Equal(bytes.TrimSpace(p.values[i]), []byte("null"))
+}
+
+// decode stores parameter i in v and leaves v alone when the parameter was not given.
+func (p rpcParams) decode(i int, v any, typ string) *RPCError {
+	if !p.has(i) {
+		return nil
+	}
+	if err := json.Unmarshal(p.values[i], v); err != nil {
+		return rpcErrorf(RPCTypeError, "Expected type %s for %s", typ, p.names[i])
+	}
+	return nil
+}
+
+// verbosity decodes parameter i, which bitcoind accepts both as a boolean and as a number.
+func (p rpcParams) verbosity(i int, def int) (int, *RPCError) {
+	if !p.has(i) {
+		return def, nil
+	}
+	var flag bool
+	if json.Unmarshal(p.values[i], &flag) == nil {
+		if flag {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	var level int
+	if err := p.decode(i, &level, "number or boolean"); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
@@ -0,0 +1,451 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// networkHashrateBlocks is how many recent blocks getmininginfo estimates the network
+// hashrate over, the default of bitcoind's getnetworkhashps.
+const networkHashrateBlocks = 120
+
+// rpcMethods holds the methods served by handleRPC. They follow the names, parameters
+// and, as far as this chain has the data, the results of bitcoind's methods.
+var rpcMethods = map[string]rpcMethod{
+	"getblockcount":        {handler: rpcGetBlockCount},
+	"getbestblockhash":     {handler: rpcGetBestBlockHash},
+	"getblockhash":         {params: []string{"height"}, required: 1, handler: rpcGetBlockHash},
+	"getblock":             {params: []string{"blockhash", "verbosity"}, required: 1, handler: rpcGetBlock},
+	"getblockheader":       {params: []string{"blockhash", "verbose"}, required: 1, handler: rpcGetBlockHeader},
+	"getblocktransactions": {params: []string{"blockhash"}, required: 1, handler: rpcGetBlockTransactions},
+	"getrawtransaction":    {params: []string{"txid", "verbose", "blockhash"}, required: 1, handler: rpcGetRawTransaction},
+	"gettransaction":       {params: []string{"txid"}, required: 1, handler: rpcGetTransaction},
+	"sendrawtransaction":   {params: []string{"hexstring"}, required: 1, handler: rpcSendRawTransaction},
+	"gettxout":             {params: []string{"txid", "n", "include_mempool"}, required: 2, handler: rpcGetTxOut},
+	"getmempoolinfo":       {handler: rpcGetMempoolInfo},
+	"getmininginfo":        {handler: rpcGetMiningInfo},
+}
+
+// RPCHeaderResponse is the verbose result of getblockheader.
+type RPCHeaderResponse struct {
+	Hash string `json:"hash"`
+	// Confirmations is -1 for blocks on a side branch.
+	Confirmations     int    `json:"confirmations"`
+	Height            int    `json:"height"`
+	Version           uint32 `json:"version"`
+	MerkleRoot        string `json:"merkleroot"`
+	Time              uint32 `json:"time"`
+	Nonce             uint32 `json:"nonce"`
+	Difficulty        uint32 `json:"difficulty"`
+	TxCount           int    `json:"nTx"`
+	PreviousBlockHash string `json:"previousblockhash,omitempty"`
+	NextBlockHash     string `json:"nextblockhash,omitempty"`
+}
+
+// RPCBlockResponse is the verbose result of getblock. Tx holds transaction ids at
+// verbosity 1 and RPCTransactionResponse objects at verbosity 2.
+type RPCBlockResponse struct {
+	RPCHeaderResponse
+	Size int `json:"size"`
+	Tx   any `json:"tx"`
+}
+
+// RPCTransactionResponse is the verbose result of getrawtransaction and the result
+// of gettransaction. The block fields are set for transactions found in a block;
+// BlockIndex is the position of the transaction in it.
+type RPCTransactionResponse struct {
+	TxID          string    `json:"txid"`
+	Size          int       `json:"size"`
+	Vin           []RPCVin  `json:"vin"`
+	Vout          []RPCVout `json:"vout"`
+	Hex           string    `json:"hex"`
+	BlockHash     string    `json:"blockhash,omitempty"`
+	BlockHeight   *int      `json:"blockheight,omitempty"`
+	BlockIndex    *int      `json:"blockindex,omitempty"`
+	Confirmations int       `json:"confirmations,omitempty"`
+	Time          uint32    `json:"time,omitempty"`
+}
+
+// RPCVin is a transaction input. Coinbase inputs carry their data instead of an outpoint.
+type RPCVin struct {
+	Coinbase string  `json:"coinbase,omitempty"`
+	TxID     string  `json:"txid,omitempty"`
+	Vout     *uint32 `json:"vout,omitempty"`
+}
+
+type RPCVout struct {
+	Value   uint32 `json:"value"`
+	N       int    `json:"n"`
+	Address string `json:"address"`
+}
+
+// RPCTxOutResponse is the result of gettxout.
+type RPCTxOutResponse struct {
+	BestBlock string `json:"bestblock"`
+	Value     uint32 `json:"value"`
+	Address   string `json:"address"`
+}
+
+type MempoolInfoResponse struct {
+	Loaded bool `json:"loaded"`
+	Size   int  `json:"size"`
+	Bytes  int  `json:"bytes"`
+}
+
+// MiningInfoResponse is the result of getmininginfo. LocalHashPS is the hashrate of
+// this node's own miner, NetworkHashPS an estimate from the recent blocks.
+type MiningInfoResponse struct {
+	Blocks        int     `json:"blocks"`
+	Difficulty    uint32  `json:"difficulty"`
+	NetworkHashPS float64 `json:"networkhashps"`
+	LocalHashPS   float64 `json:"localhashps"`
+	PooledTx      int     `json:"pooledtx"`
+}
+
+func NewRPCTransactionResponse(tx d.Transaction) RPCTransactionResponse {
+	raw := tx.Serialize()
+	resp := RPCTransactionResponse{
+		TxID: tx.TxID.String(),
+		Size: len(raw),
+		Vin:  make([]RPCVin, len(tx.Inputs)),
+		Vout: make([]RPCVout, len(tx.Outputs)),
+		Hex:  hex.EncodeToString(raw),
+	}
+	for i, in := range tx.Inputs {
+		if in.Prev.Index == d.CoinbaseIndex {
+			resp.Vin[i] = RPCVin{Coinbase: in.Prev.TxID.String()}
+			continue
+		}
+		index := in.Prev.Index
+		resp.Vin[i] = RPCVin{TxID: in.Prev.TxID.String(), Vout: &index}
+	}
+	for i, out := range tx.Outputs {
+		resp.Vout[i] = RPCVout{Value: out.Value, N: i, Address: hex.EncodeToString(out.To[:])}
+	}
+	return resp
+}
+
+// rpcBlock is a block found by hash or height together with where it is.
+type rpcBlock struct {
+	block  d.Block
+	hash   d.Hash32
+	height int
+	main   bool
+}
+
+// locateBlock finds a block by hash, or by main chain height when parameter i is a
+// number, and works out where it is. The height of a side branch block is found by
+// following its parents back to the main chain.
+func (s *Server) locateBlock(p rpcParams, i int) (rpcBlock, *RPCError) {
+	var hash d.Hash32
+	var height int
+	if p.has(i) && json.Unmarshal(p.values[i], &height) == nil {
+		var err error
+		if hash, err = s.bch.GetBlockHash(height); err != nil {
+			return rpcBlock{}, rpcErrorf(RPCInvalidParameter, "Block height out of range")
+		}
+	} else {
+		var rpcErr *RPCError
+		if hash, rpcErr = hashParam(p, i); rpcErr != nil {
+			return rpcBlock{}, rpcErr
+		}
+	}
+	b, err := s.bch.GetBlockByHash(hash)
+	if errors.Is(err, d.ErrBlockPruned) {
+		return rpcBlock{}, rpcErrorf(RPCMiscError, "Block not available (pruned data)")
+	}
+	if errors.Is(err, d.ErrBlockDataUnavailable) {
+		return rpcBlock{}, rpcErrorf(RPCMiscError, "Block not available")
+	}
+	if err != nil {
+		return rpcBlock{}, rpcErrorf(RPCInvalidAddressOrKey, "Block not found")
+	}
+	if height, err := s.bch.GetHeightByHash(hash); err == nil {
+		return rpcBlock{block: b, hash: hash, height: height, main: true}, nil
+	}
+	height = 1
+	for prev := b.Header.PrevHash; ; height++ {
+		if forkHeight, err := s.bch.GetHeightByHash(prev); err == nil {
+			height += forkHeight
+			break
+		}
+		parent, err := s.bch.GetBlockByHash(prev)
+		if err != nil {
+			break
+		}
+		prev = parent.Header.PrevHash
+	}
+	return rpcBlock{block: b, hash: hash, height: height}, nil
+}
+
+func (s *Server) headerResponse(rb rpcBlock) RPCHeaderResponse {
+	h := rb.block.Header
+	resp := RPCHeaderResponse{
+		Hash:          rb.hash.String(),
+		Confirmations: -1,
+		Height:        rb.height,
+		Version:       h.Version,
+		MerkleRoot:    h.MerkleRoot.String(),
+		Time:          h.Timestamp,
+		Nonce:         h.Nonce,
+		Difficulty:    h.Difficulty,
+		TxCount:       len(rb.block.Body.Transactions),
+	}
+	if rb.height > 0 {
+		resp.PreviousBlockHash = h.PrevHash.String()
+	}
+	if rb.main {
+		resp.Confirmations = s.bch.Len() - rb.height
+		if nextHash, err := s.bch.GetBlockHash(rb.height + 1); err == nil {
+			resp.NextBlockHash = nextHash.String()
+		}
+	}
+	return resp
+}
+
+func rpcGetBlockCount(s *Server, _ rpcParams) (any, *RPCError) {
+	return s.bch.Len() - 1, nil
+}
+
+func rpcGetBestBlockHash(s *Server, _ rpcParams) (any, *RPCError) {
+	hash, err := s.bch.GetLatestBlockHash()
+	if err != nil {
+		return nil, rpcErrorf(RPCMiscError, "%v", err)
+	}
+	return hash.String(), nil
+}
+
+func rpcGetBlockHash(s *Server, p rpcParams) (any, *RPCError) {
+	var height int
+	if err := p.decode(0, &height, "number"); err != nil {
+		return nil, err
+	}
+	hash, err := s.bch.GetBlockHash(height)
+	if err != nil {
+		return nil, rpcErrorf(RPCInvalidParameter, "Block height out of range")
+	}
+	return hash.String(), nil
+}
+
+func rpcGetBlock(s *Server, p rpcParams) (any, *RPCError) {
+	rb, rpcErr := s.locateBlock(p, 0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	verbosity, rpcErr := p.verbosity(1, 1)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	raw := rb.block.Serialize()
+	resp := RPCBlockResponse{RPCHeaderResponse: s.headerResponse(rb), Size: len(raw)}
+	switch verbosity {
+	case 0:
+		return hex.EncodeToString(raw), nil
+	case 1:
+		ids := make([]string, len(rb.block.Body.Transactions))
+		for i, tx := range rb.block.Body.Transactions {
+			ids[i] = tx.TxID.String()
+		}
+		resp.Tx = ids
+	case 2:
+		resp.Tx = blockTransactions(rb.block)
+	default:
+		return nil, rpcErrorf(RPCInvalidParameter, "verbosity must be 0, 1 or 2")
+	}
+	return resp, nil
+}
+
+func rpcGetBlockHeader(s *Server, p rpcParams) (any, *RPCError) {
+	rb, rpcErr := s.locateBlock(p, 0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	verbose := true
+	if err := p.decode(1, &verbose, "boolean"); err != nil {
+		return nil, err
+	}
+	if !verbose {
+		return hex.EncodeToString(rb.block.Header.Serialize()), nil
+	}
+	return s.headerResponse(rb), nil
+}
+
+func rpcGetBlockTransactions(s *Server, p rpcParams) (any, *RPCError) {
+	rb, rpcErr := s.locateBlock(p, 0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return blockTransactions(rb.block), nil
+}
+
+func blockTransactions(b d.Block) []RPCTransactionResponse {
+	txs := make([]RPCTransactionResponse, len(b.Body.Transactions))
+	for i, tx := range b.Body.Transactions {
+		txs[i] = NewRPCTransactionResponse(tx)
+	}
+	return txs
+}
+
+// rpcGetRawTransaction finds a transaction in the mempool, on the main chain when
+// the node keeps a transaction index or, when blockhash is given, in that block.
+func rpcGetRawTransaction(s *Server, p rpcParams) (any, *RPCError) {
+	txID, rpcErr := hashParam(p, 0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	verbosity, rpcErr := p.verbosity(1, 0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	var st blockchain.TxStatus
+	if p.has(2) {
+		rb, rpcErr := s.locateBlock(p, 2)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		position := slices.IndexFunc(rb.block.Body.Transactions, func(tx d.Transaction) bool { return tx.TxID == txID })
+		if position < 0 {
+			return nil, rpcErrorf(RPCInvalidAddressOrKey, "No such transaction found in the provided block")
+		}
+		st.Tx = rb.block.Body.Transactions[position]
+		st.Block = &blockchain.TxLocation{BlockHash: rb.hash, Height: rb.height, Position: position}
+		if rb.main {
+			st.Confirmations = s.bch.Len() - rb.height
+		}
+	} else if st, rpcErr = s.findTransaction(txID); rpcErr != nil {
+		return nil, rpcErr
+	}
+	if verbosity == 0 {
+		return hex.EncodeToString(st.Tx.Serialize()), nil
+	}
+	return s.transactionResponse(st), nil
+}
+
+// rpcGetTransaction finds a transaction in the mempool or, with a transaction index,
+// on the main chain.
+func rpcGetTransaction(s *Server, p rpcParams) (any, *RPCError) {
+	txID, rpcErr := hashParam(p, 0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	st, rpcErr := s.findTransaction(txID)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return s.transactionResponse(st), nil
+}
+
+func (s *Server) findTransaction(txID d.Hash32) (blockchain.TxStatus, *RPCError) {
+	st, err := s.bch.GetTransaction(txID)
+	switch {
+	case errors.Is(err, blockchain.ErrTxIndexDisabled):
+		return st, rpcErrorf(RPCInvalidAddressOrKey, "No such mempool transaction. Use --txindex or provide a block hash")
+	case err != nil:
+		return st, rpcErrorf(RPCInvalidAddressOrKey, "No such mempool or blockchain transaction")
+	}
+	return st, nil
+}
+
+func (s *Server) transactionResponse(st blockchain.TxStatus) RPCTransactionResponse {
+	resp := NewRPCTransactionResponse(st.Tx)
+	if loc := st.Block; loc != nil {
+		resp.BlockHash = loc.BlockHash.String()
+		resp.BlockHeight = &loc.Height
+		resp.BlockIndex = &loc.Position
+		resp.Confirmations = st.Confirmations
+		if b, err := s.bch.GetBlockByHash(loc.BlockHash); err == nil {
+			resp.Time = b.Header.Timestamp
+		}
+	}
+	return resp
+}
+
+func rpcSendRawTransaction(s *Server, p rpcParams) (any, *RPCError) {
+	var rawHex string
+	if err := p.decode(0, &rawHex, "string"); err != nil {
+		return nil, err
+	}
+	tx, err := DecodeRawTransaction(rawHex, s.bch)
+	if err != nil {
+		return nil, rpcErrorf(RPCDeserializationError, "TX decode failed: %v", err)
+	}
+	err = s.bch.SubmitTransaction(tx)
+	switch {
+	case err == nil:
+		slog.Info("Accepted transaction into mempool", "txid", tx.TxID.String(), "inputs", len(tx.Inputs), "outputs", len(tx.Outputs))
+	case errors.Is(err, d.ErrTxInMempool):
+		// Like bitcoind, resubmitting a pending transaction is not an error.
+	case errors.Is(err, d.ErrUTXONotFound):
+		return nil, rpcErrorf(RPCVerifyError, "bad-txns-inputs-missingorspent: %v", err)
+	case errors.Is(err, d.ErrDoubleSpend):
+		return nil, rpcErrorf(RPCVerifyRejected, "txn-mempool-conflict: %v", err)
+	default:
+		return nil, rpcErrorf(RPCVerifyRejected, "%v", err)
+	}
+	return tx.TxID.String(), nil
+}
+
+// rpcGetTxOut returns an unspent output, or null when it is spent or unknown. Outputs
+// are named by the outpoint ids of the UTXO set. Unless include_mempool is false,
+// outputs spent by a mempool transaction count as spent.
+func rpcGetTxOut(s *Server, p rpcParams) (any, *RPCError) {
+	txID, rpcErr := hashParam(p, 0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	var index uint32
+	if err := p.decode(1, &index, "number"); err != nil {
+		return nil, err
+	}
+	includeMempool := true
+	if err := p.decode(2, &includeMempool, "boolean"); err != nil {
+		return nil, err
+	}
+	outpoint := d.Outpoint{TxID: txID, Index: index}
+	utxo, ok := s.bch.GetUTXO(outpoint)
+	if !ok || includeMempool && s.bch.Mempool().IsSpent(outpoint) {
+		return nil, nil
+	}
+	best, err := s.bch.GetLatestBlockHash()
+	if err != nil {
+		return nil, rpcErrorf(RPCMiscError, "%v", err)
+	}
+	return RPCTxOutResponse{BestBlock: best.String(), Value: utxo.Value, Address: hex.EncodeToString(utxo.To[:])}, nil
+}
+
+func rpcGetMempoolInfo(s *Server, _ rpcParams) (any, *RPCError) {
+	mempool := s.bch.Mempool()
+	return MempoolInfoResponse{Loaded: true, Size: mempool.Len(), Bytes: mempool.Bytes()}, nil
+}
+
+func rpcGetMiningInfo(s *Server, _ rpcParams) (any, *RPCError) {
+	tip, err := s.bch.GetLatestBlock()
+	if err != nil {
+		return nil, rpcErrorf(RPCMiscError, "%v", err)
+	}
+	return MiningInfoResponse{
+		Blocks:        s.bch.Len() - 1,
+		Difficulty:    tip.Header.Difficulty,
+		NetworkHashPS: s.bch.NetworkHashrate(networkHashrateBlocks),
+		LocalHashPS:   s.bch.MiningStats().Hashrate(),
+		PooledTx:      s.bch.Mempool().Len(),
+	}, nil
+}
+
+func hashParam(p rpcParams, i int) (d.Hash32, *RPCError) {
+	var hexHash string
+	if err := p.decode(i, &hexHash, "string"); err != nil {
+		return d.Hash32{}, err
+	}
+	hash, err := d.ParseHash32(hexHash)
+	if err != nil {
+		return d.Hash32{}, rpcErrorf(RPCInvalidParameter, "%s must be a 64 character hex string", p.names[i])
+	}
+	return hash, nil
+}
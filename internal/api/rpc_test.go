@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// postRPC sends body to the JSON-RPC endpoint.
+func postRPC(t *testing.T, server *Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return rec
+}
+
+// callRPC makes a JSON-RPC 2.0 call and decodes its result into result, if any.
+func callRPC(t *testing.T, server *Server, method string, params string, result any) *RPCError {
+	t.Helper()
+	rec := postRPC(t, server, fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":%q,"params":%s}`, method, params))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s status = %d, want %d: %s", method, rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp RPCResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("%s decode error = %v", method, err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			t.Fatalf("%s result %s: %v", method, resp.Result, err)
+		}
+	}
+	return nil
+}
+
+func TestRPC_Batch(t *testing.T) {
+	server, bch, _ := setupServer(t)
+	genesis, _ := bch.GetBlock(0)
+	genesisHash := bch.CalculateHash(genesis)
+
+	rec := postRPC(t, server, `[
+		{"jsonrpc":"2.0","id":1,"method":"getblockcount"},
+		{"jsonrpc":"2.0","id":"two","method":"getblockhash","params":{"height":0}},
+		{"jsonrpc":"2.0","method":"getblockcount"},
+		{"jsonrpc":"2.0","id":3,"method":"nosuchmethod"},
+		{"jsonrpc":"2.0","id":4,"method":"getblockhash","params":["zero"]},
+		{"jsonrpc":"2.0","id":5,"method":"getblockhash","params":[7]},
+		{"jsonrpc":"2.0","id":6,"method":"getblockhash"},
+		{"jsonrpc":"2.0","id":7,"method":"getblockhash","params":{"height":0,"verbose":true}},
+		42
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got []RPCResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	want := []struct {
+		id     string
+		result string
+		code   int
+	}{
+		{id: `1`, result: `0`},
+		{id: `"two"`, result: fmt.Sprintf("%q", genesisHash.String())},
+		{id: `3`, code: RPCMethodNotFound},
+		{id: `4`, code: RPCTypeError},
+		{id: `5`, code: RPCInvalidParameter},
+		{id: `6`, code: RPCInvalidParams},
+		{id: `7`, code: RPCInvalidParameter},
+		{id: `null`, code: RPCInvalidRequest},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d responses, want %d without the notification: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		r := got[i]
+		if r.JSONRPC != "2.0" || string(r.ID) != w.id {
+			t.Errorf("response %d = %+v, want version 2.0 and id %s", i, r, w.id)
+		}
+		if w.code != 0 {
+			if r.Error == nil || r.Error.Code != w.code || r.Result != nil {
+				t.Errorf("response %d error = %v, result %s; want code %d", i, r.Error, r.Result, w.code)
+			}
+		} else if r.Error != nil || string(r.Result) != w.result {
+			t.Errorf("response %d = %s, %v; want %s", i, r.Result, r.Error, w.result)
+		}
+	}
+
+	for body, code := range map[string]int{`[]`: RPCInvalidRequest, `{"jsonrpc":"2.0",`: RPCParseError} {
+		var resp RPCResponse
+		if err := json.NewDecoder(postRPC(t, server, body).Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: decode error = %v", body, err)
+		}
+		if resp.Error == nil || resp.Error.Code != code || string(resp.ID) != "null" {
+			t.Errorf("%s: response = %+v, want error %d", body, resp, code)
+		}
+	}
+
+	if rec := postRPC(t, server, `[{"jsonrpc":"2.0","method":"getblockcount"}]`); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("notification batch = %d %q, want an empty %d", rec.Code, rec.Body.String(), http.StatusNoContent)
+	}
+}
+
+func TestRPC_Legacy(t *testing.T) {
+	server, _, _ := setupServer(t)
+
+	rec := postRPC(t, server, `{"jsonrpc":"1.0","id":"cli","method":"getblockcount","params":[]}`)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"result":0,"error":null,"id":"cli"}` {
+		t.Errorf("legacy call = %d %s", rec.Code, rec.Body.String())
+	}
+	rec = postRPC(t, server, `{"id":"cli","method":"getblocks"}`)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), `"result":null,"error":{"code":-32601`) {
+		t.Errorf("legacy unknown method = %d %s, want 404 with a null result", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRPC_Blocks(t *testing.T) {
+	server, bch, users := setupServer(t)
+	if err := bch.MineBlocks(context.Background(), 1, 1, 1, 10, users, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	genesis, _ := bch.GetBlock(0)
+	genesisHash := bch.CalculateHash(genesis)
+	tip, _ := bch.GetLatestBlock()
+	tipHash := bch.CalculateHash(tip)
+
+	var best string
+	if err := callRPC(t, server, "getbestblockhash", `[]`, &best); err != nil || best != tipHash.String() {
+		t.Errorf("getbestblockhash = %s, %v; want %s", best, err, tipHash.String())
+	}
+
+	var raw string
+	if err := callRPC(t, server, "getblock", fmt.Sprintf(`[%q, 0]`, tipHash.String()), &raw); err != nil {
+		t.Fatalf("getblock verbosity 0 error = %v", err)
+	}
+	if raw != hex.EncodeToString(tip.Serialize()) {
+		t.Errorf("getblock verbosity 0 = %s, want the serialized tip", raw)
+	}
+
+	var header RPCHeaderResponse
+	if err := callRPC(t, server, "getblockheader", fmt.Sprintf(`[%q]`, genesisHash.String()), &header); err != nil {
+		t.Fatalf("getblockheader error = %v", err)
+	}
+	if header.Height != 0 || header.Confirmations != 2 || header.PreviousBlockHash != "" || header.NextBlockHash != tipHash.String() {
+		t.Errorf("getblockheader(genesis) = %+v", header)
+	}
+	if err := callRPC(t, server, "getblockheader", fmt.Sprintf(`{"blockhash":%q,"verbose":false}`, tipHash.String()), &raw); err != nil || raw != hex.EncodeToString(tip.Header.Serialize()) {
+		t.Errorf("getblockheader non-verbose = %s, %v", raw, err)
+	}
+
+	var block struct {
+		RPCHeaderResponse
+		Tx []string `json:"tx"`
+	}
+	if err := callRPC(t, server, "getblock", fmt.Sprintf(`[%q, true]`, tipHash.String()), &block); err != nil {
+		t.Fatalf("getblock error = %v", err)
+	}
+	if block.Height != 1 || block.Confirmations != 1 || block.PreviousBlockHash != genesisHash.String() || len(block.Tx) != len(tip.Body.Transactions) {
+		t.Errorf("getblock(tip) = %+v", block)
+	}
+	for i, id := range block.Tx {
+		if id != tip.Body.Transactions[i].TxID.String() {
+			t.Errorf("getblock tx %d = %s, want %x", i, id, tip.Body.Transactions[i].TxID)
+		}
+	}
+
+	var txs []RPCTransactionResponse
+	if err := callRPC(t, server, "getblocktransactions", fmt.Sprintf(`[%q]`, tipHash.String()), &txs); err != nil {
+		t.Fatalf("getblocktransactions error = %v", err)
+	}
+	if len(txs) != len(tip.Body.Transactions) || txs[0].Vin[0].Coinbase == "" || txs[1].Vin[0].Vout == nil {
+		t.Errorf("getblocktransactions = %+v, want a coinbase and a spend", txs)
+	}
+
+	missing := d.Hash32{1}
+	if err := callRPC(t, server, "getblock", fmt.Sprintf(`[%q]`, missing.String()), nil); err == nil || err.Code != RPCInvalidAddressOrKey {
+		t.Errorf("getblock(unknown) error = %v, want code %d", err, RPCInvalidAddressOrKey)
+	}
+	if err := callRPC(t, server, "getblock", fmt.Sprintf(`[%q, 3]`, tipHash.String()), nil); err == nil || err.Code != RPCInvalidParameter {
+		t.Errorf("getblock verbosity 3 error = %v, want code %d", err, RPCInvalidParameter)
+	}
+
+	if err := callRPC(t, server, "getblockheader", `[1]`, &header); err != nil || header.Hash != tipHash.String() || header.Height != 1 {
+		t.Errorf("getblockheader(1) = %+v, %v; want the tip", header, err)
+	}
+	if err := callRPC(t, server, "getblock", `[2]`, nil); err == nil || err.Code != RPCInvalidParameter {
+		t.Errorf("getblock(2) error = %v, want code %d", err, RPCInvalidParameter)
+	}
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 5, To: users[0].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	body := d.Body{Transactions: []d.Transaction{coinbase}}
+	side := d.Block{Header: d.Header{Version: 1, Timestamp: tip.Header.Timestamp, PrevHash: genesisHash, MerkleRoot: blockchain.MerkleRootHash(body, c.NewArchasHasher()), Difficulty: 1}, Body: body}
+	if _, _, err := blockchain.FindValidNonce(context.Background(), &side.Header, c.NewArchasHasher()); err != nil {
+		t.Fatalf("FindValidNonce() error = %v", err)
+	}
+	if status, err := bch.ProcessBlock(side); err != nil || status != blockchain.BlockSideChain {
+		t.Fatalf("ProcessBlock(side) = %v, %v; want side chain", status, err)
+	}
+	sideHash := bch.CalculateHash(side)
+	var sideHeader RPCHeaderResponse
+	if err := callRPC(t, server, "getblockheader", fmt.Sprintf(`[%q]`, sideHash.String()), &sideHeader); err != nil || sideHeader.Height != 1 || sideHeader.Confirmations != -1 || sideHeader.NextBlockHash != "" {
+		t.Errorf("getblockheader(side) = %+v, %v; want a side block at height 1", sideHeader, err)
+	}
+}
+
+func TestRPC_Transactions(t *testing.T) {
+	server, bch, users := setupServer(t)
+	tx := signedTransfer(t, bch, users)
+	spent := tx.Inputs[0].Prev
+	rawTx := hex.EncodeToString(tx.Serialize())
+
+	for range 2 {
+		var txID string
+		if err := callRPC(t, server, "sendrawtransaction", fmt.Sprintf(`[%q]`, rawTx), &txID); err != nil || txID != tx.TxID.String() {
+			t.Fatalf("sendrawtransaction = %s, %v; want %x", txID, err, tx.TxID)
+		}
+	}
+	if err := callRPC(t, server, "sendrawtransaction", `["00ff"]`, nil); err == nil || err.Code != RPCDeserializationError {
+		t.Errorf("sendrawtransaction(garbage) error = %v, want code %d", err, RPCDeserializationError)
+	}
+
+	var info MempoolInfoResponse
+	if err := callRPC(t, server, "getmempoolinfo", `[]`, &info); err != nil || info.Size != 1 || info.Bytes != len(tx.Serialize()) {
+		t.Errorf("getmempoolinfo = %+v, %v", info, err)
+	}
+
+	var out *RPCTxOutResponse
+	outParams := fmt.Sprintf(`[%q, %d]`, spent.TxID.String(), spent.Index)
+	if err := callRPC(t, server, "gettxout", outParams, &out); err != nil || out != nil {
+		t.Errorf("gettxout of an output spent in the mempool = %+v, %v; want null", out, err)
+	}
+	outParams = fmt.Sprintf(`[%q, %d, false]`, spent.TxID.String(), spent.Index)
+	if err := callRPC(t, server, "gettxout", outParams, &out); err != nil || out == nil || out.Address != hex.EncodeToString(users[0].PublicAddress[:]) {
+		t.Errorf("gettxout ignoring the mempool = %+v, %v", out, err)
+	}
+
+	var verbose RPCTransactionResponse
+	if err := callRPC(t, server, "getrawtransaction", fmt.Sprintf(`[%q, true]`, tx.TxID.String()), &verbose); err != nil {
+		t.Fatalf("getrawtransaction error = %v", err)
+	}
+	if verbose.Hex != rawTx || verbose.BlockHash != "" || len(verbose.Vout) != 1 {
+		t.Errorf("getrawtransaction(mempool) = %+v", verbose)
+	}
+
+	if err := bch.MineBlocks(context.Background(), 1, 0, 1, 10, nil, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	tip, _ := bch.GetLatestBlock()
+	tipHash := bch.CalculateHash(tip)
+	if err := callRPC(t, server, "getrawtransaction", fmt.Sprintf(`[%q]`, tx.TxID.String()), nil); err == nil || err.Code != RPCInvalidAddressOrKey {
+		t.Errorf("getrawtransaction of a mined tx without block hash error = %v, want code %d", err, RPCInvalidAddressOrKey)
+	}
+	params := fmt.Sprintf(`{"txid":%q,"verbose":1,"blockhash":%q}`, tx.TxID.String(), tipHash.String())
+	if err := callRPC(t, server, "getrawtransaction", params, &verbose); err != nil {
+		t.Fatalf("getrawtransaction in block error = %v", err)
+	}
+	if verbose.BlockHash != tipHash.String() || verbose.Confirmations != 1 || verbose.Time != tip.Header.Timestamp {
+		t.Errorf("getrawtransaction(block) = %+v", verbose)
+	}
+
+	if err := callRPC(t, server, "sendrawtransaction", fmt.Sprintf(`[%q]`, rawTx), nil); err == nil || err.Code != RPCVerifyError {
+		t.Errorf("sendrawtransaction of a mined tx error = %v, want code %d", err, RPCVerifyError)
+	}
+
+	var mining MiningInfoResponse
+	if err := callRPC(t, server, "getmininginfo", `[]`, &mining); err != nil || mining.Blocks != 1 || mining.Difficulty != tip.Header.Difficulty || mining.PooledTx != 0 {
+		t.Errorf("getmininginfo = %+v, %v", mining, err)
+	}
+}
@@ -0,0 +1,404 @@
+// Package api serves the node's HTTP interface.
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/Quikmove/blockchain-uzd2/internal/metrics"
+)
+
+// maxRequestBody bounds the size of request bodies accepted by the server.
+const maxRequestBody = 1 << 20
+
+// Page sizes of /api/address/{address}/history.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+type Server struct {
+	bch      *blockchain.Blockchain
+	mux      *http.ServeMux
+	registry *metrics.Registry
+	// streamCtx is cancelled when the server shuts down, which closes the WebSocket
+	// streams that http.Server.Shutdown does not track.
+	streamCtx     context.Context
+	cancelStreams context.CancelFunc
+}
+
+func NewServer(bch *blockchain.Blockchain) *Server {
+	streamCtx, cancelStreams := context.WithCancel(context.Background())
+	s := &Server{
+		bch:           bch,
+		mux:           http.NewServeMux(),
+		registry:      metrics.NewRegistry(),
+		streamCtx:     streamCtx,
+		cancelStreams: cancelStreams,
+	}
+	bch.RegisterMetrics(s.registry)
+	s.mux.Handle("GET /metrics", s.registry.Handler())
+	s.mux.HandleFunc("GET /api/utxo/{txid}/{index}", s.handleGetUTXO)
+	s.mux.HandleFunc("GET /api/address/{address}/utxos", s.handleGetAddressUTXOs)
+	s.mux.HandleFunc("GET /api/address/{address}/history", s.handleGetAddressHistory)
+	s.mux.HandleFunc("POST /api/tx", s.handleSendTransaction)
+	s.mux.HandleFunc("GET /api/tx/{txid}", s.handleGetTransaction)
+	s.mux.HandleFunc("GET /api/mempool", s.handleGetMempool)
+	s.mux.HandleFunc("GET /api/mining", s.handleGetMining)
+	s.mux.HandleFunc("GET /api/ws", s.handleStream)
+	s.mux.HandleFunc("POST /{$}", s.handleRPC)
+	s.registerExplorer()
+	return s
+}
+
+// Registry returns the registry served on /metrics, for other components of the node
+// to add their metrics to.
+func (s *Server) Registry() *metrics.Registry {
+	return s.registry
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe serves on addr until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	srv.RegisterOnShutdown(s.cancelStreams)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// UTXOResponse is the JSON form of an unspent output.
+type UTXOResponse struct {
+	TxID    string `json:"txid"`
+	Index   uint32 `json:"index"`
+	Value   uint32 `json:"value"`
+	Address string `json:"address"`
+}
+
+// AddressHistoryResponse is one page of an address's history, newest entries first.
+// Total is the number of entries in the whole history.
+type AddressHistoryResponse struct {
+	Address string                 `json:"address"`
+	Total   int                    `json:"total"`
+	Offset  int                    `json:"offset"`
+	Limit   int                    `json:"limit"`
+	Entries []AddressEntryResponse `json:"entries"`
+}
+
+// AddressEntryResponse is a transaction that paid (credit) or spent from (debit) an
+// address, with the address's confirmed balance after it.
+type AddressEntryResponse struct {
+	Height    int    `json:"height"`
+	BlockHash string `json:"blockHash"`
+	TxID      string `json:"txid"`
+	Credit    uint64 `json:"credit"`
+	Debit     uint64 `json:"debit"`
+	Balance   uint64 `json:"balance"`
+}
+
+// SendTransactionRequest carries a hex encoded transaction in the format of Transaction.Serialize.
+type SendTransactionRequest struct {
+	Hex string `json:"hex"`
+}
+
+type SendTransactionResponse struct {
+	TxID string `json:"txid"`
+}
+
+// TransactionStatusResponse tells whether a transaction is confirmed. Block is
+// omitted while the transaction waits in the mempool.
+type TransactionStatusResponse struct {
+	TxID          string              `json:"txid"`
+	Confirmations int                 `json:"confirmations"`
+	Block         *TxBlockResponse    `json:"block,omitempty"`
+	Tx            TransactionResponse `json:"tx"`
+}
+
+// TxBlockResponse is the block a transaction is in and its position in the block.
+type TxBlockResponse struct {
+	Hash     string `json:"hash"`
+	Height   int    `json:"height"`
+	Position int    `json:"position"`
+}
+
+// MiningResponse is the JSON form of the node's mining telemetry. Times are in seconds.
+type MiningResponse struct {
+	Blocks           int                  `json:"blocks"`
+	StaleRounds      int                  `json:"staleRounds"`
+	CancelledRounds  int                  `json:"cancelledRounds"`
+	Hashes           uint64               `json:"hashes"`
+	WastedHashes     uint64               `json:"wastedHashes"`
+	Hashrate         float64              `json:"hashrate"`
+	MiningTime       float64              `json:"miningTime"`
+	AverageBlockTime float64              `json:"averageBlockTime"`
+	Workers          []WorkerResponse     `json:"workers"`
+	Last             *MiningEventResponse `json:"last,omitempty"`
+}
+
+type WorkerResponse struct {
+	Hashes   uint64  `json:"hashes"`
+	Hashrate float64 `json:"hashrate"`
+}
+
+type MiningEventResponse struct {
+	Kind     string  `json:"kind"`
+	Height   int     `json:"height"`
+	Hash     string  `json:"hash,omitempty"`
+	Hashes   uint64  `json:"hashes"`
+	Duration float64 `json:"duration"`
+	Hashrate float64 `json:"hashrate"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) handleGetUTXO(w http.ResponseWriter, r *http.Request) {
+	txID, err := d.ParseHash32(r.PathValue("txid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	index, err := strconv.ParseUint(r.PathValue("index"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	utxo, ok := s.bch.GetUTXO(d.Outpoint{TxID: txID, Index: uint32(index)})
+	if !ok {
+		writeError(w, http.StatusNotFound, d.ErrUTXONotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewUTXOResponse(utxo))
+}
+
+func (s *Server) handleGetAddressUTXOs(w http.ResponseWriter, r *http.Request) {
+	address, err := d.ParsePublicAddress(r.PathValue("address"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	utxos := s.bch.GetUTXOsForAddress(address)
+	resp := make([]UTXOResponse, 0, len(utxos))
+	for _, utxo := range utxos {
+		resp = append(resp, NewUTXOResponse(utxo))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleGetAddressHistory serves a page of an address's history, selected by the
+// offset and limit query parameters.
+func (s *Server) handleGetAddressHistory(w http.ResponseWriter, r *http.Request) {
+	address, err := d.ParsePublicAddress(r.PathValue("address"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, math.MaxInt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultHistoryLimit, 1, maxHistoryLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	entries, total, err := s.bch.AddressHistory(address, offset, limit)
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	resp := AddressHistoryResponse{
+		Address: hex.EncodeToString(address[:]),
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+		Entries: make([]AddressEntryResponse, 0, len(entries)),
+	}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, NewAddressEntryResponse(e))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// queryInt parses the query parameter name, which must lie in [low, high], or
+// returns def when it is absent.
+func queryInt(r *http.Request, name string, def, low, high int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < low || v > high {
+		return 0, fmt.Errorf("%s must be an integer between %d and %d", name, low, high)
+	}
+	return v, nil
+}
+
+func (s *Server) handleSendTransaction(w http.ResponseWriter, r *http.Request) {
+	var req SendTransactionRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBody)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tx, err := DecodeRawTransaction(req.Hex, s.bch)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.bch.SubmitTransaction(tx); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	slog.Info("Accepted transaction into mempool", "txid", tx.TxID.String(), "inputs", len(tx.Inputs), "outputs", len(tx.Outputs))
+	writeJSON(w, http.StatusOK, SendTransactionResponse{TxID: tx.TxID.String()})
+}
+
+func (s *Server) handleGetTransaction(w http.ResponseWriter, r *http.Request) {
+	txID, err := d.ParseHash32(r.PathValue("txid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	st, err := s.bch.GetTransaction(txID)
+	switch {
+	case errors.Is(err, blockchain.ErrTxIndexDisabled):
+		writeError(w, http.StatusNotImplemented, err)
+	case err != nil:
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeJSON(w, http.StatusOK, NewTransactionStatusResponse(st))
+	}
+}
+
+func (s *Server) handleGetMempool(w http.ResponseWriter, r *http.Request) {
+	txs := s.bch.Mempool().Transactions(0)
+	ids := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		ids = append(ids, tx.TxID.String())
+	}
+	writeJSON(w, http.StatusOK, ids)
+}
+
+func (s *Server) handleGetMining(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, NewMiningResponse(s.bch.MiningStats()))
+}
+
+func NewMiningResponse(stats blockchain.MiningStats) MiningResponse {
+	resp := MiningResponse{
+		Blocks:           stats.Blocks,
+		StaleRounds:      stats.StaleRounds,
+		CancelledRounds:  stats.CancelledRounds,
+		Hashes:           stats.Hashes,
+		WastedHashes:     stats.WastedHashes,
+		Hashrate:         stats.Hashrate(),
+		MiningTime:       stats.Time.Seconds(),
+		AverageBlockTime: stats.AverageBlockTime().Seconds(),
+		Workers:          make([]WorkerResponse, 0, len(stats.Workers)),
+	}
+	for _, w := range stats.Workers {
+		resp.Workers = append(resp.Workers, WorkerResponse{Hashes: w.Hashes, Hashrate: w.Hashrate()})
+	}
+	if e := stats.Last; e != nil {
+		resp.Last = &MiningEventResponse{
+			Kind:     e.Kind.String(),
+			Height:   e.Height,
+			Hashes:   e.Hashes,
+			Duration: e.Duration.Seconds(),
+			Hashrate: e.Hashrate(),
+		}
+		if e.Kind != blockchain.RoundCancelled {
+			resp.Last.Hash = e.Hash.String()
+		}
+	}
+	return resp
+}
+
+func NewTransactionStatusResponse(st blockchain.TxStatus) TransactionStatusResponse {
+	resp := TransactionStatusResponse{
+		TxID:          st.Tx.TxID.String(),
+		Confirmations: st.Confirmations,
+		Tx:            NewTransactionResponse(st.Tx),
+	}
+	if loc := st.Block; loc != nil {
+		resp.Block = &TxBlockResponse{Hash: loc.BlockHash.String(), Height: loc.Height, Position: loc.Position}
+	}
+	return resp
+}
+
+func NewAddressEntryResponse(e blockchain.AddressEntry) AddressEntryResponse {
+	return AddressEntryResponse{
+		Height:    e.Height,
+		BlockHash: e.BlockHash.String(),
+		TxID:      e.TxID.String(),
+		Credit:    e.Credit,
+		Debit:     e.Debit,
+		Balance:   e.Balance,
+	}
+}
+
+func NewUTXOResponse(utxo d.UTXO) UTXOResponse {
+	return UTXOResponse{
+		TxID:    utxo.Outpoint.TxID.String(),
+		Index:   utxo.Outpoint.Index,
+		Value:   utxo.Value,
+		Address: hex.EncodeToString(utxo.To[:]),
+	}
+}
+
+// DecodeRawTransaction parses a hex encoded transaction and computes its TxID.
+func DecodeRawTransaction(rawHex string, bch *blockchain.Blockchain) (d.Transaction, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(rawHex))
+	if err != nil {
+		return d.Transaction{}, err
+	}
+	r := bytes.NewReader(raw)
+	tx, err := d.DeserializeTransaction(r)
+	if err != nil {
+		return d.Transaction{}, err
+	}
+	if r.Len() != 0 {
+		return d.Transaction{}, d.ErrInvalidTransaction
+	}
+	tx.TxID = bch.HashTransaction(tx)
+	return tx, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/gorilla/websocket"
+)
+
+func setupServer(t *testing.T, opts ...blockchain.Option) (*Server, *blockchain.Blockchain, []d.User) {
+	t.Helper()
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	users := blockchain.NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob"}, 2)
+	bch := blockchain.InitBlockchainWithFunds(1000, 1000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), opts...)
+	return NewServer(bch), bch, users
+}
+
+func TestGetUTXO(t *testing.T) {
+	server, bch, users := setupServer(t)
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/utxo/%s/%d", utxo.Outpoint.TxID.String(), utxo.Outpoint.Index), nil)
+	server.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got UTXOResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if got != NewUTXOResponse(utxo) {
+		t.Errorf("response = %+v, want %+v", got, NewUTXOResponse(utxo))
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/utxo/%s/%d", utxo.Outpoint.TxID.String(), 999), nil)
+	server.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing utxo status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// signedTransfer returns a transaction moving the first UTXO of users[0] to users[1].
+func signedTransfer(t *testing.T, bch *blockchain.Blockchain, users []d.User) d.Transaction {
+	t.Helper()
+	hasher := c.NewArchasHasher()
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	tx := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+		Outputs: []d.TxOutput{{Value: utxo.Value, To: users[1].PublicAddress}},
+	}
+	tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+	if err := blockchain.SignInput(&tx, 0, utxo, d.SigHashAll, users[0].GetPrivateKeyObject(), c.NewTransactionSigner(), hasher); err != nil {
+		t.Fatalf("SignInput() error = %v", err)
+	}
+	return tx
+}
+
+func TestSendTransaction(t *testing.T) {
+	server, bch, users := setupServer(t)
+	tx := signedTransfer(t, bch, users)
+
+	body := fmt.Sprintf(`{"hex":%q}`, hex.EncodeToString(tx.Serialize()))
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/tx", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if _, ok := bch.Mempool().Get(tx.TxID); !ok {
+		t.Error("Broadcast transaction should be in the mempool")
+	}
+
+	rec = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/tx", strings.NewReader(body)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("duplicate broadcast status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestGetTransaction(t *testing.T) {
+	server, bch, users := setupServer(t, blockchain.WithTxIndex())
+	tx := signedTransfer(t, bch, users)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	get := func(txID d.Hash32) (TransactionStatusResponse, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tx/"+txID.String(), nil))
+		var got TransactionStatusResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+		}
+		return got, rec.Code
+	}
+
+	if got, code := get(tx.TxID); code != http.StatusOK || got.Confirmations != 0 || got.Block != nil || got.Tx.TxID != tx.TxID.String() {
+		t.Errorf("pending transaction = %d %+v, want it unconfirmed", code, got)
+	}
+	if err := bch.MineBlocks(context.Background(), 1, 0, 1, 10, nil, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	tip, _ := bch.GetLatestBlock()
+	tipHash := bch.CalculateHash(tip)
+	got, code := get(tx.TxID)
+	want := TxBlockResponse{Hash: tipHash.String(), Height: 1, Position: len(tip.Body.Transactions) - 1}
+	if code != http.StatusOK || got.Confirmations != 1 || got.Block == nil || *got.Block != want {
+		t.Errorf("mined transaction = %d %+v, want confirmed in %+v", code, got, want)
+	}
+	var rpcTx RPCTransactionResponse
+	if err := callRPC(t, server, "gettransaction", fmt.Sprintf(`[%q]`, tx.TxID.String()), &rpcTx); err != nil || rpcTx.BlockHash != tipHash.String() || rpcTx.Confirmations != 1 {
+		t.Errorf("gettransaction = %+v, %v; want the mined transaction", rpcTx, err)
+	}
+	if _, code := get(d.Hash32{1}); code != http.StatusNotFound {
+		t.Errorf("unknown transaction status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	server, bch, _ = setupServer(t)
+	genesis, _ := bch.GetLatestBlock()
+	if _, code := get(genesis.Body.Transactions[0].TxID); code != http.StatusNotImplemented {
+		t.Errorf("status without a transaction index = %d, want %d", code, http.StatusNotImplemented)
+	}
+}
+
+func TestGetAddressHistory(t *testing.T) {
+	server, bch, users := setupServer(t, blockchain.WithAddressIndex())
+	tx := signedTransfer(t, bch, users)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	if err := bch.MineBlocks(context.Background(), 1, 0, 1, 10, nil, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	address := hex.EncodeToString(users[0].PublicAddress[:])
+	get := func(query string) (AddressHistoryResponse, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/address/"+address+"/history"+query, nil))
+		var got AddressHistoryResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+		}
+		return got, rec.Code
+	}
+
+	all, code := get("")
+	if code != http.StatusOK || all.Limit != defaultHistoryLimit || all.Total != len(all.Entries) || all.Total < 2 {
+		t.Fatalf("history = %d %+v, want the whole history", code, all)
+	}
+	if newest := all.Entries[0]; newest.TxID != tx.TxID.String() || newest.Height != 1 || newest.Balance != uint64(bch.GetUserBalance(users[0].PublicAddress)) {
+		t.Errorf("newest entry = %+v, want the mined transfer", newest)
+	}
+	page, code := get("?offset=1&limit=1")
+	if code != http.StatusOK || len(page.Entries) != 1 || page.Entries[0] != all.Entries[1] || page.Total != all.Total {
+		t.Errorf("second page = %d %+v, want %+v", code, page, all.Entries[1])
+	}
+	for _, query := range []string{"?limit=0", "?limit=100000", "?offset=-1", "?offset=x"} {
+		if _, code := get(query); code != http.StatusBadRequest {
+			t.Errorf("history%s status = %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+
+	server, _, _ = setupServer(t)
+	if _, code := get(""); code != http.StatusNotImplemented {
+		t.Errorf("status without an address index = %d, want %d", code, http.StatusNotImplemented)
+	}
+}
+
+func TestGetMining(t *testing.T) {
+	server, bch, users := setupServer(t)
+	if err := bch.MineBlocks(context.Background(), 1, 1, 1, 10, users, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/mining", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got MiningResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	stats := bch.MiningStats()
+	if got.Blocks != 1 || got.Hashes != stats.Hashes || len(got.Workers) != len(stats.Workers) {
+		t.Errorf("response = %+v, want the chain's stats %+v", got, stats)
+	}
+	tip, _ := bch.GetLatestBlock()
+	tipHash := bch.CalculateHash(tip)
+	if got.Last == nil || got.Last.Kind != "mined" || got.Last.Hash != tipHash.String() {
+		t.Errorf("last round = %+v, want the mined tip", got.Last)
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	server, bch, users := setupServer(t)
+	tx := signedTransfer(t, bch, users)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	expect := func(lines ...string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		body := rec.Body.String()
+		for _, line := range lines {
+			if !strings.Contains(body, line+"\n") {
+				t.Errorf("metrics do not contain %q:\n%s", line, body)
+			}
+		}
+	}
+
+	expect(
+		"blockchain_height 0",
+		fmt.Sprintf("blockchain_utxo_set_size %d", len(bch.GetUTXOsForAddress(users[0].PublicAddress))+len(bch.GetUTXOsForAddress(users[1].PublicAddress))),
+		"blockchain_mempool_transactions 1",
+		fmt.Sprintf("blockchain_mempool_bytes %d", len(tx.Serialize())),
+		`blockchain_signature_verifications_total{result="valid"} 1`,
+		`blockchain_signature_verifications_total{result="invalid"} 0`,
+		"blockchain_reorgs_total 0",
+		`blockchain_mining_rounds_total{result="mined"} 0`,
+	)
+
+	if err := bch.MineBlocks(context.Background(), 1, 0, 1, 10, nil, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	expect(
+		"blockchain_height 1",
+		"blockchain_mempool_transactions 0",
+		"blockchain_mempool_bytes 0",
+		`blockchain_block_validation_seconds_count{stage="block"} 1`,
+		`blockchain_block_validation_seconds_count{stage="transactions"} 1`,
+		`blockchain_mining_rounds_total{result="mined"} 1`,
+	)
+}
+
+func TestStream(t *testing.T) {
+	server, bch, users := setupServer(t)
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/ws?address="
+	dial := func(address string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(url+address, nil)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	read := func(conn *websocket.Conn, want string) StreamMessage {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg StreamMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON() error = %v, want a %s message", err, want)
+		}
+		if msg.Type != want {
+			t.Fatalf("message = %+v, want type %s", msg, want)
+		}
+		return msg
+	}
+	sender := hex.EncodeToString(users[0].PublicAddress[:])
+	receiver := hex.EncodeToString(users[1].PublicAddress[:])
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"zz", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Dial() with a malformed address = %v, want status %d", err, http.StatusBadRequest)
+	}
+	toReceiver := dial(receiver)
+	toOther := dial(strings.Repeat("ab", len(d.PublicAddress{})))
+
+	tx := signedTransfer(t, bch, users)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	if msg := read(toReceiver, StreamTx); msg.Tx.TxID != tx.TxID.String() {
+		t.Errorf("tx message = %+v, want %s", msg.Tx, tx.TxID.String())
+	}
+
+	// Requests are handled in order, so the error reply confirms the filter before it.
+	if err := toOther.WriteJSON(StreamFilter{Addresses: []string{sender}}); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	if err := toOther.WriteJSON(StreamFilter{Addresses: []string{"zz"}}); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	read(toOther, StreamError)
+
+	if err := bch.MineBlocks(context.Background(), 1, 0, 1, 10, nil, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	tip, _ := bch.GetLatestBlock()
+	tipHash := bch.CalculateHash(tip)
+	for _, conn := range []*websocket.Conn{toReceiver, toOther} {
+		msg := read(conn, StreamBlock)
+		if msg.Block.Height != 1 || msg.Block.Hash != tipHash.String() || msg.Block.TxCount != 1 || msg.Block.Header.Nonce != tip.Header.Nonce {
+			t.Errorf("block message = %+v, want block 1 with 1 transaction", msg.Block)
+		}
+		read(conn, StreamTx)
+	}
+	value := int64(tx.Outputs[0].Value)
+	if b := read(toReceiver, StreamBalance).Balance; b.Address != receiver || b.Delta != value || b.Balance != bch.GetUserBalance(users[1].PublicAddress) {
+		t.Errorf("receiver balance = %+v, want +%d", b, value)
+	}
+	if b := read(toOther, StreamBalance).Balance; b.Address != sender || b.Delta != -value || b.Height != 1 {
+		t.Errorf("sender balance = %+v, want -%d", b, value)
+	}
+}
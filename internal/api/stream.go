@@ -0,0 +1,377 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamBuffer is how many chain events a stream can fall behind before it is closed.
+	streamBuffer = 256
+	// streamWriteTimeout bounds the time a client may take to accept one message.
+	streamWriteTimeout = 10 * time.Second
+	// streamPingInterval is how often idle clients are pinged; a client that sends
+	// nothing, not even a pong, for twice as long is disconnected.
+	streamPingInterval = 30 * time.Second
+	// maxStreamMessage bounds the size of the messages clients send.
+	maxStreamMessage = 64 << 10
+)
+
+// Stream message types.
+const (
+	StreamBlock             = "block"
+	StreamBlockDisconnected = "blockdisconnected"
+	StreamTx                = "tx"
+	StreamBalance           = "balance"
+	StreamError             = "error"
+)
+
+// StreamMessage is one message sent on /api/ws. Type tells which of the other fields is set.
+type StreamMessage struct {
+	Type    string                 `json:"type"`
+	Block   *BlockSummaryResponse  `json:"block,omitempty"`
+	Tx      *TransactionResponse   `json:"tx,omitempty"`
+	Balance *BalanceChangeResponse `json:"balance,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// StreamFilter is a message a client sends on /api/ws to replace its address filter.
+// An empty list streams the transactions and balances of every address.
+type StreamFilter struct {
+	Addresses []string `json:"addresses"`
+}
+
+type HeaderResponse struct {
+	Version    uint32 `json:"version"`
+	Timestamp  uint32 `json:"timestamp"`
+	PrevHash   string `json:"prevHash"`
+	MerkleRoot string `json:"merkleRoot"`
+	Difficulty uint32 `json:"difficulty"`
+	Nonce      uint32 `json:"nonce"`
+}
+
+type BlockSummaryResponse struct {
+	Height  int            `json:"height"`
+	Hash    string         `json:"hash"`
+	TxCount int            `json:"txCount"`
+	Header  HeaderResponse `json:"header"`
+}
+
+type TransactionResponse struct {
+	TxID    string           `json:"txid"`
+	Inputs  []InputResponse  `json:"inputs"`
+	Outputs []OutputResponse `json:"outputs"`
+}
+
+type InputResponse struct {
+	TxID  string `json:"txid"`
+	Index uint32 `json:"index"`
+}
+
+type OutputResponse struct {
+	Address string `json:"address"`
+	Value   uint32 `json:"value"`
+}
+
+// BalanceChangeResponse reports that a connected or disconnected block changed the
+// confirmed balance of an address by Delta. Balance is the confirmed balance when the
+// message is sent.
+type BalanceChangeResponse struct {
+	Address string `json:"address"`
+	Height  int    `json:"height"`
+	Delta   int64  `json:"delta"`
+	Balance uint32 `json:"balance"`
+}
+
+func NewHeaderResponse(h d.Header) HeaderResponse {
+	return HeaderResponse{
+		Version:    h.Version,
+		Timestamp:  h.Timestamp,
+		PrevHash:   h.PrevHash.String(),
+		MerkleRoot: h.MerkleRoot.String(),
+		Difficulty: h.Difficulty,
+		Nonce:      h.Nonce,
+	}
+}
+
+func NewTransactionResponse(tx d.Transaction) TransactionResponse {
+	resp := TransactionResponse{
+		TxID:    tx.TxID.String(),
+		Inputs:  make([]InputResponse, len(tx.Inputs)),
+		Outputs: make([]OutputResponse, len(tx.Outputs)),
+	}
+	for i, in := range tx.Inputs {
+		resp.Inputs[i] = InputResponse{TxID: in.Prev.TxID.String(), Index: in.Prev.Index}
+	}
+	for i, out := range tx.Outputs {
+		resp.Outputs[i] = OutputResponse{Address: hex.EncodeToString(out.To[:]), Value: out.Value}
+	}
+	return resp
+}
+
+var upgrader = websocket.Upgrader{
+	// The stream only publishes chain data, so dashboards may connect from any origin.
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// handleStream upgrades to a WebSocket and streams connected and disconnected blocks,
+// transactions accepted into the mempool and the balance changes of every block. The
+// address query parameter, which can be repeated, restricts transactions to those
+// paying or spending from the given addresses and balances to those addresses.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAddresses(r.URL.Query()["address"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Subscribing first delivers every event after the handshake completes.
+	sub := s.bch.Subscribe(streamBuffer,
+		blockchain.EventBlockConnected, blockchain.EventBlockDisconnected, blockchain.EventTxAccepted)
+	defer sub.Unsubscribe()
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		return
+	}
+	st := &stream{server: s, conn: conn, filter: filter}
+	st.run(sub)
+}
+
+func parseAddresses(hexAddrs []string) (map[d.PublicAddress]bool, error) {
+	if len(hexAddrs) == 0 {
+		return nil, nil
+	}
+	addrs := make(map[d.PublicAddress]bool, len(hexAddrs))
+	for _, h := range hexAddrs {
+		addr, err := d.ParsePublicAddress(h)
+		if err != nil {
+			return nil, err
+		}
+		addrs[addr] = true
+	}
+	return addrs, nil
+}
+
+// stream is one WebSocket client. Its run goroutine writes every message and owns the
+// filter; readLoop only reads.
+type stream struct {
+	server *Server
+	conn   *websocket.Conn
+	// filter holds the addresses the client follows, or nil for all of them.
+	filter map[d.PublicAddress]bool
+}
+
+func (st *stream) run(sub *blockchain.Subscription) {
+	defer st.conn.Close()
+
+	requests := make(chan streamRequest)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go st.readLoop(requests, closed, done)
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-st.server.streamCtx.Done():
+			st.close(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-closed:
+			return
+		case req := <-requests:
+			err := req.err
+			var filter map[d.PublicAddress]bool
+			if err == nil {
+				filter, err = parseAddresses(req.filter.Addresses)
+			}
+			if err != nil {
+				if st.send(StreamMessage{Type: StreamError, Error: err.Error()}) != nil {
+					return
+				}
+				continue
+			}
+			st.filter = filter
+		case e, ok := <-sub.Events():
+			if !ok {
+				_ = st.send(StreamMessage{Type: StreamError, Error: sub.Err().Error()})
+				st.close(websocket.ClosePolicyViolation, "client fell behind")
+				return
+			}
+			for _, msg := range st.messages(e) {
+				if st.send(msg) != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			deadline := time.Now().Add(streamWriteTimeout)
+			if st.conn.WriteControl(websocket.PingMessage, nil, deadline) != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamRequest is a filter update read from the client, or the reason it could not be read.
+type streamRequest struct {
+	filter StreamFilter
+	err    error
+}
+
+// readLoop passes filter updates to run until done, and closes closed when the client goes away.
+func (st *stream) readLoop(requests chan<- streamRequest, closed, done chan struct{}) {
+	defer close(closed)
+	st.conn.SetReadLimit(maxStreamMessage)
+	extend := func(string) error {
+		return st.conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+	}
+	_ = extend("")
+	st.conn.SetPongHandler(extend)
+	for {
+		_, data, err := st.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Debug("WebSocket client disconnected", "remote", st.conn.RemoteAddr().String(), "err", err)
+			}
+			return
+		}
+		_ = extend("")
+		var req streamRequest
+		req.err = json.Unmarshal(data, &req.filter)
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (st *stream) send(msg StreamMessage) error {
+	_ = st.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	return st.conn.WriteJSON(msg)
+}
+
+func (st *stream) close(code int, reason string) {
+	deadline := time.Now().Add(time.Second)
+	_ = st.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+}
+
+// follows reports whether the client's filter includes addr.
+func (st *stream) follows(addr d.PublicAddress) bool {
+	return st.filter == nil || st.filter[addr]
+}
+
+// messages turns a chain event into the messages the client's filter lets through.
+func (st *stream) messages(e blockchain.Event) []StreamMessage {
+	switch e.Kind {
+	case blockchain.EventBlockConnected, blockchain.EventBlockDisconnected:
+		typ := StreamBlock
+		sign := int64(1)
+		if e.Kind == blockchain.EventBlockDisconnected {
+			typ = StreamBlockDisconnected
+			sign = -1
+		}
+		msgs := []StreamMessage{{Type: typ, Block: &BlockSummaryResponse{
+			Height:  e.Height,
+			Hash:    e.Hash.String(),
+			TxCount: len(e.Block.Body.Transactions),
+			Header:  NewHeaderResponse(e.Block.Header),
+		}}}
+		if e.Kind == blockchain.EventBlockConnected {
+			for _, tx := range e.Block.Body.Transactions {
+				if st.followsTx(tx, e.Spent) {
+					resp := NewTransactionResponse(tx)
+					msgs = append(msgs, StreamMessage{Type: StreamTx, Tx: &resp})
+				}
+			}
+		}
+		for _, change := range balanceChanges(e.Block, e.Spent) {
+			if !st.follows(change.address) {
+				continue
+			}
+			msgs = append(msgs, StreamMessage{Type: StreamBalance, Balance: &BalanceChangeResponse{
+				Address: hex.EncodeToString(change.address[:]),
+				Height:  e.Height,
+				Delta:   sign * change.delta,
+				Balance: st.server.bch.GetUserBalance(change.address),
+			}})
+		}
+		return msgs
+	case blockchain.EventTxAccepted:
+		var spent []d.UTXO
+		for _, in := range e.Tx.Inputs {
+			if utxo, ok := st.server.bch.GetUTXO(in.Prev); ok {
+				spent = append(spent, utxo)
+			}
+		}
+		if !st.followsTx(e.Tx, spent) {
+			return nil
+		}
+		resp := NewTransactionResponse(e.Tx)
+		return []StreamMessage{{Type: StreamTx, Tx: &resp}}
+	}
+	return nil
+}
+
+// followsTx reports whether tx pays a followed address or spends one of the outputs
+// in spent that belong to one.
+func (st *stream) followsTx(tx d.Transaction, spent []d.UTXO) bool {
+	for _, out := range tx.Outputs {
+		if st.follows(out.To) {
+			return true
+		}
+	}
+	inputs := make(map[d.Outpoint]bool, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		inputs[in.Prev] = true
+	}
+	for _, utxo := range spent {
+		if inputs[utxo.Outpoint] && st.follows(utxo.To) {
+			return true
+		}
+	}
+	return false
+}
+
+type balanceChange struct {
+	address d.PublicAddress
+	delta   int64
+}
+
+// balanceChanges returns the net amount a block paid to each address it touches,
+// minus what it spent from it, in the order the addresses first appear.
+func balanceChanges(b d.Block, spent []d.UTXO) []balanceChange {
+	var changes []balanceChange
+	index := make(map[d.PublicAddress]int)
+	add := func(addr d.PublicAddress, delta int64) {
+		i, ok := index[addr]
+		if !ok {
+			i = len(changes)
+			index[addr] = i
+			changes = append(changes, balanceChange{address: addr})
+		}
+		changes[i].delta += delta
+	}
+	for _, utxo := range spent {
+		add(utxo.To, -int64(utxo.Value))
+	}
+	for _, tx := range b.Body.Transactions {
+		for _, out := range tx.Outputs {
+			add(out.To, int64(out.Value))
+		}
+	}
+	kept := changes[:0]
+	for _, c := range changes {
+		if c.delta != 0 {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,133 @@
+package blockchain
+
+import (
+	"errors"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// ErrAddressIndexDisabled is returned by AddressHistory when the chain keeps no
+// address index.
+var ErrAddressIndexDisabled = errors.New("blockchain: address index is disabled")
+
+// AddressEntry is one transaction of an address's history: what the transaction
+// paid to the address, what it spent from it and the confirmed balance after it.
+type AddressEntry struct {
+	Height    int
+	BlockHash d.Hash32
+	TxID      d.Hash32
+	Credit    uint64
+	Debit     uint64
+	Balance   uint64
+}
+
+// addressIndex keeps the history of every address in main chain order. It is
+// guarded by chainMutex and maintained as blocks are connected and disconnected. A
+// nil addressIndex is a disabled one.
+type addressIndex struct {
+	history map[d.PublicAddress][]AddressEntry
+}
+
+func newAddressIndex(enabled bool) *addressIndex {
+	if !enabled {
+		return nil
+	}
+	return &addressIndex{history: make(map[d.PublicAddress][]AddressEntry)}
+}
+
+// connectBlock appends an entry for every address a transaction of b pays or spends
+// from and returns the entries in the order it appended them. spent holds the outputs
+// b spent, as returned by UTXOTracker.connectBlock.
+func (idx *addressIndex) connectBlock(b d.Block, hash d.Hash32, height int, spent []d.UTXO) []AddressRecord {
+	if idx == nil {
+		return nil
+	}
+	var records []AddressRecord
+	spentBy := make(map[d.Outpoint]d.UTXO, len(spent))
+	for _, utxo := range spent {
+		spentBy[utxo.Outpoint] = utxo
+	}
+	for _, tx := range b.Body.Transactions {
+		var order []d.PublicAddress
+		entries := make(map[d.PublicAddress]*AddressEntry)
+		entry := func(addr d.PublicAddress) *AddressEntry {
+			e, ok := entries[addr]
+			if !ok {
+				e = &AddressEntry{Height: height, BlockHash: hash, TxID: tx.TxID}
+				entries[addr] = e
+				order = append(order, addr)
+			}
+			return e
+		}
+		for _, in := range tx.Inputs {
+			if utxo, ok := spentBy[in.Prev]; ok {
+				entry(utxo.To).Debit += uint64(utxo.Value)
+			}
+		}
+		for _, out := range tx.Outputs {
+			entry(out.To).Credit += uint64(out.Value)
+		}
+		for _, addr := range order {
+			e := entries[addr]
+			history := idx.history[addr]
+			if n := len(history); n > 0 {
+				e.Balance = history[n-1].Balance
+			}
+			e.Balance = e.Balance + e.Credit - e.Debit
+			idx.history[addr] = append(history, *e)
+			records = append(records, AddressRecord{Address: addr, Entry: *e})
+		}
+	}
+	return records
+}
+
+// disconnectBlock removes the entries of b, which are the last ones of every address it touches.
+func (idx *addressIndex) disconnectBlock(b d.Block, hash d.Hash32, spent []d.UTXO) {
+	if idx == nil {
+		return
+	}
+	touched := make(map[d.PublicAddress]bool)
+	for _, utxo := range spent {
+		touched[utxo.To] = true
+	}
+	for _, tx := range b.Body.Transactions {
+		for _, out := range tx.Outputs {
+			touched[out.To] = true
+		}
+	}
+	for addr := range touched {
+		history := idx.history[addr]
+		n := len(history)
+		for n > 0 && history[n-1].BlockHash == hash {
+			n--
+		}
+		if n == 0 {
+			delete(idx.history, addr)
+		} else {
+			idx.history[addr] = history[:n]
+		}
+	}
+}
+
+// AddressHistory returns up to limit entries of the address's history, newest first,
+// after skipping the offset newest ones, together with the number of entries in the
+// whole history. A limit of zero or less returns all of them. It needs the chain to
+// be created WithAddressIndex.
+func (bch *Blockchain) AddressHistory(address d.PublicAddress, offset, limit int) ([]AddressEntry, int, error) {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+
+	if bch.addressIndex == nil {
+		return nil, 0, ErrAddressIndexDisabled
+	}
+	history := bch.addressIndex.history[address]
+	total := len(history)
+	if limit <= 0 {
+		limit = total
+	}
+	var page []AddressEntry
+	for i := total - 1 - max(offset, 0); i >= 0 && len(page) < limit; i-- {
+		page = append(page, history[i])
+	}
+	return page, total, nil
+}
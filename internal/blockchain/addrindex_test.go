@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// checkBalances verifies that the newest history entry of every user holds the
+// user's confirmed balance.
+func checkBalances(t *testing.T, bch *Blockchain, users []d.User) {
+	t.Helper()
+	for _, u := range users {
+		entries, _, err := bch.AddressHistory(u.PublicAddress, 0, 1)
+		if err != nil {
+			t.Fatalf("AddressHistory(%s) error = %v", u.Name, err)
+		}
+		if len(entries) != 1 || entries[0].Balance != uint64(bch.GetUserBalance(u.PublicAddress)) {
+			t.Errorf("newest entry of %s = %+v, want balance %d", u.Name, entries, bch.GetUserBalance(u.PublicAddress))
+		}
+	}
+}
+
+func TestAddressHistory(t *testing.T) {
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	users := NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	bch := InitBlockchainWithFunds(100000, 100000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithAddressIndex())
+	genesis, _ := bch.GetLatestBlock()
+	checkBalances(t, bch, users)
+	_, genesisEntries, _ := bch.AddressHistory(users[0].PublicAddress, 0, 0)
+
+	spent := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	mainTx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	main1 := mineOnParent(t, bch, genesis, mainTx)
+	if _, err := bch.ProcessBlock(main1); err != nil {
+		t.Fatalf("ProcessBlock(main1) error = %v", err)
+	}
+	checkBalances(t, bch, users)
+
+	entries, total, err := bch.AddressHistory(users[0].PublicAddress, 0, 10)
+	if err != nil {
+		t.Fatalf("AddressHistory() error = %v", err)
+	}
+	if total != genesisEntries+1 || len(entries) != total {
+		t.Fatalf("history has %d of %d entries, want %d", len(entries), total, genesisEntries+1)
+	}
+	if e := entries[0]; e.TxID != mainTx.TxID || e.Height != 1 || e.Debit != uint64(spent.Value) || e.Credit != 0 {
+		t.Errorf("newest entry = %+v, want a debit of %d by main1", e, spent.Value)
+	}
+	if entries[0].Balance != entries[1].Balance-uint64(spent.Value) {
+		t.Errorf("running balance went from %d to %d, want a drop of %d", entries[1].Balance, entries[0].Balance, spent.Value)
+	}
+	page, _, _ := bch.AddressHistory(users[0].PublicAddress, 1, 1)
+	if len(page) != 1 || page[0] != entries[1] {
+		t.Errorf("second page = %+v, want %+v", page, entries[1])
+	}
+	if received, _, _ := bch.AddressHistory(users[1].PublicAddress, 0, 1); received[0].TxID != mainTx.TxID || received[0].Credit != uint64(mainTx.Outputs[0].Value) {
+		t.Errorf("newest entry of the payee = %+v, want a credit of %d", received[0], mainTx.Outputs[0].Value)
+	}
+
+	side1 := mineOnParent(t, bch, genesis, signedTestTransaction(t, bch, users[2], users[1], 10))
+	if _, err := bch.ProcessBlock(side1); err != nil {
+		t.Fatalf("ProcessBlock(side1) error = %v", err)
+	}
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 5, To: users[2].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	if status, err := bch.ProcessBlock(mineOnParent(t, bch, side1, coinbase)); err != nil || status != BlockReorganized {
+		t.Fatalf("ProcessBlock(side2) = %v, %v; want reorganized", status, err)
+	}
+	checkBalances(t, bch, users)
+	if _, total, _ := bch.AddressHistory(users[0].PublicAddress, 0, 0); total != genesisEntries {
+		t.Errorf("history after reorg has %d entries, want the %d of genesis", total, genesisEntries)
+	}
+	if _, total, _ := bch.AddressHistory(users[2].PublicAddress, 0, 0); total != genesisEntries+2 {
+		t.Errorf("history of the side branch miner has %d entries, want %d", total, genesisEntries+2)
+	}
+
+	if _, _, err := NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner()).AddressHistory(users[0].PublicAddress, 0, 1); err != ErrAddressIndexDisabled {
+		t.Errorf("AddressHistory() without index error = %v, want %v", err, ErrAddressIndexDisabled)
+	}
+}
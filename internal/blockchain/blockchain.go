@@ -2,52 +2,137 @@ package blockchain
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/big"
 	"math/rand"
+	"slices"
 	"sync"
-	"time"
 
+	"github.com/Quikmove/blockchain-uzd2/internal/clock"
 	"github.com/Quikmove/blockchain-uzd2/internal/config"
 	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
 	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
 )
 
 type Blockchain struct {
-	blocks       []d.Block
+	blocks []d.Block
+	// hashes and heights index the main chain: hashes[i] is the hash of blocks[i] and
+	// heights maps it back to i, so that a block's hash is computed once, when it is
+	// connected.
+	hashes       []d.Hash32
+	heights      map[d.Hash32]int
+	undo         [][]d.UTXO
+	sideBlocks   map[d.Hash32]sideBlock
+	chainWork    *big.Int
 	chainMutex   *sync.RWMutex
 	txGenMutex   *sync.Mutex
 	utxoTracker  *UTXOTracker
+	mempool      *Mempool
 	hasher       c.Hasher
 	txSigner     c.TransactionSigner
 	userRegistry map[d.PublicAddress]d.PublicKey
 	userMutex    *sync.RWMutex
+	// rng is guarded by txGenMutex.
+	rng       *rand.Rand
+	clock     clock.Clock
+	telemetry *telemetry
+	metrics   *chainMetrics
+	logger    *slog.Logger
+	events    *eventBus
+	// txIndex and addressIndex are nil unless the chain was created WithTxIndex and
+	// WithAddressIndex.
+	txIndex      *txIndex
+	addressIndex *addressIndex
+	// Main chain blocks below firstBody carry only their header, as in a chain loaded
+	// with LoadUTXOSet whose snapshot is not validated yet or a pruned chain.
+	firstBody     int
+	pruneDepth    int
+	snapshot      UTXOSnapshot
+	snapshotState SnapshotState
+	// store is nil unless the chain was created WithStore. storeErr is the write error
+	// that stopped the chain from writing to it.
+	store    Store
+	storeErr error
+	// validationClock is what block timestamps are checked against; unlike clock it
+	// does not advance when read.
+	validationClock clock.Clock
+	minDifficulty   uint32
+	blockReward     uint32
 }
 
-func NewBlockchain(hasher c.Hasher, signer c.TransactionSigner) *Blockchain {
+func NewBlockchain(hasher c.Hasher, signer c.TransactionSigner, opts ...Option) *Blockchain {
+	e := newOptions(opts)
 	return &Blockchain{
 		blocks:       []d.Block{},
+		heights:      make(map[d.Hash32]int),
+		sideBlocks:   make(map[d.Hash32]sideBlock),
+		chainWork:    new(big.Int),
 		chainMutex:   &sync.RWMutex{},
 		txGenMutex:   &sync.Mutex{},
 		utxoTracker:  NewUTXOTracker(),
+		mempool:      NewMempool(),
 		hasher:       hasher,
 		txSigner:     signer,
 		userRegistry: make(map[d.PublicAddress]d.PublicKey),
 		userMutex:    &sync.RWMutex{},
+		rng:          e.rng,
+		clock:        e.clock,
+		telemetry:    newTelemetry(),
+		metrics:      newChainMetrics(),
+		logger:       e.logger,
+		events:       newEventBus(),
+		txIndex:      newTxIndex(e.txIndex),
+		addressIndex: newAddressIndex(e.addressIndex),
+		pruneDepth:   e.pruneDepth,
+		store:        e.store,
+
+		validationClock: e.validationClock,
+		minDifficulty:   e.minDifficulty,
+		blockReward:     e.blockReward,
 	}
 }
 
+// GetBlock returns the main chain block at the given height.
 func (bch *Blockchain) GetBlock(index int) (d.Block, error) {
 	bch.chainMutex.RLock()
 	defer bch.chainMutex.RUnlock()
 	if index < 0 || index >= len(bch.blocks) {
 		return d.Block{}, d.ErrBlockIndexOutOfRange
 	}
+	if index < bch.firstBody {
+		return d.Block{}, bch.missingBodyLocked(index)
+	}
 	return bch.blocks[index], nil
 }
+
+// GetBlockHash returns the hash of the main chain block at the given height.
+func (bch *Blockchain) GetBlockHash(index int) (d.Hash32, error) {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	if index < 0 || index >= len(bch.hashes) {
+		return d.Hash32{}, d.ErrBlockIndexOutOfRange
+	}
+	return bch.hashes[index], nil
+}
+
+// GetHeightByHash returns the height of a main chain block. Blocks on side branches
+// are not found; GetBlockByHash returns those too.
+func (bch *Blockchain) GetHeightByHash(hash d.Hash32) (int, error) {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	height, ok := bch.heights[hash]
+	if !ok {
+		return 0, d.ErrBlockNotFound
+	}
+	return height, nil
+}
+
 func (bch *Blockchain) GetLatestBlock() (d.Block, error) {
 	bch.chainMutex.RLock()
 	defer bch.chainMutex.RUnlock()
@@ -56,14 +141,23 @@ func (bch *Blockchain) GetLatestBlock() (d.Block, error) {
 	}
 	return bch.blocks[len(bch.blocks)-1], nil
 }
-func (bch *Blockchain) AddBlock(b d.Block) error {
-	if err := bch.ValidateBlock(b); err != nil {
-		return fmt.Errorf("block validation failed: %w", err)
+
+// GetLatestBlockHash returns the hash of the tip.
+func (bch *Blockchain) GetLatestBlockHash() (d.Hash32, error) {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	if len(bch.hashes) == 0 {
+		return d.Hash32{}, d.ErrEmptyBlockchain
 	}
+	return bch.hashes[len(bch.hashes)-1], nil
+}
 
-	users := bch.getUsersFromRegistry()
-	if err := bch.ValidateBlockTransactions(b, users); err != nil {
-		return fmt.Errorf("block transaction validation failed: %w", err)
+// AddBlock validates b and connects it on top of the tip. A rejected block is logged
+// at warn level together with the rule it broke.
+func (bch *Blockchain) AddBlock(b d.Block) error {
+	if err := bch.validateNewBlock(b); err != nil {
+		bch.logRejected(b, bch.Len(), err)
+		return fmt.Errorf("block validation failed: %w", err)
 	}
 
 	bch.chainMutex.Lock()
@@ -72,21 +166,25 @@ func (bch *Blockchain) AddBlock(b d.Block) error {
 	height := len(bch.blocks)
 
 	if height != 0 {
-		tip := bch.blocks[height-1]
-		tipHash := bch.CalculateHash(tip)
 		header := b.Header
-		if tipHash != header.PrevHash {
+		if bch.hashes[height-1] != header.PrevHash {
+			bch.logRejected(b, height, ruleError("prev-hash", d.ErrInvalidPrevHash))
 			return d.ErrInvalidPrevHash
 		}
-		hash := bch.CalculateHash(b)
-		if !IsHashValid(hash, header.Difficulty) {
+		if !bch.CheckProofOfWork(header) {
+			bch.logRejected(b, height, ruleError("proof-of-work", d.ErrInvalidDifficulty))
 			return d.ErrInvalidDifficulty
 		}
 	}
 
-	bch.blocks = append(bch.blocks, b)
+	users := bch.getUsersFromRegistry()
+	if err := bch.validateBlockTransactions(b, users, height == 0); err != nil {
+		bch.logRejected(b, height, err)
+		return fmt.Errorf("block transaction validation failed: %w", err)
+	}
 
-	bch.utxoTracker.ScanBlock(b, bch.hasher)
+	bch.connectBlockLocked(b, true)
+	bch.removeMempoolForBlock(b)
 
 	return nil
 }
@@ -145,20 +243,18 @@ func (bch *Blockchain) Len() int {
 	defer bch.chainMutex.RUnlock()
 	return len(bch.blocks)
 }
-func InitBlockchainWithFunds(low, high uint32, users []d.User, cfg *config.Config, hasher c.Hasher, txSigner c.TransactionSigner) *Blockchain {
-	fundTransactions, err := GenerateFundTransactionsForUsers(users, low, high, hasher)
+func InitBlockchainWithFunds(low, high uint32, users []d.User, cfg *config.Config, hasher c.Hasher, txSigner c.TransactionSigner, opts ...Option) *Blockchain {
+	blockchain := NewBlockchain(hasher, txSigner, opts...)
+	fundTransactions, err := GenerateFundTransactionsForUsers(users, low, high, hasher, blockchain.rng)
 	if err != nil {
 		panic(err)
 	}
-	genesisBlock, err := CreateGenesisBlock(context.Background(), fundTransactions, cfg, hasher)
+	genesisBlock, err := CreateGenesisBlock(context.Background(), fundTransactions, cfg, hasher, uint32(blockchain.clock.Now().Unix()))
 	if err != nil {
 		panic(err)
 	}
-	blockchain := NewBlockchain(hasher, txSigner)
 	blockchain.RegisterUsers(users)
-	blockchain.blocks = append(blockchain.blocks, genesisBlock)
-
-	blockchain.utxoTracker.ScanBlock(genesisBlock, hasher)
+	blockchain.connectBlockLocked(genesisBlock, true)
 
 	return blockchain
 }
@@ -188,6 +284,17 @@ func (bch *Blockchain) CalculateHash(block d.Block) d.Hash32 {
 	hash := bch.hasher.Hash(block.Header.Serialize())
 	return hash
 }
+
+// CheckProofOfWork reports whether header, which is not the genesis header, declares
+// at least the chain's minimum difficulty and meets it under the proof-of-work
+// algorithm its version selects.
+func (bch *Blockchain) CheckProofOfWork(header d.Header) bool {
+	if header.Difficulty < bch.minDifficulty {
+		return false
+	}
+	return NewPoW(header.Version, bch.hasher).Verify(&header)
+}
+
 func IsHashValid(hash d.Hash32, diff uint32) bool {
 	if diff == 0 {
 		return true
@@ -242,10 +349,10 @@ func (bch *Blockchain) GenerateRandomTransactions(users []d.User, low, high, n i
 	for len(generatedTxs) < n && attempts < maxAttempts {
 		attempts++
 
-		senderIndex := rand.Intn(userAmount)
-		recipientIndex := rand.Intn(userAmount)
+		senderIndex := bch.rng.Intn(userAmount)
+		recipientIndex := bch.rng.Intn(userAmount)
 		for senderIndex == recipientIndex {
-			recipientIndex = rand.Intn(userAmount)
+			recipientIndex = bch.rng.Intn(userAmount)
 		}
 		sender := users[senderIndex]
 		recipient := users[recipientIndex]
@@ -256,7 +363,7 @@ func (bch *Blockchain) GenerateRandomTransactions(users []d.User, low, high, n i
 			continue
 		}
 
-		amount := uint32(low + rand.Intn(high-low+1))
+		amount := uint32(low + bch.rng.Intn(high-low+1))
 		if amount == 0 {
 			continue
 		}
@@ -269,7 +376,7 @@ func (bch *Blockchain) GenerateRandomTransactions(users []d.User, low, high, n i
 			if totalInput >= amount {
 				break
 			}
-			if usedOutpoints[utxo.Outpoint] {
+			if usedOutpoints[utxo.Outpoint] || bch.mempool.IsSpent(utxo.Outpoint) {
 				continue
 			}
 			if totalInput > ^uint32(0)-utxo.Value {
@@ -305,9 +412,9 @@ func (bch *Blockchain) GenerateRandomTransactions(users []d.User, low, high, n i
 		tx.TxID = txID
 
 		for j := range tx.Inputs {
-			hashToSign := SignatureHash(tx, selectedUTXOs[j].Value, selectedUTXOs[j].To[:], bch.hasher)
-			sig := bch.txSigner.SignTransaction(hashToSign[:], sender.GetPrivateKeyObject())
-			tx.Inputs[j].Sig = sig[:]
+			if err := SignInput(&tx, j, selectedUTXOs[j], d.SigHashAll, sender.GetPrivateKeyObject(), bch.txSigner, bch.hasher); err != nil {
+				return nil, err
+			}
 		}
 
 		generatedTxs = append(generatedTxs, tx)
@@ -323,40 +430,28 @@ func (bch *Blockchain) GenerateRandomTransactions(users []d.User, low, high, n i
 	return generatedTxs, nil
 }
 func (bch *Blockchain) GenerateBlock(ctx context.Context, body d.Body, version uint32, difficulty uint32) (d.Block, error) {
-	latestBlock, err := bch.GetLatestBlock()
+	latestHash, err := bch.GetLatestBlockHash()
 	if err != nil {
 		return d.Block{}, err
 	}
 	var newHeader d.Header
-	t := time.Now()
+	t := bch.clock.Now()
 
 	newHeader.Version = version
 	newHeader.Timestamp = uint32(t.Unix())
-	newHeader.PrevHash = bch.CalculateHash(latestBlock)
-	newHeader.MerkleRoot = MerkleRootHash(body, bch.hasher)
+	newHeader.PrevHash = latestHash
 	newHeader.Difficulty = difficulty
 
-	nonce, _, err := FindValidNonce(ctx, &newHeader, bch.hasher)
-	if err != nil {
-		return d.Block{}, err
-	}
-	newHeader.Nonce = nonce
-
 	newBlock := d.Block{
 		Header: newHeader,
 		Body:   body,
 	}
+	if err := bch.sealBlock(ctx, &newBlock, 1, nil); err != nil {
+		return d.Block{}, err
+	}
 
 	return newBlock, nil
 }
-func (bch *Blockchain) GetBlockByIndex(index int) (d.Block, error) {
-	bch.chainMutex.RLock()
-	defer bch.chainMutex.RUnlock()
-	if index < 0 || index >= len(bch.blocks) {
-		return d.Block{}, d.ErrBlockIndexOutOfRange
-	}
-	return bch.blocks[index], nil
-}
 func (bch *Blockchain) Print(w io.Writer) error {
 	blocks := bch.Blocks()
 	enc := json.NewEncoder(w)
@@ -373,12 +468,78 @@ func (bch *Blockchain) GetUTXOsForAddress(address d.PublicAddress) []d.UTXO {
 	return bch.utxoTracker.GetUTXOsForAddress(address)
 }
 
+func (bch *Blockchain) GetUTXO(outpoint d.Outpoint) (d.UTXO, bool) {
+	return bch.utxoTracker.GetUTXO(outpoint)
+}
+
+// HashTransaction returns the TxID of tx, which covers everything except the signatures.
+func (bch *Blockchain) HashTransaction(tx d.Transaction) d.Hash32 {
+	return bch.hasher.Hash(tx.SerializeWithoutSignatures())
+}
+
+// OutpointTxID returns the id that outpoints spending tx's outputs refer to it by.
+// Unlike the TxID it covers the signatures.
+func (bch *Blockchain) OutpointTxID(tx d.Transaction) d.Hash32 {
+	return bch.hasher.Hash(tx.Serialize())
+}
+
+// SpentOutputs returns the outputs that the main chain block at the given height spent.
+func (bch *Blockchain) SpentOutputs(index int) ([]d.UTXO, error) {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	if index < 0 || index >= len(bch.undo) {
+		return nil, d.ErrBlockIndexOutOfRange
+	}
+	if index < bch.firstBody {
+		return nil, bch.missingBodyLocked(index)
+	}
+	return slices.Clone(bch.undo[index]), nil
+}
+
+// AddressBalance is the confirmed balance of an address and the number of unspent
+// outputs it is made of.
+type AddressBalance struct {
+	Address d.PublicAddress
+	Balance uint64
+	UTXOs   int
+}
+
+// RichList returns the n addresses with the highest confirmed balances, highest first.
+// A limit of zero or less returns all of them.
+func (bch *Blockchain) RichList(n int) []AddressBalance {
+	balances := bch.utxoTracker.Balances()
+	list := make([]AddressBalance, 0, len(balances))
+	for _, b := range balances {
+		list = append(list, b)
+	}
+	slices.SortFunc(list, func(a, b AddressBalance) int {
+		if a.Balance != b.Balance {
+			return cmp.Compare(b.Balance, a.Balance)
+		}
+		return bytes.Compare(a.Address[:], b.Address[:])
+	})
+	if n > 0 && n < len(list) {
+		list = list[:n]
+	}
+	return list
+}
+
 func (bch *Blockchain) RegisterUsers(users []d.User) {
 	bch.userMutex.Lock()
 	defer bch.userMutex.Unlock()
 	for _, user := range users {
 		bch.userRegistry[user.PublicAddress] = user.PublicKey
 	}
+	if bch.store != nil && len(users) > 0 {
+		if err := bch.store.PutUsers(users); err != nil {
+			bch.logger.Error("Could not write users to store", "users", len(users), "err", err)
+		}
+	}
+}
+
+// Users returns the registered users. Only their addresses and public keys are known to the chain.
+func (bch *Blockchain) Users() []d.User {
+	return bch.getUsersFromRegistry()
 }
 
 func (bch *Blockchain) getUsersFromRegistry() []d.User {
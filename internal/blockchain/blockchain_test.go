@@ -1,10 +1,15 @@
 package blockchain
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"testing"
 	"time"
 
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
 	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
 	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
 )
@@ -309,3 +314,58 @@ func TestCalculateHash(t *testing.T) {
 	}
 }
 
+
+// logRecords decodes the JSON lines written by a slog.JSONHandler.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var r map[string]any
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decoding log record: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestAddBlock_LogsFailedRule(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	users := NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	bch := InitBlockchainWithFunds(100000, 100000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithLogger(logger))
+	tip, _ := bch.GetLatestBlock()
+
+	tx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	tx.Inputs[0].Prev.Index = 99
+	tx.TxID = bch.HashTransaction(tx)
+	block := mineOnParent(t, bch, tip, tx)
+	err := bch.AddBlock(block)
+	var ruleErr *RuleError
+	if !errors.As(err, &ruleErr) || ruleErr.Rule != "missing-utxo" || !errors.Is(err, d.ErrUTXONotFound) {
+		t.Fatalf("AddBlock() error = %v, want missing-utxo rule", err)
+	}
+
+	wrongRoot := mineOnParent(t, bch, tip, signedTestTransaction(t, bch, users[0], users[1], 10))
+	wrongRoot.Header.MerkleRoot = d.Hash32{}
+	if err := bch.AddBlock(wrongRoot); !errors.Is(err, d.ErrInvalidMerkleRoot) {
+		t.Fatalf("AddBlock() error = %v, want %v", err, d.ErrInvalidMerkleRoot)
+	}
+
+	records := logRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("got %d log records, want 2: %v", len(records), records)
+	}
+	hash := bch.CalculateHash(block)
+	want := map[string]any{"level": "WARN", "msg": "Block rejected", "rule": "missing-utxo", "height": 1.0, "hash": hash.String(), "tx": 0.0, "input": 0.0, "txs": 1.0}
+	for k, v := range want {
+		if records[0][k] != v {
+			t.Errorf("record[%q] = %v, want %v", k, records[0][k], v)
+		}
+	}
+	if records[1]["rule"] != "merkle-root" {
+		t.Errorf("record rule = %v, want merkle-root", records[1]["rule"])
+	}
+}
@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"errors"
+	"sync"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// ErrSubscriberTooSlow is the Err of a subscription that was closed because its
+// buffer was full when an event had to be delivered.
+var ErrSubscriberTooSlow = errors.New("blockchain: subscriber fell behind")
+
+// EventKind tells what changed in the chain or the mempool.
+type EventKind int
+
+const (
+	// EventBlockConnected events carry a block added to the tip of the main chain.
+	EventBlockConnected EventKind = iota
+	// EventBlockDisconnected events carry a block removed from the tip of the main
+	// chain by a reorganization.
+	EventBlockDisconnected
+	// EventTxAccepted events carry a transaction admitted to the mempool, either
+	// submitted or returned from a disconnected block.
+	EventTxAccepted
+	// EventTxRemoved events carry a transaction that left the mempool; Reason tells why.
+	EventTxRemoved
+	// EventReorgStarted events precede the block events of a reorganization.
+	EventReorgStarted
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventBlockConnected:
+		return "blockconnected"
+	case EventBlockDisconnected:
+		return "blockdisconnected"
+	case EventTxAccepted:
+		return "txaccepted"
+	case EventTxRemoved:
+		return "txremoved"
+	case EventReorgStarted:
+		return "reorgstarted"
+	}
+	return "unknown"
+}
+
+// RemoveReason tells why a transaction left the mempool.
+type RemoveReason int
+
+const (
+	// RemovedMined transactions were confirmed by a connected block.
+	RemovedMined RemoveReason = iota
+	// RemovedConflict transactions spend an output that a connected block spent, or
+	// became invalid otherwise after a reorganization.
+	RemovedConflict
+)
+
+func (r RemoveReason) String() string {
+	switch r {
+	case RemovedMined:
+		return "mined"
+	case RemovedConflict:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+// Event is a change of the chain or the mempool. Blocks and transactions share their
+// slices with the chain and must not be modified.
+type Event struct {
+	Kind EventKind
+	// Height and Hash identify the block of block events, and the tip of the branch
+	// an EventReorgStarted event switches to.
+	Height int
+	Hash   d.Hash32
+	Block  d.Block
+	// Spent holds the outputs a connected block spent, or a disconnected block spent
+	// and returned to the UTXO set, in the order of its inputs.
+	Spent []d.UTXO
+	// Tx is the transaction of EventTxAccepted and EventTxRemoved events.
+	Tx     d.Transaction
+	Reason RemoveReason
+	// ForkHeight is the height of the last block a reorganization keeps and Depth the
+	// number of blocks it disconnects.
+	ForkHeight int
+	Depth      int
+}
+
+// Subscription delivers events in the order they happened. Delivery never waits for
+// the subscriber: when the buffer is full, the subscription is closed and Err returns
+// ErrSubscriberTooSlow, after which the subscriber has to catch up from the chain
+// itself and subscribe again.
+type Subscription struct {
+	bus    *eventBus
+	ch     chan Event
+	kinds  map[EventKind]bool
+	err    error
+	closed bool
+}
+
+// Events returns the channel events are delivered on. It is closed by Unsubscribe
+// and when the subscriber falls behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Err returns ErrSubscriberTooSlow once the subscription was closed for falling behind.
+func (s *Subscription) Err() error {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	return s.err
+}
+
+// Unsubscribe stops delivery and closes the events channel. It can be called more than once.
+func (s *Subscription) Unsubscribe() {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	s.bus.closeLocked(s, nil)
+}
+
+// eventBus fans events out to subscriptions. publish only holds the bus mutex, never
+// blocks on a subscriber, and so can be called with chainMutex held.
+type eventBus struct {
+	mutex *sync.Mutex
+	subs  map[*Subscription]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{mutex: &sync.Mutex{}, subs: make(map[*Subscription]struct{})}
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for s := range b.subs {
+		if s.kinds != nil && !s.kinds[e.Kind] {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			b.closeLocked(s, ErrSubscriberTooSlow)
+		}
+	}
+}
+
+func (b *eventBus) closeLocked(s *Subscription, err error) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	delete(b.subs, s)
+	close(s.ch)
+}
+
+// Subscribe delivers the events of the given kinds, or of every kind when none is
+// given, on a channel with room for buffer events.
+func (bch *Blockchain) Subscribe(buffer int, kinds ...EventKind) *Subscription {
+	s := &Subscription{bus: bch.events, ch: make(chan Event, max(buffer, 1))}
+	if len(kinds) > 0 {
+		s.kinds = make(map[EventKind]bool, len(kinds))
+		for _, k := range kinds {
+			s.kinds[k] = true
+		}
+	}
+	bch.events.mutex.Lock()
+	defer bch.events.mutex.Unlock()
+	bch.events.subs[s] = struct{}{}
+	return s
+}
+
+// publishTxRemoved publishes an EventTxRemoved event for each of txs.
+func (bch *Blockchain) publishTxRemoved(txs []d.Transaction, reason RemoveReason) {
+	for _, tx := range txs {
+		bch.events.publish(Event{Kind: EventTxRemoved, Tx: tx, Reason: reason})
+	}
+}
@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"testing"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// receive returns the events already delivered to sub.
+func receive(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestSubscribe_BlockAndMempoolEvents(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+	sub := bch.Subscribe(16)
+	defer sub.Unsubscribe()
+	blocksOnly := bch.Subscribe(16, EventBlockConnected)
+	defer blocksOnly.Unsubscribe()
+
+	tx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	block := mineOnParent(t, bch, genesis, tx)
+	if err := bch.AddBlock(block); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+
+	events := receive(sub)
+	want := []EventKind{EventTxAccepted, EventBlockConnected, EventTxRemoved}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %v: %+v", len(events), want, events)
+	}
+	for i, e := range events {
+		if e.Kind != want[i] {
+			t.Errorf("event %d = %v, want %v", i, e.Kind, want[i])
+		}
+	}
+	if events[0].Tx.TxID != tx.TxID || events[2].Tx.TxID != tx.TxID || events[2].Reason != RemovedMined {
+		t.Errorf("tx events = %+v, %+v; want %x accepted and mined", events[0], events[2], tx.TxID)
+	}
+	if e := events[1]; e.Height != 1 || e.Hash != bch.CalculateHash(block) {
+		t.Errorf("block event = height %d, hash %x; want block 1", e.Height, e.Hash)
+	}
+	if events := receive(blocksOnly); len(events) != 1 || events[0].Kind != EventBlockConnected {
+		t.Errorf("filtered subscription got %+v, want one connected block", events)
+	}
+}
+
+func TestSubscribe_ReorgEvents(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+
+	mainTx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	main1 := mineOnParent(t, bch, genesis, mainTx)
+	if _, err := bch.ProcessBlock(main1); err != nil {
+		t.Fatalf("ProcessBlock(main1) error = %v", err)
+	}
+	side1 := mineOnParent(t, bch, genesis, signedTestTransaction(t, bch, users[2], users[1], 10))
+	if _, err := bch.ProcessBlock(side1); err != nil {
+		t.Fatalf("ProcessBlock(side1) error = %v", err)
+	}
+
+	sub := bch.Subscribe(16)
+	defer sub.Unsubscribe()
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 5, To: users[2].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	side2 := mineOnParent(t, bch, side1, coinbase)
+	if status, err := bch.ProcessBlock(side2); err != nil || status != BlockReorganized {
+		t.Fatalf("ProcessBlock(side2) = %v, %v; want reorganized", status, err)
+	}
+
+	events := receive(sub)
+	want := []EventKind{EventReorgStarted, EventBlockDisconnected, EventBlockConnected, EventBlockConnected, EventTxAccepted}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %v: %+v", len(events), want, events)
+	}
+	for i, e := range events {
+		if e.Kind != want[i] {
+			t.Errorf("event %d = %v, want %v", i, e.Kind, want[i])
+		}
+	}
+	if e := events[0]; e.ForkHeight != 0 || e.Depth != 1 || e.Height != 2 || e.Hash != bch.CalculateHash(side2) {
+		t.Errorf("reorg event = %+v, want fork at 0, depth 1, new tip side2 at 2", e)
+	}
+	if e := events[1]; e.Height != 1 || e.Hash != bch.CalculateHash(main1) {
+		t.Errorf("disconnected event = height %d, hash %x; want main1", e.Height, e.Hash)
+	}
+	if events[4].Tx.TxID != mainTx.TxID {
+		t.Errorf("accepted tx = %x, want the transaction of main1", events[4].Tx.TxID)
+	}
+}
+
+func TestSubscribe_SlowSubscriberIsDropped(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	slow := bch.Subscribe(1)
+	fast := bch.Subscribe(8)
+	defer fast.Unsubscribe()
+
+	for _, from := range users[:2] {
+		if err := bch.SubmitTransaction(signedTestTransaction(t, bch, from, users[2], 10)); err != nil {
+			t.Fatalf("SubmitTransaction() error = %v", err)
+		}
+	}
+
+	if events := receive(slow); len(events) != 1 {
+		t.Errorf("slow subscriber got %d events, want the 1 that fit its buffer", len(events))
+	}
+	if _, ok := <-slow.Events(); ok {
+		t.Error("slow subscription should be closed")
+	}
+	if slow.Err() != ErrSubscriberTooSlow {
+		t.Errorf("Err() = %v, want %v", slow.Err(), ErrSubscriberTooSlow)
+	}
+	if events := receive(fast); len(events) != 2 {
+		t.Errorf("fast subscriber got %d events, want 2", len(events))
+	}
+
+	fast.Unsubscribe()
+	fast.Unsubscribe()
+	if fast.Err() != nil {
+		t.Errorf("Err() after Unsubscribe = %v, want nil", fast.Err())
+	}
+}
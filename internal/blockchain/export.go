@@ -0,0 +1,240 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// ErrInvalidChainFile is returned by ImportChain for input that is not a chain
+// written by Export.
+var ErrInvalidChainFile = errors.New("blockchain: invalid chain file")
+
+// ExportFormat selects how Export writes the chain.
+type ExportFormat int
+
+const (
+	// FormatJSON is an indented JSON document, readable and easy to edit by hand.
+	FormatJSON ExportFormat = iota
+	// FormatBinary is a compact bootstrap file made of the blocks in the format of
+	// Block.Serialize.
+	FormatBinary
+)
+
+func (f ExportFormat) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatBinary:
+		return "binary"
+	}
+	return "unknown"
+}
+
+// ParseExportFormat parses the name of an export format.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch name {
+	case "json":
+		return FormatJSON, nil
+	case "binary", "bin":
+		return FormatBinary, nil
+	}
+	return 0, fmt.Errorf("unknown chain format %q, want json or binary", name)
+}
+
+// chainFileVersion is the version of both export formats.
+const chainFileVersion = 1
+
+// bootstrapMagic starts every binary chain file, which is how ImportChain tells the
+// formats apart.
+var bootstrapMagic = [4]byte{'B', 'U', 'Z', 'D'}
+
+// chainFile is the JSON export format. Users carries the registered public keys, which
+// the signatures of the blocks are checked against.
+type chainFile struct {
+	Version int         `json:"version"`
+	Users   []chainUser `json:"users"`
+	Blocks  []d.Block   `json:"blocks"`
+}
+
+type chainUser struct {
+	Address   d.PublicAddress `json:"address"`
+	PublicKey d.PublicKey     `json:"public_key"`
+}
+
+// Export writes the main chain and the registered users' public keys in the given
+// format. ImportChain reads either format back.
+func (bch *Blockchain) Export(w io.Writer, format ExportFormat) error {
+	bch.chainMutex.RLock()
+	firstBody := bch.firstBody
+	bch.chainMutex.RUnlock()
+	if firstBody > 0 {
+		return fmt.Errorf("exporting blocks below height %d: %w", firstBody, bch.missingBody(0))
+	}
+	users := bch.Users()
+	slices.SortFunc(users, func(a, b d.User) int {
+		return bytes.Compare(a.PublicAddress[:], b.PublicAddress[:])
+	})
+	blocks := bch.Blocks()
+
+	switch format {
+	case FormatJSON:
+		file := chainFile{Version: chainFileVersion, Blocks: blocks, Users: make([]chainUser, len(users))}
+		for i, u := range users {
+			file.Users[i] = chainUser{Address: u.PublicAddress, PublicKey: u.PublicKey}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(file)
+	case FormatBinary:
+		bw := bufio.NewWriter(w)
+		bw.Write(bootstrapMagic[:])
+		_ = binary.Write(bw, binary.LittleEndian, uint32(chainFileVersion))
+		_ = binary.Write(bw, binary.LittleEndian, uint32(len(users)))
+		for _, u := range users {
+			bw.Write(u.PublicKey[:])
+		}
+		_ = binary.Write(bw, binary.LittleEndian, uint32(len(blocks)))
+		for _, b := range blocks {
+			raw := b.Serialize()
+			_ = binary.Write(bw, binary.LittleEndian, uint32(len(raw)))
+			bw.Write(raw)
+		}
+		return bw.Flush()
+	}
+	return fmt.Errorf("unknown chain format %d", format)
+}
+
+// maxBootstrapBlockSize bounds the size of a block read from a binary chain file.
+const maxBootstrapBlockSize = 32 << 20
+
+// ImportChain builds a new chain from a file written by Export in either format. The
+// users are registered first, and every block then goes through the full validation of
+// ProcessBlock, so a tampered file is rejected at the first invalid block.
+func ImportChain(r io.Reader, hasher c.Hasher, signer c.TransactionSigner, opts ...Option) (*Blockchain, error) {
+	users, blocks, err := ReadChain(r, hasher)
+	if err != nil {
+		return nil, err
+	}
+	bch := NewBlockchain(hasher, signer, opts...)
+	bch.RegisterUsers(users)
+	for height, b := range blocks {
+		status, err := bch.ProcessBlock(b)
+		if err == nil && status != BlockConnected {
+			err = fmt.Errorf("%w: block does not extend the chain", ErrInvalidChainFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", height, err)
+		}
+	}
+	return bch, nil
+}
+
+// ReadChain reads the users and blocks of a file written by Export in either format
+// without validating the blocks.
+func ReadChain(r io.Reader, hasher c.Hasher) ([]d.User, []d.Block, error) {
+	br := bufio.NewReader(r)
+	var (
+		users  []d.User
+		blocks []d.Block
+		err    error
+	)
+	if magic, peekErr := br.Peek(len(bootstrapMagic)); peekErr == nil && bytes.Equal(magic, bootstrapMagic[:]) {
+		users, blocks, err = readBootstrap(br)
+		// The binary format does not carry transaction ids, so they are computed the
+		// same way as for blocks received from peers.
+		for _, b := range blocks {
+			for i, tx := range b.Body.Transactions {
+				b.Body.Transactions[i].TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+			}
+		}
+	} else {
+		users, blocks, err = readChainJSON(br)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, nil, fmt.Errorf("%w: no blocks", ErrInvalidChainFile)
+	}
+	return users, blocks, nil
+}
+
+func readChainJSON(r io.Reader) ([]d.User, []d.Block, error) {
+	var file chainFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidChainFile, err)
+	}
+	if file.Version != chainFileVersion {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidChainFile, file.Version)
+	}
+	users := make([]d.User, len(file.Users))
+	for i, u := range file.Users {
+		if d.PublicAddress(c.GenerateAddress(u.PublicKey[:])) != u.Address {
+			return nil, nil, fmt.Errorf("%w: address %x does not belong to its public key", ErrInvalidChainFile, u.Address)
+		}
+		users[i] = d.User{PublicAddress: u.Address, PublicKey: u.PublicKey}
+	}
+	return users, file.Blocks, nil
+}
+
+func readBootstrap(r io.Reader) ([]d.User, []d.Block, error) {
+	invalid := func(err error) error {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidChainFile, err)
+	}
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+		Users   uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, nil, invalid(err)
+	}
+	if header.Version != chainFileVersion {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidChainFile, header.Version)
+	}
+	var users []d.User
+	for range header.Users {
+		var key d.PublicKey
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return nil, nil, invalid(err)
+		}
+		users = append(users, d.User{PublicAddress: c.GenerateAddress(key[:]), PublicKey: key})
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, nil, invalid(err)
+	}
+	var blocks []d.Block
+	for range count {
+		var size uint32
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, nil, invalid(err)
+		}
+		if size > maxBootstrapBlockSize {
+			return nil, nil, fmt.Errorf("%w: block of %d bytes", ErrInvalidChainFile, size)
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, nil, invalid(err)
+		}
+		br := bytes.NewReader(raw)
+		b, err := d.DeserializeBlock(br)
+		if err != nil || br.Len() != 0 {
+			return nil, nil, fmt.Errorf("%w: malformed block %d", ErrInvalidChainFile, len(blocks))
+		}
+		blocks = append(blocks, b)
+	}
+	return users, blocks, nil
+}
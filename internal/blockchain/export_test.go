@@ -0,0 +1,72 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+func TestExportImport(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+	if _, err := bch.ProcessBlock(mineOnParent(t, bch, genesis, signedTestTransaction(t, bch, users[0], users[1], 10))); err != nil {
+		t.Fatalf("ProcessBlock() error = %v", err)
+	}
+	tipHash, _ := bch.GetLatestBlockHash()
+
+	for _, format := range []ExportFormat{FormatJSON, FormatBinary} {
+		t.Run(format.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := bch.Export(&buf, format); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			imported, err := ImportChain(&buf, c.NewArchasHasher(), c.NewTransactionSigner())
+			if err != nil {
+				t.Fatalf("ImportChain() error = %v", err)
+			}
+			if got, _ := imported.GetLatestBlockHash(); imported.Len() != bch.Len() || got != tipHash {
+				t.Errorf("imported chain has %d blocks and tip %s, want %d and %s", imported.Len(), got.String(), bch.Len(), tipHash.String())
+			}
+			for _, u := range users {
+				if imported.GetUserBalance(u.PublicAddress) != bch.GetUserBalance(u.PublicAddress) {
+					t.Errorf("balance of %s = %d, want %d", u.Name, imported.GetUserBalance(u.PublicAddress), bch.GetUserBalance(u.PublicAddress))
+				}
+			}
+			if len(imported.Users()) != len(users) {
+				t.Errorf("imported %d users, want %d", len(imported.Users()), len(users))
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := bch.Export(&buf, FormatJSON); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	var file chainFile
+	if err := json.Unmarshal(buf.Bytes(), &file); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	file.Blocks[1].Body.Transactions[0].Outputs[0].Value++
+	tampered, _ := json.Marshal(file)
+	if _, err := ImportChain(bytes.NewReader(tampered), c.NewArchasHasher(), c.NewTransactionSigner()); err == nil {
+		t.Error("ImportChain() accepted a chain with a modified transaction")
+	}
+
+	file.Users[0].Address = d.PublicAddress{1}
+	tampered, _ = json.Marshal(file)
+	if _, err := ImportChain(bytes.NewReader(tampered), c.NewArchasHasher(), c.NewTransactionSigner()); !errors.Is(err, ErrInvalidChainFile) {
+		t.Errorf("ImportChain() with a mismatched address error = %v, want %v", err, ErrInvalidChainFile)
+	}
+
+	buf.Reset()
+	if err := bch.Export(&buf, FormatBinary); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if _, err := ImportChain(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), c.NewArchasHasher(), c.NewTransactionSigner()); !errors.Is(err, ErrInvalidChainFile) {
+		t.Errorf("ImportChain() of a truncated file error = %v, want %v", err, ErrInvalidChainFile)
+	}
+}
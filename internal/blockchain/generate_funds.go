@@ -8,7 +8,8 @@ import (
 	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
 )
 
-func GenerateFundTransactionsForUsers(users []d.User, low, high uint32, hasher c.Hasher) (Transactions, error) {
+// GenerateFundTransactionsForUsers creates one coinbase per user with an amount drawn from rng.
+func GenerateFundTransactionsForUsers(users []d.User, low, high uint32, hasher c.Hasher, rng *rand.Rand) (Transactions, error) {
 	var txs Transactions
 	for _, usr := range users {
 		var amount uint32
@@ -19,7 +20,7 @@ func GenerateFundTransactionsForUsers(users []d.User, low, high uint32, hasher c
 			if delta <= 0 {
 				amount = low
 			} else {
-				amount = low + uint32(rand.Intn(delta))
+				amount = low + uint32(rng.Intn(delta))
 			}
 		}
 		var utxos []uint32
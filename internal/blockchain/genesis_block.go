@@ -2,19 +2,17 @@ package blockchain
 
 import (
 	"context"
-	"time"
 
 	"github.com/Quikmove/blockchain-uzd2/internal/config"
 	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
 	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
 )
 
-func CreateGenesisBlock(ctx context.Context, txs Transactions, conf *config.Config, hasher c.Hasher) (d.Block, error) {
-	t := time.Now()
+func CreateGenesisBlock(ctx context.Context, txs Transactions, conf *config.Config, hasher c.Hasher, timestamp uint32) (d.Block, error) {
 	merkleRoot := merkleRootHash(txs, hasher)
 	header := d.NewHeader(
 		conf.Version,
-		uint32(t.Unix()),
+		timestamp,
 		d.Hash32{},
 		merkleRoot,
 		conf.Difficulty,
@@ -0,0 +1,195 @@
+package blockchain
+
+import (
+	"sync"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// Mempool holds validated transactions waiting to be included in a block.
+type Mempool struct {
+	txs   map[d.Hash32]d.Transaction
+	order []d.Hash32
+	spent map[d.Outpoint]d.Hash32
+	// bytes is the serialized size of txs.
+	bytes int
+	mutex *sync.RWMutex
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{
+		txs:   make(map[d.Hash32]d.Transaction),
+		spent: make(map[d.Outpoint]d.Hash32),
+		mutex: &sync.RWMutex{},
+	}
+}
+
+// add stores tx unless it is already known or spends an outpoint claimed by another
+// mempool transaction. The check is repeated here, under the lock, because two
+// conflicting transactions may both have been validated before either was added.
+func (m *Mempool) add(tx d.Transaction) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.txs[tx.TxID]; exists {
+		return d.ErrTxInMempool
+	}
+	for _, in := range tx.Inputs {
+		if _, conflict := m.spent[in.Prev]; conflict {
+			return d.ErrDoubleSpend
+		}
+	}
+
+	m.txs[tx.TxID] = tx
+	m.bytes += len(tx.Serialize())
+	m.order = append(m.order, tx.TxID)
+	for _, in := range tx.Inputs {
+		m.spent[in.Prev] = tx.TxID
+	}
+	return nil
+}
+
+func (m *Mempool) removeLocked(txID d.Hash32) {
+	tx, exists := m.txs[txID]
+	if !exists {
+		return
+	}
+	delete(m.txs, txID)
+	m.bytes -= len(tx.Serialize())
+	for _, in := range tx.Inputs {
+		delete(m.spent, in.Prev)
+	}
+	for i, id := range m.order {
+		if id == txID {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// removeForBlock drops transactions confirmed by b and any that conflict with its
+// spends, and returns both.
+func (m *Mempool) removeForBlock(b d.Block) (mined, conflicts []d.Transaction) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, tx := range b.Body.Transactions {
+		if pending, ok := m.txs[tx.TxID]; ok {
+			m.removeLocked(tx.TxID)
+			mined = append(mined, pending)
+		}
+		for _, in := range tx.Inputs {
+			if conflicting, ok := m.spent[in.Prev]; ok {
+				conflicts = append(conflicts, m.txs[conflicting])
+				m.removeLocked(conflicting)
+			}
+		}
+	}
+	return mined, conflicts
+}
+
+// drain empties the mempool and returns its transactions in arrival order.
+func (m *Mempool) drain() []d.Transaction {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	txs := make([]d.Transaction, 0, len(m.order))
+	for _, id := range m.order {
+		txs = append(txs, m.txs[id])
+	}
+	m.txs = make(map[d.Hash32]d.Transaction)
+	m.order = nil
+	m.spent = make(map[d.Outpoint]d.Hash32)
+	m.bytes = 0
+	return txs
+}
+
+// Get returns the mempool transaction with the given id.
+func (m *Mempool) Get(txID d.Hash32) (d.Transaction, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	tx, exists := m.txs[txID]
+	return tx, exists
+}
+
+// Transactions returns up to limit transactions in arrival order. A limit of zero or less returns all of them.
+func (m *Mempool) Transactions(limit int) []d.Transaction {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	n := len(m.order)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	txs := make([]d.Transaction, 0, n)
+	for _, id := range m.order[:n] {
+		txs = append(txs, m.txs[id])
+	}
+	return txs
+}
+
+// IsSpent reports whether a mempool transaction already spends outpoint.
+func (m *Mempool) IsSpent(outpoint d.Outpoint) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	_, spent := m.spent[outpoint]
+	return spent
+}
+
+func (m *Mempool) Len() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.txs)
+}
+
+// Bytes returns the serialized size of the mempool transactions.
+func (m *Mempool) Bytes() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.bytes
+}
+
+// SubmitTransaction validates tx against the current chain state and admits it to the mempool.
+func (bch *Blockchain) SubmitTransaction(tx d.Transaction) error {
+	// Holding chainMutex keeps the UTXO set that tx was validated against until it is
+	// added, and keeps a block that confirms tx from publishing its removal before its
+	// acceptance. Conflicts with transactions submitted meanwhile are caught by add,
+	// which checks them under the mempool's lock.
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	if err := bch.ValidateTransaction(tx); err != nil {
+		return err
+	}
+	if err := bch.mempool.add(tx); err != nil {
+		return err
+	}
+	bch.events.publish(Event{Kind: EventTxAccepted, Tx: tx})
+	return nil
+}
+
+// removeMempoolForBlock drops the mempool transactions b confirms or conflicts with.
+func (bch *Blockchain) removeMempoolForBlock(b d.Block) {
+	mined, conflicts := bch.mempool.removeForBlock(b)
+	bch.publishTxRemoved(mined, RemovedMined)
+	bch.publishTxRemoved(conflicts, RemovedConflict)
+}
+
+func (bch *Blockchain) Mempool() *Mempool {
+	return bch.mempool
+}
+
+// candidateTransactions returns mempool transactions followed by random transactions, n in total.
+func (bch *Blockchain) candidateTransactions(users []d.User, low, high, n int) (Transactions, error) {
+	txs := Transactions(bch.mempool.Transactions(n))
+	if len(txs) >= n {
+		return txs, nil
+	}
+	generated, err := bch.GenerateRandomTransactions(users, low, high, n-len(txs))
+	if err != nil {
+		if len(txs) > 0 {
+			return txs, nil
+		}
+		return nil, err
+	}
+	return append(txs, generated...), nil
+}
@@ -0,0 +1,162 @@
+package blockchain
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+func signedTestTransaction(t *testing.T, bch *Blockchain, from, to d.User, value uint32) d.Transaction {
+	t.Helper()
+	hasher := c.NewArchasHasher()
+	txSigner := c.NewTransactionSigner()
+
+	utxo := bch.GetUTXOsForAddress(from.PublicAddress)[0]
+	if value > utxo.Value {
+		value = utxo.Value
+	}
+	tx := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+		Outputs: []d.TxOutput{{Value: value, To: to.PublicAddress}},
+	}
+	tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+	if err := SignInput(&tx, 0, utxo, d.SigHashAll, from.GetPrivateKeyObject(), txSigner, hasher); err != nil {
+		t.Fatalf("SignInput() error = %v", err)
+	}
+	return tx
+}
+
+func TestSubmitTransaction(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+
+	tx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	if got, ok := bch.Mempool().Get(tx.TxID); !ok || got.TxID != tx.TxID {
+		t.Error("Submitted transaction should be in the mempool")
+	}
+
+	if err := bch.SubmitTransaction(tx); err != d.ErrTxInMempool {
+		t.Errorf("Resubmission error = %v, want %v", err, d.ErrTxInMempool)
+	}
+
+	spent, _ := bch.GetUTXO(tx.Inputs[0].Prev)
+	conflict := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: spent.Outpoint}},
+		Outputs: []d.TxOutput{{Value: spent.Value, To: users[2].PublicAddress}},
+	}
+	conflict.TxID = bch.HashTransaction(conflict)
+	if err := SignInput(&conflict, 0, spent, d.SigHashAll, users[0].GetPrivateKeyObject(), c.NewTransactionSigner(), c.NewArchasHasher()); err != nil {
+		t.Fatalf("SignInput() error = %v", err)
+	}
+	if err := bch.SubmitTransaction(conflict); err != d.ErrDoubleSpend {
+		t.Errorf("Conflicting submission error = %v, want %v", err, d.ErrDoubleSpend)
+	}
+}
+
+func TestSubmitTransaction_ConcurrentConflicts(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	var utxo d.UTXO
+	for _, u := range bch.GetUTXOsForAddress(users[0].PublicAddress) {
+		if u.Value > utxo.Value {
+			utxo = u
+		}
+	}
+
+	// Every transaction spends the same output, so only one may enter the mempool.
+	txs := make([]d.Transaction, 8)
+	for i := range txs {
+		tx := d.Transaction{
+			Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+			Outputs: []d.TxOutput{{Value: utxo.Value - uint32(i), To: users[1].PublicAddress}},
+		}
+		tx.TxID = bch.HashTransaction(tx)
+		if err := SignInput(&tx, 0, utxo, d.SigHashAll, users[0].GetPrivateKeyObject(), c.NewTransactionSigner(), c.NewArchasHasher()); err != nil {
+			t.Fatalf("SignInput() error = %v", err)
+		}
+		txs[i] = tx
+	}
+
+	errs := make([]error, len(txs))
+	var wg sync.WaitGroup
+	for i, tx := range txs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = bch.SubmitTransaction(tx)
+		}()
+	}
+	wg.Wait()
+
+	accepted := 0
+	for i, err := range errs {
+		switch err {
+		case nil:
+			accepted++
+		case d.ErrDoubleSpend:
+		default:
+			t.Errorf("SubmitTransaction(%d) error = %v, want nil or %v", i, err, d.ErrDoubleSpend)
+		}
+	}
+	if accepted != 1 || bch.Mempool().Len() != 1 {
+		t.Errorf("%d transactions accepted and %d in the mempool, want 1", accepted, bch.Mempool().Len())
+	}
+}
+
+func TestSubmitTransaction_RejectsInvalid(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+
+	tx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	tx.Inputs[0].Sig = nil
+	if err := bch.SubmitTransaction(tx); err == nil {
+		t.Error("Expected error for unsigned transaction")
+	}
+
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 10, To: users[0].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	if err := bch.SubmitTransaction(coinbase); err != d.ErrInvalidTransaction {
+		t.Errorf("Coinbase submission error = %v, want %v", err, d.ErrInvalidTransaction)
+	}
+	if bch.Mempool().Len() != 0 {
+		t.Error("Rejected transactions should not enter the mempool")
+	}
+}
+
+func TestMempool_RemovedWhenMined(t *testing.T) {
+	bch, users, cfg := setupTestBlockchain()
+
+	tx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+
+	txs, err := bch.candidateTransactions(users, 1, 10, 3)
+	if err != nil {
+		t.Fatalf("candidateTransactions() error = %v", err)
+	}
+	if txs[0].TxID != tx.TxID {
+		t.Fatal("Mempool transactions should come first in a candidate block")
+	}
+	for _, candidate := range txs[1:] {
+		for _, in := range candidate.Inputs {
+			if in.Prev == tx.Inputs[0].Prev {
+				t.Fatal("Random transactions must not spend outputs claimed by the mempool")
+			}
+		}
+	}
+
+	block, err := bch.GenerateBlock(context.Background(), *d.NewBody(txs), cfg.Version, cfg.Difficulty)
+	if err != nil {
+		t.Fatalf("GenerateBlock() error = %v", err)
+	}
+	if err := bch.AddBlock(block); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+	if bch.Mempool().Len() != 0 {
+		t.Errorf("Mempool size after mining = %d, want 0", bch.Mempool().Len())
+	}
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/metrics"
+)
+
+// chainMetrics are the measurements a Blockchain takes while it validates and
+// reorganizes. RegisterMetrics exposes them together with gauges read from its state.
+type chainMetrics struct {
+	blockValidation *metrics.Histogram
+	txValidation    *metrics.Histogram
+	validSigs       *metrics.Counter
+	invalidSigs     *metrics.Counter
+	reorgs          *metrics.Counter
+	reorgDepth      *metrics.Histogram
+}
+
+func newChainMetrics() *chainMetrics {
+	return &chainMetrics{
+		blockValidation: metrics.NewHistogram(metrics.DefaultBuckets),
+		txValidation:    metrics.NewHistogram(metrics.DefaultBuckets),
+		validSigs:       metrics.NewCounter(),
+		invalidSigs:     metrics.NewCounter(),
+		reorgs:          metrics.NewCounter(),
+		reorgDepth:      metrics.NewHistogram([]float64{1, 2, 3, 5, 10, 20, 50, 100}),
+	}
+}
+
+// observeSince records the seconds elapsed since start in h.
+func observeSince(h *metrics.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// RegisterMetrics adds the chain, mempool, validation and mining metrics to r.
+func (bch *Blockchain) RegisterMetrics(r *metrics.Registry) {
+	m := bch.metrics
+	r.RegisterGaugeFunc("blockchain_height", "Height of the main chain tip.", nil, func() float64 {
+		return float64(bch.Len() - 1)
+	})
+	r.RegisterGaugeFunc("blockchain_prune_height", "Height of the oldest main chain block whose body is kept.", nil, func() float64 {
+		return float64(bch.PruneHeight())
+	})
+	r.RegisterGaugeFunc("blockchain_utxo_set_size", "Number of unspent transaction outputs.", nil, func() float64 {
+		return float64(bch.utxoTracker.Len())
+	})
+	r.RegisterGaugeFunc("blockchain_mempool_transactions", "Number of transactions in the mempool.", nil, func() float64 {
+		return float64(bch.mempool.Len())
+	})
+	r.RegisterGaugeFunc("blockchain_mempool_bytes", "Serialized size of the transactions in the mempool.", nil, func() float64 {
+		return float64(bch.mempool.Bytes())
+	})
+
+	const validationHelp = "Time spent validating a block: its header, Merkle root and proof of work (stage block) or its transactions against the UTXO set (stage transactions)."
+	r.RegisterHistogram("blockchain_block_validation_seconds", validationHelp, metrics.Labels{"stage": "block"}, m.blockValidation)
+	r.RegisterHistogram("blockchain_block_validation_seconds", validationHelp, metrics.Labels{"stage": "transactions"}, m.txValidation)
+	const sigHelp = "Number of input signatures verified, by result."
+	r.RegisterCounter("blockchain_signature_verifications_total", sigHelp, metrics.Labels{"result": "valid"}, m.validSigs)
+	r.RegisterCounter("blockchain_signature_verifications_total", sigHelp, metrics.Labels{"result": "invalid"}, m.invalidSigs)
+	r.RegisterCounter("blockchain_reorgs_total", "Number of reorganizations onto a branch with more work.", nil, m.reorgs)
+	r.RegisterHistogram("blockchain_reorg_depth_blocks", "Number of blocks disconnected by a reorganization.", nil, m.reorgDepth)
+
+	r.RegisterGaugeFunc("blockchain_mining_hashrate", "Hashes per second of the most recent mining round.", nil, func() float64 {
+		if last := bch.MiningStats().Last; last != nil {
+			return last.Hashrate()
+		}
+		return 0
+	})
+	r.RegisterCounterFunc("blockchain_mining_hashes_total", "Number of nonces tried by the miner.", nil, func() float64 {
+		return float64(bch.MiningStats().Hashes)
+	})
+	r.RegisterCounterFunc("blockchain_mining_wasted_hashes_total", "Number of nonces tried in stale and cancelled mining rounds.", nil, func() float64 {
+		return float64(bch.MiningStats().WastedHashes)
+	})
+	r.RegisterCounterFunc("blockchain_mining_seconds_total", "Wall clock time the miner spent searching nonces.", nil, func() float64 {
+		return bch.MiningStats().Time.Seconds()
+	})
+	const roundsHelp = "Number of mining rounds, by how they ended."
+	for _, kind := range []MiningEventKind{BlockMined, RoundStale, RoundCancelled} {
+		r.RegisterCounterFunc("blockchain_mining_rounds_total", roundsHelp, metrics.Labels{"result": kind.String()}, func() float64 {
+			stats := bch.MiningStats()
+			switch kind {
+			case RoundStale:
+				return float64(stats.StaleRounds)
+			case RoundCancelled:
+				return float64(stats.CancelledRounds)
+			}
+			return float64(stats.Blocks)
+		})
+	}
+}
@@ -3,107 +3,124 @@ package blockchain
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"runtime"
-	"sync"
 	"time"
 
 	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
 	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
 )
 
+// MineBlocks mines blockCount blocks of txCount transactions each. Every round builds
+// one candidate from the mempool and random transactions, and all CPUs search its
+// nonces together for the lowest valid one. The mined chain therefore depends only on
+// the chain's random generator and clock, not on how the workers were scheduled.
 func (bch *Blockchain) MineBlocks(parentCtx context.Context, blockCount, txCount, low, high int, users []d.User, version, difficulty uint32) error {
 	if blockCount <= 0 {
 		return nil
 	}
 
-	numWorkers := runtime.NumCPU()
-
 	for round := 0; round < blockCount; round++ {
-		if parentCtx.Err() != nil {
-			return parentCtx.Err()
+		blk, event, err := bch.mineBlock(parentCtx, txCount, low, high, users, version, difficulty)
+		if err != nil {
+			return err
 		}
+		bch.logger.Info("Mined block",
+			"round", round+1,
+			"height", event.Height,
+			"hash", event.Hash.String(),
+			"txs", len(blk.Body.Transactions),
+			"nonce", blk.Header.Nonce,
+			"hashes", event.Hashes,
+			"duration", event.Duration.Round(time.Millisecond),
+			"hashrate", event.Hashrate())
+	}
+	return nil
+}
 
-		roundCtx, cancelRound := context.WithCancel(parentCtx)
-
-		blockChan := make(chan d.Block, 1)
-
-		var wg sync.WaitGroup
-		wg.Add(numWorkers)
-
-		for i := 0; i < numWorkers; i++ {
-			go func(ctx context.Context, workerID int) {
-				defer wg.Done()
-				for {
-					if ctx.Err() != nil {
-						return
-					}
-
-					txs, err := bch.GenerateRandomTransactions(users, low, high, txCount)
-					if err != nil {
-						if errors.Is(err, context.Canceled) {
-							return
-						}
-						continue
-					}
-
-					if len(txs) == 0 {
-						continue
-					}
-
-					body := d.NewBody(txs)
-
-					blk, err := bch.generateBlockWithTimestamp(ctx, *body, version, difficulty, uint32(time.Now().Unix())+uint32(workerID))
-					if err != nil {
-						if errors.Is(err, context.Canceled) {
-							return
-						}
-						continue
-					}
-
-					err = bch.AddBlock(blk)
-					if err != nil {
-						if errors.Is(err, context.Canceled) {
-							return
-						}
-						continue
-					}
+// BlockReward returns what the coinbase of a block mined on the chain pays.
+func (bch *Blockchain) BlockReward() uint32 {
+	return bch.blockReward
+}
 
-					select {
-					case blockChan <- blk:
-					default:
-					}
-					return
-				}
-			}(roundCtx, i)
+// mineBlock mines and connects one block. A mined block no longer fits when another
+// block, for example one received from a peer, extended the chain in the meantime;
+// the round is then repeated on the new tip. Every round is recorded as a MiningEvent,
+// and the event of the round that mined the block is returned with it.
+func (bch *Blockchain) mineBlock(ctx context.Context, txCount, low, high int, users []d.User, version, difficulty uint32) (d.Block, MiningEvent, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return d.Block{}, MiningEvent{}, err
 		}
-
-		select {
-		case blk := <-blockChan:
-			blockIndex := bch.Len() - 1
-			header := blk.Header
-			blockBody := blk.Body
-			blockTxs := blockBody.Transactions
-			log.Printf("Round %d: Successfully mined block at index %d (block #%d) with %d transactions and nonce %d\n", round+1, blockIndex, round+1, len(blockTxs), header.Nonce)
-		case <-parentCtx.Done():
-			cancelRound()
-			wg.Wait()
-			return parentCtx.Err()
+		txs, err := bch.candidateTransactions(users, low, high, txCount)
+		if err != nil {
+			return d.Block{}, MiningEvent{}, err
 		}
-
-		cancelRound()
-
-		wg.Wait()
+		if len(users) > 0 && bch.blockReward > 0 {
+			bch.txGenMutex.Lock()
+			miner := users[bch.rng.Intn(len(users))]
+			bch.txGenMutex.Unlock()
+			txs = append(Transactions{bch.newCoinbase([]d.TxOutput{{To: miner.PublicAddress, Value: bch.blockReward}})}, txs...)
+		}
+		event := MiningEvent{Height: bch.Len(), WorkerHashes: make([]uint64, runtime.NumCPU())}
+		start := time.Now()
+		blk, err := bch.generateBlockWithTimestamp(ctx, *d.NewBody(txs), version, difficulty, uint32(bch.clock.Now().Unix()), event.WorkerHashes)
+		event.Duration = time.Since(start)
+		for _, h := range event.WorkerHashes {
+			event.Hashes += h
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				event.Kind = RoundCancelled
+				bch.recordRound(event)
+			}
+			return d.Block{}, MiningEvent{}, err
+		}
+		event.Hash = bch.CalculateHash(blk)
+		event.Nonce = blk.Header.Nonce
+		event.Transactions = len(blk.Body.Transactions)
+		if err := bch.AddBlock(blk); err != nil {
+			if tipHash, tipErr := bch.GetLatestBlockHash(); tipErr == nil && tipHash != blk.Header.PrevHash {
+				event.Kind = RoundStale
+				bch.recordRound(event)
+				continue
+			}
+			return d.Block{}, MiningEvent{}, err
+		}
+		event.Kind = BlockMined
+		bch.recordRound(event)
+		return blk, event, nil
 	}
+}
 
-	if parentCtx.Err() != nil {
-		return parentCtx.Err()
+// recordRound records a mining round and logs it at debug level, with one line per
+// worker. Mined blocks are logged at info level by MineBlocks.
+func (bch *Blockchain) recordRound(e MiningEvent) {
+	bch.telemetry.record(e)
+	if !bch.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	if e.Kind != BlockMined {
+		bch.logger.Debug("Mining round ended",
+			"result", e.Kind.String(),
+			"height", e.Height,
+			"hashes", e.Hashes,
+			"duration", e.Duration.Round(time.Millisecond))
+	}
+	for i, h := range e.WorkerHashes {
+		bch.logger.Debug("Mining worker",
+			"result", e.Kind.String(),
+			"height", e.Height,
+			"worker", i,
+			"hashes", h,
+			"hashrate", hashrate(h, e.Duration))
 	}
-	return nil
 }
 
-func (bch *Blockchain) generateBlockWithTimestamp(ctx context.Context, body d.Body, version uint32, difficulty uint32, timestamp uint32) (d.Block, error) {
-	latestBlock, err := bch.GetLatestBlock()
+// generateBlockWithTimestamp seals a block on the tip with one worker per element of hashes,
+// counting the nonces each of them tried there.
+func (bch *Blockchain) generateBlockWithTimestamp(ctx context.Context, body d.Body, version uint32, difficulty uint32, timestamp uint32, hashes []uint64) (d.Block, error) {
+	latestHash, err := bch.GetLatestBlockHash()
 	if err != nil {
 		return d.Block{}, err
 	}
@@ -111,218 +128,78 @@ func (bch *Blockchain) generateBlockWithTimestamp(ctx context.Context, body d.Bo
 
 	newHeader.Version = version
 	newHeader.Timestamp = timestamp
-	newHeader.PrevHash = bch.CalculateHash(latestBlock)
-	newHeader.MerkleRoot = MerkleRootHash(body, bch.hasher)
+	newHeader.PrevHash = latestHash
 	newHeader.Difficulty = difficulty
 
-	nonce, _, err := FindValidNonce(ctx, &newHeader, bch.hasher)
-	if err != nil {
-		return d.Block{}, err
-	}
-	newHeader.Nonce = nonce
-
 	newBlock := d.Block{
 		Header: newHeader,
 		Body:   body,
 	}
+	if err := bch.sealBlock(ctx, &newBlock, len(hashes), hashes); err != nil {
+		return d.Block{}, err
+	}
 
 	return newBlock, nil
 }
 
-func FindValidNonce(ctx context.Context, header *d.Header, hasher c.Hasher) (uint32, d.Hash32, error) {
+// newCoinbase creates the coinbase with the given outputs for a block on the current
+// tip. MineBlocks pays the block reward to a user picked with the chain's random
+// generator, who stands in for the miner.
+func (bch *Blockchain) newCoinbase(outputs []d.TxOutput) d.Transaction {
+	coinbase := d.NewCoinbase(uint32(bch.Len()), 0, outputs)
+	coinbase.TxID = bch.HashTransaction(*coinbase)
+	return *coinbase
+}
 
-	if header.Difficulty == 0 {
-		hash := hasher.Hash(header.Serialize())
-		return header.Nonce, hash, nil
-	}
-	if header.MerkleRoot.IsZero() {
-		return 0, d.Hash32{}, d.ErrInvalidMerkleRoot
+// BlockTemplate returns an unsealed block on the current tip for an external miner:
+// a coinbase with the given outputs, which split the block reward, followed by up to
+// txCount mempool transactions. The header carries the Merkle root for extra nonce 0
+// and nonce 0.
+func (bch *Blockchain) BlockTemplate(outputs []d.TxOutput, txCount int, version, difficulty uint32) (d.Block, error) {
+	tipHash, err := bch.GetLatestBlockHash()
+	if err != nil {
+		return d.Block{}, err
 	}
+	txs := append(Transactions{bch.newCoinbase(outputs)}, bch.mempool.Transactions(txCount)...)
+	body := d.NewBody(txs)
+	header := d.NewHeader(version, uint32(bch.clock.Now().Unix()), tipHash, MerkleRootHash(*body, bch.hasher), difficulty, 0)
+	return *d.NewBlock(*header, *body), nil
+}
 
-	var nonce uint32
-
+// sealBlock sets the Merkle root of b's header and searches its nonces for the lowest
+// valid one. When no nonce is valid and the block starts with a coinbase carrying an
+// extra nonce, the extra nonce is incremented, which changes the Merkle root and with
+// it every header hash, and the search starts over. When hashes is not nil, the nonces
+// tried by worker i over all extra nonces are added to hashes[i].
+func (bch *Blockchain) sealBlock(ctx context.Context, b *d.Block, workers int, hashes []uint64) error {
+	pow := NewPoW(b.Header.Version, bch.hasher)
 	for {
-		if err := ctx.Err(); err != nil {
-			return 0, d.Hash32{}, err
-		}
-		header.Nonce = nonce
-		hash := hasher.Hash(header.Serialize())
-		if IsHashValid(hash, header.Difficulty) {
-			return nonce, hash, nil
-		}
-		nonce++
-
-		if nonce == ^uint32(0) {
-			return 0, d.Hash32{}, d.ErrNoValidNonce
+		b.Header.MerkleRoot = MerkleRootHash(b.Body, bch.hasher)
+		_, _, err := sealLowestNonce(ctx, &b.Header, NonceHasher(pow, &b.Header), workers, hashes)
+		if !errors.Is(err, d.ErrNoValidNonce) || !bch.nextExtraNonce(b.Body) {
+			return err
 		}
 	}
 }
 
-type DecentralizedMiningConfig struct {
-	BlockCount       int
-	TxCount          int
-	CandidateCount   int
-	InitialTimeLimit time.Duration
-	TimeMultiplier   float64
-	Low              int
-	High             int
-	Version          uint32
-	Difficulty       uint32
-}
-
-func DefaultDecentralizedMiningConfig() DecentralizedMiningConfig {
-	return DecentralizedMiningConfig{
-		BlockCount:       1,
-		TxCount:          100,
-		CandidateCount:   5,
-		InitialTimeLimit: 5 * time.Second,
-		TimeMultiplier:   2.0,
-		Low:              1,
-		High:             1000,
-		Version:          1,
-		Difficulty:       1,
+// nextExtraNonce increments the extra nonce of the body's coinbase and recomputes its
+// TxID. It reports false when the body has no such coinbase or its extra nonces ran out.
+func (bch *Blockchain) nextExtraNonce(body d.Body) bool {
+	if len(body.Transactions) == 0 {
+		return false
 	}
-}
-
-type blockResult struct {
-	block    d.Block
-	workerId int
-}
-
-func (bch *Blockchain) MineBlocksDecentralized(
-	parentCtx context.Context,
-	users []d.User,
-	config DecentralizedMiningConfig,
-) error {
-	if config.BlockCount <= 0 {
-		return nil
+	coinbase := &body.Transactions[0]
+	extraNonce, ok := coinbase.ExtraNonce()
+	if !ok || extraNonce == ^uint32(0) {
+		return false
 	}
-
-	for round := 0; round < config.BlockCount; round++ {
-		if err := parentCtx.Err(); err != nil {
-			return err
-		}
-
-		timeLimit := config.InitialTimeLimit
-		miningSuccess := false
-
-		for !miningSuccess {
-			if err := parentCtx.Err(); err != nil {
-				return err
-			}
-
-			candidateBlocks := make([]d.Body, 0, config.CandidateCount)
-			for i := 0; i < config.CandidateCount; i++ {
-				txs, err := bch.GenerateRandomTransactions(users, config.Low, config.High, config.TxCount)
-				if err != nil {
-					if errors.Is(err, context.Canceled) {
-						return err
-					}
-					log.Printf("Warning: failed to generate transactions for candidate %d: %v", i, err)
-					continue
-				}
-
-				if len(txs) == 0 {
-					continue
-				}
-
-				body := d.NewBody(txs)
-				candidateBlocks = append(candidateBlocks, *body)
-			}
-
-			if len(candidateBlocks) == 0 {
-				return d.ErrMiningCanceled
-			}
-
-			roundCtx, cancelRound := context.WithCancel(parentCtx)
-			timeoutCtx := roundCtx
-			var timeoutCancel context.CancelFunc
-
-			if timeLimit > 0 {
-				timeoutCtx, timeoutCancel = context.WithTimeout(roundCtx, timeLimit)
-			}
-
-			blockResultChan := make(chan blockResult, 1)
-
-			var wg sync.WaitGroup
-
-			for i, body := range candidateBlocks {
-				wg.Add(1)
-				go func(workerId int, b d.Body) {
-					defer wg.Done()
-					_ = MineBlockConcurrently(timeoutCtx, workerId, bch, b, config.Version, config.Difficulty, blockResultChan)
-				}(i, body)
-			}
-
-			select {
-			case blkResult := <-blockResultChan:
-				cancelRound()
-				if timeoutCancel != nil {
-					timeoutCancel()
-				}
-
-				wg.Wait()
-
-				err := bch.AddBlock(blkResult.block)
-				if err != nil {
-					log.Printf("Round %d: Failed to add block: %v", round+1, err)
-					timeLimit = time.Duration(float64(timeLimit) * config.TimeMultiplier)
-					continue
-				}
-				blockIndex := bch.Len() - 1
-				blockTxs := blkResult.block.Body.Transactions
-				log.Printf("Round %d: Successfully mined block at index %d (block #%d) with %d transactions and nonce %d by worker %d\n",
-					round+1, blockIndex, round+1, len(blockTxs), blkResult.block.Header.Nonce, blkResult.workerId)
-				miningSuccess = true
-			case <-timeoutCtx.Done():
-				cancelRound()
-				if timeoutCancel != nil {
-					timeoutCancel()
-				}
-				wg.Wait()
-				timeLimit = time.Duration(float64(timeLimit) * config.TimeMultiplier)
-				log.Printf("Round %d: No block mined within time limit, retrying with increased time limit: %v", round+1, timeLimit)
-			case <-parentCtx.Done():
-				cancelRound()
-				if timeoutCancel != nil {
-					timeoutCancel()
-				}
-				wg.Wait()
-				return parentCtx.Err()
-			}
-		}
-	}
-
-	return nil
+	coinbase.SetExtraNonce(extraNonce + 1)
+	coinbase.TxID = bch.HashTransaction(*coinbase)
+	return true
 }
-func MineBlockConcurrently(ctx context.Context, workerId int, bch *Blockchain, body d.Body, version, difficulty uint32, mineChan chan blockResult) error {
-	errChan := make(chan error, 1)
-	go func() {
-		latestBlock, err := bch.GetLatestBlock()
-		if err != nil {
-			errChan <- err
-			return
-		}
-		prevBlockHash := bch.CalculateHash(latestBlock)
-		header := d.NewHeader(version, uint32(time.Now().Unix()), prevBlockHash, MerkleRootHash(body, bch.hasher), difficulty, 0)
-		_, _, err = FindValidNonce(ctx, header, bch.hasher)
-		if err != nil {
-			errChan <- err
-			return
-		}
-		result := blockResult{block: *d.NewBlock(*header, body), workerId: workerId}
-		select {
-		case mineChan <- result:
-			errChan <- nil
-		case <-ctx.Done():
-			errChan <- ctx.Err()
-		}
-	}()
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-errChan:
-		return err
-	}
+// FindValidNonce seals header with the proof-of-work algorithm its version selects,
+// searching nonces from zero on a single goroutine.
+func FindValidNonce(ctx context.Context, header *d.Header, hasher c.Hasher) (uint32, d.Hash32, error) {
+	return NewPoW(header.Version, hasher).Seal(ctx, header, 1)
 }
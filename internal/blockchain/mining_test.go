@@ -0,0 +1,239 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// seededChain generates users, funds and blocks from seed alone.
+func seededChain(t *testing.T, seed int64, blocks int) *Blockchain {
+	t.Helper()
+	users := NewUserGeneratorService(c.NewKeyGenerator(), WithSeed(seed)).GenerateUsers([]string{"Alice", "Bob", "Charlie", "Dave", "Eve"}, 5)
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	bch := InitBlockchainWithFunds(100, 10000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithSeed(seed))
+	if err := bch.MineBlocks(context.Background(), blocks, 5, 1, 50, users, cfg.Version, cfg.Difficulty); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	return bch
+}
+
+func serializeChain(bch *Blockchain) []byte {
+	var buf bytes.Buffer
+	for _, b := range bch.Blocks() {
+		buf.Write(b.Serialize())
+	}
+	return buf.Bytes()
+}
+
+func TestMineBlocks_SameSeedSameChain(t *testing.T) {
+	first := serializeChain(seededChain(t, 42, 3))
+	second := serializeChain(seededChain(t, 42, 3))
+	if !bytes.Equal(first, second) {
+		t.Error("Two runs with the same seed produced different chains")
+	}
+	if other := serializeChain(seededChain(t, 43, 3)); bytes.Equal(first, other) {
+		t.Error("Runs with different seeds produced the same chain")
+	}
+}
+
+func TestWithSeed_ValidationKeepsClock(t *testing.T) {
+	validated := seededChain(t, 42, 1)
+	tip, _ := validated.GetLatestBlock()
+	block := mineOnParent(t, validated, tip, signedTestTransaction(t, validated, validated.Users()[0], validated.Users()[1], 1))
+	// Stamped by the wall clock, which is ahead of the seeded clock, the block is from
+	// the future for the seeded chain, however late it runs.
+	if err := validated.ValidateBlock(block); err == nil {
+		t.Error("ValidateBlock() accepted a block stamped far ahead of the seeded clock")
+	}
+	block.Header.Timestamp = tip.Header.Timestamp + 1
+	block = atDifficulty(t, block, block.Header.Difficulty)
+	for range 3 {
+		if err := validated.ValidateBlock(block); err != nil {
+			t.Fatalf("ValidateBlock() of a block stamped by the seeded clock error = %v", err)
+		}
+	}
+	users := NewUserGeneratorService(c.NewKeyGenerator(), WithSeed(42)).GenerateUsers([]string{"Alice", "Bob", "Charlie", "Dave", "Eve"}, 5)
+	if err := validated.MineBlocks(context.Background(), 1, 5, 1, 50, users, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	if !bytes.Equal(serializeChain(validated), serializeChain(seededChain(t, 42, 2))) {
+		t.Error("Validating blocks changed the timestamps of a seeded chain")
+	}
+}
+
+func TestMineBlocks_PaysCoinbase(t *testing.T) {
+	bch := seededChain(t, 7, 2)
+	for _, b := range bch.Blocks()[1:] {
+		coinbase := b.Body.Transactions[0]
+		if extraNonce, ok := coinbase.ExtraNonce(); !ok || extraNonce != 0 {
+			t.Errorf("Coinbase extra nonce = %d, %v, want 0, true", extraNonce, ok)
+		}
+		if len(coinbase.Outputs) != 1 || coinbase.Outputs[0].Value != DefaultBlockReward {
+			t.Errorf("Coinbase outputs = %+v, want one output of %d", coinbase.Outputs, DefaultBlockReward)
+		}
+	}
+}
+
+func TestMineBlocks_WithBlockReward(t *testing.T) {
+	users := NewUserGeneratorService(c.NewKeyGenerator(), WithSeed(7)).GenerateUsers([]string{"Alice", "Bob"}, 2)
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	for _, reward := range []uint32{0, 25} {
+		bch := InitBlockchainWithFunds(100, 10000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithSeed(7), WithBlockReward(reward))
+		if err := bch.MineBlocks(context.Background(), 1, 2, 1, 50, users, cfg.Version, cfg.Difficulty); err != nil {
+			t.Fatalf("MineBlocks() error = %v", err)
+		}
+		tip, _ := bch.GetLatestBlock()
+		coinbase := tip.Body.Transactions[0]
+		if reward == 0 {
+			if coinbase.IsCoinbase() {
+				t.Errorf("Block reward 0 mined a coinbase %+v", coinbase.Outputs)
+			}
+			continue
+		}
+		if !coinbase.IsCoinbase() || len(coinbase.Outputs) != 1 || coinbase.Outputs[0].Value != reward {
+			t.Errorf("Coinbase outputs = %+v, want one output of %d", coinbase.Outputs, reward)
+		}
+	}
+}
+
+func TestSealBlock_RollsExtraNonce(t *testing.T) {
+	defer func(limit uint32) { maxNonce = limit }(maxNonce)
+	maxNonce = 16
+
+	bch := NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner())
+	coinbase := d.NewCoinbase(1, 0, []d.TxOutput{{To: d.PublicAddress{1}, Value: DefaultBlockReward}})
+	coinbase.TxID = bch.HashTransaction(*coinbase)
+	b := d.Block{
+		Header: d.Header{Version: 1, PrevHash: d.Hash32{1}, Difficulty: 2},
+		Body:   *d.NewBody([]d.Transaction{*coinbase}),
+	}
+	if err := bch.sealBlock(context.Background(), &b, 4, nil); err != nil {
+		t.Fatalf("sealBlock() error = %v", err)
+	}
+
+	sealed := b.Body.Transactions[0]
+	extraNonce, _ := sealed.ExtraNonce()
+	if extraNonce == 0 {
+		t.Error("sealBlock() found a nonce without rolling the extra nonce; pick a harder header")
+	}
+	if sealed.TxID != bch.HashTransaction(sealed) {
+		t.Error("Coinbase TxID was not recomputed")
+	}
+	if b.Header.MerkleRoot != MerkleRootHash(b.Body, bch.hasher) {
+		t.Error("Merkle root was not rebuilt")
+	}
+	if b.Header.Nonce >= maxNonce || !bch.CheckProofOfWork(b.Header) {
+		t.Errorf("sealBlock() nonce = %d does not meet the difficulty", b.Header.Nonce)
+	}
+
+	// Without a coinbase there is nothing to roll.
+	b.Body.Transactions[0].Inputs = nil
+	b.Body.Transactions[0].TxID = bch.HashTransaction(b.Body.Transactions[0])
+	b.Header.Difficulty = 8
+	if err := bch.sealBlock(context.Background(), &b, 4, nil); err != d.ErrNoValidNonce {
+		t.Errorf("sealBlock() error = %v, want %v", err, d.ErrNoValidNonce)
+	}
+}
+
+func TestMineBlocks_RecordsTelemetry(t *testing.T) {
+	users := NewUserGeneratorService(c.NewKeyGenerator(), WithSeed(3)).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	cfg := &config.Config{Version: 1, Difficulty: 2}
+	bch := InitBlockchainWithFunds(100, 10000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithSeed(3))
+	var events []MiningEvent
+	bch.OnMiningEvent(func(e MiningEvent) { events = append(events, e) })
+	if err := bch.MineBlocks(context.Background(), 2, 3, 1, 50, users, cfg.Version, cfg.Difficulty); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	for i, e := range events {
+		block, _ := bch.GetBlock(i + 1)
+		if e.Kind != BlockMined || e.Height != i+1 || e.Hash != bch.CalculateHash(block) || e.Nonce != block.Header.Nonce {
+			t.Errorf("event %d = %+v, want block %d mined", i, e, i+1)
+		}
+		var sum uint64
+		for _, h := range e.WorkerHashes {
+			sum += h
+		}
+		if e.Hashes == 0 || sum != e.Hashes {
+			t.Errorf("event %d hashes = %d, workers sum to %d", i, e.Hashes, sum)
+		}
+		// Every nonce up to the sealed one was tried, and workers stop soon after.
+		if e.Hashes <= uint64(e.Nonce) {
+			t.Errorf("event %d hashes = %d, want more than nonce %d", i, e.Hashes, e.Nonce)
+		}
+	}
+
+	stats := bch.MiningStats()
+	if stats.Blocks != 2 || stats.Hashes != events[0].Hashes+events[1].Hashes || stats.WastedHashes != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.AverageBlockTime() <= 0 || stats.Hashrate() <= 0 || len(stats.Workers) != len(events[0].WorkerHashes) {
+		t.Errorf("stats = %+v, want positive block time and hashrate", stats)
+	}
+	if stats.Last == nil || stats.Last.Hash != events[1].Hash {
+		t.Errorf("stats.Last = %+v, want the second event", stats.Last)
+	}
+}
+
+func TestMineBlocks_RecordsCancelledRound(t *testing.T) {
+	users := NewUserGeneratorService(c.NewKeyGenerator(), WithSeed(42)).GenerateUsers([]string{"Alice", "Bob", "Charlie", "Dave", "Eve"}, 5)
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	bch := InitBlockchainWithFunds(100, 10000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithSeed(42))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// No nonce meets this difficulty in time; the round ends with the context.
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := bch.MineBlocks(ctx, 1, 1, 1, 50, users, cfg.Version, 20); err == nil {
+		t.Fatal("MineBlocks() succeeded at an impossible difficulty")
+	}
+
+	stats := bch.MiningStats()
+	if stats.CancelledRounds != 1 || stats.Blocks != 0 {
+		t.Fatalf("stats = %+v, want one cancelled round", stats)
+	}
+	if stats.Hashes == 0 || stats.WastedHashes != stats.Hashes {
+		t.Errorf("wasted hashes = %d of %d, want all of them", stats.WastedHashes, stats.Hashes)
+	}
+}
+
+func TestMineBlocks_LogsMinedBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	users := NewUserGeneratorService(c.NewKeyGenerator(), WithSeed(3)).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	cfg := &config.Config{Version: 1, Difficulty: 2}
+	bch := InitBlockchainWithFunds(100, 10000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithSeed(3), WithLogger(logger))
+	if err := bch.MineBlocks(context.Background(), 1, 3, 1, 50, users, cfg.Version, cfg.Difficulty); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+
+	block, _ := bch.GetBlock(1)
+	hash := bch.CalculateHash(block)
+	var mined, workers int
+	for _, r := range logRecords(t, &buf) {
+		switch r["msg"] {
+		case "Mined block":
+			mined++
+			if r["height"] != 1.0 || r["hash"] != hash.String() || r["txs"] != float64(len(block.Body.Transactions)) {
+				t.Errorf("mined block record = %v, want height 1, hash %s", r, hash.String())
+			}
+		case "Mining worker":
+			if r["worker"] != float64(workers) {
+				t.Errorf("worker record = %v, want worker %d", r, workers)
+			}
+			workers++
+		}
+	}
+	if mined != 1 || workers != len(bch.MiningStats().Workers) {
+		t.Errorf("got %d mined and %d worker records, want 1 and %d", mined, workers, len(bch.MiningStats().Workers))
+	}
+}
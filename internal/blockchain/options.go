@@ -0,0 +1,147 @@
+package blockchain
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/clock"
+)
+
+// Option replaces a source of randomness or time used by a Blockchain or a
+// UserGeneratorService, or the logger of a Blockchain, or enables an optional index,
+// pruning or a store.
+type Option func(*options)
+
+// DefaultMinDifficulty is the minimum block difficulty of a chain created without
+// WithMinDifficulty.
+const DefaultMinDifficulty uint32 = 1
+
+// DefaultBlockReward is the block reward of a chain created without WithBlockReward.
+const DefaultBlockReward uint32 = 50
+
+// options holds everything that makes two runs differ: the random number generator
+// behind user, fund and transaction generation and the clock behind block timestamps.
+// It also holds the logger and the optional indexes, which do not affect the chain.
+type options struct {
+	rng          *rand.Rand
+	clock        clock.Clock
+	logger       *slog.Logger
+	txIndex      bool
+	addressIndex bool
+	pruneDepth   int
+	store        Store
+
+	// validationClock is what block timestamps are checked against. It must not
+	// advance when read, so that validating a block does not shift later timestamps.
+	validationClock clock.Clock
+	// minDifficulty is the lowest difficulty a block other than genesis may declare.
+	minDifficulty uint32
+	// blockReward is what the coinbase of a block mined on the chain pays.
+	blockReward uint32
+}
+
+func newOptions(opts []Option) options {
+	e := options{
+		rng:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		clock:           clock.System,
+		validationClock: clock.System,
+		logger:          slog.Default(),
+		minDifficulty:   DefaultMinDifficulty,
+		blockReward:     DefaultBlockReward,
+	}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	return e
+}
+
+// WithRand makes random choices with rng. The caller must not use rng concurrently.
+func WithRand(rng *rand.Rand) Option {
+	return func(e *options) {
+		e.rng = rng
+	}
+}
+
+// WithClock stamps blocks and users with times read from c and checks block
+// timestamps against it, so reading c must not advance it.
+func WithClock(c clock.Clock) Option {
+	return func(e *options) {
+		e.clock = c
+		e.validationClock = c
+	}
+}
+
+// WithSeed makes a run reproducible: random choices come from a generator seeded
+// with seed and timestamps from a clock that starts at clock.Epoch and advances one
+// second per reading. The same seed and difficulty then produce a byte-identical chain.
+// Block timestamps are checked against the clock's position without advancing it.
+func WithSeed(seed int64) Option {
+	return func(e *options) {
+		step := clock.NewStepClock(clock.Epoch, time.Second)
+		e.rng = rand.New(rand.NewSource(seed))
+		e.clock = step
+		e.validationClock = clock.Func(step.Peek)
+	}
+}
+
+// WithLogger logs mining rounds, reorganizations and rejected blocks to l instead of
+// slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(e *options) {
+		e.logger = l
+	}
+}
+
+// WithTxIndex keeps an index of the main chain transactions by TxID, which
+// GetTransaction needs to find confirmed transactions.
+func WithTxIndex() Option {
+	return func(e *options) {
+		e.txIndex = true
+	}
+}
+
+// WithAddressIndex keeps the history of every address, which AddressHistory reads.
+func WithAddressIndex() Option {
+	return func(e *options) {
+		e.addressIndex = true
+	}
+}
+
+// WithPrune keeps the bodies and undo data of only the last blocks main chain blocks
+// and drops those of older blocks, keeping their headers. The chain still validates
+// new blocks, since they only need the UTXO set, and reorganizes up to blocks deep.
+// A value of zero or less keeps every block.
+func WithPrune(blocks int) Option {
+	return func(e *options) {
+		e.pruneDepth = max(blocks, 0)
+	}
+}
+
+// WithMinDifficulty rejects blocks other than genesis that declare a difficulty below
+// difficulty. A header is checked against the difficulty it declares itself, so
+// without a floor a header of difficulty 0, which every hash meets, would carry no
+// work. A chain mined at a configured difficulty should use it as the minimum.
+func WithMinDifficulty(difficulty uint32) Option {
+	return func(e *options) {
+		e.minDifficulty = difficulty
+	}
+}
+
+// WithBlockReward sets the reward that MineBlocks and the stratum server pay in the
+// coinbase of every block they mine. With a reward of zero MineBlocks mines blocks
+// without a coinbase.
+func WithBlockReward(reward uint32) Option {
+	return func(e *options) {
+		e.blockReward = reward
+	}
+}
+
+// WithStore writes every main chain block the chain connects or disconnects, together
+// with its undo data, UTXO changes and index entries, to s. Use OpenBlockchain to
+// start from the chain s holds.
+func WithStore(s Store) Option {
+	return func(e *options) {
+		e.store = s
+	}
+}
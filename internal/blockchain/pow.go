@@ -0,0 +1,253 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/binary"
+	"math/big"
+	"sync"
+
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PoW is a proof-of-work algorithm. The header version selects the algorithm of a
+// block (see NewPoW); the block is still identified by the chain hasher's hash of its
+// header, only the difficulty check uses the proof-of-work hash.
+type PoW interface {
+	// Name is the name POW_ALGORITHM selects the algorithm by.
+	Name() string
+	// Hash returns the proof-of-work hash of header.
+	Hash(header *d.Header) d.Hash32
+	// Seal sets header.Nonce to the lowest nonce whose hash meets the header's
+	// difficulty, searching with workers goroutines, and returns it with its hash.
+	Seal(ctx context.Context, header *d.Header, workers int) (uint32, d.Hash32, error)
+	// Verify reports whether the header's hash meets its difficulty.
+	Verify(header *d.Header) bool
+	// Work returns the expected number of hashes needed to meet difficulty.
+	Work(difficulty uint32) *big.Int
+}
+
+// NewPoW returns the proof-of-work algorithm that blocks of the given header version
+// are sealed with. Versions without an algorithm of their own hash with hasher.
+func NewPoW(version uint32, hasher c.Hasher) PoW {
+	switch version {
+	case d.VersionScryptPoW:
+		return NewScryptPoW()
+	case d.VersionArgon2idPoW:
+		return NewArgon2idPoW()
+	}
+	return NewHashPoW(hasher)
+}
+
+// HashPoW is the original scheme: the chain hasher applied to the serialized header.
+type HashPoW struct {
+	hasher c.Hasher
+}
+
+func NewHashPoW(hasher c.Hasher) *HashPoW {
+	return &HashPoW{hasher: hasher}
+}
+
+func (p *HashPoW) Name() string { return "hash" }
+
+func (p *HashPoW) Hash(header *d.Header) d.Hash32 {
+	return p.hasher.Hash(header.Serialize())
+}
+
+func (p *HashPoW) Seal(ctx context.Context, header *d.Header, workers int) (uint32, d.Hash32, error) {
+	return sealLowestNonce(ctx, header, p.nonceHasher(header), workers, nil)
+}
+
+// nonceHasher hashes header with a given nonce. When the chain hasher supports it the
+// header prefix is absorbed once, and every nonce only costs hashing its four bytes.
+func (p *HashPoW) nonceHasher(header *d.Header) func(uint32) d.Hash32 {
+	prefixHasher, ok := p.hasher.(c.PrefixHasher)
+	if !ok {
+		return headerNonceHasher(header, p.Hash)
+	}
+	midstate := prefixHasher.HashPrefix(header.SerializePrefix())
+	return func(nonce uint32) d.Hash32 {
+		var suffix [4]byte
+		binary.LittleEndian.PutUint32(suffix[:], nonce)
+		return midstate.Hash(suffix[:])
+	}
+}
+
+func (p *HashPoW) Verify(header *d.Header) bool {
+	return IsHashValid(p.Hash(header), header.Difficulty)
+}
+
+func (p *HashPoW) Work(difficulty uint32) *big.Int {
+	return blockWork(difficulty)
+}
+
+// ScryptPoW hashes the serialized header with scrypt, using the header as both
+// password and salt, with Litecoin's parameters: 128 KiB of memory per hash.
+type ScryptPoW struct {
+	n, r, p int
+}
+
+func NewScryptPoW() *ScryptPoW {
+	return &ScryptPoW{n: 1024, r: 1, p: 1}
+}
+
+func (p *ScryptPoW) Name() string { return "scrypt" }
+
+func (p *ScryptPoW) Hash(header *d.Header) d.Hash32 {
+	serialized := header.Serialize()
+	key, err := scrypt.Key(serialized, serialized, p.n, p.r, p.p, len(d.Hash32{}))
+	if err != nil {
+		// The parameters are fixed by NewScryptPoW and always valid.
+		panic(err)
+	}
+	return d.Hash32(key)
+}
+
+func (p *ScryptPoW) Seal(ctx context.Context, header *d.Header, workers int) (uint32, d.Hash32, error) {
+	return sealLowestNonce(ctx, header, headerNonceHasher(header, p.Hash), workers, nil)
+}
+
+func (p *ScryptPoW) Verify(header *d.Header) bool {
+	return IsHashValid(p.Hash(header), header.Difficulty)
+}
+
+func (p *ScryptPoW) Work(difficulty uint32) *big.Int {
+	return blockWork(difficulty)
+}
+
+// Argon2idPoW hashes the serialized header with argon2id, using the header as both
+// password and salt. Every hash fills 4 MiB of memory in one pass on one thread.
+type Argon2idPoW struct {
+	time, memory uint32
+	threads      uint8
+}
+
+func NewArgon2idPoW() *Argon2idPoW {
+	return &Argon2idPoW{time: 1, memory: 4 * 1024, threads: 1}
+}
+
+func (p *Argon2idPoW) Name() string { return "argon2id" }
+
+func (p *Argon2idPoW) Hash(header *d.Header) d.Hash32 {
+	serialized := header.Serialize()
+	return d.Hash32(argon2.IDKey(serialized, serialized, p.time, p.memory, p.threads, uint32(len(d.Hash32{}))))
+}
+
+func (p *Argon2idPoW) Seal(ctx context.Context, header *d.Header, workers int) (uint32, d.Hash32, error) {
+	return sealLowestNonce(ctx, header, headerNonceHasher(header, p.Hash), workers, nil)
+}
+
+func (p *Argon2idPoW) Verify(header *d.Header) bool {
+	return IsHashValid(p.Hash(header), header.Difficulty)
+}
+
+func (p *Argon2idPoW) Work(difficulty uint32) *big.Int {
+	return blockWork(difficulty)
+}
+
+// NonceHasher returns a function computing pow's hash of header with a given nonce,
+// as Seal does, for miners that search nonces themselves.
+func NonceHasher(pow PoW, header *d.Header) func(nonce uint32) d.Hash32 {
+	if p, ok := pow.(*HashPoW); ok {
+		return p.nonceHasher(header)
+	}
+	return headerNonceHasher(header, pow.Hash)
+}
+
+// headerNonceHasher hashes copies of header with the nonce replaced.
+func headerNonceHasher(header *d.Header, hash func(*d.Header) d.Hash32) func(uint32) d.Hash32 {
+	fixed := *header
+	return func(nonce uint32) d.Hash32 {
+		h := fixed
+		h.Nonce = nonce
+		return hash(&h)
+	}
+}
+
+// nonceBatch is the number of nonces a mining worker claims at a time.
+const nonceBatch = 1024
+
+// maxNonce bounds the nonce search; the nonce maxNonce itself is never tried, as in the
+// original sequential search. It is a variable so that tests can exhaust the nonce space.
+var maxNonce = ^uint32(0)
+
+// sealLowestNonce finds the lowest nonce whose hash meets the header's difficulty.
+// Workers claim batches of nonces in increasing order and stop claiming once a valid
+// nonce below the next batch is known, so every nonce below the result has been
+// checked when all of them return and the result does not depend on scheduling.
+// When hashes is not nil, worker i adds the number of nonces it tried to hashes[i];
+// it must then have at least workers elements.
+func sealLowestNonce(ctx context.Context, header *d.Header, hash func(nonce uint32) d.Hash32, workers int, hashes []uint64) (uint32, d.Hash32, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if header.Difficulty == 0 {
+		if hashes != nil {
+			hashes[0]++
+		}
+		return header.Nonce, hash(header.Nonce), nil
+	}
+	if header.MerkleRoot.IsZero() {
+		return 0, d.Hash32{}, d.ErrInvalidMerkleRoot
+	}
+
+	noNonce := uint64(maxNonce)
+	var (
+		mutex    sync.Mutex
+		next     uint64
+		best     = noNonce
+		bestHash d.Hash32
+		wg       sync.WaitGroup
+	)
+	claim := func() (uint64, bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if next >= best || next >= noNonce {
+			return 0, false
+		}
+		start := next
+		next += nonceBatch
+		return start, true
+	}
+
+	for i := range workers {
+		wg.Add(1)
+		go func(difficulty uint32) {
+			defer wg.Done()
+			var tried uint64
+			if hashes != nil {
+				defer func() { hashes[i] += tried }()
+			}
+			for ctx.Err() == nil {
+				start, ok := claim()
+				if !ok {
+					return
+				}
+				for nonce := start; nonce < start+nonceBatch && nonce < noNonce; nonce++ {
+					tried++
+					sum := hash(uint32(nonce))
+					if IsHashValid(sum, difficulty) {
+						mutex.Lock()
+						if nonce < best {
+							best, bestHash = nonce, sum
+						}
+						mutex.Unlock()
+						break
+					}
+				}
+			}
+		}(header.Difficulty)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return 0, d.Hash32{}, err
+	}
+	if best == noNonce {
+		return 0, d.Hash32{}, d.ErrNoValidNonce
+	}
+	header.Nonce = uint32(best)
+	return header.Nonce, bestHash, nil
+}
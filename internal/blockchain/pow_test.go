@@ -0,0 +1,136 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+func TestNewPoW_SelectsByVersion(t *testing.T) {
+	hasher := c.NewArchasHasher()
+	tests := []struct {
+		version uint32
+		want    string
+	}{
+		{0, "hash"},
+		{d.VersionHashPoW, "hash"},
+		{d.VersionScryptPoW, "scrypt"},
+		{d.VersionArgon2idPoW, "argon2id"},
+		{42, "hash"},
+	}
+	for _, tt := range tests {
+		if got := NewPoW(tt.version, hasher).Name(); got != tt.want {
+			t.Errorf("NewPoW(%d).Name() = %q, want %q", tt.version, got, tt.want)
+		}
+		if version, ok := d.PoWVersion(tt.want); !ok || NewPoW(version, hasher).Name() != tt.want {
+			t.Errorf("PoWVersion(%q) = %d, %v", tt.want, version, ok)
+		}
+	}
+}
+
+func TestPoW_SealAndVerify(t *testing.T) {
+	hasher := c.NewArchasHasher()
+	for _, version := range []uint32{d.VersionHashPoW, d.VersionScryptPoW, d.VersionArgon2idPoW} {
+		pow := NewPoW(version, hasher)
+		t.Run(pow.Name(), func(t *testing.T) {
+			header := d.Header{Version: version, MerkleRoot: d.Hash32{1}, Difficulty: 1}
+			nonce, hash, err := pow.Seal(context.Background(), &header, 4)
+			if err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+			if header.Nonce != nonce || pow.Hash(&header) != hash || !pow.Verify(&header) {
+				t.Fatalf("Seal() = %d, %x, header does not verify", nonce, hash)
+			}
+			for lower := range nonce {
+				header.Nonce = lower
+				if pow.Verify(&header) {
+					t.Fatalf("Seal() = %d, but nonce %d is valid too", nonce, lower)
+				}
+			}
+		})
+	}
+}
+
+func TestPoW_SealMatchesSequentialSearch(t *testing.T) {
+	hasher := c.NewArchasHasher()
+	for i := range 5 {
+		header := d.Header{Version: 1, Timestamp: uint32(i), MerkleRoot: d.Hash32{byte(i + 1)}, Difficulty: 2}
+		sequential := header
+		want, wantHash, err := FindValidNonce(context.Background(), &sequential, hasher)
+		if err != nil {
+			t.Fatalf("FindValidNonce() error = %v", err)
+		}
+		got, gotHash, err := NewHashPoW(hasher).Seal(context.Background(), &header, 4)
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		if got != want || gotHash != wantHash || header.Nonce != want {
+			t.Errorf("Seal() = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestPoW_SealCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	header := d.Header{Version: 1, MerkleRoot: d.Hash32{1}, Difficulty: 60}
+	if _, _, err := NewHashPoW(c.NewArchasHasher()).Seal(ctx, &header, 4); err != context.Canceled {
+		t.Errorf("Seal() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMineBlocks_MemoryHardPoW(t *testing.T) {
+	users := NewUserGeneratorService(c.NewKeyGenerator(), WithSeed(1)).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	cfg := &config.Config{Version: d.VersionScryptPoW, Difficulty: 1}
+	bch := InitBlockchainWithFunds(100, 10000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithSeed(1))
+	if err := bch.MineBlocks(context.Background(), 2, 3, 1, 50, users, cfg.Version, cfg.Difficulty); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	tip, err := bch.GetLatestBlock()
+	if err != nil {
+		t.Fatalf("GetLatestBlock() error = %v", err)
+	}
+	if !NewScryptPoW().Verify(&tip.Header) {
+		t.Error("Mined block does not meet its difficulty under scrypt")
+	}
+
+	// The seeded chain is fixed, and its tip does not meet the difficulty under argon2id.
+	relabeled := tip.Header
+	relabeled.Version = d.VersionArgon2idPoW
+	if bch.CheckProofOfWork(relabeled) {
+		t.Error("CheckProofOfWork() accepted a header sealed with another algorithm")
+	}
+}
+
+func BenchmarkHashPoW_Seal(b *testing.B) {
+	hasher := c.NewArchasHasher()
+	header := d.Header{Version: 1, MerkleRoot: d.Hash32{1}}
+	b.Run("header", func(b *testing.B) {
+		hash := headerNonceHasher(&header, NewHashPoW(hasher).Hash)
+		for i := 0; i < b.N; i++ {
+			hash(uint32(i))
+		}
+	})
+	b.Run("midstate", func(b *testing.B) {
+		hash := NewHashPoW(hasher).nonceHasher(&header)
+		for i := 0; i < b.N; i++ {
+			hash(uint32(i))
+		}
+	})
+}
+
+func BenchmarkPoW_Hash(b *testing.B) {
+	for _, version := range []uint32{d.VersionHashPoW, d.VersionScryptPoW, d.VersionArgon2idPoW} {
+		pow := NewPoW(version, c.NewArchasHasher())
+		b.Run(pow.Name(), func(b *testing.B) {
+			header := d.Header{Version: version, MerkleRoot: d.Hash32{1}}
+			for i := 0; i < b.N; i++ {
+				header.Nonce = uint32(i)
+				pow.Hash(&header)
+			}
+		})
+	}
+}
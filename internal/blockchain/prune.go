@@ -0,0 +1,48 @@
+package blockchain
+
+import (
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// pruneLocked drops the bodies and undo data of the main chain blocks more than
+// pruneDepth below the tip, keeping their headers. The floor only moves up: when a
+// reorganization shortens the chain, the pruned blocks stay pruned.
+func (bch *Blockchain) pruneLocked() {
+	if bch.pruneDepth <= 0 {
+		return
+	}
+	floor := len(bch.blocks) - bch.pruneDepth
+	if floor <= bch.firstBody {
+		return
+	}
+	// Blocks below a snapshot that is not validated yet have no body to drop.
+	for height := bch.firstBody; height < floor; height++ {
+		bch.blocks[height] = d.Block{Header: bch.blocks[height].Header}
+		bch.undo[height] = nil
+	}
+	bch.firstBody = floor
+}
+
+// missingBodyLocked returns why the main chain block at height, which is below
+// firstBody, has no body: d.ErrBlockPruned when pruning dropped it and
+// d.ErrBlockDataUnavailable when it lies below a snapshot awaiting validation.
+func (bch *Blockchain) missingBodyLocked(height int) error {
+	if bch.pruneDepth > 0 && height < len(bch.blocks)-bch.pruneDepth {
+		return d.ErrBlockPruned
+	}
+	return d.ErrBlockDataUnavailable
+}
+
+func (bch *Blockchain) missingBody(height int) error {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	return bch.missingBodyLocked(height)
+}
+
+// PruneHeight returns the height of the oldest main chain block whose body the chain
+// keeps, which is 0 unless the chain is pruned or loaded from a snapshot.
+func (bch *Blockchain) PruneHeight() int {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	return bch.firstBody
+}
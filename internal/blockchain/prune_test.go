@@ -0,0 +1,171 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// coinbaseBlock mines a block on parent paying value to user, so that branches can be
+// built without spending anything.
+func coinbaseBlock(t *testing.T, bch *Blockchain, parent d.Block, user d.User, value uint32) d.Block {
+	t.Helper()
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: value, To: user.PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	return mineOnParent(t, bch, parent, coinbase)
+}
+
+func TestPrune(t *testing.T) {
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	users := NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	bch := InitBlockchainWithFunds(100000, 100000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithPrune(2))
+	var txs []d.Transaction
+	for i := range 3 {
+		tip, _ := bch.GetLatestBlock()
+		tx := signedTestTransaction(t, bch, users[i], users[(i+1)%3], 10)
+		if status, err := bch.ProcessBlock(mineOnParent(t, bch, tip, tx)); err != nil || status != BlockConnected {
+			t.Fatalf("ProcessBlock() = %v, %v; want connected", status, err)
+		}
+		txs = append(txs, tx)
+	}
+
+	if got := bch.PruneHeight(); got != 2 {
+		t.Errorf("PruneHeight() = %d, want 2", got)
+	}
+	if _, err := bch.GetBlock(1); !errors.Is(err, d.ErrBlockPruned) {
+		t.Errorf("GetBlock(1) error = %v, want %v", err, d.ErrBlockPruned)
+	}
+	hash1, _ := bch.GetBlockHash(1)
+	if _, err := bch.GetBlockByHash(hash1); !errors.Is(err, d.ErrBlockPruned) {
+		t.Errorf("GetBlockByHash(1) error = %v, want %v", err, d.ErrBlockPruned)
+	}
+	if _, err := bch.SpentOutputs(0); !errors.Is(err, d.ErrBlockPruned) {
+		t.Errorf("SpentOutputs(0) error = %v, want %v", err, d.ErrBlockPruned)
+	}
+	if b, err := bch.GetBlock(2); err != nil || len(b.Body.Transactions) == 0 {
+		t.Errorf("GetBlock(2) = %d transactions, %v; want a full block", len(b.Body.Transactions), err)
+	}
+	if headers := bch.LocateHeaders(nil, 0); len(headers) != bch.Len() {
+		t.Errorf("LocateHeaders() = %d headers, want all %d", len(headers), bch.Len())
+	}
+	if !validateChainHeaders(bch) {
+		t.Error("headers of the pruned chain do not link up")
+	}
+
+	// A reorganization as deep as the prune depth still works.
+	blocks := bch.Blocks()
+	side := blocks[1]
+	for i := range 3 {
+		side = coinbaseBlock(t, bch, side, users[0], uint32(100+i))
+		status, err := bch.ProcessBlock(side)
+		if err != nil {
+			t.Fatalf("ProcessBlock(side %d) error = %v", i, err)
+		}
+		if i == 2 && status != BlockReorganized {
+			t.Fatalf("ProcessBlock(side %d) = %v, want reorganized", i, status)
+		}
+	}
+	if tipHash, _ := bch.GetLatestBlockHash(); tipHash != bch.CalculateHash(side) || bch.Len() != 5 {
+		t.Fatalf("tip = %s at length %d, want the side branch at length 5", tipHash.String(), bch.Len())
+	}
+	if _, ok := bch.GetUTXO(d.Outpoint{TxID: bch.OutpointTxID(txs[2])}); ok {
+		t.Error("Output created by a disconnected block should be gone")
+	}
+	if _, ok := bch.GetUTXO(d.Outpoint{TxID: bch.OutpointTxID(side.Body.Transactions[0])}); !ok {
+		t.Error("Output created by the new branch should be unspent")
+	}
+	if got := bch.PruneHeight(); got != 3 {
+		t.Errorf("PruneHeight() after reorganization = %d, want 3", got)
+	}
+
+	// A branch forking below the kept bodies never replaces the main chain.
+	deep := blocks[1]
+	for i := range 5 {
+		deep = coinbaseBlock(t, bch, deep, users[1], uint32(200+i))
+		if status, err := bch.ProcessBlock(deep); err != nil || status != BlockSideChain {
+			t.Fatalf("ProcessBlock(deep %d) = %v, %v; want side chain", i, status, err)
+		}
+	}
+	if tipHash, _ := bch.GetLatestBlockHash(); tipHash != bch.CalculateHash(side) {
+		t.Error("A branch forking below the pruned height must not replace the main chain")
+	}
+}
+
+// TestPrune_FailedReorganization checks that a reorganization connecting more blocks
+// than the prune depth before it hits an invalid one restores the original chain with
+// its bodies and undo data.
+func TestPrune_FailedReorganization(t *testing.T) {
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	users := NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob"}, 2)
+	bch := InitBlockchainWithFunds(1000, 1000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithPrune(2))
+	for i := range 3 {
+		tip, _ := bch.GetLatestBlock()
+		tx := signedTestTransaction(t, bch, users[i%2], users[(i+1)%2], 10)
+		if status, err := bch.ProcessBlock(atDifficulty(t, mineOnParent(t, bch, tip, tx), 2)); err != nil || status != BlockConnected {
+			t.Fatalf("ProcessBlock() = %v, %v; want connected", status, err)
+		}
+	}
+	tipHash, _ := bch.GetLatestBlockHash()
+	length, pruneHeight, utxos := bch.Len(), bch.PruneHeight(), bch.utxoTracker.Len()
+
+	// Blocks of difficulty 1 add too little work to replace the main chain of difficulty
+	// 2, until a heavier block on top of them spends an output that does not exist.
+	side := bch.Blocks()[1]
+	for i := range 4 {
+		side = coinbaseBlock(t, bch, side, users[0], uint32(100+i))
+		if status, err := bch.ProcessBlock(side); err != nil || status != BlockSideChain {
+			t.Fatalf("ProcessBlock(side %d) = %v, %v; want side chain", i, status, err)
+		}
+	}
+	bad := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: d.Outpoint{TxID: d.Hash32{1}}}},
+		Outputs: []d.TxOutput{{Value: 1, To: users[0].PublicAddress}},
+	}
+	bad.TxID = bch.HashTransaction(bad)
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 200, To: users[0].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	heavy := atDifficulty(t, mineOnParent(t, bch, side, coinbase, bad), 3)
+	if _, err := bch.ProcessBlock(heavy); err == nil {
+		t.Fatal("ProcessBlock() accepted a branch spending a missing output")
+	}
+
+	if got, _ := bch.GetLatestBlockHash(); got != tipHash || bch.Len() != length {
+		t.Fatalf("tip = %s at length %d, want the original tip at length %d", got.String(), bch.Len(), length)
+	}
+	if got := bch.PruneHeight(); got != pruneHeight {
+		t.Errorf("PruneHeight() = %d, want %d", got, pruneHeight)
+	}
+	if got := bch.utxoTracker.Len(); got != utxos {
+		t.Errorf("UTXO set holds %d outputs, want %d", got, utxos)
+	}
+	for height := pruneHeight; height < length; height++ {
+		if b, err := bch.GetBlock(height); err != nil || len(b.Body.Transactions) == 0 {
+			t.Errorf("GetBlock(%d) = %d transactions, %v; want a full block", height, len(b.Body.Transactions), err)
+		}
+	}
+}
+
+// atDifficulty mines b again at difficulty.
+func atDifficulty(t *testing.T, b d.Block, difficulty uint32) d.Block {
+	t.Helper()
+	b.Header.Difficulty = difficulty
+	if _, _, err := FindValidNonce(context.Background(), &b.Header, c.NewArchasHasher()); err != nil {
+		t.Fatalf("FindValidNonce() error = %v", err)
+	}
+	return b
+}
+
+// validateChainHeaders reports whether every main chain header links to the previous one.
+func validateChainHeaders(bch *Blockchain) bool {
+	headers := bch.LocateHeaders(nil, 0)
+	for i := 1; i < len(headers); i++ {
+		if headers[i].PrevHash != bch.CalculateHash(d.Block{Header: headers[i-1]}) {
+			return false
+		}
+	}
+	return true
+}
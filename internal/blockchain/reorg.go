@@ -0,0 +1,444 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// BlockStatus describes what ProcessBlock did with a block.
+type BlockStatus int
+
+const (
+	// BlockConnected means the block extended the main chain.
+	BlockConnected BlockStatus = iota
+	// BlockSideChain means the block was stored on a branch with less work than the main chain.
+	BlockSideChain
+	// BlockReorganized means the block completed a branch with more work and the main chain switched to it.
+	BlockReorganized
+)
+
+func (s BlockStatus) String() string {
+	switch s {
+	case BlockConnected:
+		return "connected"
+	case BlockSideChain:
+		return "side chain"
+	case BlockReorganized:
+		return "reorganized"
+	}
+	return "unknown"
+}
+
+// sideBlock is a block whose proof of work is valid but which is not part of the main chain.
+type sideBlock struct {
+	block  d.Block
+	height int
+}
+
+// blockWork returns the expected number of hashes needed to meet difficulty,
+// which is 16 to the power of difficulty because every level adds 4 leading zero bits.
+func blockWork(difficulty uint32) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty)*4)
+}
+
+// headerWork returns the work of a block as estimated by its proof-of-work algorithm.
+func (bch *Blockchain) headerWork(header d.Header) *big.Int {
+	return NewPoW(header.Version, bch.hasher).Work(header.Difficulty)
+}
+
+// ProcessBlock accepts a block received from another node. Unlike AddBlock it also
+// accepts blocks that do not extend the current tip: they are kept on a side branch
+// and the chain reorganizes onto that branch once it carries more cumulative work.
+// Blocks whose parent is unknown are rejected with ErrOrphanBlock.
+func (bch *Blockchain) ProcessBlock(b d.Block) (BlockStatus, error) {
+	bch.chainMutex.Lock()
+	defer bch.chainMutex.Unlock()
+
+	users := bch.getUsersFromRegistry()
+	if len(bch.blocks) == 0 {
+		if !b.Header.PrevHash.IsZero() {
+			return 0, d.ErrOrphanBlock
+		}
+		if err := bch.validateBlock(b, true); err != nil {
+			bch.logRejected(b, 0, err)
+			return 0, err
+		}
+		if err := bch.validateBlockTransactions(b, users, true); err != nil {
+			bch.logRejected(b, 0, err)
+			return 0, err
+		}
+		bch.connectBlockLocked(b, true)
+		return BlockConnected, nil
+	}
+
+	hash := bch.CalculateHash(b)
+	if bch.haveBlockLocked(hash) {
+		return 0, d.ErrDuplicateBlock
+	}
+	if err := bch.validateBlock(b, false); err != nil {
+		bch.logRejected(b, -1, err)
+		return 0, err
+	}
+
+	parent, height, ok := bch.parentLocked(b.Header.PrevHash)
+	if !ok {
+		return 0, d.ErrOrphanBlock
+	}
+	// Old blocks are accepted as long as they are not far older than their parent,
+	// otherwise a node could never download a chain that is more than a few hours old.
+	if b.Header.Timestamp+maxTimestampDrift < parent.Header.Timestamp {
+		bch.logRejected(b, height, ruleError("timestamp-too-old", d.ErrTimestampTooOld))
+		return 0, d.ErrTimestampTooOld
+	}
+
+	if _, onMain := bch.heights[b.Header.PrevHash]; onMain && height == len(bch.blocks) {
+		if err := bch.validateBlockTransactions(b, users, false); err != nil {
+			bch.logRejected(b, height, err)
+			return 0, err
+		}
+		bch.connectBlockLocked(b, true)
+		bch.removeMempoolForBlock(b)
+		return BlockConnected, nil
+	}
+
+	bch.sideBlocks[hash] = sideBlock{block: b, height: height}
+
+	branch, forkHeight := bch.branchLocked(hash)
+	if bch.branchWorkLocked(branch, forkHeight).Cmp(bch.chainWork) <= 0 {
+		return BlockSideChain, nil
+	}
+	// Blocks without a body have no undo data and cannot be disconnected.
+	if forkHeight+1 < bch.firstBody {
+		bch.logger.Warn("Not reorganizing onto a branch that forks below the oldest block body",
+			"hash", hash.String(), "forkHeight", forkHeight, "firstBody", bch.firstBody)
+		return BlockSideChain, nil
+	}
+	if err := bch.reorganizeLocked(branch, forkHeight); err != nil {
+		return 0, err
+	}
+	return BlockReorganized, nil
+}
+
+// HaveBlock reports whether the block is known, either on the main chain or on a side branch.
+func (bch *Blockchain) HaveBlock(hash d.Hash32) bool {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	return bch.haveBlockLocked(hash)
+}
+
+func (bch *Blockchain) haveBlockLocked(hash d.Hash32) bool {
+	if _, ok := bch.heights[hash]; ok {
+		return true
+	}
+	_, ok := bch.sideBlocks[hash]
+	return ok
+}
+
+// GetBlockByHash returns a main chain or side branch block by its header hash.
+func (bch *Blockchain) GetBlockByHash(hash d.Hash32) (d.Block, error) {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	if height, ok := bch.heights[hash]; ok {
+		if height < bch.firstBody {
+			return d.Block{}, bch.missingBodyLocked(height)
+		}
+		return bch.blocks[height], nil
+	}
+	if side, ok := bch.sideBlocks[hash]; ok {
+		return side.block, nil
+	}
+	return d.Block{}, d.ErrBlockNotFound
+}
+
+// ChainWork returns the cumulative work of the main chain.
+func (bch *Blockchain) ChainWork() *big.Int {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	return new(big.Int).Set(bch.chainWork)
+}
+
+// NetworkHashrate estimates the hashes per second spent on the last blocks main chain
+// blocks from their work and the time their timestamps span. It returns 0 while the
+// chain is too short or its timestamps span no time.
+func (bch *Blockchain) NetworkHashrate(blocks int) float64 {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+
+	n := len(bch.blocks)
+	if n < 2 || blocks < 1 {
+		return 0
+	}
+	first := max(n-1-blocks, 0)
+	work := new(big.Int)
+	minTime, maxTime := bch.blocks[first].Header.Timestamp, bch.blocks[first].Header.Timestamp
+	for _, b := range bch.blocks[first+1:] {
+		work.Add(work, bch.headerWork(b.Header))
+		minTime = min(minTime, b.Header.Timestamp)
+		maxTime = max(maxTime, b.Header.Timestamp)
+	}
+	if maxTime == minTime {
+		return 0
+	}
+	hashes, _ := new(big.Float).SetInt(work).Float64()
+	return hashes / float64(maxTime-minTime)
+}
+
+// BlockLocator returns main chain hashes from the tip back to genesis, dense near
+// the tip and exponentially sparser further back, so that a peer can find the
+// most recent block both chains share.
+func (bch *Blockchain) BlockLocator() []d.Hash32 {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+
+	var locator []d.Hash32
+	step := 1
+	height := len(bch.blocks) - 1
+	for height > 0 {
+		locator = append(locator, bch.hashes[height])
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		height -= step
+	}
+	if len(bch.hashes) > 0 {
+		locator = append(locator, bch.hashes[0])
+	}
+	return locator
+}
+
+// LocateBlocks finds the first locator hash on the main chain and returns the
+// hashes of up to max blocks that follow it. When no locator hash is known the
+// hashes start at genesis.
+func (bch *Blockchain) LocateBlocks(locator []d.Hash32, max int) []d.Hash32 {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+
+	start, end := bch.locateLocked(locator, max)
+	return append([]d.Hash32(nil), bch.hashes[start:end]...)
+}
+
+// LocateHeaders is like LocateBlocks but returns the block headers.
+func (bch *Blockchain) LocateHeaders(locator []d.Hash32, max int) []d.Header {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+
+	start, end := bch.locateLocked(locator, max)
+	var headers []d.Header
+	for height := start; height < end; height++ {
+		headers = append(headers, bch.blocks[height].Header)
+	}
+	return headers
+}
+
+// locateLocked returns the main chain height range following the first known locator hash.
+func (bch *Blockchain) locateLocked(locator []d.Hash32, max int) (int, int) {
+	start := 0
+	for _, hash := range locator {
+		if height, ok := bch.heights[hash]; ok {
+			start = height + 1
+			break
+		}
+	}
+	end := len(bch.blocks)
+	if max > 0 && start+max < end {
+		end = start + max
+	}
+	return start, end
+}
+
+// connectBlockLocked appends an already validated block to the main chain. With prune
+// set it then prunes the chain, which a reorganization leaves to its last block: the
+// blocks it connects before may still be rolled back and need their bodies and undo data.
+func (bch *Blockchain) connectBlockLocked(b d.Block, prune bool) {
+	spent := bch.utxoTracker.connectBlock(b, bch.hasher)
+	hash := bch.CalculateHash(b)
+	height := len(bch.blocks)
+	bch.heights[hash] = height
+	bch.hashes = append(bch.hashes, hash)
+	bch.blocks = append(bch.blocks, b)
+	bch.undo = append(bch.undo, spent)
+	bch.chainWork.Add(bch.chainWork, bch.headerWork(b.Header))
+	bch.txIndex.connectBlock(b, hash, height)
+	addresses := bch.addressIndex.connectBlock(b, hash, height, spent)
+	if prune {
+		bch.pruneLocked()
+	}
+	bch.storeLocked(true, height, hash, b, spent, addresses)
+	bch.events.publish(Event{Kind: EventBlockConnected, Height: height, Hash: hash, Block: b, Spent: spent})
+}
+
+// disconnectTipLocked removes the tip from the main chain and restores the outputs it spent.
+func (bch *Blockchain) disconnectTipLocked() d.Block {
+	height := len(bch.blocks) - 1
+	tip := bch.blocks[height]
+	hash := bch.hashes[height]
+	spent := bch.undo[height]
+	bch.utxoTracker.disconnectBlock(tip, spent, bch.hasher)
+	delete(bch.heights, hash)
+	bch.hashes = bch.hashes[:height]
+	bch.blocks = bch.blocks[:height]
+	bch.undo = bch.undo[:height]
+	bch.chainWork.Sub(bch.chainWork, bch.headerWork(tip.Header))
+	bch.txIndex.disconnectBlock(tip, hash)
+	bch.addressIndex.disconnectBlock(tip, hash, spent)
+	bch.storeLocked(false, height, hash, tip, spent, nil)
+	bch.events.publish(Event{Kind: EventBlockDisconnected, Height: height, Hash: hash, Block: tip, Spent: spent})
+	return tip
+}
+
+// branchLocked walks back from a side block to the main chain and returns the
+// branch in connection order together with the height of the last shared block.
+func (bch *Blockchain) branchLocked(hash d.Hash32) ([]d.Block, int) {
+	var branch []d.Block
+	for {
+		side := bch.sideBlocks[hash]
+		branch = append(branch, side.block)
+		hash = side.block.Header.PrevHash
+		if height, ok := bch.heights[hash]; ok {
+			for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+				branch[i], branch[j] = branch[j], branch[i]
+			}
+			return branch, height
+		}
+	}
+}
+
+// branchWorkLocked returns the cumulative work of the chain formed by the main
+// chain up to forkHeight followed by branch.
+func (bch *Blockchain) branchWorkLocked(branch []d.Block, forkHeight int) *big.Int {
+	work := new(big.Int).Set(bch.chainWork)
+	for _, b := range bch.blocks[forkHeight+1:] {
+		work.Sub(work, bch.headerWork(b.Header))
+	}
+	for _, b := range branch {
+		work.Add(work, bch.headerWork(b.Header))
+	}
+	return work
+}
+
+// reorganizeLocked replaces the main chain above forkHeight with branch. If a
+// branch block turns out to be invalid, the original chain is restored and the
+// invalid block is forgotten together with its descendants.
+func (bch *Blockchain) reorganizeLocked(branch []d.Block, forkHeight int) error {
+	bch.events.publish(Event{
+		Kind:       EventReorgStarted,
+		Height:     forkHeight + len(branch),
+		Hash:       bch.CalculateHash(branch[len(branch)-1]),
+		ForkHeight: forkHeight,
+		Depth:      len(bch.blocks) - 1 - forkHeight,
+	})
+	var disconnected []d.Block
+	for len(bch.blocks)-1 > forkHeight {
+		disconnected = append(disconnected, bch.disconnectTipLocked())
+	}
+
+	users := bch.getUsersFromRegistry()
+	for i, b := range branch {
+		if err := bch.validateBlockTransactions(b, users, false); err != nil {
+			bch.logRejected(b, forkHeight+1+i, err)
+			for range branch[:i] {
+				valid := bch.disconnectTipLocked()
+				bch.sideBlocks[bch.CalculateHash(valid)] = sideBlock{block: valid, height: len(bch.blocks)}
+			}
+			for j := len(disconnected) - 1; j >= 0; j-- {
+				bch.connectBlockLocked(disconnected[j], false)
+			}
+			bch.forgetBranchLocked(bch.CalculateHash(b))
+			return fmt.Errorf("reorganization failed: %w", err)
+		}
+		delete(bch.sideBlocks, bch.CalculateHash(b))
+		bch.connectBlockLocked(b, i == len(branch)-1)
+	}
+
+	for _, b := range disconnected {
+		bch.sideBlocks[bch.CalculateHash(b)] = sideBlock{block: b, height: bch.heightOfSideLocked(b)}
+	}
+	bch.resetMempoolLocked(disconnected, branch)
+	bch.metrics.reorgs.Inc()
+	bch.metrics.reorgDepth.Observe(float64(len(disconnected)))
+	tipHash := bch.hashes[len(bch.hashes)-1]
+	bch.logger.Info("Chain reorganized",
+		"fork_height", forkHeight,
+		"depth", len(disconnected),
+		"height", len(bch.blocks)-1,
+		"hash", tipHash.String())
+	return nil
+}
+
+// parentLocked looks up a block's parent on the main chain or a side branch and
+// returns it together with the height the child block has.
+func (bch *Blockchain) parentLocked(prevHash d.Hash32) (d.Block, int, bool) {
+	if height, ok := bch.heights[prevHash]; ok {
+		return bch.blocks[height], height + 1, true
+	}
+	if parent, ok := bch.sideBlocks[prevHash]; ok {
+		return parent.block, parent.height + 1, true
+	}
+	return d.Block{}, 0, false
+}
+
+// heightOfSideLocked returns the height of a block whose ancestors are on the main chain or side branches.
+func (bch *Blockchain) heightOfSideLocked(b d.Block) int {
+	_, height, _ := bch.parentLocked(b.Header.PrevHash)
+	return height
+}
+
+// forgetBranchLocked removes a side block and every side block built on top of it.
+func (bch *Blockchain) forgetBranchLocked(hash d.Hash32) {
+	delete(bch.sideBlocks, hash)
+	for childHash, side := range bch.sideBlocks {
+		if side.block.Header.PrevHash == hash {
+			bch.forgetBranchLocked(childHash)
+		}
+	}
+}
+
+// resetMempoolLocked revalidates the mempool after a reorganization. Transactions
+// from disconnected blocks are offered back first, then the previous mempool
+// content; anything that no longer fits the new UTXO set is dropped. Transactions
+// that leave the mempool are published as mined when a connected block confirms
+// them and as conflicts otherwise, and those returned from disconnected blocks as
+// accepted.
+func (bch *Blockchain) resetMempoolLocked(disconnected, connected []d.Block) {
+	var candidates []d.Transaction
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Body.Transactions {
+			if !tx.IsCoinbase() {
+				candidates = append(candidates, tx)
+			}
+		}
+	}
+	pending := bch.mempool.drain()
+	wasPending := make(map[d.Hash32]bool, len(pending))
+	for _, tx := range pending {
+		wasPending[tx.TxID] = true
+	}
+	candidates = append(candidates, pending...)
+	for _, tx := range candidates {
+		if err := bch.ValidateTransaction(tx); err != nil {
+			continue
+		}
+		if bch.mempool.add(tx) == nil && !wasPending[tx.TxID] {
+			bch.events.publish(Event{Kind: EventTxAccepted, Tx: tx})
+		}
+	}
+
+	confirmed := make(map[d.Hash32]bool)
+	for _, b := range connected {
+		for _, tx := range b.Body.Transactions {
+			confirmed[tx.TxID] = true
+		}
+	}
+	for _, tx := range pending {
+		if _, ok := bch.mempool.Get(tx.TxID); ok {
+			continue
+		}
+		reason := RemovedConflict
+		if confirmed[tx.TxID] {
+			reason = RemovedMined
+		}
+		bch.events.publish(Event{Kind: EventTxRemoved, Tx: tx, Reason: reason})
+	}
+}
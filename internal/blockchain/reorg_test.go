@@ -0,0 +1,271 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// mineOnParent mines a block with txs on top of parent, which need not be the tip.
+func mineOnParent(t *testing.T, bch *Blockchain, parent d.Block, txs ...d.Transaction) d.Block {
+	t.Helper()
+	hasher := c.NewArchasHasher()
+	body := d.Body{Transactions: txs}
+	header := d.Header{
+		Version:    1,
+		Timestamp:  uint32(time.Now().Unix()),
+		PrevHash:   bch.CalculateHash(parent),
+		MerkleRoot: MerkleRootHash(body, hasher),
+		Difficulty: 1,
+	}
+	if _, _, err := FindValidNonce(context.Background(), &header, hasher); err != nil {
+		t.Fatalf("FindValidNonce() error = %v", err)
+	}
+	return d.Block{Header: header, Body: body}
+}
+
+func TestProcessBlock_Reorganization(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+
+	mainTx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	main1 := mineOnParent(t, bch, genesis, mainTx)
+	if status, err := bch.ProcessBlock(main1); err != nil || status != BlockConnected {
+		t.Fatalf("ProcessBlock(main1) = %v, %v; want connected", status, err)
+	}
+
+	sideTx := signedTestTransaction(t, bch, users[2], users[1], 10)
+	side1 := mineOnParent(t, bch, genesis, sideTx)
+	if status, err := bch.ProcessBlock(side1); err != nil || status != BlockSideChain {
+		t.Fatalf("ProcessBlock(side1) = %v, %v; want side chain", status, err)
+	}
+	if tip, _ := bch.GetLatestBlock(); bch.CalculateHash(tip) != bch.CalculateHash(main1) {
+		t.Fatal("Equal work branch must not replace the main chain")
+	}
+
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 5, To: users[2].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	side2 := mineOnParent(t, bch, side1, coinbase)
+	if status, err := bch.ProcessBlock(side2); err != nil || status != BlockReorganized {
+		t.Fatalf("ProcessBlock(side2) = %v, %v; want reorganized", status, err)
+	}
+
+	if bch.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", bch.Len())
+	}
+	if tip, _ := bch.GetLatestBlock(); bch.CalculateHash(tip) != bch.CalculateHash(side2) {
+		t.Error("Tip should be the last block of the heavier branch")
+	}
+	if _, ok := bch.GetUTXO(mainTx.Inputs[0].Prev); !ok {
+		t.Error("Output spent by the disconnected block should be unspent again")
+	}
+	if _, ok := bch.GetUTXO(sideTx.Inputs[0].Prev); ok {
+		t.Error("Output spent by the new branch should be spent")
+	}
+	if _, ok := bch.Mempool().Get(mainTx.TxID); !ok {
+		t.Error("Transaction from the disconnected block should return to the mempool")
+	}
+	if !bch.HaveBlock(bch.CalculateHash(main1)) {
+		t.Error("Disconnected block should be kept on a side branch")
+	}
+	if depth := bch.metrics.reorgDepth.Snapshot(); bch.metrics.reorgs.Value() != 1 || depth.Count != 1 || depth.Sum != 1 {
+		t.Errorf("reorgs = %v, depth = %+v; want one reorganization of depth 1", bch.metrics.reorgs.Value(), depth)
+	}
+}
+
+func TestProcessBlock_InvalidBranchKeepsChain(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+
+	main1 := mineOnParent(t, bch, genesis, signedTestTransaction(t, bch, users[0], users[1], 10))
+	if _, err := bch.ProcessBlock(main1); err != nil {
+		t.Fatalf("ProcessBlock(main1) error = %v", err)
+	}
+
+	badTx := signedTestTransaction(t, bch, users[2], users[1], 10)
+	badTx.Inputs[0].Sig = signedTestTransaction(t, bch, users[1], users[2], 10).Inputs[0].Sig
+	side1 := mineOnParent(t, bch, genesis, badTx)
+	if _, err := bch.ProcessBlock(side1); err != nil {
+		t.Fatalf("ProcessBlock(side1) error = %v", err)
+	}
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 5, To: users[2].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	side2 := mineOnParent(t, bch, side1, coinbase)
+	if _, err := bch.ProcessBlock(side2); err == nil {
+		t.Fatal("Expected error for a branch containing an invalid signature")
+	}
+
+	if tip, _ := bch.GetLatestBlock(); bch.CalculateHash(tip) != bch.CalculateHash(main1) {
+		t.Error("Main chain should be restored after a failed reorganization")
+	}
+	if bch.HaveBlock(bch.CalculateHash(side1)) || bch.HaveBlock(bch.CalculateHash(side2)) {
+		t.Error("Invalid branch should be forgotten")
+	}
+	if _, ok := bch.GetUTXO(main1.Body.Transactions[0].Inputs[0].Prev); ok {
+		t.Error("UTXO set should match the restored main chain")
+	}
+}
+
+func TestProcessBlock_OrphanAndDuplicate(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+
+	block := mineOnParent(t, bch, genesis, signedTestTransaction(t, bch, users[0], users[1], 10))
+	orphan := block
+	orphan.Header.PrevHash = d.Hash32{0x01}
+	if _, _, err := FindValidNonce(context.Background(), &orphan.Header, c.NewArchasHasher()); err != nil {
+		t.Fatalf("FindValidNonce() error = %v", err)
+	}
+	if _, err := bch.ProcessBlock(orphan); !errors.Is(err, d.ErrOrphanBlock) {
+		t.Errorf("ProcessBlock(orphan) error = %v, want %v", err, d.ErrOrphanBlock)
+	}
+
+	empty := NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner())
+	if _, err := empty.ProcessBlock(block); !errors.Is(err, d.ErrOrphanBlock) {
+		t.Errorf("ProcessBlock() on an empty chain error = %v, want %v", err, d.ErrOrphanBlock)
+	}
+
+	if _, err := bch.ProcessBlock(block); err != nil {
+		t.Fatalf("ProcessBlock() error = %v", err)
+	}
+	if _, err := bch.ProcessBlock(block); !errors.Is(err, d.ErrDuplicateBlock) {
+		t.Errorf("ProcessBlock(duplicate) error = %v, want %v", err, d.ErrDuplicateBlock)
+	}
+}
+
+func TestProcessBlock_OldBlocks(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	hasher := c.NewArchasHasher()
+	dayAgo := uint32(time.Now().Add(-24 * time.Hour).Unix())
+
+	genesis, _ := bch.GetBlock(0)
+	genesis.Header.Timestamp = dayAgo
+	old := NewBlockchain(hasher, c.NewTransactionSigner())
+	if _, err := old.ProcessBlock(genesis); err != nil {
+		t.Fatalf("ProcessBlock(genesis) error = %v", err)
+	}
+
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 50, To: users[0].PublicAddress}}}
+	coinbase.TxID = old.HashTransaction(coinbase)
+	block := mineOnParent(t, old, genesis, coinbase)
+	block.Header.Timestamp = dayAgo + 60
+	if _, _, err := FindValidNonce(context.Background(), &block.Header, hasher); err != nil {
+		t.Fatalf("FindValidNonce() error = %v", err)
+	}
+	if err := old.ValidateBlock(block); !errors.Is(err, d.ErrTimestampTooOld) {
+		t.Errorf("ValidateBlock() error = %v, want %v for a block mined a day ago", err, d.ErrTimestampTooOld)
+	}
+	if status, err := old.ProcessBlock(block); err != nil || status != BlockConnected {
+		t.Fatalf("ProcessBlock() = %v, %v; a day old block on a day old parent should connect", status, err)
+	}
+
+	stale := mineOnParent(t, old, block, coinbase)
+	stale.Header.Timestamp = dayAgo - 3*3600
+	if _, _, err := FindValidNonce(context.Background(), &stale.Header, hasher); err != nil {
+		t.Fatalf("FindValidNonce() error = %v", err)
+	}
+	if _, err := old.ProcessBlock(stale); !errors.Is(err, d.ErrTimestampTooOld) {
+		t.Errorf("ProcessBlock() error = %v, want %v for a block far older than its parent", err, d.ErrTimestampTooOld)
+	}
+}
+
+func TestProcessBlock_DifficultyBelowMinimum(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+
+	// Every hash meets difficulty 0, so such a block carries no work.
+	block := atDifficulty(t, coinbaseBlock(t, bch, genesis, users[0], 50), 0)
+	if _, err := bch.ProcessBlock(block); !errors.Is(err, d.ErrInvalidDifficulty) {
+		t.Errorf("ProcessBlock() error = %v, want %v", err, d.ErrInvalidDifficulty)
+	}
+	if bch.CheckProofOfWork(block.Header) {
+		t.Error("CheckProofOfWork() accepted a header below the minimum difficulty")
+	}
+	if bch.Len() != 1 {
+		t.Errorf("chain length = %d, want 1", bch.Len())
+	}
+}
+
+func TestBlockLocator_LocateBlocks(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	for i := 0; i < 3; i++ {
+		tip, _ := bch.GetLatestBlock()
+		if err := bch.AddBlock(mineOnParent(t, bch, tip, signedTestTransaction(t, bch, users[i], users[(i+1)%len(users)], 10))); err != nil {
+			t.Fatalf("AddBlock() error = %v", err)
+		}
+	}
+
+	locator := bch.BlockLocator()
+	genesis, _ := bch.GetBlock(0)
+	if len(locator) != 4 || locator[len(locator)-1] != bch.CalculateHash(genesis) {
+		t.Fatalf("BlockLocator() should list every block of a short chain down to genesis, got %d hashes", len(locator))
+	}
+
+	hashes := bch.LocateBlocks([]d.Hash32{{0xAB}, bch.CalculateHash(genesis)}, 2)
+	if len(hashes) != 2 {
+		t.Fatalf("LocateBlocks() returned %d hashes, want 2", len(hashes))
+	}
+	first, _ := bch.GetBlock(1)
+	if hashes[0] != bch.CalculateHash(first) {
+		t.Error("LocateBlocks() should start right after the first known locator hash")
+	}
+	if got := bch.LocateBlocks(nil, 0); len(got) != 4 {
+		t.Errorf("LocateBlocks(nil) returned %d hashes, want the whole chain", len(got))
+	}
+
+	headers := bch.LocateHeaders([]d.Hash32{hashes[0]}, 0)
+	if len(headers) != 2 || bch.CalculateHash(d.Block{Header: headers[0]}) != hashes[1] {
+		t.Errorf("LocateHeaders() returned %d headers, want the 2 blocks after the locator hash", len(headers))
+	}
+}
+
+func TestChainIndex_FollowsReorganization(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+	main1 := mineOnParent(t, bch, genesis, signedTestTransaction(t, bch, users[0], users[1], 10))
+	if _, err := bch.ProcessBlock(main1); err != nil {
+		t.Fatalf("ProcessBlock(main1) error = %v", err)
+	}
+	side1 := mineOnParent(t, bch, genesis, signedTestTransaction(t, bch, users[2], users[1], 10))
+	if _, err := bch.ProcessBlock(side1); err != nil {
+		t.Fatalf("ProcessBlock(side1) error = %v", err)
+	}
+	if height, err := bch.GetHeightByHash(bch.CalculateHash(main1)); err != nil || height != 1 {
+		t.Errorf("GetHeightByHash(main1) = %d, %v; want 1", height, err)
+	}
+	if _, err := bch.GetHeightByHash(bch.CalculateHash(side1)); err != d.ErrBlockNotFound {
+		t.Errorf("GetHeightByHash(side1) error = %v, want %v", err, d.ErrBlockNotFound)
+	}
+
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 5, To: users[2].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	side2 := mineOnParent(t, bch, side1, coinbase)
+	if status, err := bch.ProcessBlock(side2); err != nil || status != BlockReorganized {
+		t.Fatalf("ProcessBlock(side2) = %v, %v; want reorganized", status, err)
+	}
+	for height, b := range []d.Block{genesis, side1, side2} {
+		hash := bch.CalculateHash(b)
+		if got, err := bch.GetBlockHash(height); err != nil || got != hash {
+			t.Errorf("GetBlockHash(%d) = %v, %v; want %v", height, got, err, hash)
+		}
+		if got, err := bch.GetHeightByHash(hash); err != nil || got != height {
+			t.Errorf("GetHeightByHash(block %d) = %d, %v", height, got, err)
+		}
+	}
+	if tipHash, err := bch.GetLatestBlockHash(); err != nil || tipHash != bch.CalculateHash(side2) {
+		t.Errorf("GetLatestBlockHash() = %v, %v; want side2", tipHash, err)
+	}
+	if _, err := bch.GetHeightByHash(bch.CalculateHash(main1)); err != d.ErrBlockNotFound {
+		t.Errorf("GetHeightByHash(disconnected main1) error = %v, want %v", err, d.ErrBlockNotFound)
+	}
+	if _, err := bch.GetBlockHash(3); err != d.ErrBlockIndexOutOfRange {
+		t.Errorf("GetBlockHash(3) error = %v, want %v", err, d.ErrBlockIndexOutOfRange)
+	}
+	if _, err := NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner()).GetLatestBlockHash(); err != d.ErrEmptyBlockchain {
+		t.Errorf("GetLatestBlockHash() of an empty chain error = %v, want %v", err, d.ErrEmptyBlockchain)
+	}
+}
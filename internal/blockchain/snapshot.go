@@ -0,0 +1,356 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+var (
+	// ErrInvalidSnapshot is returned by LoadUTXOSet for a malformed snapshot, one whose
+	// commitment does not match its outputs or one whose block is not in the headers.
+	ErrInvalidSnapshot = errors.New("blockchain: invalid UTXO snapshot")
+	// ErrSnapshotMismatch is returned by ValidateSnapshot when replaying the chain
+	// history does not lead to the UTXO set of the snapshot.
+	ErrSnapshotMismatch = errors.New("blockchain: chain history does not match the UTXO snapshot")
+	// ErrNoPendingSnapshot is returned by ValidateSnapshot for a chain that was not
+	// loaded from a snapshot or whose snapshot was already validated.
+	ErrNoPendingSnapshot = errors.New("blockchain: no UTXO snapshot awaits validation")
+)
+
+// UTXOSnapshot describes the UTXO set after the main chain block at Height.
+// Commitment is the hash of the set in the format DumpUTXOSet writes it, which two
+// nodes with the same set always compute alike.
+type UTXOSnapshot struct {
+	Height     int
+	BlockHash  d.Hash32
+	UTXOs      int
+	Amount     uint64
+	Commitment d.Hash32
+}
+
+// SnapshotState tells whether a chain was loaded from a UTXO snapshot and how far the
+// validation of the history below it got.
+type SnapshotState int
+
+const (
+	// SnapshotNone means the chain was built block by block from genesis.
+	SnapshotNone SnapshotState = iota
+	// SnapshotPending means the chain was loaded from a snapshot whose history has not
+	// been validated yet. Blocks up to the snapshot carry only their headers.
+	SnapshotPending
+	// SnapshotValidated means the history was replayed and led to the snapshot's UTXO set.
+	SnapshotValidated
+	// SnapshotInvalid means the history did not lead to the snapshot's UTXO set.
+	SnapshotInvalid
+)
+
+func (s SnapshotState) String() string {
+	switch s {
+	case SnapshotNone:
+		return "none"
+	case SnapshotPending:
+		return "pending"
+	case SnapshotValidated:
+		return "validated"
+	case SnapshotInvalid:
+		return "invalid"
+	}
+	return "unknown"
+}
+
+// snapshotMagic starts every UTXO snapshot file.
+var snapshotMagic = [4]byte{'B', 'U', 'Z', 'U'}
+
+const snapshotVersion = 1
+
+// utxoEntrySize is the size of a serialized UTXO: outpoint TxID and index, address and value.
+const utxoEntrySize = 32 + 4 + 20 + 4
+
+// serializeUTXOs writes utxos, which must be sorted by outpoint, in the snapshot format.
+func serializeUTXOs(utxos []d.UTXO) []byte {
+	buf := make([]byte, 0, len(utxos)*utxoEntrySize)
+	for _, u := range utxos {
+		buf = append(buf, u.Outpoint.TxID[:]...)
+		buf = binary.LittleEndian.AppendUint32(buf, u.Outpoint.Index)
+		buf = append(buf, u.To[:]...)
+		buf = binary.LittleEndian.AppendUint32(buf, u.Value)
+	}
+	return buf
+}
+
+func compareOutpoints(a, b d.Outpoint) int {
+	if cmp := bytes.Compare(a.TxID[:], b.TxID[:]); cmp != 0 {
+		return cmp
+	}
+	return int(a.Index) - int(b.Index)
+}
+
+// sortedUTXOs returns the outputs of set ordered by outpoint.
+func sortedUTXOs(set map[d.Outpoint]d.UTXO) []d.UTXO {
+	utxos := make([]d.UTXO, 0, len(set))
+	for _, u := range set {
+		utxos = append(utxos, u)
+	}
+	slices.SortFunc(utxos, func(a, b d.UTXO) int { return compareOutpoints(a.Outpoint, b.Outpoint) })
+	return utxos
+}
+
+// utxoSetAtLocked returns the UTXO set after the main chain block at height, rewinding
+// the current set with the undo data of the blocks above it.
+func (bch *Blockchain) utxoSetAtLocked(height int) map[d.Outpoint]d.UTXO {
+	set := bch.utxoTracker.clone()
+	for h := len(bch.blocks) - 1; h > height; h-- {
+		txs := bch.blocks[h].Body.Transactions
+		for i := len(txs) - 1; i >= 0; i-- {
+			txHash := bch.OutpointTxID(txs[i])
+			for idx := range txs[i].Outputs {
+				delete(set, d.Outpoint{TxID: txHash, Index: uint32(idx)})
+			}
+		}
+		for _, utxo := range bch.undo[h] {
+			set[utxo.Outpoint] = utxo
+		}
+	}
+	return set
+}
+
+// DumpUTXOSet writes the UTXO set after the main chain block at height, together with
+// its commitment. The set is rewound from the tip, so the height may lie anywhere
+// above the oldest block whose body the chain keeps.
+func (bch *Blockchain) DumpUTXOSet(w io.Writer, height int) (UTXOSnapshot, error) {
+	bch.chainMutex.RLock()
+	if height < 0 || height >= len(bch.blocks) {
+		bch.chainMutex.RUnlock()
+		return UTXOSnapshot{}, d.ErrBlockIndexOutOfRange
+	}
+	if height+1 < bch.firstBody {
+		bch.chainMutex.RUnlock()
+		return UTXOSnapshot{}, fmt.Errorf("rewinding to height %d: %w", height, bch.missingBodyLocked(height+1))
+	}
+	utxos := sortedUTXOs(bch.utxoSetAtLocked(height))
+	snap := UTXOSnapshot{Height: height, BlockHash: bch.hashes[height], UTXOs: len(utxos)}
+	bch.chainMutex.RUnlock()
+
+	for _, u := range utxos {
+		snap.Amount += uint64(u.Value)
+	}
+	raw := serializeUTXOs(utxos)
+	snap.Commitment = bch.hasher.Hash(raw)
+	return snap, writeUTXOSnapshot(w, snap, raw)
+}
+
+// writeUTXOSnapshot writes a snapshot file for snap, whose outputs raw holds as
+// serializeUTXOs returns them.
+func writeUTXOSnapshot(w io.Writer, snap UTXOSnapshot, raw []byte) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic[:])
+	_ = binary.Write(bw, binary.LittleEndian, uint32(snapshotVersion))
+	_ = binary.Write(bw, binary.LittleEndian, uint32(snap.Height))
+	bw.Write(snap.BlockHash[:])
+	_ = binary.Write(bw, binary.LittleEndian, uint64(snap.UTXOs))
+	bw.Write(raw)
+	bw.Write(snap.Commitment[:])
+	return bw.Flush()
+}
+
+// maxSnapshotUTXOs bounds the number of outputs a snapshot file may announce.
+const maxSnapshotUTXOs = 1 << 26
+
+// readUTXOSnapshot reads a file written by DumpUTXOSet and checks its commitment.
+func readUTXOSnapshot(r io.Reader, hasher c.Hasher) (UTXOSnapshot, map[d.Outpoint]d.UTXO, error) {
+	invalid := func(err error) error {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	var header struct {
+		Magic     [4]byte
+		Version   uint32
+		Height    uint32
+		BlockHash d.Hash32
+		Count     uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return UTXOSnapshot{}, nil, invalid(err)
+	}
+	if header.Magic != snapshotMagic {
+		return UTXOSnapshot{}, nil, fmt.Errorf("%w: not a snapshot file", ErrInvalidSnapshot)
+	}
+	if header.Version != snapshotVersion {
+		return UTXOSnapshot{}, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, header.Version)
+	}
+	if header.Count > maxSnapshotUTXOs {
+		return UTXOSnapshot{}, nil, fmt.Errorf("%w: %d outputs", ErrInvalidSnapshot, header.Count)
+	}
+	raw := make([]byte, header.Count*utxoEntrySize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return UTXOSnapshot{}, nil, invalid(err)
+	}
+	var commitment d.Hash32
+	if _, err := io.ReadFull(r, commitment[:]); err != nil {
+		return UTXOSnapshot{}, nil, invalid(err)
+	}
+	if hasher.Hash(raw) != commitment {
+		return UTXOSnapshot{}, nil, fmt.Errorf("%w: commitment does not match the outputs", ErrInvalidSnapshot)
+	}
+
+	snap := UTXOSnapshot{Height: int(header.Height), BlockHash: header.BlockHash, UTXOs: int(header.Count), Commitment: commitment}
+	set := make(map[d.Outpoint]d.UTXO, header.Count)
+	var prev d.Outpoint
+	for i := 0; i < len(raw); i += utxoEntrySize {
+		entry := raw[i : i+utxoEntrySize]
+		var u d.UTXO
+		copy(u.Outpoint.TxID[:], entry[:32])
+		u.Outpoint.Index = binary.LittleEndian.Uint32(entry[32:36])
+		copy(u.To[:], entry[36:56])
+		u.Value = binary.LittleEndian.Uint32(entry[56:60])
+		// The commitment is only unique if the outputs are in order.
+		if i > 0 && compareOutpoints(prev, u.Outpoint) >= 0 {
+			return UTXOSnapshot{}, nil, fmt.Errorf("%w: outputs out of order", ErrInvalidSnapshot)
+		}
+		prev = u.Outpoint
+		set[u.Outpoint] = u
+		snap.Amount += uint64(u.Value)
+	}
+	return snap, set, nil
+}
+
+// LoadUTXOSet builds a chain from a snapshot written by DumpUTXOSet and the main chain
+// headers from genesis up to at least the snapshot's block. The headers must link up
+// and carry valid proof of work, but the blocks up to the snapshot are not validated:
+// the chain keeps only their headers and trusts the snapshot's UTXO set until
+// ValidateSnapshot replays the history. Blocks on top of the snapshot can be added
+// with ProcessBlock right away.
+func LoadUTXOSet(r io.Reader, headers []d.Header, hasher c.Hasher, signer c.TransactionSigner, opts ...Option) (*Blockchain, error) {
+	bch := NewBlockchain(hasher, signer, opts...)
+	if bch.store != nil {
+		return nil, ErrSnapshotStore
+	}
+	snap, set, err := readUTXOSnapshot(r, hasher)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) <= snap.Height {
+		return nil, fmt.Errorf("%w: %d headers do not reach height %d", ErrInvalidSnapshot, len(headers), snap.Height)
+	}
+	var prevHash d.Hash32
+	for height, header := range headers[:snap.Height+1] {
+		if header.PrevHash != prevHash {
+			return nil, fmt.Errorf("header %d: %w", height, d.ErrInvalidPrevHash)
+		}
+		if !bch.CheckProofOfWork(header) {
+			return nil, fmt.Errorf("header %d: %w", height, d.ErrInvalidDifficulty)
+		}
+		prevHash = bch.CalculateHash(d.Block{Header: header})
+		bch.blocks = append(bch.blocks, d.Block{Header: header})
+		bch.hashes = append(bch.hashes, prevHash)
+		bch.heights[prevHash] = height
+		bch.undo = append(bch.undo, nil)
+		bch.chainWork.Add(bch.chainWork, bch.headerWork(header))
+	}
+	if prevHash != snap.BlockHash {
+		return nil, fmt.Errorf("%w: block %s is not at height %d of the headers", ErrInvalidSnapshot, snap.BlockHash.String(), snap.Height)
+	}
+	bch.utxoTracker.load(set)
+	bch.firstBody = snap.Height + 1
+	bch.snapshot = snap
+	bch.snapshotState = SnapshotPending
+	bch.logger.Warn("Loaded UTXO snapshot; history below it is assumed valid until validated",
+		"height", snap.Height, "hash", snap.BlockHash.String(), "utxos", snap.UTXOs, "commitment", snap.Commitment.String())
+	return bch, nil
+}
+
+// Snapshot returns the snapshot the chain was loaded from and the state of its validation.
+func (bch *Blockchain) Snapshot() (UTXOSnapshot, SnapshotState) {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	return bch.snapshot, bch.snapshotState
+}
+
+// ValidateSnapshot replays the main chain blocks from genesis up to the snapshot the
+// chain was loaded from, with full validation, and checks that they lead to the
+// snapshot's UTXO set. It is meant to run in the background while the chain keeps
+// growing on top of the snapshot. On success the chain takes over the blocks'
+// bodies and undo data and rebuilds its indexes, so it no longer differs from a
+// chain built from genesis. On failure the snapshot is marked invalid.
+func (bch *Blockchain) ValidateSnapshot(ctx context.Context, blocks []d.Block) error {
+	bch.chainMutex.RLock()
+	snap, state := bch.snapshot, bch.snapshotState
+	hashes := slices.Clone(bch.hashes[:min(snap.Height+1, len(bch.hashes))])
+	bch.chainMutex.RUnlock()
+	if state != SnapshotPending {
+		return ErrNoPendingSnapshot
+	}
+	if len(blocks) <= snap.Height {
+		return fmt.Errorf("%d blocks do not reach the snapshot at height %d", len(blocks), snap.Height)
+	}
+
+	replay := NewBlockchain(bch.hasher, bch.txSigner, WithLogger(bch.logger), WithMinDifficulty(bch.minDifficulty))
+	replay.RegisterUsers(bch.Users())
+	for height, b := range blocks[:snap.Height+1] {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if hash := replay.CalculateHash(b); hash != hashes[height] {
+			return bch.failSnapshot(fmt.Errorf("block %d is %s, the header is %s", height, hash.String(), hashes[height].String()))
+		}
+		status, err := replay.ProcessBlock(b)
+		if err == nil && status != BlockConnected {
+			err = fmt.Errorf("block does not extend the chain")
+		}
+		if err != nil {
+			return bch.failSnapshot(fmt.Errorf("block %d: %w", height, err))
+		}
+	}
+	if commitment := d.Hash32(replay.hasher.Hash(serializeUTXOs(sortedUTXOs(replay.utxoTracker.clone())))); commitment != snap.Commitment {
+		return bch.failSnapshot(fmt.Errorf("history commits to %s", commitment.String()))
+	}
+
+	bch.chainMutex.Lock()
+	defer bch.chainMutex.Unlock()
+	// A pruned chain takes over only the bodies it would not have pruned by now.
+	first := 0
+	if bch.pruneDepth > 0 {
+		first = max(len(bch.blocks)-bch.pruneDepth, 0)
+	}
+	if bch.firstBody > snap.Height+1 {
+		first = bch.firstBody
+	}
+	for height := first; height <= snap.Height; height++ {
+		bch.blocks[height] = replay.blocks[height]
+		bch.undo[height] = replay.undo[height]
+	}
+	bch.firstBody = first
+	bch.snapshotState = SnapshotValidated
+	bch.txIndex = newTxIndex(bch.txIndex != nil)
+	bch.addressIndex = newAddressIndex(bch.addressIndex != nil)
+	for height := range bch.blocks {
+		b, spent := bch.blocks[height], bch.undo[height]
+		if height <= snap.Height {
+			b, spent = replay.blocks[height], replay.undo[height]
+		}
+		bch.txIndex.connectBlock(b, bch.hashes[height], height)
+		bch.addressIndex.connectBlock(b, bch.hashes[height], height, spent)
+	}
+	bch.logger.Info("Validated UTXO snapshot", "height", snap.Height, "hash", snap.BlockHash.String())
+	return nil
+}
+
+func (bch *Blockchain) failSnapshot(err error) error {
+	bch.chainMutex.Lock()
+	bch.snapshotState = SnapshotInvalid
+	bch.chainMutex.Unlock()
+	err = fmt.Errorf("%w: %w", ErrSnapshotMismatch, err)
+	bch.logger.Error("UTXO snapshot failed validation", "err", err)
+	return err
+}
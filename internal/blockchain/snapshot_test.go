@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+func headersOf(blocks []d.Block) []d.Header {
+	headers := make([]d.Header, len(blocks))
+	for i, b := range blocks {
+		headers[i] = b.Header
+	}
+	return headers
+}
+
+func TestUTXOSnapshot(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	for i := range 2 {
+		tip, _ := bch.GetLatestBlock()
+		if _, err := bch.ProcessBlock(mineOnParent(t, bch, tip, signedTestTransaction(t, bch, users[i], users[i+1], 10))); err != nil {
+			t.Fatalf("ProcessBlock() error = %v", err)
+		}
+	}
+	blocks := bch.Blocks()
+
+	var atTip bytes.Buffer
+	tipSnap, err := bch.DumpUTXOSet(&atTip, 2)
+	if err != nil {
+		t.Fatalf("DumpUTXOSet(2) error = %v", err)
+	}
+	var buf bytes.Buffer
+	snap, err := bch.DumpUTXOSet(&buf, 1)
+	if err != nil {
+		t.Fatalf("DumpUTXOSet(1) error = %v", err)
+	}
+	if snap.Height != 1 || snap.BlockHash != bch.CalculateHash(blocks[1]) || snap.Commitment == tipSnap.Commitment {
+		t.Fatalf("snapshot = %+v, want one of block 1 that differs from the tip's", snap)
+	}
+	raw := bytes.Clone(buf.Bytes())
+
+	loaded, err := LoadUTXOSet(&buf, headersOf(blocks), c.NewArchasHasher(), c.NewTransactionSigner(), WithTxIndex())
+	if err != nil {
+		t.Fatalf("LoadUTXOSet() error = %v", err)
+	}
+	loaded.RegisterUsers(users)
+	if _, state := loaded.Snapshot(); state != SnapshotPending {
+		t.Errorf("state = %v, want pending", state)
+	}
+	if _, err := loaded.GetBlock(0); !errors.Is(err, d.ErrBlockDataUnavailable) {
+		t.Errorf("GetBlock(0) error = %v, want %v", err, d.ErrBlockDataUnavailable)
+	}
+	if status, err := loaded.ProcessBlock(blocks[2]); err != nil || status != BlockConnected {
+		t.Fatalf("ProcessBlock(2) = %v, %v; want connected on top of the snapshot", status, err)
+	}
+	for _, u := range users {
+		if loaded.GetUserBalance(u.PublicAddress) != bch.GetUserBalance(u.PublicAddress) {
+			t.Errorf("balance of %s = %d, want %d", u.Name, loaded.GetUserBalance(u.PublicAddress), bch.GetUserBalance(u.PublicAddress))
+		}
+	}
+	if again, _ := loaded.DumpUTXOSet(&bytes.Buffer{}, 2); again != tipSnap {
+		t.Errorf("snapshot of the loaded chain = %+v, want %+v", again, tipSnap)
+	}
+
+	if err := loaded.ValidateSnapshot(context.Background(), blocks); err != nil {
+		t.Fatalf("ValidateSnapshot() error = %v", err)
+	}
+	if _, state := loaded.Snapshot(); state != SnapshotValidated {
+		t.Errorf("state = %v, want validated", state)
+	}
+	if b, err := loaded.GetBlock(1); err != nil || len(b.Body.Transactions) == 0 {
+		t.Errorf("GetBlock(1) = %d transactions, %v; want the validated body", len(b.Body.Transactions), err)
+	}
+	if _, err := loaded.GetTransaction(blocks[1].Body.Transactions[0].TxID); err != nil {
+		t.Errorf("GetTransaction() of a block below the snapshot error = %v", err)
+	}
+
+	tampered := bytes.Clone(raw)
+	tampered[len(tampered)-40]++
+	if _, err := LoadUTXOSet(bytes.NewReader(tampered), headersOf(blocks), c.NewArchasHasher(), c.NewTransactionSigner()); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("LoadUTXOSet() of a tampered file error = %v, want %v", err, ErrInvalidSnapshot)
+	}
+	if _, err := LoadUTXOSet(bytes.NewReader(raw), headersOf(blocks[:1]), c.NewArchasHasher(), c.NewTransactionSigner()); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("LoadUTXOSet() without the snapshot's header error = %v, want %v", err, ErrInvalidSnapshot)
+	}
+
+	// A forged snapshot with a consistent commitment loads, but its history gives it away.
+	bch.chainMutex.RLock()
+	utxos := sortedUTXOs(bch.utxoSetAtLocked(1))
+	bch.chainMutex.RUnlock()
+	utxos[0].Value += 1000
+	forgedRaw := serializeUTXOs(utxos)
+	forged := UTXOSnapshot{Height: 1, BlockHash: snap.BlockHash, UTXOs: len(utxos), Commitment: bch.hasher.Hash(forgedRaw)}
+	var forgedFile bytes.Buffer
+	if err := writeUTXOSnapshot(&forgedFile, forged, forgedRaw); err != nil {
+		t.Fatalf("writeUTXOSnapshot() error = %v", err)
+	}
+	fooled, err := LoadUTXOSet(&forgedFile, headersOf(blocks), c.NewArchasHasher(), c.NewTransactionSigner())
+	if err != nil {
+		t.Fatalf("LoadUTXOSet() of a forged snapshot error = %v", err)
+	}
+	fooled.RegisterUsers(users)
+	if err := fooled.ValidateSnapshot(context.Background(), blocks); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Errorf("ValidateSnapshot() of a forged snapshot error = %v, want %v", err, ErrSnapshotMismatch)
+	}
+	if _, state := fooled.Snapshot(); state != SnapshotInvalid {
+		t.Errorf("state = %v, want invalid", state)
+	}
+}
@@ -0,0 +1,209 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+var (
+	// ErrNoStore is returned by OpenBlockchain when no store was given WithStore.
+	ErrNoStore = errors.New("blockchain: no store")
+	// ErrStoreIndexMissing is returned by OpenBlockchain when an index is enabled but
+	// the store holds a chain that was written without it.
+	ErrStoreIndexMissing = errors.New("blockchain: store does not keep the requested index")
+	// ErrCorruptStore is returned by OpenBlockchain when the stored chain does not hang
+	// together.
+	ErrCorruptStore = errors.New("blockchain: corrupt store")
+	// ErrSnapshotStore is returned by LoadUTXOSet when given a store, since the store
+	// could not hold the blocks below the snapshot before they are validated.
+	ErrSnapshotStore = errors.New("blockchain: a chain loaded from a UTXO snapshot cannot use a store")
+)
+
+// Store persists the main chain so that a node can restart where it stopped. The
+// chain hands it one BlockUpdate for every block it connects or disconnects, which the
+// store must apply atomically: after a crash it then holds the chain as it was after
+// some block. A reorganization cut short leaves a valid, if lighter, chain behind,
+// which the node extends again once it hears of the better branch.
+type Store interface {
+	// Load returns the persisted chain. An empty store returns a StoredChain without headers.
+	Load() (StoredChain, error)
+	ConnectBlock(u BlockUpdate) error
+	DisconnectBlock(u BlockUpdate) error
+	PutUsers(users []d.User) error
+	Close() error
+}
+
+// BlockUpdate is everything that connecting or disconnecting the main chain block at
+// Height changes.
+type BlockUpdate struct {
+	Height int
+	Hash   d.Hash32
+	Block  d.Block
+	// Spent are the outputs the block spent, which are its undo data, and Created the
+	// outputs it added to the UTXO set.
+	Spent   []d.UTXO
+	Created []d.UTXO
+	// TxIndex and AddressIndex tell whether the chain keeps the indexes. Addresses
+	// holds the address index entries of a connected block.
+	TxIndex      bool
+	AddressIndex bool
+	Addresses    []AddressRecord
+	// PruneHeight is the height of the oldest block whose body and undo data the chain
+	// keeps after the update; the store drops those of the blocks below it.
+	PruneHeight int
+}
+
+// AddressRecord is an entry of the address index together with its address.
+type AddressRecord struct {
+	Address d.PublicAddress
+	Entry   AddressEntry
+}
+
+// StoredChain is the chain as a Store loads it.
+type StoredChain struct {
+	// Headers holds the main chain headers from genesis to the tip.
+	Headers []d.Header
+	// Blocks and Undo hold the blocks and undo data from PruneHeight to the tip.
+	Blocks      []d.Block
+	Undo        [][]d.UTXO
+	PruneHeight int
+	UTXOs       []d.UTXO
+	Users       []d.User
+	// TxIndex is nil and Addresses is nil when the store does not keep the index.
+	// Addresses is in main chain order.
+	TxIndex   map[d.Hash32]TxLocation
+	Addresses []AddressRecord
+}
+
+// OpenBlockchain creates a chain that persists itself to the store given WithStore and
+// starts from the chain the store holds, which is empty for a new store. The headers
+// must link up; the blocks are not validated again, since only validated blocks are
+// written. An index enabled for the chain must be kept by the store, unless it is empty.
+func OpenBlockchain(hasher c.Hasher, signer c.TransactionSigner, opts ...Option) (*Blockchain, error) {
+	bch := NewBlockchain(hasher, signer, opts...)
+	if bch.store == nil {
+		return nil, ErrNoStore
+	}
+	stored, err := bch.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range stored.Users {
+		bch.userRegistry[u.PublicAddress] = u.PublicKey
+	}
+	n := len(stored.Headers)
+	if n == 0 {
+		return bch, nil
+	}
+	if stored.PruneHeight < 0 || stored.PruneHeight > n || len(stored.Blocks) != n-stored.PruneHeight || len(stored.Undo) != len(stored.Blocks) {
+		return nil, fmt.Errorf("%w: %d headers but blocks from height %d", ErrCorruptStore, n, stored.PruneHeight)
+	}
+	if bch.txIndex != nil && stored.TxIndex == nil {
+		return nil, fmt.Errorf("%w: transaction index", ErrStoreIndexMissing)
+	}
+	if bch.addressIndex != nil && stored.Addresses == nil {
+		return nil, fmt.Errorf("%w: address index", ErrStoreIndexMissing)
+	}
+
+	var prevHash d.Hash32
+	for height, header := range stored.Headers {
+		if header.PrevHash != prevHash {
+			return nil, fmt.Errorf("%w: header %d does not follow its parent", ErrCorruptStore, height)
+		}
+		block := d.Block{Header: header}
+		prevHash = bch.CalculateHash(block)
+		var undo []d.UTXO
+		if height >= stored.PruneHeight {
+			block = stored.Blocks[height-stored.PruneHeight]
+			undo = stored.Undo[height-stored.PruneHeight]
+			if block.Header != header {
+				return nil, fmt.Errorf("%w: block %d does not match its header", ErrCorruptStore, height)
+			}
+			for i, tx := range block.Body.Transactions {
+				block.Body.Transactions[i].TxID = bch.HashTransaction(tx)
+			}
+		}
+		bch.blocks = append(bch.blocks, block)
+		bch.hashes = append(bch.hashes, prevHash)
+		bch.heights[prevHash] = height
+		bch.undo = append(bch.undo, undo)
+		bch.chainWork.Add(bch.chainWork, bch.headerWork(header))
+	}
+	set := make(map[d.Outpoint]d.UTXO, len(stored.UTXOs))
+	for _, utxo := range stored.UTXOs {
+		set[utxo.Outpoint] = utxo
+	}
+	bch.utxoTracker.load(set)
+	bch.firstBody = stored.PruneHeight
+	if bch.txIndex != nil {
+		bch.txIndex.locations = stored.TxIndex
+	}
+	if bch.addressIndex != nil {
+		for _, r := range stored.Addresses {
+			bch.addressIndex.history[r.Address] = append(bch.addressIndex.history[r.Address], r.Entry)
+		}
+	}
+	tip := bch.hashes[n-1]
+	bch.logger.Info("Loaded chain from store", "height", n-1, "hash", tip.String(), "utxos", len(set), "prune_height", stored.PruneHeight)
+	return bch, nil
+}
+
+// createdOutputs returns the outputs b adds to the UTXO set: all its outputs except
+// those spent within the block.
+func (bch *Blockchain) createdOutputs(b d.Block, spent []d.UTXO) []d.UTXO {
+	spentHere := make(map[d.Outpoint]bool, len(spent))
+	for _, utxo := range spent {
+		spentHere[utxo.Outpoint] = true
+	}
+	var created []d.UTXO
+	for _, tx := range b.Body.Transactions {
+		txHash := bch.OutpointTxID(tx)
+		for idx, out := range tx.Outputs {
+			outpoint := d.Outpoint{TxID: txHash, Index: uint32(idx)}
+			if !spentHere[outpoint] {
+				created = append(created, d.UTXO{Outpoint: outpoint, To: out.To, Value: out.Value})
+			}
+		}
+	}
+	return created
+}
+
+// storeLocked writes a connected or disconnected block to the store. A failed write
+// stops all later ones, so that the store keeps the consistent chain it held before
+// instead of missing a block in the middle; the chain in memory goes on.
+func (bch *Blockchain) storeLocked(connect bool, height int, hash d.Hash32, b d.Block, spent []d.UTXO, addresses []AddressRecord) {
+	if bch.store == nil || bch.storeErr != nil {
+		return
+	}
+	u := BlockUpdate{
+		Height:       height,
+		Hash:         hash,
+		Block:        b,
+		Spent:        spent,
+		Created:      bch.createdOutputs(b, spent),
+		TxIndex:      bch.txIndex != nil,
+		AddressIndex: bch.addressIndex != nil,
+		Addresses:    addresses,
+		PruneHeight:  bch.firstBody,
+	}
+	var err error
+	if connect {
+		err = bch.store.ConnectBlock(u)
+	} else {
+		err = bch.store.DisconnectBlock(u)
+	}
+	if err != nil {
+		bch.storeErr = err
+		bch.logger.Error("Could not write block to store; no further blocks will be written", "height", height, "hash", hash.String(), "err", err)
+	}
+}
+
+// StoreErr returns the error that stopped the chain from writing to its store, if any.
+func (bch *Blockchain) StoreErr() error {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+	return bch.storeErr
+}
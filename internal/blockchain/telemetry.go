@@ -0,0 +1,169 @@
+package blockchain
+
+import (
+	"sync"
+	"time"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// MiningEventKind tells how a mining round of MineBlocks ended.
+type MiningEventKind int
+
+const (
+	// BlockMined rounds sealed a block and connected it to the chain.
+	BlockMined MiningEventKind = iota
+	// RoundStale rounds sealed a block whose parent was no longer the tip, because
+	// another block extended the chain in the meantime. Their work is wasted.
+	RoundStale
+	// RoundCancelled rounds were stopped by their context before a block was sealed.
+	// Their work is wasted.
+	RoundCancelled
+)
+
+func (k MiningEventKind) String() string {
+	switch k {
+	case BlockMined:
+		return "mined"
+	case RoundStale:
+		return "stale"
+	case RoundCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
+
+// MiningEvent describes one mining round.
+type MiningEvent struct {
+	Kind MiningEventKind
+	// Height is the height the round mined at. Hash, Nonce and Transactions describe
+	// the sealed block and are zero for cancelled rounds.
+	Height       int
+	Hash         d.Hash32
+	Nonce        uint32
+	Transactions int
+	// Hashes is the number of nonces tried, WorkerHashes the same per worker.
+	Hashes       uint64
+	WorkerHashes []uint64
+	// Duration is the wall clock time spent searching nonces.
+	Duration time.Duration
+}
+
+// Hashrate returns the round's hashes per second.
+func (e MiningEvent) Hashrate() float64 {
+	return hashrate(e.Hashes, e.Duration)
+}
+
+// MiningStats aggregates the rounds mined by a Blockchain.
+type MiningStats struct {
+	Blocks          int
+	StaleRounds     int
+	CancelledRounds int
+	// Hashes counts every nonce tried, WastedHashes those of stale and cancelled rounds.
+	Hashes       uint64
+	WastedHashes uint64
+	// Time is the wall clock time spent searching nonces, BlockTime the part of it
+	// spent in rounds that mined a block.
+	Time      time.Duration
+	BlockTime time.Duration
+	Workers   []WorkerStats
+	// Last is the most recent round, if any.
+	Last *MiningEvent
+}
+
+// WorkerStats is the work of one mining goroutine over all rounds.
+type WorkerStats struct {
+	Hashes uint64
+	Time   time.Duration
+}
+
+// Hashrate returns the worker's hashes per second.
+func (w WorkerStats) Hashrate() float64 {
+	return hashrate(w.Hashes, w.Time)
+}
+
+// Hashrate returns the hashes per second over all rounds.
+func (s MiningStats) Hashrate() float64 {
+	return hashrate(s.Hashes, s.Time)
+}
+
+// AverageBlockTime returns the mean time it took to mine a block.
+func (s MiningStats) AverageBlockTime() time.Duration {
+	if s.Blocks == 0 {
+		return 0
+	}
+	return s.BlockTime / time.Duration(s.Blocks)
+}
+
+func hashrate(hashes uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(hashes) / elapsed.Seconds()
+}
+
+// telemetry records mining rounds and passes them on to the listeners.
+type telemetry struct {
+	mutex     *sync.Mutex
+	stats     MiningStats
+	listeners []func(MiningEvent)
+}
+
+func newTelemetry() *telemetry {
+	return &telemetry{mutex: &sync.Mutex{}}
+}
+
+func (t *telemetry) record(e MiningEvent) {
+	t.mutex.Lock()
+	s := &t.stats
+	switch e.Kind {
+	case BlockMined:
+		s.Blocks++
+		s.BlockTime += e.Duration
+	case RoundStale:
+		s.StaleRounds++
+		s.WastedHashes += e.Hashes
+	case RoundCancelled:
+		s.CancelledRounds++
+		s.WastedHashes += e.Hashes
+	}
+	s.Hashes += e.Hashes
+	s.Time += e.Duration
+	for len(s.Workers) < len(e.WorkerHashes) {
+		s.Workers = append(s.Workers, WorkerStats{})
+	}
+	for i, h := range e.WorkerHashes {
+		s.Workers[i].Hashes += h
+		s.Workers[i].Time += e.Duration
+	}
+	s.Last = &e
+	listeners := t.listeners
+	t.mutex.Unlock()
+
+	for _, fn := range listeners {
+		fn(e)
+	}
+}
+
+// MiningStats returns the aggregated telemetry of the rounds MineBlocks mined so far.
+func (bch *Blockchain) MiningStats() MiningStats {
+	t := bch.telemetry
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stats := t.stats
+	stats.Workers = append([]WorkerStats(nil), stats.Workers...)
+	if stats.Last != nil {
+		last := *stats.Last
+		last.WorkerHashes = append([]uint64(nil), last.WorkerHashes...)
+		stats.Last = &last
+	}
+	return stats
+}
+
+// OnMiningEvent calls fn after every mining round of MineBlocks, on the mining goroutine.
+func (bch *Blockchain) OnMiningEvent(fn func(MiningEvent)) {
+	t := bch.telemetry
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.listeners = append(t.listeners[:len(t.listeners):len(t.listeners)], fn)
+}
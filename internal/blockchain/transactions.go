@@ -7,8 +7,11 @@ import (
 	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
 	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
 	"github.com/Quikmove/blockchain-uzd2/internal/merkletree"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
+// SignatureHash returns the digest of a legacy signature: it commits to every input,
+// every output and the value and address of the output being spent.
 func SignatureHash(t d.Transaction, value uint32, to []byte, hasher c.Hasher) d.Hash32 {
 	var buf bytes.Buffer
 
@@ -31,6 +34,76 @@ func SignatureHash(t d.Transaction, value uint32, to []byte, hasher c.Hasher) d.
 	return h2
 }
 
+// SignatureHashType returns the digest that input idx of t signs under hashType.
+// d.SigHashLegacy yields SignatureHash. Every other type commits to the hash type
+// itself, so a signature cannot be replayed under a different type or encoding.
+func SignatureHashType(t d.Transaction, idx int, value uint32, to []byte, hashType d.SigHashType, hasher c.Hasher) (d.Hash32, error) {
+	if idx < 0 || idx >= len(t.Inputs) {
+		return d.Hash32{}, d.ErrInvalidTransaction
+	}
+	if hashType == d.SigHashLegacy {
+		return SignatureHash(t, value, to, hasher), nil
+	}
+	if !hashType.IsValid() {
+		return d.Hash32{}, d.ErrInvalidSigHashType
+	}
+
+	var buf bytes.Buffer
+
+	if hashType.AnyoneCanPay() {
+		in := t.Inputs[idx]
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(1))
+		buf.Write(in.Prev.TxID[:])
+		_ = binary.Write(&buf, binary.LittleEndian, in.Prev.Index)
+	} else {
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(t.Inputs)))
+		for _, in := range t.Inputs {
+			buf.Write(in.Prev.TxID[:])
+			_ = binary.Write(&buf, binary.LittleEndian, in.Prev.Index)
+		}
+	}
+
+	var outputs []d.TxOutput
+	switch hashType.Base() {
+	case d.SigHashAll:
+		outputs = t.Outputs
+	case d.SigHashSingle:
+		if idx >= len(t.Outputs) {
+			return d.Hash32{}, d.ErrSigHashSingle
+		}
+		outputs = t.Outputs[idx : idx+1]
+	}
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(outputs)))
+	for _, out := range outputs {
+		buf.Write(out.To[:])
+		_ = binary.Write(&buf, binary.LittleEndian, out.Value)
+	}
+	_ = binary.Write(&buf, binary.LittleEndian, value)
+	buf.Write(to[:])
+
+	// With ANYONECANPAY the input may move when others add theirs, so its position is left out.
+	if !hashType.AnyoneCanPay() {
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(idx))
+	}
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(hashType))
+
+	h1 := hasher.Hash(buf.Bytes())
+	h2 := hasher.Hash(h1[:])
+
+	return h2, nil
+}
+
+// SignInput signs input idx of tx, which spends utxo, and stores the encoded signature on the input.
+func SignInput(tx *d.Transaction, idx int, utxo d.UTXO, hashType d.SigHashType, key *secp256k1.PrivateKey, signer c.TransactionSigner, hasher c.Hasher) error {
+	hashToSign, err := SignatureHashType(*tx, idx, utxo.Value, utxo.To[:], hashType, hasher)
+	if err != nil {
+		return err
+	}
+	sig := signer.SignTransaction(hashToSign[:], key)
+	tx.Inputs[idx].Sig = d.EncodeSignature(sig, hashType)
+	return nil
+}
+
 func merkleRootHash(t Transactions, hasher c.Hasher) d.Hash32 {
 	if len(t) == 0 {
 		return d.Hash32{}
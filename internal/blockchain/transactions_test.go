@@ -262,3 +262,69 @@ func TestTransaction_SerializationRoundTrip(t *testing.T) {
 	}
 }
 
+func TestSignatureHashType(t *testing.T) {
+	hasher := c.NewArchasHasher()
+
+	tx := d.Transaction{
+		Inputs: []d.TxInput{
+			{Prev: d.Outpoint{TxID: d.Hash32{0x01}, Index: 0}},
+			{Prev: d.Outpoint{TxID: d.Hash32{0x02}, Index: 1}},
+		},
+		Outputs: []d.TxOutput{
+			{Value: 100, To: d.PublicAddress{0xCC}},
+			{Value: 50, To: d.PublicAddress{0xDD}},
+		},
+	}
+	value := uint32(200)
+	to := d.PublicAddress{0xEE}
+
+	hashFor := func(tx d.Transaction, idx int, hashType d.SigHashType) d.Hash32 {
+		h, err := SignatureHashType(tx, idx, value, to[:], hashType, hasher)
+		if err != nil {
+			t.Fatalf("SignatureHashType(%v) error = %v", hashType, err)
+		}
+		return h
+	}
+
+	if hashFor(tx, 0, d.SigHashLegacy) != SignatureHash(tx, value, to[:], hasher) {
+		t.Error("Legacy digest should match SignatureHash")
+	}
+	if hashFor(tx, 0, d.SigHashAll) == hashFor(tx, 0, d.SigHashLegacy) {
+		t.Error("SIGHASH_ALL digest should differ from the legacy digest")
+	}
+
+	changedOutputs := tx
+	changedOutputs.Outputs = []d.TxOutput{{Value: 1, To: d.PublicAddress{0x01}}, tx.Outputs[1]}
+	if hashFor(tx, 1, d.SigHashNone) != hashFor(changedOutputs, 1, d.SigHashNone) {
+		t.Error("SIGHASH_NONE should not commit to outputs")
+	}
+	if hashFor(tx, 1, d.SigHashSingle) != hashFor(changedOutputs, 1, d.SigHashSingle) {
+		t.Error("SIGHASH_SINGLE should only commit to the output at the input index")
+	}
+	if hashFor(tx, 0, d.SigHashSingle) == hashFor(changedOutputs, 0, d.SigHashSingle) {
+		t.Error("SIGHASH_SINGLE should commit to the output at the input index")
+	}
+
+	extraInput := tx
+	extraInput.Inputs = append([]d.TxInput{}, tx.Inputs...)
+	extraInput.Inputs = append(extraInput.Inputs, d.TxInput{Prev: d.Outpoint{TxID: d.Hash32{0x03}}})
+	acp := d.SigHashAll | d.SigHashAnyoneCanPay
+	if hashFor(tx, 0, acp) != hashFor(extraInput, 0, acp) {
+		t.Error("ANYONECANPAY should not commit to other inputs")
+	}
+	if hashFor(tx, 0, d.SigHashNone) == hashFor(extraInput, 0, d.SigHashNone) {
+		t.Error("SIGHASH_NONE without ANYONECANPAY should commit to all inputs")
+	}
+
+	if hashFor(tx, 0, d.SigHashNone) == hashFor(tx, 0, d.SigHashNone|d.SigHashAnyoneCanPay) {
+		t.Error("Different sighash types should produce different digests")
+	}
+
+	single := d.Transaction{Inputs: tx.Inputs, Outputs: tx.Outputs[:1]}
+	if _, err := SignatureHashType(single, 1, value, to[:], d.SigHashSingle, hasher); err != d.ErrSigHashSingle {
+		t.Errorf("SIGHASH_SINGLE without matching output error = %v, want %v", err, d.ErrSigHashSingle)
+	}
+	if _, err := SignatureHashType(tx, 0, value, to[:], d.SigHashType(0x04), hasher); err != d.ErrInvalidSigHashType {
+		t.Errorf("Unknown sighash type error = %v, want %v", err, d.ErrInvalidSigHashType)
+	}
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"errors"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// ErrTxIndexDisabled is returned by GetTransaction for a transaction that is not in
+// the mempool when the chain keeps no transaction index.
+var ErrTxIndexDisabled = errors.New("blockchain: transaction index is disabled")
+
+// TxLocation is where a main chain transaction is: its block and its position in the
+// block body.
+type TxLocation struct {
+	BlockHash d.Hash32
+	Height    int
+	Position  int
+}
+
+// TxStatus is a transaction found by GetTransaction.
+type TxStatus struct {
+	Tx d.Transaction
+	// Block is where the transaction was confirmed, or nil while it waits in the mempool.
+	Block *TxLocation
+	// Confirmations is the number of main chain blocks from Block to the tip, or 0
+	// for a mempool transaction.
+	Confirmations int
+}
+
+// txIndex maps the TxID of every main chain transaction to its location. It is
+// guarded by chainMutex and maintained as blocks are connected and disconnected. A nil
+// txIndex is a disabled one.
+type txIndex struct {
+	locations map[d.Hash32]TxLocation
+}
+
+func newTxIndex(enabled bool) *txIndex {
+	if !enabled {
+		return nil
+	}
+	return &txIndex{locations: make(map[d.Hash32]TxLocation)}
+}
+
+func (idx *txIndex) connectBlock(b d.Block, hash d.Hash32, height int) {
+	if idx == nil {
+		return
+	}
+	for i, tx := range b.Body.Transactions {
+		idx.locations[tx.TxID] = TxLocation{BlockHash: hash, Height: height, Position: i}
+	}
+}
+
+func (idx *txIndex) disconnectBlock(b d.Block, hash d.Hash32) {
+	if idx == nil {
+		return
+	}
+	for _, tx := range b.Body.Transactions {
+		if loc, ok := idx.locations[tx.TxID]; ok && loc.BlockHash == hash {
+			delete(idx.locations, tx.TxID)
+		}
+	}
+}
+
+// GetTransaction finds a transaction by TxID on the main chain, which needs the chain
+// to be created WithTxIndex, or in the mempool. It returns d.ErrTxNotFound when the
+// transaction is in neither, and ErrTxIndexDisabled when it is not in the mempool and
+// the chain keeps no index.
+func (bch *Blockchain) GetTransaction(txID d.Hash32) (TxStatus, error) {
+	bch.chainMutex.RLock()
+	defer bch.chainMutex.RUnlock()
+
+	if bch.txIndex != nil {
+		if loc, ok := bch.txIndex.locations[txID]; ok {
+			if loc.Height < bch.firstBody {
+				return TxStatus{}, bch.missingBodyLocked(loc.Height)
+			}
+			return TxStatus{
+				Tx:            bch.blocks[loc.Height].Body.Transactions[loc.Position],
+				Block:         &loc,
+				Confirmations: len(bch.blocks) - loc.Height,
+			}, nil
+		}
+	}
+	if tx, ok := bch.mempool.Get(txID); ok {
+		return TxStatus{Tx: tx}, nil
+	}
+	if bch.txIndex == nil {
+		return TxStatus{}, ErrTxIndexDisabled
+	}
+	return TxStatus{}, d.ErrTxNotFound
+}
@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+func TestGetTransaction_FollowsReorganization(t *testing.T) {
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	users := NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	bch := InitBlockchainWithFunds(100000, 100000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), WithTxIndex())
+	genesis, _ := bch.GetLatestBlock()
+
+	mainTx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	main1 := mineOnParent(t, bch, genesis, mainTx)
+	if _, err := bch.ProcessBlock(main1); err != nil {
+		t.Fatalf("ProcessBlock(main1) error = %v", err)
+	}
+	if st, err := bch.GetTransaction(mainTx.TxID); err != nil || st.Block == nil || st.Block.Height != 1 || st.Confirmations != 1 {
+		t.Fatalf("GetTransaction(mainTx) = %+v, %v; want confirmed at height 1", st, err)
+	}
+
+	sideTx := signedTestTransaction(t, bch, users[2], users[1], 10)
+	side1 := mineOnParent(t, bch, genesis, sideTx)
+	if _, err := bch.ProcessBlock(side1); err != nil {
+		t.Fatalf("ProcessBlock(side1) error = %v", err)
+	}
+	if _, err := bch.GetTransaction(sideTx.TxID); err != d.ErrTxNotFound {
+		t.Errorf("GetTransaction(side branch tx) error = %v, want %v", err, d.ErrTxNotFound)
+	}
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 5, To: users[2].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	side2 := mineOnParent(t, bch, side1, coinbase)
+	if status, err := bch.ProcessBlock(side2); err != nil || status != BlockReorganized {
+		t.Fatalf("ProcessBlock(side2) = %v, %v; want reorganized", status, err)
+	}
+
+	st, err := bch.GetTransaction(sideTx.TxID)
+	if err != nil {
+		t.Fatalf("GetTransaction(sideTx) error = %v", err)
+	}
+	want := TxLocation{BlockHash: bch.CalculateHash(side1), Height: 1, Position: 0}
+	if st.Block == nil || *st.Block != want || st.Confirmations != 2 || st.Tx.TxID != sideTx.TxID {
+		t.Errorf("GetTransaction(sideTx) = %+v, want %+v with 2 confirmations", st, want)
+	}
+	if st, err := bch.GetTransaction(mainTx.TxID); err != nil || st.Block != nil || st.Confirmations != 0 {
+		t.Errorf("GetTransaction(mainTx) after reorg = %+v, %v; want it back in the mempool", st, err)
+	}
+	if st, err := bch.GetTransaction(genesis.Body.Transactions[0].TxID); err != nil || st.Block == nil || st.Block.Height != 0 || st.Confirmations != 3 {
+		t.Errorf("GetTransaction(genesis tx) = %+v, %v; want 3 confirmations", st, err)
+	}
+	if _, err := bch.GetTransaction(d.Hash32{1}); err != d.ErrTxNotFound {
+		t.Errorf("GetTransaction(unknown) error = %v, want %v", err, d.ErrTxNotFound)
+	}
+}
+
+func TestGetTransaction_WithoutIndex(t *testing.T) {
+	bch, users, _ := setupTestBlockchain()
+	genesis, _ := bch.GetLatestBlock()
+	if _, err := bch.GetTransaction(genesis.Body.Transactions[0].TxID); err != ErrTxIndexDisabled {
+		t.Errorf("GetTransaction(confirmed) error = %v, want %v", err, ErrTxIndexDisabled)
+	}
+	tx := signedTestTransaction(t, bch, users[0], users[1], 10)
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	if st, err := bch.GetTransaction(tx.TxID); err != nil || st.Block != nil || st.Tx.TxID != tx.TxID {
+		t.Errorf("GetTransaction(mempool tx) = %+v, %v", st, err)
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"crypto/sha256"
 	"math/rand"
 	"strconv"
-	"time"
 
+	"github.com/Quikmove/blockchain-uzd2/internal/clock"
 	"github.com/Quikmove/blockchain-uzd2/internal/crypto"
 	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
 )
@@ -31,10 +31,13 @@ func GetUserByPublicKey(users []d.User, pk []byte) (d.User, error) {
 
 type UserGeneratorService struct {
 	keyGen crypto.KeyGenerator
+	rng    *rand.Rand
+	clock  clock.Clock
 }
 
-func NewUserGeneratorService(keyGen crypto.KeyGenerator) *UserGeneratorService {
-	return &UserGeneratorService{keyGen: keyGen}
+func NewUserGeneratorService(keyGen crypto.KeyGenerator, opts ...Option) *UserGeneratorService {
+	e := newOptions(opts)
+	return &UserGeneratorService{keyGen: keyGen, rng: e.rng, clock: e.clock}
 }
 
 func (ugs *UserGeneratorService) GenerateUsers(names []string, n int) []d.User {
@@ -47,11 +50,11 @@ func (ugs *UserGeneratorService) GenerateUsers(names []string, n int) []d.User {
 		return []d.User{}
 	}
 	for i := 0; i < n; i++ {
-		name := names[rand.Intn(namesLen)]
+		name := names[ugs.rng.Intn(namesLen)]
 		for usedNames[name] {
-			name = names[rand.Intn(namesLen)]
+			name = names[ugs.rng.Intn(namesLen)]
 		}
-		mneumonicBytes := sha256.Sum256([]byte(strconv.FormatInt(time.Now().UnixNano(), 36)))
+		mneumonicBytes := sha256.Sum256([]byte(strconv.FormatInt(ugs.rng.Int63(), 36)))
 		mneumonicString := string(mneumonicBytes[:])
 		privateKey, publicKey, err := ugs.keyGen.GenerateKeyPair(mneumonicString)
 		if err != nil {
@@ -64,6 +67,7 @@ func (ugs *UserGeneratorService) GenerateUsers(names []string, n int) []d.User {
 			publicKey,
 			privateKey,
 		)
+		user.CreatedAt = uint32(ugs.clock.Now().Unix())
 		id++
 
 		users = append(users, *user)
@@ -1,6 +1,9 @@
 package blockchain
 
 import (
+	"bytes"
+	"maps"
+	"sort"
 	"sync"
 
 	"github.com/Quikmove/blockchain-uzd2/internal/crypto"
@@ -23,6 +26,20 @@ func (t *UTXOTracker) reset() {
 	defer t.UTXOMutex.Unlock()
 	t.utxoSet = make(map[d.Outpoint]d.UTXO)
 }
+
+// clone returns a copy of the UTXO set.
+func (t *UTXOTracker) clone() map[d.Outpoint]d.UTXO {
+	t.UTXOMutex.RLock()
+	defer t.UTXOMutex.RUnlock()
+	return maps.Clone(t.utxoSet)
+}
+
+// load replaces the UTXO set with set.
+func (t *UTXOTracker) load(set map[d.Outpoint]d.UTXO) {
+	t.UTXOMutex.Lock()
+	defer t.UTXOMutex.Unlock()
+	t.utxoSet = set
+}
 func (t *UTXOTracker) ScanBlockchain(bc *Blockchain) {
 	blocks := bc.Blocks()
 	t.reset()
@@ -31,14 +48,24 @@ func (t *UTXOTracker) ScanBlockchain(bc *Blockchain) {
 	}
 }
 func (t *UTXOTracker) ScanBlock(b d.Block, hasher crypto.Hasher) {
+	t.connectBlock(b, hasher)
+}
+
+// connectBlock applies b to the UTXO set and returns the outputs it spent, which
+// disconnectBlock needs to undo the block during a reorganization.
+func (t *UTXOTracker) connectBlock(b d.Block, hasher crypto.Hasher) []d.UTXO {
 	t.UTXOMutex.Lock()
 	defer t.UTXOMutex.Unlock()
 
+	var spent []d.UTXO
 	body := b.Body
 	txs := body.Transactions
 	for _, tx := range txs {
 		if len(tx.Inputs) > 0 {
 			for _, input := range tx.Inputs {
+				if utxo, exists := t.utxoSet[input.Prev]; exists {
+					spent = append(spent, utxo)
+				}
 				delete(t.utxoSet, input.Prev)
 			}
 		}
@@ -58,6 +85,32 @@ func (t *UTXOTracker) ScanBlock(b d.Block, hasher crypto.Hasher) {
 			t.utxoSet[outpoint] = utxo
 		}
 	}
+	return spent
+}
+
+// disconnectBlock reverts connectBlock: it removes the outputs created by b and
+// restores the spent outputs recorded when b was connected.
+func (t *UTXOTracker) disconnectBlock(b d.Block, spent []d.UTXO, hasher crypto.Hasher) {
+	t.UTXOMutex.Lock()
+	defer t.UTXOMutex.Unlock()
+
+	txs := b.Body.Transactions
+	for i := len(txs) - 1; i >= 0; i-- {
+		txHash := hasher.Hash(txs[i].Serialize())
+		for idx := range txs[i].Outputs {
+			delete(t.utxoSet, d.Outpoint{TxID: txHash, Index: uint32(idx)})
+		}
+	}
+	for _, utxo := range spent {
+		t.utxoSet[utxo.Outpoint] = utxo
+	}
+}
+
+// Len returns the number of unspent outputs.
+func (t *UTXOTracker) Len() int {
+	t.UTXOMutex.RLock()
+	defer t.UTXOMutex.RUnlock()
+	return len(t.utxoSet)
 }
 
 func (t *UTXOTracker) GetUTXO(outpoint d.Outpoint) (d.UTXO, bool) {
@@ -77,9 +130,33 @@ func (t *UTXOTracker) GetUTXOsForAddress(address d.PublicAddress) []d.UTXO {
 			utxos = append(utxos, utxo)
 		}
 	}
+	// Map iteration order is random; a fixed order keeps transaction generation reproducible.
+	sort.Slice(utxos, func(i, j int) bool {
+		if cmp := bytes.Compare(utxos[i].Outpoint.TxID[:], utxos[j].Outpoint.TxID[:]); cmp != 0 {
+			return cmp < 0
+		}
+		return utxos[i].Outpoint.Index < utxos[j].Outpoint.Index
+	})
 	return utxos
 }
 
+// Balances returns the balance of every address that owns unspent outputs, together
+// with the number of those outputs.
+func (t *UTXOTracker) Balances() map[d.PublicAddress]AddressBalance {
+	t.UTXOMutex.RLock()
+	defer t.UTXOMutex.RUnlock()
+
+	balances := make(map[d.PublicAddress]AddressBalance)
+	for _, utxo := range t.utxoSet {
+		b := balances[utxo.To]
+		b.Address = utxo.To
+		b.Balance += uint64(utxo.Value)
+		b.UTXOs++
+		balances[utxo.To] = b
+	}
+	return balances
+}
+
 func (t *UTXOTracker) GetBalance(address d.PublicAddress) uint32 {
 	utxos := t.GetUTXOsForAddress(address)
 	var balance uint32
@@ -9,6 +9,80 @@ import (
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
+// RuleError is a validation failure together with the rule that failed. The
+// unexported validators return it so that AddBlock and ProcessBlock can log the rule;
+// the exported ones return the underlying Err, which callers compare against.
+type RuleError struct {
+	// Rule names the failed check, e.g. "merkle-root" or "signature".
+	Rule string
+	// Tx is the index of the offending transaction in its block and Input that of the
+	// offending input, or -1 when the rule does not concern a single one.
+	Tx    int
+	Input int
+	Err   error
+}
+
+func (e *RuleError) Error() string {
+	return e.Rule + ": " + e.Err.Error()
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+func ruleError(rule string, err error) *RuleError {
+	return &RuleError{Rule: rule, Tx: -1, Input: -1, Err: err}
+}
+
+// inputRuleError is a ruleError about input j of a transaction.
+func inputRuleError(rule string, j int, err error) *RuleError {
+	e := ruleError(rule, err)
+	e.Input = j
+	return e
+}
+
+// logRejected logs that b was rejected at height, which is -1 when not yet known,
+// with the rule of err if it is a RuleError.
+func (bch *Blockchain) logRejected(b d.Block, height int, err error) {
+	hash := bch.CalculateHash(b)
+	attrs := []any{
+		"height", height,
+		"hash", hash.String(),
+		"txs", len(b.Body.Transactions),
+	}
+	var re *RuleError
+	if errors.As(err, &re) {
+		attrs = append(attrs, "rule", re.Rule)
+		if re.Tx >= 0 {
+			attrs = append(attrs, "tx", re.Tx)
+		}
+		if re.Input >= 0 {
+			attrs = append(attrs, "input", re.Input)
+		}
+		err = re.Err
+	}
+	attrs = append(attrs, "err", err)
+	bch.logger.Warn("Block rejected", attrs...)
+}
+
+// ruleCause returns the error a RuleError wraps, or err itself.
+func ruleCause(err error) error {
+	var re *RuleError
+	if errors.As(err, &re) {
+		return re.Err
+	}
+	return err
+}
+
+// withTx records in a RuleError which transaction of the block broke the rule.
+func withTx(err error, i int) error {
+	var re *RuleError
+	if errors.As(err, &re) && re.Tx < 0 {
+		re.Tx = i
+	}
+	return err
+}
+
 func (bch *Blockchain) IsBlockValid(newBlock d.Block) bool {
 	bch.chainMutex.RLock()
 	height := len(bch.blocks)
@@ -16,83 +90,98 @@ func (bch *Blockchain) IsBlockValid(newBlock d.Block) bool {
 	if height == 0 {
 		return true
 	}
-	oldBlock, err := bch.GetLatestBlock()
+	oldBlockHash, err := bch.GetLatestBlockHash()
 	if err != nil {
 		panic(err)
 	}
 
-	oldBlockHash := bch.CalculateHash(oldBlock)
 	header := newBlock.Header
 	if oldBlockHash != header.PrevHash {
 		return false
 	}
 
-	diff := header.Difficulty
-	hash := bch.CalculateHash(newBlock)
-
-	return IsHashValid(hash, diff)
+	return bch.CheckProofOfWork(header)
 }
 
 func (bch *Blockchain) ValidateBlock(b d.Block) error {
+	return ruleCause(bch.validateNewBlock(b))
+}
+
+// validateNewBlock performs the checks of ValidateBlock and returns a RuleError.
+func (bch *Blockchain) validateNewBlock(b d.Block) error {
 	bch.chainMutex.RLock()
 	height := len(bch.blocks)
 	bch.chainMutex.RUnlock()
 
-	isGenesis := height == 0
+	if err := bch.validateBlock(b, height == 0); err != nil {
+		return err
+	}
+	minPastTime := uint32(bch.validationClock.Now().Unix()) - maxTimestampDrift
+	if height != 0 && b.Header.Timestamp < minPastTime {
+		return ruleError("timestamp-too-old", d.ErrTimestampTooOld)
+	}
+	return nil
+}
+
+// maxTimestampDrift is how far, in seconds, a block timestamp may lie ahead of
+// the current time or behind the time it is compared against.
+const maxTimestampDrift = 7200
+
+// validateBlock performs the checks of ValidateBlock that do not depend on the UTXO set
+// or on the current time lying close to the block's timestamp, so that it also applies to
+// old blocks downloaded from peers. The caller decides whether b is checked as a genesis block.
+func (bch *Blockchain) validateBlock(b d.Block, isGenesis bool) error {
+	defer observeSince(bch.metrics.blockValidation, time.Now())
 
 	// Validate block has transactions
 	body := b.Body
 	txs := body.Transactions
 	if len(txs) == 0 {
-		return d.ErrInvalidBlock
+		return ruleError("block-empty", d.ErrInvalidBlock)
 	}
 
 	// Validate merkle root
 	computedMerkleRoot := MerkleRootHash(body, bch.hasher)
 	if computedMerkleRoot != b.Header.MerkleRoot {
-		return d.ErrInvalidMerkleRoot
+		return ruleError("merkle-root", d.ErrInvalidMerkleRoot)
 	}
 
 	// Validate block hash meets difficulty (for non-genesis blocks)
 	if !isGenesis {
-		hash := bch.CalculateHash(b)
-		if !IsHashValid(hash, b.Header.Difficulty) {
-			return d.ErrInvalidDifficulty
+		if !bch.CheckProofOfWork(b.Header) {
+			return ruleError("proof-of-work", d.ErrInvalidDifficulty)
 		}
 	}
 
-	currentTime := uint32(time.Now().Unix())
-	maxFutureTime := currentTime + 7200
-	minPastTime := currentTime - 7200
+	// Only the chain's own clock decides, which is the wall clock unless one was
+	// injected, so that a seeded chain accepts the same blocks whenever it runs.
+	maxFutureTime := uint32(bch.validationClock.Now().Unix()) + maxTimestampDrift
 	if b.Header.Timestamp > maxFutureTime {
-		return errors.New("block timestamp too far in future")
-	}
-	if !isGenesis && b.Header.Timestamp < minPastTime {
-		return errors.New("block timestamp too far in past")
+		return ruleError("timestamp-future", errors.New("block timestamp too far in future"))
 	}
 
 	for i, tx := range txs {
 		expectedTxID := bch.hasher.Hash(tx.SerializeWithoutSignatures())
 		if tx.TxID != expectedTxID {
-			return d.ErrInvalidTransaction
+			return withTx(ruleError("txid", d.ErrInvalidTransaction), i)
 		}
 
-		isCoinbase := len(tx.Inputs) == 0
+		isCoinbase := tx.IsCoinbase()
 		if isGenesis {
 			if !isCoinbase {
-				return d.ErrInvalidTransaction
+				return withTx(ruleError("genesis-coinbase-only", d.ErrInvalidTransaction), i)
 			}
 			continue
 		}
 		if isCoinbase {
 			if i != 0 {
-				return d.ErrInvalidTransaction
+				return withTx(ruleError("coinbase-position", d.ErrInvalidTransaction), i)
 			}
 			continue
 		}
 
 		if len(tx.Inputs) == 0 {
-			return d.ErrInvalidTransaction
+			return withTx(ruleError("tx-no-inputs", d.ErrInvalidTransaction), i)
 		}
 	}
 
@@ -104,12 +193,16 @@ func (bch *Blockchain) ValidateBlockTransactions(b d.Block, users []d.User) erro
 	height := len(bch.blocks)
 	bch.chainMutex.RUnlock()
 
-	isGenesis := height == 0
+	return ruleCause(bch.validateBlockTransactions(b, users, height == 0))
+}
+
+func (bch *Blockchain) validateBlockTransactions(b d.Block, users []d.User, isGenesis bool) error {
+	defer observeSince(bch.metrics.txValidation, time.Now())
 
 	body := b.Body
 	txs := body.Transactions
 	if len(txs) == 0 {
-		return d.ErrInvalidBlock
+		return ruleError("block-empty", d.ErrInvalidBlock)
 	}
 
 	spentInBlock := make(map[d.Outpoint]bool)
@@ -120,112 +213,153 @@ func (bch *Blockchain) ValidateBlockTransactions(b d.Block, users []d.User) erro
 	}
 
 	for i, tx := range txs {
-		isCoinbase := len(tx.Inputs) == 0
+		isCoinbase := tx.IsCoinbase()
 
 		if isGenesis && !isCoinbase {
-			return d.ErrInvalidTransaction
+			return withTx(ruleError("genesis-coinbase-only", d.ErrInvalidTransaction), i)
 		}
 
 		if isCoinbase {
-			if i != 0 {
-				return d.ErrInvalidTransaction
+			// Only the first transaction may be a coinbase, so a block has at most one.
+			if i != 0 && !isGenesis {
+				return withTx(ruleError("coinbase-position", d.ErrInvalidTransaction), i)
 			}
 			if len(tx.Outputs) == 0 {
-				return d.ErrInvalidTransaction
+				return withTx(ruleError("coinbase-no-outputs", d.ErrInvalidTransaction), i)
 			}
 			var coinbaseTotal uint32
 			for _, output := range tx.Outputs {
 				if output.Value == 0 {
-					return d.ErrInvalidTransaction
+					return withTx(ruleError("coinbase-zero-output", d.ErrInvalidTransaction), i)
 				}
 				if coinbaseTotal > ^uint32(0)-output.Value {
-					return errors.New("coinbase tx total reward overflow")
+					return withTx(ruleError("coinbase-overflow", errors.New("coinbase tx total reward overflow")), i)
 				}
 				coinbaseTotal += output.Value
 			}
 			continue
 		}
 
-		if len(tx.Inputs) == 0 {
-			return d.ErrInvalidTransaction
+		if err := bch.validateSpend(tx, users, addressToPublicKey, spentInBlock, !isGenesis); err != nil {
+			return withTx(err, i)
 		}
+	}
 
-		if len(tx.Outputs) == 0 {
-			return d.ErrEmptyTransaction
+	return nil
+}
+
+// validateSpend checks a non-coinbase transaction against the UTXO set. Outpoints
+// it spends are recorded in spent so that later transactions cannot reuse them.
+// Failures are RuleErrors.
+func (bch *Blockchain) validateSpend(tx d.Transaction, users []d.User, addressToPublicKey map[d.PublicAddress]d.PublicKey, spent map[d.Outpoint]bool, checkSigs bool) error {
+	if len(tx.Inputs) == 0 {
+		return ruleError("tx-no-inputs", d.ErrInvalidTransaction)
+	}
+
+	if len(tx.Outputs) == 0 {
+		return ruleError("tx-no-outputs", d.ErrEmptyTransaction)
+	}
+
+	var inputSum uint32
+	for j, input := range tx.Inputs {
+		if spent[input.Prev] {
+			return inputRuleError("double-spend", j, d.ErrDoubleSpend)
 		}
 
-		var inputSum uint32
-		for _, input := range tx.Inputs {
-			if spentInBlock[input.Prev] {
-				return d.ErrDoubleSpend
-			}
+		utxo, exists := bch.utxoTracker.GetUTXO(input.Prev)
+		if !exists {
+			return inputRuleError("missing-utxo", j, d.ErrUTXONotFound)
+		}
 
-			utxo, exists := bch.utxoTracker.GetUTXO(input.Prev)
-			if !exists {
-				return d.ErrUTXONotFound
-			}
+		if inputSum > ^uint32(0)-utxo.Value {
+			return inputRuleError("input-overflow", j, d.ErrNoValidNonce)
+		}
+		inputSum += utxo.Value
 
-			if inputSum > ^uint32(0)-utxo.Value {
-				return d.ErrNoValidNonce
+		if checkSigs {
+			if len(input.Sig) == 0 {
+				return inputRuleError("missing-signature", j, errors.New("missing signature for non-genesis transaction"))
 			}
-			inputSum += utxo.Value
-
-			if !isGenesis {
-				if len(input.Sig) == 0 {
-					return errors.New("missing signature for non-genesis transaction")
-				}
 
-				publicKey, hasKey := addressToPublicKey[utxo.To]
-				if !hasKey {
-					for _, user := range users {
-						if user.PublicAddress == utxo.To {
-							publicKey = user.PublicKey
-							hasKey = true
-							break
-						}
+			publicKey, hasKey := addressToPublicKey[utxo.To]
+			if !hasKey {
+				for _, user := range users {
+					if user.PublicAddress == utxo.To {
+						publicKey = user.PublicKey
+						hasKey = true
+						break
 					}
 				}
+			}
 
-				if !hasKey {
-					return d.ErrInvalidPublicKey
-				}
+			if !hasKey {
+				return inputRuleError("unknown-public-key", j, d.ErrInvalidPublicKey)
+			}
 
-				expectedAddress := c.GenerateAddress(publicKey[:])
-				if utxo.To != expectedAddress {
-					return d.ErrInvalidPublicKey
-				}
+			expectedAddress := c.GenerateAddress(publicKey[:])
+			if utxo.To != expectedAddress {
+				return inputRuleError("public-key-address", j, d.ErrInvalidPublicKey)
+			}
 
-				hashToVerify := SignatureHash(tx, utxo.Value, utxo.To[:], bch.hasher)
+			der, hashType, err := d.DecodeSignature(input.Sig)
+			if err != nil {
+				return inputRuleError("signature-encoding", j, err)
+			}
 
-				publicKeyObj, err := secp256k1.ParsePubKey(publicKey[:])
-				if err != nil {
-					return d.ErrInvalidPublicKey
-				}
+			hashToVerify, err := SignatureHashType(tx, j, utxo.Value, utxo.To[:], hashType, bch.hasher)
+			if err != nil {
+				return inputRuleError("sighash", j, err)
+			}
 
-				if !bch.txSigner.VerifySignature(hashToVerify[:], input.Sig, publicKeyObj) {
-					return d.ErrInvalidSignature
-				}
+			publicKeyObj, err := secp256k1.ParsePubKey(publicKey[:])
+			if err != nil {
+				return inputRuleError("public-key-encoding", j, d.ErrInvalidPublicKey)
 			}
 
-			spentInBlock[input.Prev] = true
+			if !bch.txSigner.VerifySignature(hashToVerify[:], der, publicKeyObj) {
+				bch.metrics.invalidSigs.Inc()
+				return inputRuleError("signature", j, d.ErrInvalidSignature)
+			}
+			bch.metrics.validSigs.Inc()
 		}
 
-		var outputSum uint32
-		for _, output := range tx.Outputs {
-			if output.Value == 0 {
-				return errors.New("zero-value output not allowed")
-			}
+		spent[input.Prev] = true
+	}
 
-			if outputSum > ^uint32(0)-output.Value {
-				return errors.New("output sum overflow")
-			}
-			outputSum += output.Value
+	var outputSum uint32
+	for _, output := range tx.Outputs {
+		if output.Value == 0 {
+			return ruleError("zero-output", errors.New("zero-value output not allowed"))
 		}
 
-		if inputSum < outputSum {
-			return d.ErrInsufficientFunds
+		if outputSum > ^uint32(0)-output.Value {
+			return ruleError("output-overflow", errors.New("output sum overflow"))
 		}
+		outputSum += output.Value
+	}
+
+	if inputSum < outputSum {
+		return ruleError("insufficient-funds", d.ErrInsufficientFunds)
 	}
 
 	return nil
 }
+
+// ValidateTransaction checks a standalone transaction against the current UTXO set,
+// as done for transactions submitted to the mempool.
+func (bch *Blockchain) ValidateTransaction(tx d.Transaction) error {
+	if tx.IsCoinbase() {
+		return d.ErrInvalidTransaction
+	}
+	if tx.TxID != bch.hasher.Hash(tx.SerializeWithoutSignatures()) {
+		return d.ErrInvalidTransaction
+	}
+
+	users := bch.getUsersFromRegistry()
+	addressToPublicKey := make(map[d.PublicAddress]d.PublicKey, len(users))
+	for _, user := range users {
+		addressToPublicKey[user.PublicAddress] = user.PublicKey
+	}
+
+	return ruleCause(bch.validateSpend(tx, users, addressToPublicKey, make(map[d.Outpoint]bool), true))
+}
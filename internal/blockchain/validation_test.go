@@ -184,6 +184,16 @@ func TestValidateBlock_ValidGenesisBlock(t *testing.T) {
 	}
 }
 
+func TestValidateBlockTransactions_GenesisFundsSeveralUsers(t *testing.T) {
+	source, _, _ := setupTestBlockchain()
+	genesis, _ := source.GetBlock(0)
+
+	bch := NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner())
+	if err := bch.ValidateBlockTransactions(genesis, nil); err != nil {
+		t.Errorf("Genesis block with one funding transaction per user should be valid: %v", err)
+	}
+}
+
 func TestValidateBlockTransactions_DoubleSpend(t *testing.T) {
 	bch, users, cfg := setupTestBlockchain()
 	hasher := c.NewArchasHasher()
@@ -462,3 +472,238 @@ func TestValidateBlockTransactions_ZeroValueOutput(t *testing.T) {
 	}
 }
 
+
+func TestValidateBlockTransactions_SigHashAnyoneCanPay(t *testing.T) {
+	bch, users, cfg := setupTestBlockchain()
+	hasher := c.NewArchasHasher()
+	txSigner := c.NewTransactionSigner()
+
+	utxoA := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	utxoB := bch.GetUTXOsForAddress(users[1].PublicAddress)[0]
+	goal := d.TxOutput{Value: utxoA.Value + utxoB.Value, To: users[2].PublicAddress}
+
+	// First pledge signs before the second input exists
+	tx := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: utxoA.Outpoint}},
+		Outputs: []d.TxOutput{goal},
+	}
+	if err := SignInput(&tx, 0, utxoA, d.SigHashAll|d.SigHashAnyoneCanPay, users[0].GetPrivateKeyObject(), txSigner, hasher); err != nil {
+		t.Fatalf("SignInput() error = %v", err)
+	}
+
+	// Second pledge is appended later without invalidating the first signature
+	tx.Inputs = append(tx.Inputs, d.TxInput{Prev: utxoB.Outpoint})
+	if err := SignInput(&tx, 1, utxoB, d.SigHashAll|d.SigHashAnyoneCanPay, users[1].GetPrivateKeyObject(), txSigner, hasher); err != nil {
+		t.Fatalf("SignInput() error = %v", err)
+	}
+	tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+
+	body := d.Body{Transactions: []d.Transaction{tx}}
+	block := d.Block{
+		Header: d.Header{
+			Version:    cfg.Version,
+			Timestamp:  uint32(time.Now().Unix()),
+			MerkleRoot: MerkleRootHash(body, hasher),
+			Difficulty: cfg.Difficulty,
+		},
+		Body: body,
+	}
+
+	if err := bch.ValidateBlockTransactions(block, users); err != nil {
+		t.Errorf("Unexpected error for ANYONECANPAY pledges: %v", err)
+	}
+}
+
+func TestValidateBlockTransactions_SigHashOutputCommitment(t *testing.T) {
+	bch, users, cfg := setupTestBlockchain()
+	hasher := c.NewArchasHasher()
+	txSigner := c.NewTransactionSigner()
+
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+
+	tests := []struct {
+		name      string
+		hashType  d.SigHashType
+		expectErr error
+	}{
+		{"ALL rejects changed outputs", d.SigHashAll, d.ErrInvalidSignature},
+		{"NONE accepts changed outputs", d.SigHashNone, nil},
+		{"SINGLE rejects changed matching output", d.SigHashSingle, d.ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := d.Transaction{
+				Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+				Outputs: []d.TxOutput{{Value: utxo.Value, To: users[1].PublicAddress}},
+			}
+			if err := SignInput(&tx, 0, utxo, tt.hashType, users[0].GetPrivateKeyObject(), txSigner, hasher); err != nil {
+				t.Fatalf("SignInput() error = %v", err)
+			}
+
+			// Redirect the payment after signing
+			tx.Outputs[0].To = users[2].PublicAddress
+			tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+
+			body := d.Body{Transactions: []d.Transaction{tx}}
+			block := d.Block{
+				Header: d.Header{
+					Version:    cfg.Version,
+					Timestamp:  uint32(time.Now().Unix()),
+					MerkleRoot: MerkleRootHash(body, hasher),
+					Difficulty: cfg.Difficulty,
+				},
+				Body: body,
+			}
+
+			err := bch.ValidateBlockTransactions(block, users)
+			if err != tt.expectErr {
+				t.Errorf("ValidateBlockTransactions() error = %v, want %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestValidateBlockTransactions_TamperedSigHashType(t *testing.T) {
+	bch, users, cfg := setupTestBlockchain()
+	hasher := c.NewArchasHasher()
+	txSigner := c.NewTransactionSigner()
+
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	tx := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+		Outputs: []d.TxOutput{{Value: utxo.Value, To: users[1].PublicAddress}},
+	}
+	if err := SignInput(&tx, 0, utxo, d.SigHashAll, users[0].GetPrivateKeyObject(), txSigner, hasher); err != nil {
+		t.Fatalf("SignInput() error = %v", err)
+	}
+	tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+
+	// Relabel the ALL signature as NONE
+	sig := tx.Inputs[0].Sig
+	sig[len(sig)-1] = byte(d.SigHashNone)
+
+	body := d.Body{Transactions: []d.Transaction{tx}}
+	block := d.Block{
+		Header: d.Header{
+			Version:    cfg.Version,
+			Timestamp:  uint32(time.Now().Unix()),
+			MerkleRoot: MerkleRootHash(body, hasher),
+			Difficulty: cfg.Difficulty,
+		},
+		Body: body,
+	}
+
+	if err := bch.ValidateBlockTransactions(block, users); err != d.ErrInvalidSignature {
+		t.Errorf("ValidateBlockTransactions() error = %v, want %v", err, d.ErrInvalidSignature)
+	}
+}
+
+func TestValidateBlockTransactions_SignatureEncoding(t *testing.T) {
+	bch, users, cfg := setupTestBlockchain()
+	hasher := c.NewArchasHasher()
+	txSigner := c.NewTransactionSigner()
+
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	signed := func(hashType d.SigHashType) d.Transaction {
+		tx := d.Transaction{
+			Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+			Outputs: []d.TxOutput{{Value: utxo.Value, To: users[1].PublicAddress}},
+		}
+		if err := SignInput(&tx, 0, utxo, hashType, users[0].GetPrivateKeyObject(), txSigner, hasher); err != nil {
+			t.Fatalf("SignInput() error = %v", err)
+		}
+		tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+		return tx
+	}
+	legacy := signed(d.SigHashLegacy)
+	all := signed(d.SigHashAll)
+
+	// A signature is valid in one encoding only: a legacy one with a type byte
+	// appended and an ALL one without its type byte are both rejected.
+	legacyAsAll := legacy
+	legacyAsAll.Inputs = []d.TxInput{{Prev: utxo.Outpoint, Sig: d.EncodeSignature(legacy.Inputs[0].Sig, d.SigHashAll)}}
+	allAsLegacy := all
+	allAsLegacy.Inputs = []d.TxInput{{Prev: utxo.Outpoint, Sig: all.Inputs[0].Sig[:len(all.Inputs[0].Sig)-1]}}
+
+	tests := []struct {
+		name      string
+		tx        d.Transaction
+		expectErr error
+	}{
+		{"legacy", legacy, nil},
+		{"ALL", all, nil},
+		{"legacy with type byte", legacyAsAll, d.ErrInvalidSignature},
+		{"ALL without type byte", allAsLegacy, d.ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := d.Body{Transactions: []d.Transaction{tt.tx}}
+			block := d.Block{
+				Header: d.Header{
+					Version:    cfg.Version,
+					Timestamp:  uint32(time.Now().Unix()),
+					MerkleRoot: MerkleRootHash(body, hasher),
+					Difficulty: cfg.Difficulty,
+				},
+				Body: body,
+			}
+			if err := bch.ValidateBlockTransactions(block, users); err != tt.expectErr {
+				t.Errorf("ValidateBlockTransactions() error = %v, want %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestValidateBlockTransactions_CoinbasePosition(t *testing.T) {
+	bch, users, cfg := setupTestBlockchain()
+	hasher := c.NewArchasHasher()
+	txSigner := c.NewTransactionSigner()
+
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	spend := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+		Outputs: []d.TxOutput{{Value: utxo.Value, To: users[1].PublicAddress}},
+	}
+	if err := SignInput(&spend, 0, utxo, d.SigHashAll, users[0].GetPrivateKeyObject(), txSigner, hasher); err != nil {
+		t.Fatalf("SignInput() error = %v", err)
+	}
+	spend.TxID = hasher.Hash(spend.SerializeWithoutSignatures())
+	coinbase := func(extraNonce uint32) d.Transaction {
+		tx := d.NewCoinbase(uint32(bch.Len()), extraNonce, []d.TxOutput{{Value: 50, To: users[2].PublicAddress}})
+		tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+		return *tx
+	}
+	// An input naming CoinbaseIndex but carrying a signature is not a coinbase input,
+	// so it is checked as a spend of an output that does not exist.
+	signedCoinbase := coinbase(0)
+	signedCoinbase.Inputs = []d.TxInput{{Prev: signedCoinbase.Inputs[0].Prev, Sig: spend.Inputs[0].Sig}}
+
+	tests := []struct {
+		name      string
+		txs       []d.Transaction
+		expectErr error
+	}{
+		{"coinbase first", []d.Transaction{coinbase(0), spend}, nil},
+		{"coinbase after a spend", []d.Transaction{spend, coinbase(0)}, d.ErrInvalidTransaction},
+		{"two coinbases", []d.Transaction{coinbase(0), coinbase(1)}, d.ErrInvalidTransaction},
+		{"signed coinbase input", []d.Transaction{signedCoinbase}, d.ErrUTXONotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := d.Body{Transactions: tt.txs}
+			block := d.Block{
+				Header: d.Header{
+					Version:    cfg.Version,
+					Timestamp:  uint32(time.Now().Unix()),
+					MerkleRoot: MerkleRootHash(body, hasher),
+					Difficulty: cfg.Difficulty,
+				},
+				Body: body,
+			}
+			if err := bch.ValidateBlockTransactions(block, users); err != tt.expectErr {
+				t.Errorf("ValidateBlockTransactions() error = %v, want %v", err, tt.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package clock abstracts the current time, so that code which stamps blocks can
+// run against a deterministic clock and produce the same chain on every run.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Epoch is where deterministic runs start their clocks.
+var Epoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// System is the wall clock.
+var System Clock = systemClock{}
+
+// StepClock is a deterministic clock: every call to Now returns a time step later
+// than the previous call, no matter how much real time has passed.
+type StepClock struct {
+	now   time.Time
+	step  time.Duration
+	mutex *sync.Mutex
+}
+
+func NewStepClock(start time.Time, step time.Duration) *StepClock {
+	return &StepClock{
+		now:   start,
+		step:  step,
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (c *StepClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now
+}
+
+// Peek returns the time the next call to Now will return, without advancing the clock.
+func (c *StepClock) Peek() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Func adapts a function to the Clock interface.
+type Func func() time.Time
+
+func (f Func) Now() time.Time {
+	return f()
+}
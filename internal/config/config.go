@@ -4,28 +4,41 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/domain"
 )
 
 type Config struct {
 	Version      uint32
 	Difficulty   uint32
 	Port         string
+	P2PPort      string
 	NameListPath string
 	UserCount    int
+	BlockReward  uint32
 }
 
 func LoadConfig() *Config {
 	version := os.Getenv("BLOCK_VERSION")
 	difficulty := os.Getenv("BLOCK_DIFFICULTY")
 	userCount := os.Getenv("USER_COUNT")
+	blockReward := os.Getenv("BLOCK_REWARD")
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
+	p2pPort := os.Getenv("P2P_PORT")
+	if p2pPort == "" {
+		p2pPort = "9333"
+	}
 	parsedVersion, err := strconv.ParseUint(version, 10, 32)
 	if err != nil {
 		parsedVersion = 1
 	}
+	// POW_ALGORITHM names the proof-of-work algorithm; the header version records it.
+	if powVersion, ok := domain.PoWVersion(os.Getenv("POW_ALGORITHM")); ok {
+		parsedVersion = uint64(powVersion)
+	}
 	parsedUsers, err := strconv.Atoi(userCount)
 	if err != nil || parsedUsers < 0 {
 		parsedUsers = 100
@@ -34,11 +47,17 @@ func LoadConfig() *Config {
 	if err != nil {
 		parsedDifficulty = 3
 	}
+	parsedReward, err := strconv.ParseUint(blockReward, 10, 32)
+	if err != nil {
+		parsedReward = 50
+	}
 	cfg := &Config{
-		Version:    uint32(parsedVersion),
-		Difficulty: uint32(parsedDifficulty),
-		Port:       port,
-		UserCount:  parsedUsers,
+		Version:     uint32(parsedVersion),
+		Difficulty:  uint32(parsedDifficulty),
+		Port:        port,
+		P2PPort:     p2pPort,
+		UserCount:   parsedUsers,
+		BlockReward: uint32(parsedReward),
 	}
 	if root, err := findModuleRoot(); err == nil {
 		cfg.NameListPath = filepath.Join(root, "assets", "name_list.txt")
@@ -106,21 +106,49 @@ func (h *ArchasHasher) collapse(bytes []byte, collapseSize int) {
 // Hash computes the Archas hash of the input data
 func (h *ArchasHasher) Hash(data []byte) [32]byte {
 	block := []byte(constant)
+	h.absorb(block, data, 0)
+	return h.finish(block)
+}
+
+// HashPrefix absorbs prefix into a copy of the initial state. Every input byte only
+// mixes into the state at its own position, so the rest of the input continues from there.
+func (h *ArchasHasher) HashPrefix(prefix []byte) Midstate {
+	block := []byte(constant)
+	h.absorb(block, prefix, 0)
+	return &archasMidstate{hasher: h, block: block, offset: len(prefix)}
+}
+
+type archasMidstate struct {
+	hasher *ArchasHasher
+	block  []byte
+	offset int
+}
 
-	if len(data) > 0 {
-		for i, d := range data {
-			idx := i % len(block)
+func (m *archasMidstate) Hash(suffix []byte) [32]byte {
+	block := make([]byte, len(m.block))
+	copy(block, m.block)
+	m.hasher.absorb(block, suffix, m.offset)
+	return m.hasher.finish(block)
+}
 
-			block[idx] ^= d
+// absorb mixes data, which starts at position offset of the whole input, into block.
+func (h *ArchasHasher) absorb(block, data []byte, offset int) {
+	for j, d := range data {
+		i := offset + j
+		idx := i % len(block)
 
-			nonlinearIdx := (idx*139 + 13) % len(block)
-			block[idx] ^= h.rotateLeft8(block[nonlinearIdx], byte(i))
+		block[idx] ^= d
 
-			rotAmt := byte((i * 13) ^ int(block[nonlinearIdx]))
-			block[(idx+11)%len(block)] ^= h.rotateLeft8(d+byte(i), rotAmt)
-		}
+		nonlinearIdx := (idx*139 + 13) % len(block)
+		block[idx] ^= h.rotateLeft8(block[nonlinearIdx], byte(i))
+
+		rotAmt := byte((i * 13) ^ int(block[nonlinearIdx]))
+		block[(idx+11)%len(block)] ^= h.rotateLeft8(d+byte(i), rotAmt)
 	}
+}
 
+// finish turns the absorbed state into the hash.
+func (h *ArchasHasher) finish(block []byte) [32]byte {
 	for i := 0; i < len(block)-1; i++ {
 		block[i] ^= archasKey[i%len(archasKey)]
 
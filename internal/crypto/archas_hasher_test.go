@@ -112,3 +112,19 @@ func TestHasherComparison(t *testing.T) {
 		t.Logf("| %-9s | %-64s | %-64s |", input, archasHash, sha256Hash)
 	}
 }
+
+func TestHashPrefix_MatchesHash(t *testing.T) {
+	data := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 4))
+	for _, hasher := range []PrefixHasher{NewArchasHasher(), NewSHA256Hasher()} {
+		for split := 0; split <= len(data); split += 7 {
+			midstate := hasher.HashPrefix(data[:split])
+			if got, want := midstate.Hash(data[split:]), hasher.Hash(data); got != want {
+				t.Fatalf("%T: HashPrefix(%d bytes).Hash() = %x, want %x", hasher, split, got, want)
+			}
+			// The midstate is reusable for other suffixes.
+			if got, want := midstate.Hash([]byte("x")), hasher.Hash(append(data[:split:split], 'x')); got != want {
+				t.Fatalf("%T: reused midstate = %x, want %x", hasher, got, want)
+			}
+		}
+	}
+}
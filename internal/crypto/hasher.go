@@ -4,3 +4,17 @@ package crypto
 type Hasher interface {
 	Hash(data []byte) [32]byte
 }
+
+// PrefixHasher is a Hasher that can save its state after a prefix, so that hashing
+// many inputs that share the prefix only costs hashing what follows it.
+type PrefixHasher interface {
+	Hasher
+	// HashPrefix absorbs prefix and returns the saved state.
+	HashPrefix(prefix []byte) Midstate
+}
+
+// Midstate is the state of a PrefixHasher after a prefix. It is safe for concurrent use.
+type Midstate interface {
+	// Hash returns the hash of the prefix followed by suffix.
+	Hash(suffix []byte) [32]byte
+}
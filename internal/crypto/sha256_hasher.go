@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto/sha256"
+	"encoding"
 )
 
 // SHA256Hasher implements the Hasher interface using SHA256
@@ -17,3 +18,28 @@ func (h *SHA256Hasher) Hash(data []byte) [32]byte {
 	hash := sha256.Sum256(data)
 	return hash
 }
+
+// HashPrefix saves the SHA256 state after prefix; the complete 64-byte blocks of the
+// prefix are compressed only once.
+func (h *SHA256Hasher) HashPrefix(prefix []byte) Midstate {
+	digest := sha256.New()
+	digest.Write(prefix)
+	state, err := digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	return sha256Midstate(state)
+}
+
+type sha256Midstate []byte
+
+func (m sha256Midstate) Hash(suffix []byte) [32]byte {
+	digest := sha256.New()
+	if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(m); err != nil {
+		panic(err)
+	}
+	digest.Write(suffix)
+	var hash [32]byte
+	digest.Sum(hash[:0])
+	return hash
+}
@@ -3,6 +3,7 @@ package domain
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 )
 
 // Header represents a block header.
@@ -30,6 +31,35 @@ func (h Header) Serialize() []byte {
 	return buf.Bytes()
 }
 
+// SerializePrefix returns the serialized header up to, but not including, the nonce,
+// which Serialize writes last. A miner hashes the prefix once and only the nonce per attempt.
+func (h Header) SerializePrefix() []byte {
+	serialized := h.Serialize()
+	return serialized[:len(serialized)-4]
+}
+
+// Header versions select the proof-of-work algorithm a block is sealed with.
+// Versions without an algorithm of their own use VersionHashPoW.
+const (
+	VersionHashPoW     uint32 = 1
+	VersionScryptPoW   uint32 = 2
+	VersionArgon2idPoW uint32 = 3
+)
+
+// PoWVersion returns the header version of the proof-of-work algorithm called name:
+// "hash", "scrypt" or "argon2id".
+func PoWVersion(name string) (uint32, bool) {
+	switch name {
+	case "hash":
+		return VersionHashPoW, true
+	case "scrypt":
+		return VersionScryptPoW, true
+	case "argon2id":
+		return VersionArgon2idPoW, true
+	}
+	return 0, false
+}
+
 // NewHeader creates a new block header
 func NewHeader(version, timestamp uint32, prevHash, merkleRoot Hash32, difficulty, nonce uint32) *Header {
 	return &Header{
@@ -68,3 +98,55 @@ func NewBlock(header Header, body Body) *Block {
 		Body:   body,
 	}
 }
+
+// Serialize encodes the block as its header followed by the transaction count and
+// every transaction in the format of Transaction.Serialize.
+func (b Block) Serialize() []byte {
+	var buf bytes.Buffer
+	buf.Write(b.Header.Serialize())
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(b.Body.Transactions)))
+	for _, tx := range b.Body.Transactions {
+		buf.Write(tx.Serialize())
+	}
+	return buf.Bytes()
+}
+
+// DeserializeHeader reads a header in the format produced by Header.Serialize.
+func DeserializeHeader(r io.Reader) (Header, error) {
+	var h Header
+	fields := []any{&h.Version, &h.PrevHash, &h.MerkleRoot, &h.Timestamp, &h.Difficulty, &h.Nonce}
+	for _, field := range fields {
+		if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+			return Header{}, err
+		}
+	}
+	return h, nil
+}
+
+// DeserializeBlock reads a block in the format produced by Block.Serialize.
+// Transaction IDs are left zero for the caller to compute.
+func DeserializeBlock(r io.Reader) (Block, error) {
+	header, err := DeserializeHeader(r)
+	if err != nil {
+		return Block{}, err
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return Block{}, err
+	}
+	if count > maxSerializedItems {
+		return Block{}, ErrInvalidBlock
+	}
+	b := Block{Header: header}
+	if count > 0 {
+		b.Body.Transactions = make([]Transaction, count)
+	}
+	for i := range b.Body.Transactions {
+		tx, err := DeserializeTransaction(r)
+		if err != nil {
+			return Block{}, err
+		}
+		b.Body.Transactions[i] = tx
+	}
+	return b, nil
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDeserializeBlock_RoundTrip(t *testing.T) {
+	b := Block{
+		Header: Header{
+			Version:    1,
+			Timestamp:  1700000000,
+			PrevHash:   Hash32{0x01},
+			MerkleRoot: Hash32{0x02},
+			Difficulty: 3,
+			Nonce:      42,
+		},
+		Body: Body{Transactions: []Transaction{
+			{Outputs: []TxOutput{{Value: 50, To: PublicAddress{0xAA}}}},
+			{
+				Inputs:  []TxInput{{Prev: Outpoint{TxID: Hash32{0x03}, Index: 1}, Sig: []byte{0x30, 0x00}}},
+				Outputs: []TxOutput{{Value: 20, To: PublicAddress{0xBB}}},
+			},
+		}},
+	}
+
+	got, err := DeserializeBlock(bytes.NewReader(b.Serialize()))
+	if err != nil {
+		t.Fatalf("DeserializeBlock() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("DeserializeBlock() = %+v, want %+v", got, b)
+	}
+	if !bytes.Equal(got.Header.Serialize(), b.Header.Serialize()) {
+		t.Error("Header serialization changed after round trip")
+	}
+
+	if _, err := DeserializeBlock(bytes.NewReader(b.Serialize()[:90])); err == nil {
+		t.Error("Expected error for truncated block")
+	}
+}
@@ -10,6 +10,11 @@ var (
 	ErrBlockNotFound        = errors.New("block not found")
 	ErrBlockIndexOutOfRange = errors.New("block index out of range")
 	ErrEmptyBlockchain      = errors.New("blockchain is empty")
+	ErrOrphanBlock          = errors.New("block parent not found")
+	ErrDuplicateBlock       = errors.New("block already known")
+	ErrTimestampTooOld      = errors.New("block timestamp too far in past")
+	ErrBlockDataUnavailable = errors.New("block data is not available")
+	ErrBlockPruned          = errors.New("block pruned")
 
 	ErrInvalidTransaction = errors.New("invalid transaction")
 	ErrInsufficientFunds  = errors.New("insufficient funds")
@@ -17,12 +22,17 @@ var (
 	ErrDoubleSpend        = errors.New("double spend detected")
 	ErrInvalidSignature   = errors.New("invalid signature")
 	ErrEmptyTransaction   = errors.New("transaction has no outputs")
+	ErrInvalidSigHashType = errors.New("invalid sighash type")
+	ErrSigHashSingle      = errors.New("sighash single input has no matching output")
+	ErrTxInMempool        = errors.New("transaction already in mempool")
+	ErrTxNotFound         = errors.New("transaction not found")
 
 	ErrUserNotFound     = errors.New("user not found")
 	ErrInvalidPublicKey = errors.New("invalid public key")
 
 	ErrInvalidHashLength          = errors.New("invalid hash length")
 	ErrInvalidPublicAddressLength = errors.New("invalid public address length")
+	ErrInvalidPublicKeyLength     = errors.New("invalid public key length")
 
 	ErrMiningCanceled = errors.New("mining operation canceled")
 	ErrNoValidNonce   = errors.New("no valid nonce found")
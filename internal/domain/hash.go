@@ -47,6 +47,15 @@ func (h *Hash32) Equals(other *Hash32) bool {
 	return *h == *other
 }
 
+// ParseHash32 decodes a 64 character hex string into a Hash32.
+func ParseHash32(s string) (Hash32, error) {
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return Hash32{}, err
+	}
+	return BytesToHash32(decoded)
+}
+
 type PrivateKey [32]byte
 
 type PublicKey [33]byte
@@ -55,8 +64,51 @@ func (p PublicKey) MarshalJSON() ([]byte, error) {
 	return json.Marshal(hex.EncodeToString(p[:]))
 }
 
+func (p *PublicKey) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(decoded) != len(p) {
+		return ErrInvalidPublicKeyLength
+	}
+	copy(p[:], decoded)
+	return nil
+}
+
 type PublicAddress [20]byte
 
+// ParsePublicAddress decodes a 40 character hex string into a PublicAddress.
+func ParsePublicAddress(s string) (PublicAddress, error) {
+	var pa PublicAddress
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return pa, err
+	}
+	if len(decoded) != len(pa) {
+		return pa, ErrInvalidPublicAddressLength
+	}
+	copy(pa[:], decoded)
+	return pa, nil
+}
+
 func (pa PublicAddress) MarshalJSON() ([]byte, error) {
 	return json.Marshal(hex.EncodeToString(pa[:]))
 }
+
+func (pa *PublicAddress) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParsePublicAddress(s)
+	if err != nil {
+		return err
+	}
+	*pa = parsed
+	return nil
+}
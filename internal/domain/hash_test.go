@@ -129,3 +129,38 @@ func TestHash32IsZero(t *testing.T) {
 		t.Error("Non-zero hash should return false for IsZero()")
 	}
 }
+
+func TestKeyAndAddressJSON(t *testing.T) {
+	type keys struct {
+		Key     PublicKey     `json:"key"`
+		Address PublicAddress `json:"address"`
+	}
+	var want keys
+	for i := range want.Key {
+		want.Key[i] = byte(i + 1)
+	}
+	for i := range want.Address {
+		want.Address[i] = byte(0xa0 + i)
+	}
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var got keys
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"key":"0102"}`), &got); err != ErrInvalidPublicKeyLength {
+		t.Errorf("short key error = %v, want %v", err, ErrInvalidPublicKeyLength)
+	}
+	if err := json.Unmarshal([]byte(`{"address":"a0a1"}`), &got); err != ErrInvalidPublicAddressLength {
+		t.Errorf("short address error = %v, want %v", err, ErrInvalidPublicAddressLength)
+	}
+	if err := json.Unmarshal([]byte(`{"address":"zz"}`), &got); err == nil {
+		t.Error("non-hex address decoded without error")
+	}
+}
@@ -0,0 +1,122 @@
+package domain
+
+import "strings"
+
+// SigHashType selects which parts of a transaction an input signature commits to.
+// The type is carried as the last byte of every input signature.
+type SigHashType uint8
+
+const (
+	// SigHashAll commits to every input and every output.
+	SigHashAll SigHashType = 0x01
+	// SigHashNone commits to every input and none of the outputs.
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle commits to every input and only the output with the same index as the signed input.
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay is a modifier that restricts the input commitment to the signed input only.
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	// SigHashLegacy marks a bare DER signature, as made before sighash types existed.
+	// It commits to every input and output like SigHashAll, but over the older digest
+	// that does not include the type, and it is never written with a type byte.
+	SigHashLegacy SigHashType = 0x00
+
+	sigHashBaseMask SigHashType = 0x1f
+)
+
+// Base returns the hash type without the ANYONECANPAY modifier.
+func (t SigHashType) Base() SigHashType {
+	return t & sigHashBaseMask
+}
+
+// AnyoneCanPay reports whether the ANYONECANPAY modifier is set.
+func (t SigHashType) AnyoneCanPay() bool {
+	return t&SigHashAnyoneCanPay != 0
+}
+
+// IsValid reports whether t is one of the supported base types, optionally combined with ANYONECANPAY.
+func (t SigHashType) IsValid() bool {
+	if t&^(sigHashBaseMask|SigHashAnyoneCanPay) != 0 {
+		return false
+	}
+	switch t.Base() {
+	case SigHashAll, SigHashNone, SigHashSingle:
+		return true
+	}
+	return false
+}
+
+func (t SigHashType) String() string {
+	var base string
+	switch t.Base() {
+	case SigHashAll:
+		base = "ALL"
+	case SigHashNone:
+		base = "NONE"
+	case SigHashSingle:
+		base = "SINGLE"
+	default:
+		return "UNKNOWN"
+	}
+	if t.AnyoneCanPay() {
+		return base + "|ANYONECANPAY"
+	}
+	return base
+}
+
+// ParseSigHashType parses names such as "ALL", "none" or "SINGLE|ANYONECANPAY".
+func ParseSigHashType(s string) (SigHashType, error) {
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(s)), "|")
+	var t SigHashType
+	switch parts[0] {
+	case "ALL":
+		t = SigHashAll
+	case "NONE":
+		t = SigHashNone
+	case "SINGLE":
+		t = SigHashSingle
+	default:
+		return 0, ErrInvalidSigHashType
+	}
+	if len(parts) == 2 && parts[1] == "ANYONECANPAY" {
+		return t | SigHashAnyoneCanPay, nil
+	}
+	if len(parts) != 1 {
+		return 0, ErrInvalidSigHashType
+	}
+	return t, nil
+}
+
+// EncodeSignature appends the sighash type to a DER encoded signature. A
+// SigHashLegacy signature stays bare DER.
+func EncodeSignature(der []byte, t SigHashType) []byte {
+	sig := make([]byte, 0, len(der)+1)
+	sig = append(sig, der...)
+	if t == SigHashLegacy {
+		return sig
+	}
+	return append(sig, byte(t))
+}
+
+// DecodeSignature splits an input signature into its DER encoding and sighash type.
+// A bare DER signature without the trailing type byte is SigHashLegacy, which keeps
+// signatures made before sighash types existed valid. Since a legacy signature signs
+// a different digest than a SigHashAll one, neither can be re-encoded as the other,
+// and every signature has exactly one valid encoding.
+func DecodeSignature(sig []byte) ([]byte, SigHashType, error) {
+	if len(sig) < 2 || sig[0] != 0x30 {
+		return nil, 0, ErrInvalidSignature
+	}
+	derLen := int(sig[1]) + 2
+	switch len(sig) {
+	case derLen:
+		return sig, SigHashLegacy, nil
+	case derLen + 1:
+		t := SigHashType(sig[derLen])
+		if !t.IsValid() {
+			return nil, 0, ErrInvalidSigHashType
+		}
+		return sig[:derLen], t, nil
+	}
+	return nil, 0, ErrInvalidSignature
+}
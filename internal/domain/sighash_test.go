@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseSigHashType(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    SigHashType
+		wantErr bool
+	}{
+		{"ALL", SigHashAll, false},
+		{"none", SigHashNone, false},
+		{"SINGLE|ANYONECANPAY", SigHashSingle | SigHashAnyoneCanPay, false},
+		{"ANYONECANPAY", 0, true},
+		{"ALL|NONE", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSigHashType(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSigHashType(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSigHashType(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if !tt.wantErr && got.String() != "" {
+			if reparsed, _ := ParseSigHashType(got.String()); reparsed != got {
+				t.Errorf("String() round trip of %v = %v", got, reparsed)
+			}
+		}
+	}
+}
+
+func TestDecodeSignature(t *testing.T) {
+	der := []byte{0x30, 0x04, 0x02, 0x01, 0x01, 0x02}
+
+	gotDER, hashType, err := DecodeSignature(der)
+	if err != nil || hashType != SigHashLegacy || !bytes.Equal(gotDER, der) {
+		t.Errorf("Bare DER signature decoded as (%x, %v, %v), want legacy", gotDER, hashType, err)
+	}
+	if encoded := EncodeSignature(der, SigHashLegacy); !bytes.Equal(encoded, der) {
+		t.Errorf("Legacy signature encoded as %x, want bare DER", encoded)
+	}
+
+	encoded := EncodeSignature(der, SigHashSingle|SigHashAnyoneCanPay)
+	gotDER, hashType, err = DecodeSignature(encoded)
+	if err != nil || hashType != SigHashSingle|SigHashAnyoneCanPay || !bytes.Equal(gotDER, der) {
+		t.Errorf("Encoded signature decoded as (%x, %v, %v)", gotDER, hashType, err)
+	}
+
+	if _, _, err := DecodeSignature(append(der, 0x07)); err != ErrInvalidSigHashType {
+		t.Errorf("Unknown type byte error = %v, want %v", err, ErrInvalidSigHashType)
+	}
+	if _, _, err := DecodeSignature([]byte{0x01, 0x02}); err != ErrInvalidSignature {
+		t.Errorf("Malformed signature error = %v, want %v", err, ErrInvalidSignature)
+	}
+}
@@ -3,6 +3,7 @@ package domain
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 )
 
 // Outpoint references a specific output in a transaction
@@ -45,9 +46,59 @@ func NewTransaction(inputs []TxInput, outputs []TxOutput) *Transaction {
 	}
 }
 
-// IsCoinbase returns true if this is a coinbase transaction (no inputs)
+// CoinbaseIndex is the outpoint index of a coinbase input. A coinbase input spends
+// nothing; like Bitcoin's coinbase script, its outpoint carries data instead: the block
+// height in the first four transaction id bytes and the miner's extra nonce in the next four.
+// The remaining transaction id bytes are zero and the input has no signature.
+const CoinbaseIndex = ^uint32(0)
+
+// NewCoinbase creates a coinbase transaction paying outputs. The height keeps the
+// transaction ids of two coinbases paying the same outputs apart, and the extra nonce
+// lets a miner change the Merkle root once the header nonces run out.
+func NewCoinbase(height, extraNonce uint32, outputs []TxOutput) *Transaction {
+	tx := NewTransaction([]TxInput{{Prev: Outpoint{Index: CoinbaseIndex}}}, outputs)
+	binary.LittleEndian.PutUint32(tx.Inputs[0].Prev.TxID[0:4], height)
+	tx.SetExtraNonce(extraNonce)
+	return tx
+}
+
+// IsCoinbase returns true if this is a coinbase transaction: one without inputs, or
+// with a single coinbase input laid out as NewCoinbase creates it (see CoinbaseIndex).
 func (t *Transaction) IsCoinbase() bool {
-	return len(t.Inputs) == 0
+	return len(t.Inputs) == 0 || t.hasCoinbaseInput()
+}
+
+// hasCoinbaseInput reports whether t has exactly the input of NewCoinbase. A signed
+// input, or one whose outpoint carries more than the height and extra nonce, is an
+// ordinary input that happens to name index CoinbaseIndex, and fails as a spend.
+func (t *Transaction) hasCoinbaseInput() bool {
+	if len(t.Inputs) != 1 {
+		return false
+	}
+	in := t.Inputs[0]
+	if in.Prev.Index != CoinbaseIndex || len(in.Sig) != 0 {
+		return false
+	}
+	var zero [len(in.Prev.TxID) - 8]byte
+	return bytes.Equal(in.Prev.TxID[8:], zero[:])
+}
+
+// ExtraNonce returns the extra nonce of a coinbase created by NewCoinbase.
+func (t *Transaction) ExtraNonce() (uint32, bool) {
+	if !t.hasCoinbaseInput() {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(t.Inputs[0].Prev.TxID[4:8]), true
+}
+
+// SetExtraNonce replaces the extra nonce of a coinbase created by NewCoinbase and
+// reports whether t has one. The caller recomputes the TxID.
+func (t *Transaction) SetExtraNonce(extraNonce uint32) bool {
+	if !t.hasCoinbaseInput() {
+		return false
+	}
+	binary.LittleEndian.PutUint32(t.Inputs[0].Prev.TxID[4:8], extraNonce)
+	return true
 }
 func (t *Transaction) Serialize() []byte {
 	var buf bytes.Buffer
@@ -84,3 +135,63 @@ func (t *Transaction) SerializeWithoutSignatures() []byte {
 	}
 	return buf.Bytes()
 }
+
+// maxSerializedItems bounds the input, output and signature counts read by DeserializeTransaction.
+const maxSerializedItems = 1 << 16
+
+// DeserializeTransaction reads a transaction in the format produced by Serialize.
+// The TxID is not part of the encoding and is left zero for the caller to compute.
+func DeserializeTransaction(r io.Reader) (Transaction, error) {
+	var t Transaction
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return Transaction{}, err
+	}
+	if count > maxSerializedItems {
+		return Transaction{}, ErrInvalidTransaction
+	}
+	if count > 0 {
+		t.Inputs = make([]TxInput, count)
+	}
+	for i := range t.Inputs {
+		in := &t.Inputs[i]
+		if _, err := io.ReadFull(r, in.Prev.TxID[:]); err != nil {
+			return Transaction{}, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &in.Prev.Index); err != nil {
+			return Transaction{}, err
+		}
+		var sigLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &sigLen); err != nil {
+			return Transaction{}, err
+		}
+		if sigLen > maxSerializedItems {
+			return Transaction{}, ErrInvalidSignature
+		}
+		if sigLen > 0 {
+			in.Sig = make([]byte, sigLen)
+			if _, err := io.ReadFull(r, in.Sig); err != nil {
+				return Transaction{}, err
+			}
+		}
+	}
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return Transaction{}, err
+	}
+	if count > maxSerializedItems {
+		return Transaction{}, ErrInvalidTransaction
+	}
+	if count > 0 {
+		t.Outputs = make([]TxOutput, count)
+	}
+	for i := range t.Outputs {
+		out := &t.Outputs[i]
+		if err := binary.Read(r, binary.LittleEndian, &out.Value); err != nil {
+			return Transaction{}, err
+		}
+		if _, err := io.ReadFull(r, out.To[:]); err != nil {
+			return Transaction{}, err
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDeserializeTransaction_RoundTrip(t *testing.T) {
+	tx := Transaction{
+		Inputs: []TxInput{
+			{Prev: Outpoint{TxID: Hash32{0x01}, Index: 3}, Sig: []byte{0x30, 0x01, 0xAA}},
+			{Prev: Outpoint{TxID: Hash32{0x02}, Index: 0}},
+		},
+		Outputs: []TxOutput{
+			{Value: 100, To: PublicAddress{0xCC}},
+			{Value: 7, To: PublicAddress{0xDD}},
+		},
+	}
+
+	got, err := DeserializeTransaction(bytes.NewReader(tx.Serialize()))
+	if err != nil {
+		t.Fatalf("DeserializeTransaction() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, tx) {
+		t.Errorf("DeserializeTransaction() = %+v, want %+v", got, tx)
+	}
+
+	if _, err := DeserializeTransaction(bytes.NewReader(tx.Serialize()[:10])); err == nil {
+		t.Error("Expected error for truncated transaction")
+	}
+}
+
+func TestNewCoinbase_ExtraNonce(t *testing.T) {
+	outputs := []TxOutput{{Value: 50, To: PublicAddress{0xAA}}}
+	tx := NewCoinbase(7, 0, outputs)
+	if !tx.IsCoinbase() {
+		t.Fatal("NewCoinbase() is not a coinbase")
+	}
+	if !tx.SetExtraNonce(42) {
+		t.Fatal("SetExtraNonce() = false on a coinbase")
+	}
+	if got, ok := tx.ExtraNonce(); !ok || got != 42 {
+		t.Errorf("ExtraNonce() = %d, %v, want 42, true", got, ok)
+	}
+	if bytes.Equal(tx.SerializeWithoutSignatures(), NewCoinbase(7, 0, outputs).SerializeWithoutSignatures()) {
+		t.Error("Extra nonce does not change the serialized coinbase")
+	}
+	if bytes.Equal(NewCoinbase(8, 0, outputs).SerializeWithoutSignatures(), NewCoinbase(7, 0, outputs).SerializeWithoutSignatures()) {
+		t.Error("Height does not change the serialized coinbase")
+	}
+
+	legacy := Transaction{Outputs: outputs}
+	if !legacy.IsCoinbase() || legacy.SetExtraNonce(1) {
+		t.Error("Input-less coinbase should be a coinbase without an extra nonce")
+	}
+	spend := Transaction{Inputs: []TxInput{{Prev: Outpoint{TxID: Hash32{1}}}}, Outputs: outputs}
+	if spend.IsCoinbase() {
+		t.Error("Spending transaction reported as coinbase")
+	}
+	signed := NewCoinbase(7, 0, outputs)
+	signed.Inputs[0].Sig = []byte{0x30}
+	tagged := NewCoinbase(7, 0, outputs)
+	tagged.Inputs[0].Prev.TxID[8] = 1
+	if signed.IsCoinbase() || tagged.IsCoinbase() {
+		t.Error("Input naming CoinbaseIndex with a signature or other outpoint data reported as coinbase")
+	}
+}
@@ -0,0 +1,127 @@
+// Package metrics implements counters, gauges and histograms and writes them in the
+// Prometheus text exposition format, without depending on the Prometheus client library.
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Labels are the label names and values of one series of a metric.
+type Labels map[string]string
+
+// Counter is a value that only goes up. It is safe for concurrent use.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func NewCounter() *Counter {
+	return &Counter{}
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// Gauge is a value that goes up and down. It is safe for concurrent use.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func NewGauge() *Gauge {
+	return &Gauge{}
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// DefaultBuckets are latency buckets in seconds, from half a millisecond to ten seconds.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets by upper bound. It is safe for concurrent use.
+type Histogram struct {
+	mutex *sync.Mutex
+	// bounds are the sorted upper bounds; counts[i] counts the observations in
+	// (bounds[i-1], bounds[i]], and the last element of counts those above every bound.
+	bounds []float64
+	counts []uint64
+	sum    float64
+}
+
+// NewHistogram returns a histogram with the given bucket upper bounds. The +Inf bucket
+// is always added.
+func NewHistogram(buckets []float64) *Histogram {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	return &Histogram{
+		mutex:  &sync.Mutex{},
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.counts[i]++
+	h.sum += v
+}
+
+// HistogramSnapshot is the state of a histogram at one moment. Cumulative[i] is the
+// number of observations not above Bounds[i]; Count includes those above every bound.
+type HistogramSnapshot struct {
+	Bounds     []float64
+	Cumulative []uint64
+	Count      uint64
+	Sum        float64
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := HistogramSnapshot{
+		Bounds:     h.bounds,
+		Cumulative: make([]uint64, len(h.bounds)),
+		Sum:        h.sum,
+	}
+	for i, n := range h.counts {
+		s.Count += n
+		if i < len(h.bounds) {
+			s.Cumulative[i] = s.Count
+		}
+	}
+	return s
+}
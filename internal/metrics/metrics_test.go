@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := NewCounter()
+	requests.Add(3)
+	r.RegisterCounter("requests_total", "Requests served.", Labels{"code": "200", "method": "GET"}, requests)
+	r.RegisterCounterFunc("requests_total", "Requests served.", Labels{"code": "500", "method": "GET"}, func() float64 { return 1 })
+	temperature := NewGauge()
+	temperature.Set(-1.5)
+	r.RegisterGauge("temperature", "Line one\nwith a \\ backslash.", Labels{"room": `say "hi"`}, temperature)
+	latency := NewHistogram([]float64{1, 0.1})
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		latency.Observe(v)
+	}
+	r.RegisterHistogram("latency_seconds", "Latency.", nil, latency)
+
+	var sb strings.Builder
+	n, err := r.WriteTo(&sb)
+	if err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 2.65
+latency_seconds_count 4
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{code="200",method="GET"} 3
+requests_total{code="500",method="GET"} 1
+# HELP temperature Line one\nwith a \\ backslash.
+# TYPE temperature gauge
+temperature{room="say \"hi\""} -1.5
+`
+	if sb.String() != want {
+		t.Errorf("WriteTo() wrote\n%s\nwant\n%s", sb.String(), want)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("WriteTo() = %d, want %d", n, len(want))
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+	if rec.Body.String() != want {
+		t.Errorf("handler served\n%s", rec.Body.String())
+	}
+}
+
+func TestRegistry_RejectsConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		register func(r *Registry)
+	}{
+		{"invalid name", func(r *Registry) { r.RegisterGauge("bad-name", "", nil, NewGauge()) }},
+		{"invalid label", func(r *Registry) { r.RegisterGauge("g", "", Labels{"__reserved": "x"}, NewGauge()) }},
+		{"other type", func(r *Registry) { r.RegisterCounter("g", "Help.", nil, NewCounter()) }},
+		{"other help", func(r *Registry) { r.RegisterGauge("g", "Other help.", Labels{"a": "2"}, NewGauge()) }},
+		{"same labels", func(r *Registry) { r.RegisterGauge("g", "Help.", Labels{"a": "1"}, NewGauge()) }},
+		{"histogram le", func(r *Registry) { r.RegisterHistogram("h", "", Labels{"le": "1"}, NewHistogram(nil)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.RegisterGauge("g", "Help.", Labels{"a": "1"}, NewGauge())
+			defer func() {
+				if recover() == nil {
+					t.Error("registration did not panic")
+				}
+			}()
+			tt.register(r)
+		})
+	}
+}
+
+func TestCounter_RejectsDecrease(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Add(-1) did not panic")
+		}
+	}()
+	NewCounter().Add(-1)
+}
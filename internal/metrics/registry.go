@@ -0,0 +1,222 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format written by Registry.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// family is a metric name with its series.
+type family struct {
+	name   string
+	help   string
+	typ    metricType
+	series []series
+}
+
+// series is one label set of a family. Exactly one of value and histogram is set.
+type series struct {
+	labels    string
+	value     func() float64
+	histogram *Histogram
+}
+
+// Registry holds metrics by name and writes them all on every scrape. It is safe for
+// concurrent use. Like http.ServeMux, it panics on registrations that are programming
+// errors: invalid names, a name registered with another type or help, or a label set
+// registered twice.
+type Registry struct {
+	mutex    *sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		mutex:    &sync.Mutex{},
+		families: make(map[string]*family),
+	}
+}
+
+func (r *Registry) RegisterCounter(name, help string, labels Labels, c *Counter) {
+	r.register(name, help, counterType, series{labels: formatLabels(labels), value: c.Value})
+}
+
+// RegisterCounterFunc registers a counter whose value fn reads on every scrape.
+func (r *Registry) RegisterCounterFunc(name, help string, labels Labels, fn func() float64) {
+	r.register(name, help, counterType, series{labels: formatLabels(labels), value: fn})
+}
+
+func (r *Registry) RegisterGauge(name, help string, labels Labels, g *Gauge) {
+	r.register(name, help, gaugeType, series{labels: formatLabels(labels), value: g.Value})
+}
+
+// RegisterGaugeFunc registers a gauge whose value fn reads on every scrape.
+func (r *Registry) RegisterGaugeFunc(name, help string, labels Labels, fn func() float64) {
+	r.register(name, help, gaugeType, series{labels: formatLabels(labels), value: fn})
+}
+
+func (r *Registry) RegisterHistogram(name, help string, labels Labels, h *Histogram) {
+	if _, ok := labels["le"]; ok {
+		panic("metrics: histogram label le is reserved")
+	}
+	r.register(name, help, histogramType, series{labels: formatLabels(labels), histogram: h})
+}
+
+func (r *Registry) register(name, help string, typ metricType, s series) {
+	if !namePattern.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ}
+		r.families[name] = f
+	}
+	if f.typ != typ || f.help != help {
+		panic(fmt.Sprintf("metrics: %s registered as %s %q and %s %q", name, f.typ, f.help, typ, help))
+	}
+	for _, other := range f.series {
+		if other.labels == s.labels {
+			panic(fmt.Sprintf("metrics: %s{%s} registered twice", name, s.labels))
+		}
+	}
+	f.series = append(f.series, s)
+}
+
+// WriteTo writes every metric in the text exposition format, families sorted by name
+// and series in registration order.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.Lock()
+	families := make([]family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, family{name: f.name, help: f.help, typ: f.typ, series: append([]series(nil), f.series...)})
+	}
+	r.mutex.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.series {
+			if s.histogram != nil {
+				writeHistogram(cw, f.name, s.labels, s.histogram.Snapshot())
+				continue
+			}
+			writeSample(cw, f.name, s.labels, s.value())
+		}
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func writeHistogram(w io.Writer, name, labels string, s HistogramSnapshot) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bound := range s.Bounds {
+		writeSample(w, name+"_bucket", labels+sep+`le="`+formatValue(bound)+`"`, float64(s.Cumulative[i]))
+	}
+	writeSample(w, name+"_bucket", labels+sep+`le="+Inf"`, float64(s.Count))
+	writeSample(w, name+"_sum", labels, s.Sum)
+	writeSample(w, name+"_count", labels, float64(s.Count))
+}
+
+func writeSample(w io.Writer, name, labels string, v float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatValue(v))
+		return
+	}
+	fmt.Fprintf(w, "%s %s\n", name, formatValue(v))
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// formatLabels renders labels sorted by name as they appear between braces.
+func formatLabels(labels Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if !namePattern.MatchString(name) || strings.HasPrefix(name, "__") || strings.Contains(name, ":") {
+			panic(fmt.Sprintf("metrics: invalid label name %q", name))
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + `="` + escapeLabelValue(labels[name]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written and remembers the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
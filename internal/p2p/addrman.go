@@ -0,0 +1,137 @@
+package p2p
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxFailedAttempts is the number of failed dials after which an address is forgotten.
+const maxFailedAttempts = 5
+
+// KnownAddress is a peer address together with its connection history.
+type KnownAddress struct {
+	Addr        string
+	LastSeen    time.Time
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Attempts    int
+}
+
+// AddrManager keeps track of peer addresses learned from seeds, addr messages and inbound peers.
+type AddrManager struct {
+	addrs map[string]*KnownAddress
+	mutex *sync.Mutex
+}
+
+func NewAddrManager() *AddrManager {
+	return &AddrManager{
+		addrs: make(map[string]*KnownAddress),
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Add records addr as seen now, creating it if it is new.
+func (a *AddrManager) Add(addr string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	ka, exists := a.addrs[addr]
+	if !exists {
+		ka = &KnownAddress{Addr: addr}
+		a.addrs[addr] = ka
+	}
+	ka.LastSeen = time.Now()
+}
+
+// Attempt records a dial attempt. Addresses that keep failing are dropped.
+func (a *AddrManager) Attempt(addr string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	ka, exists := a.addrs[addr]
+	if !exists {
+		return
+	}
+	ka.LastAttempt = time.Now()
+	ka.Attempts++
+	if ka.Attempts > maxFailedAttempts {
+		delete(a.addrs, addr)
+	}
+}
+
+// Good marks addr as reachable, which resets its failed attempt counter.
+func (a *AddrManager) Good(addr string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	ka, exists := a.addrs[addr]
+	if !exists {
+		ka = &KnownAddress{Addr: addr}
+		a.addrs[addr] = ka
+	}
+	now := time.Now()
+	ka.LastSeen = now
+	ka.LastSuccess = now
+	ka.Attempts = 0
+}
+
+// Remove forgets addr, for example after it turned out to be this node's own address.
+func (a *AddrManager) Remove(addr string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.addrs, addr)
+}
+
+// Addresses returns up to max addresses, most recently seen first.
+func (a *AddrManager) Addresses(max int) []string {
+	known := a.snapshot()
+	sort.Slice(known, func(i, j int) bool {
+		return known[i].LastSeen.After(known[j].LastSeen)
+	})
+	var addrs []string
+	for _, ka := range known {
+		if max > 0 && len(addrs) >= max {
+			break
+		}
+		addrs = append(addrs, ka.Addr)
+	}
+	return addrs
+}
+
+// Candidates returns addresses worth dialing: not skipped by the caller and not
+// attempted within retryInterval. Addresses with fewer failures come first.
+func (a *AddrManager) Candidates(skip func(addr string) bool, retryInterval time.Duration) []string {
+	known := a.snapshot()
+	sort.Slice(known, func(i, j int) bool {
+		if known[i].Attempts != known[j].Attempts {
+			return known[i].Attempts < known[j].Attempts
+		}
+		return known[i].LastSeen.After(known[j].LastSeen)
+	})
+	now := time.Now()
+	var addrs []string
+	for _, ka := range known {
+		if skip != nil && skip(ka.Addr) {
+			continue
+		}
+		if now.Sub(ka.LastAttempt) < retryInterval {
+			continue
+		}
+		addrs = append(addrs, ka.Addr)
+	}
+	return addrs
+}
+
+func (a *AddrManager) Len() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return len(a.addrs)
+}
+
+func (a *AddrManager) snapshot() []KnownAddress {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	known := make([]KnownAddress, 0, len(a.addrs))
+	for _, ka := range a.addrs {
+		known = append(known, *ka)
+	}
+	return known
+}
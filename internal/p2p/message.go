@@ -0,0 +1,298 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// ProtocolVersion is announced in the version message. Peers speaking an older version are refused.
+// Version 2 replaced getblocks with headers-first synchronization.
+const ProtocolVersion uint32 = 2
+
+// MaxInvPerMessage bounds the inventory vectors carried by one inv, getdata or notfound message.
+const MaxInvPerMessage = 500
+
+// MaxHeadersPerMessage bounds the block headers carried by one headers message.
+// A full headers message means the sender may have more.
+const MaxHeadersPerMessage = 2000
+
+const (
+	commandSize    = 12
+	maxPayloadSize = 32 << 20
+	maxAddrPerMsg  = 1000
+	maxAddrLength  = 255
+)
+
+var networkMagic = [4]byte{'u', 'z', 'd', '2'}
+
+var (
+	ErrBadMagic         = errors.New("p2p: message does not start with the network magic")
+	ErrPayloadTooLarge  = errors.New("p2p: message payload too large")
+	ErrMalformedMessage = errors.New("p2p: malformed message payload")
+	ErrSelfConnection   = errors.New("p2p: connected to self")
+	ErrGenesisMismatch  = errors.New("p2p: peer follows a different genesis block")
+	ErrOldProtocol      = errors.New("p2p: peer protocol version too old")
+	ErrHandshake        = errors.New("p2p: unexpected message during handshake")
+	ErrBanned           = errors.New("p2p: peer address is banned")
+	ErrTooManyPeers     = errors.New("p2p: peer limit reached")
+)
+
+// Message commands. Every message is framed as the network magic, the command
+// padded to 12 bytes, the payload length and the payload itself.
+const (
+	CmdVersion    = "version"
+	CmdVerAck     = "verack"
+	CmdGetAddr    = "getaddr"
+	CmdAddr       = "addr"
+	CmdInv        = "inv"
+	CmdGetData    = "getdata"
+	CmdNotFound   = "notfound"
+	CmdGetHeaders = "getheaders"
+	CmdHeaders    = "headers"
+	CmdBlock      = "block"
+	CmdTx         = "tx"
+	CmdGetUsers   = "getusers"
+	CmdUsers      = "users"
+)
+
+// Message is a single framed protocol message.
+type Message struct {
+	Command string
+	Payload []byte
+}
+
+func writeMessage(w io.Writer, msg Message) error {
+	if len(msg.Payload) > maxPayloadSize {
+		return ErrPayloadTooLarge
+	}
+	var header [4 + commandSize + 4]byte
+	copy(header[:4], networkMagic[:])
+	copy(header[4:4+commandSize], msg.Command)
+	binary.LittleEndian.PutUint32(header[4+commandSize:], uint32(len(msg.Payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(msg.Payload)
+	return err
+}
+
+func readMessage(r io.Reader) (Message, error) {
+	var header [4 + commandSize + 4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Message{}, err
+	}
+	if !bytes.Equal(header[:4], networkMagic[:]) {
+		return Message{}, ErrBadMagic
+	}
+	length := binary.LittleEndian.Uint32(header[4+commandSize:])
+	if length > maxPayloadSize {
+		return Message{}, ErrPayloadTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Message{}, err
+	}
+	command := string(bytes.TrimRight(header[4:4+commandSize], "\x00"))
+	return Message{Command: command, Payload: payload}, nil
+}
+
+// VersionMsg opens the handshake. Nonce detects connections to self and
+// ListenPort lets the remote side learn the address this node accepts peers on.
+type VersionMsg struct {
+	Version    uint32
+	Height     uint32
+	Genesis    d.Hash32
+	ListenPort uint16
+	Nonce      uint64
+}
+
+func (v VersionMsg) encode() []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, v)
+	return buf.Bytes()
+}
+
+func decodeVersion(payload []byte) (VersionMsg, error) {
+	var v VersionMsg
+	if err := decodeExact(payload, &v); err != nil {
+		return VersionMsg{}, err
+	}
+	return v, nil
+}
+
+// InvType identifies the kind of object an inventory vector refers to.
+type InvType uint8
+
+const (
+	InvTx    InvType = 1
+	InvBlock InvType = 2
+)
+
+// InvVect announces or requests a transaction or block by hash.
+type InvVect struct {
+	Type InvType
+	Hash d.Hash32
+}
+
+func encodeInv(items []InvVect) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(items)))
+	for _, item := range items {
+		buf.WriteByte(byte(item.Type))
+		buf.Write(item.Hash[:])
+	}
+	return buf.Bytes()
+}
+
+func decodeInv(payload []byte) ([]InvVect, error) {
+	r := bytes.NewReader(payload)
+	count, err := readCount(r, MaxInvPerMessage)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]InvVect, count)
+	for i := range items {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrMalformedMessage
+		}
+		items[i].Type = InvType(typ)
+		if _, err := io.ReadFull(r, items[i].Hash[:]); err != nil {
+			return nil, ErrMalformedMessage
+		}
+	}
+	if r.Len() != 0 {
+		return nil, ErrMalformedMessage
+	}
+	return items, nil
+}
+
+func encodeHashes(hashes []d.Hash32) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(hashes)))
+	for _, hash := range hashes {
+		buf.Write(hash[:])
+	}
+	return buf.Bytes()
+}
+
+func decodeHashes(payload []byte) ([]d.Hash32, error) {
+	r := bytes.NewReader(payload)
+	count, err := readCount(r, MaxInvPerMessage)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]d.Hash32, count)
+	if err := decodeExact(payload[4:], hashes); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+func encodeHeaders(headers []d.Header) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(headers)))
+	for _, header := range headers {
+		buf.Write(header.Serialize())
+	}
+	return buf.Bytes()
+}
+
+func decodeHeaders(payload []byte) ([]d.Header, error) {
+	r := bytes.NewReader(payload)
+	count, err := readCount(r, MaxHeadersPerMessage)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]d.Header, count)
+	for i := range headers {
+		if headers[i], err = d.DeserializeHeader(r); err != nil {
+			return nil, ErrMalformedMessage
+		}
+	}
+	if r.Len() != 0 {
+		return nil, ErrMalformedMessage
+	}
+	return headers, nil
+}
+
+func encodeAddrs(addrs []string) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(addrs)))
+	for _, addr := range addrs {
+		buf.WriteByte(byte(len(addr)))
+		buf.WriteString(addr)
+	}
+	return buf.Bytes()
+}
+
+func decodeAddrs(payload []byte) ([]string, error) {
+	r := bytes.NewReader(payload)
+	count, err := readCount(r, maxAddrPerMsg)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, count)
+	for i := range addrs {
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrMalformedMessage
+		}
+		addr := make([]byte, length)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return nil, ErrMalformedMessage
+		}
+		addrs[i] = string(addr)
+	}
+	if r.Len() != 0 {
+		return nil, ErrMalformedMessage
+	}
+	return addrs, nil
+}
+
+func encodePublicKeys(keys []d.PublicKey) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(keys)))
+	for _, key := range keys {
+		buf.Write(key[:])
+	}
+	return buf.Bytes()
+}
+
+func decodePublicKeys(payload []byte) ([]d.PublicKey, error) {
+	r := bytes.NewReader(payload)
+	count, err := readCount(r, maxPayloadSize/len(d.PublicKey{}))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]d.PublicKey, count)
+	if err := decodeExact(payload[4:], keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// readCount reads a little-endian element count and rejects counts above max.
+func readCount(r io.Reader, max int) (int, error) {
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return 0, ErrMalformedMessage
+	}
+	if int64(count) > int64(max) {
+		return 0, ErrMalformedMessage
+	}
+	return int(count), nil
+}
+
+// decodeExact decodes payload into v and fails unless every byte is consumed.
+func decodeExact(payload []byte, v any) error {
+	r := bytes.NewReader(payload)
+	if err := binary.Read(r, binary.LittleEndian, v); err != nil || r.Len() != 0 {
+		return ErrMalformedMessage
+	}
+	return nil
+}
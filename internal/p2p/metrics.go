@@ -0,0 +1,23 @@
+package p2p
+
+import "github.com/Quikmove/blockchain-uzd2/internal/metrics"
+
+// RegisterMetrics adds the node's peer and sync metrics to r.
+func (n *Node) RegisterMetrics(r *metrics.Registry) {
+	const peersHelp = "Number of connected peers, by who opened the connection."
+	r.RegisterGaugeFunc("p2p_peers", peersHelp, metrics.Labels{"direction": "inbound"}, func() float64 {
+		return float64(n.countInbound())
+	})
+	r.RegisterGaugeFunc("p2p_peers", peersHelp, metrics.Labels{"direction": "outbound"}, func() float64 {
+		return float64(len(n.peerList()) - n.countInbound())
+	})
+	r.RegisterGaugeFunc("p2p_known_addresses", "Number of peer addresses in the address manager.", nil, func() float64 {
+		return float64(n.addrMan.Len())
+	})
+	r.RegisterGaugeFunc("p2p_syncing", "1 while blocks are downloaded from a sync peer, 0 otherwise.", nil, func() float64 {
+		if n.SyncStatus().Syncing {
+			return 1
+		}
+		return 0
+	})
+}
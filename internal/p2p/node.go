@@ -0,0 +1,746 @@
+// Package p2p connects blockchain nodes over TCP. Nodes exchange versions in a
+// handshake, catch up with headers-first synchronization, announce blocks and
+// transactions with inv messages, fetch them with getdata, share peer addresses
+// and ban peers that relay invalid data.
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// Misbehavior scores added to a peer's ban score.
+const (
+	scoreInvalidBlock = 100
+	scoreInvalidTx    = 10
+	scoreMalformed    = 20
+)
+
+type Config struct {
+	// ListenAddr is the TCP address to accept peers on, e.g. ":9333" or "127.0.0.1:0".
+	ListenAddr string
+	// Seeds are dialed on start and whenever the node has fewer than MaxOutbound peers.
+	Seeds            []string
+	MaxOutbound      int
+	MaxInbound       int
+	BanThreshold     int
+	BanDuration      time.Duration
+	HandshakeTimeout time.Duration
+	// WriteTimeout is how long a peer may take to read a message before it is
+	// disconnected. Zero means DefaultConfig's.
+	WriteTimeout time.Duration
+	// DialInterval is how often the node tries to fill its outbound slots.
+	DialInterval time.Duration
+	// AnnounceInterval is how often the node checks its mempool for new transactions to
+	// announce. A new tip is announced as soon as it is connected.
+	AnnounceInterval time.Duration
+	// MaxBlocksInFlight is how many block downloads may be outstanding per peer during sync.
+	MaxBlocksInFlight int
+	// DownloadWindow is how far past the last connected block bodies are requested during sync.
+	DownloadWindow int
+	// StallTimeout is how long a peer may leave a sync request unanswered before it is disconnected.
+	StallTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:        ":9333",
+		MaxOutbound:       8,
+		MaxInbound:        32,
+		BanThreshold:      100,
+		BanDuration:       24 * time.Hour,
+		HandshakeTimeout:  5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		DialInterval:      5 * time.Second,
+		AnnounceInterval:  250 * time.Millisecond,
+		MaxBlocksInFlight: 16,
+		DownloadWindow:    1024,
+		StallTimeout:      30 * time.Second,
+	}
+}
+
+// Node is a peer-to-peer participant serving one Blockchain.
+type Node struct {
+	cfg     Config
+	bch     *blockchain.Blockchain
+	addrMan *AddrManager
+	sync    *syncManager
+	nonce   uint64
+
+	listener net.Listener
+
+	peers      map[*Peer]struct{}
+	peersMutex *sync.RWMutex
+
+	bans     map[string]time.Time
+	banMutex *sync.Mutex
+
+	selfAddrs map[string]bool
+
+	announcedTip d.Hash32
+	announcedTxs map[d.Hash32]struct{}
+
+	wg *sync.WaitGroup
+}
+
+func NewNode(bch *blockchain.Blockchain, cfg Config) *Node {
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = DefaultConfig().WriteTimeout
+	}
+	n := &Node{
+		cfg:          cfg,
+		bch:          bch,
+		addrMan:      NewAddrManager(),
+		nonce:        rand.Uint64(),
+		peers:        make(map[*Peer]struct{}),
+		peersMutex:   &sync.RWMutex{},
+		bans:         make(map[string]time.Time),
+		banMutex:     &sync.Mutex{},
+		selfAddrs:    make(map[string]bool),
+		announcedTxs: make(map[d.Hash32]struct{}),
+		wg:           &sync.WaitGroup{},
+	}
+	n.sync = newSyncManager(n)
+	return n
+}
+
+// Start begins accepting peers, dials the seeds and keeps announcing new
+// blocks and transactions until ctx is cancelled.
+func (n *Node) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", n.cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+	n.listener = listener
+	for _, seed := range n.cfg.Seeds {
+		n.addrMan.Add(seed)
+	}
+
+	n.wg.Add(4)
+	go n.acceptLoop()
+	go n.dialLoop(ctx)
+	go n.announceLoop(ctx)
+	go n.stallLoop(ctx)
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+		for _, p := range n.peerList() {
+			p.Disconnect()
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until the node's background loops exit after its context is cancelled.
+func (n *Node) Wait() {
+	n.wg.Wait()
+}
+
+// Addr returns the address the node accepts peers on.
+func (n *Node) Addr() string {
+	if n.listener == nil {
+		return n.cfg.ListenAddr
+	}
+	return n.listener.Addr().String()
+}
+
+func (n *Node) AddrManager() *AddrManager {
+	return n.addrMan
+}
+
+// SyncStatus reports the progress of the headers-first block download.
+func (n *Node) SyncStatus() SyncStatus {
+	return n.sync.status()
+}
+
+func (n *Node) Peers() []PeerInfo {
+	peers := n.peerList()
+	infos := make([]PeerInfo, 0, len(peers))
+	for _, p := range peers {
+		infos = append(infos, p.Info())
+	}
+	return infos
+}
+
+// Connect dials addr and completes the handshake.
+func (n *Node) Connect(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return err
+	}
+	if n.IsBanned(addr) {
+		return ErrBanned
+	}
+	n.addrMan.Add(addr)
+	n.addrMan.Attempt(addr)
+	conn, err := net.DialTimeout("tcp", addr, n.cfg.HandshakeTimeout)
+	if err != nil {
+		return err
+	}
+	if err := n.setupPeer(conn, false, addr); err != nil {
+		_ = conn.Close()
+		if errors.Is(err, ErrSelfConnection) {
+			n.peersMutex.Lock()
+			n.selfAddrs[addr] = true
+			n.peersMutex.Unlock()
+			n.addrMan.Remove(addr)
+		}
+		return err
+	}
+	n.addrMan.Good(addr)
+	return nil
+}
+
+// Ban refuses connections to and from the node listening on addr until the ban
+// expires and drops the current peer with that listen address. Bans apply to listen
+// addresses rather than hosts, so that the other nodes on a host stay connected;
+// inbound peers are matched by the listen port they announce in their version.
+func (n *Node) Ban(addr string) {
+	n.banMutex.Lock()
+	n.bans[addr] = time.Now().Add(n.cfg.BanDuration)
+	n.banMutex.Unlock()
+	for _, p := range n.peerList() {
+		if p.banKey() == addr {
+			p.Disconnect()
+		}
+	}
+}
+
+func (n *Node) IsBanned(addr string) bool {
+	n.banMutex.Lock()
+	defer n.banMutex.Unlock()
+	until, banned := n.bans[addr]
+	if !banned {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(n.bans, addr)
+		return false
+	}
+	return true
+}
+
+func (n *Node) acceptLoop() {
+	defer n.wg.Done()
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			if err := n.setupPeer(conn, true, ""); err != nil {
+				log.Printf("p2p: rejected inbound peer %s: %v", conn.RemoteAddr(), err)
+				_ = conn.Close()
+			}
+		}()
+	}
+}
+
+// dialLoop keeps the outbound slots filled with addresses from the address manager.
+func (n *Node) dialLoop(ctx context.Context) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.DialInterval)
+	defer ticker.Stop()
+	for {
+		n.fillOutbound()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *Node) fillOutbound() {
+	connected := make(map[string]bool)
+	outbound := 0
+	for _, info := range n.Peers() {
+		connected[info.ListenAddr] = true
+		if !info.Inbound {
+			outbound++
+		}
+	}
+	skip := func(addr string) bool {
+		_, _, err := net.SplitHostPort(addr)
+		return err != nil || connected[addr] || n.isSelf(addr) || n.IsBanned(addr)
+	}
+	for _, addr := range n.addrMan.Candidates(skip, n.cfg.DialInterval) {
+		if outbound >= n.cfg.MaxOutbound {
+			return
+		}
+		if err := n.Connect(addr); err != nil {
+			if !errors.Is(err, ErrSelfConnection) {
+				log.Printf("p2p: could not connect to %s: %v", addr, err)
+			}
+			continue
+		}
+		outbound++
+	}
+}
+
+// setupPeer runs the version handshake on conn and registers the resulting peer.
+func (n *Node) setupPeer(conn net.Conn, inbound bool, dialedAddr string) error {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if inbound && n.countInbound() >= n.cfg.MaxInbound {
+		return ErrTooManyPeers
+	}
+
+	version, err := n.handshake(conn)
+	if err != nil {
+		return err
+	}
+
+	listenAddr := dialedAddr
+	if inbound {
+		listenAddr = net.JoinHostPort(host, strconv.Itoa(int(version.ListenPort)))
+		if n.IsBanned(listenAddr) {
+			return ErrBanned
+		}
+		n.addrMan.Good(listenAddr)
+	}
+	p := newPeer(n, conn, inbound, version, listenAddr)
+
+	n.peersMutex.Lock()
+	n.peers[p] = struct{}{}
+	n.peersMutex.Unlock()
+	log.Printf("p2p: connected to %s (inbound: %t, height: %d)", p.Addr(), inbound, version.Height)
+
+	go p.writeLoop()
+	go func() {
+		p.readLoop()
+		n.peersMutex.Lock()
+		delete(n.peers, p)
+		n.peersMutex.Unlock()
+		n.sync.peerDisconnected(p)
+	}()
+
+	p.queue(CmdGetUsers, nil)
+	if !inbound {
+		p.queue(CmdGetAddr, nil)
+	}
+	if inbound {
+		n.relayAddr(p, listenAddr)
+	}
+	n.sync.peerConnected(p)
+	if tipHash, err := n.bch.GetLatestBlockHash(); err == nil {
+		inv := InvVect{Type: InvBlock, Hash: tipHash}
+		p.markKnown(inv)
+		p.queue(CmdInv, encodeInv([]InvVect{inv}))
+	}
+	return nil
+}
+
+func (n *Node) handshake(conn net.Conn) (VersionMsg, error) {
+	_ = conn.SetDeadline(time.Now().Add(n.cfg.HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	ours := n.versionMsg()
+	if err := writeMessage(conn, Message{Command: CmdVersion, Payload: ours.encode()}); err != nil {
+		return VersionMsg{}, err
+	}
+	msg, err := readMessage(conn)
+	if err != nil {
+		return VersionMsg{}, err
+	}
+	if msg.Command != CmdVersion {
+		return VersionMsg{}, ErrHandshake
+	}
+	theirs, err := decodeVersion(msg.Payload)
+	if err != nil {
+		return VersionMsg{}, err
+	}
+	switch {
+	case theirs.Nonce == n.nonce:
+		return VersionMsg{}, ErrSelfConnection
+	case theirs.Version < ProtocolVersion:
+		return VersionMsg{}, ErrOldProtocol
+	case !ours.Genesis.IsZero() && !theirs.Genesis.IsZero() && ours.Genesis != theirs.Genesis:
+		return VersionMsg{}, ErrGenesisMismatch
+	}
+
+	if err := writeMessage(conn, Message{Command: CmdVerAck}); err != nil {
+		return VersionMsg{}, err
+	}
+	msg, err = readMessage(conn)
+	if err != nil {
+		return VersionMsg{}, err
+	}
+	if msg.Command != CmdVerAck {
+		return VersionMsg{}, ErrHandshake
+	}
+	return theirs, nil
+}
+
+func (n *Node) versionMsg() VersionMsg {
+	v := VersionMsg{
+		Version: ProtocolVersion,
+		Height:  uint32(n.bch.Len()),
+		Nonce:   n.nonce,
+	}
+	if genesis, err := n.bch.GetBlockHash(0); err == nil {
+		v.Genesis = genesis
+	}
+	if _, port, err := net.SplitHostPort(n.Addr()); err == nil {
+		if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+			v.ListenPort = uint16(p)
+		}
+	}
+	return v
+}
+
+// misbehaving adds score to the peer's ban score and bans its address once the threshold is reached.
+func (n *Node) misbehaving(p *Peer, score int, reason error) {
+	total := p.addBanScore(score)
+	log.Printf("p2p: peer %s misbehaved (+%d, total %d): %v", p.Addr(), score, total, reason)
+	if total >= n.cfg.BanThreshold {
+		log.Printf("p2p: banning %s", p.banKey())
+		n.Ban(p.banKey())
+	}
+}
+
+func (n *Node) handleMessage(p *Peer, msg Message) {
+	switch msg.Command {
+	case CmdGetAddr:
+		p.queue(CmdAddr, encodeAddrs(n.addrMan.Addresses(maxAddrPerMsg)))
+	case CmdAddr:
+		addrs, err := decodeAddrs(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, scoreMalformed, err)
+			return
+		}
+		for _, addr := range addrs {
+			if _, _, err := net.SplitHostPort(addr); err == nil && !n.isSelf(addr) {
+				n.addrMan.Add(addr)
+			}
+		}
+	case CmdInv:
+		items, err := decodeInv(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, scoreMalformed, err)
+			return
+		}
+		n.handleInv(p, items)
+	case CmdGetData:
+		items, err := decodeInv(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, scoreMalformed, err)
+			return
+		}
+		n.handleGetData(p, items)
+	case CmdGetHeaders:
+		locator, err := decodeHashes(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, scoreMalformed, err)
+			return
+		}
+		p.queue(CmdHeaders, encodeHeaders(n.bch.LocateHeaders(locator, MaxHeadersPerMessage)))
+	case CmdHeaders:
+		headers, err := decodeHeaders(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, scoreMalformed, err)
+			return
+		}
+		if err := n.sync.handleHeaders(p, headers); err != nil {
+			score := scoreInvalidBlock
+			if errors.Is(err, d.ErrOrphanBlock) {
+				score = scoreMalformed
+			}
+			n.misbehaving(p, score, err)
+		}
+	case CmdNotFound:
+		items, err := decodeInv(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, scoreMalformed, err)
+			return
+		}
+		n.sync.handleNotFound(p, items)
+	case CmdBlock:
+		n.handleBlock(p, msg.Payload)
+	case CmdTx:
+		n.handleTx(p, msg.Payload)
+	case CmdGetUsers:
+		users := n.bch.Users()
+		keys := make([]d.PublicKey, 0, len(users))
+		for _, user := range users {
+			keys = append(keys, user.PublicKey)
+		}
+		p.queue(CmdUsers, encodePublicKeys(keys))
+	case CmdUsers:
+		keys, err := decodePublicKeys(msg.Payload)
+		if err != nil {
+			n.misbehaving(p, scoreMalformed, err)
+			return
+		}
+		n.handleUsers(p, keys)
+	case CmdVersion, CmdVerAck:
+	default:
+		log.Printf("p2p: ignoring unknown command %q from %s", msg.Command, p.Addr())
+	}
+}
+
+// handleInv requests announced blocks and transactions that are not known yet.
+// Blocks announced while a download is running are left to the sync manager.
+func (n *Node) handleInv(p *Peer, items []InvVect) {
+	var wanted []InvVect
+	for _, item := range items {
+		p.markKnown(item)
+		switch item.Type {
+		case InvBlock:
+			if !n.bch.HaveBlock(item.Hash) && !n.sync.blockAnnounced(p, item.Hash) {
+				wanted = append(wanted, item)
+			}
+		case InvTx:
+			if _, exists := n.bch.Mempool().Get(item.Hash); !exists {
+				wanted = append(wanted, item)
+			}
+		}
+	}
+	if len(wanted) > 0 {
+		p.queue(CmdGetData, encodeInv(wanted))
+	}
+}
+
+func (n *Node) handleGetData(p *Peer, items []InvVect) {
+	var notFound []InvVect
+	for _, item := range items {
+		switch item.Type {
+		case InvBlock:
+			b, err := n.bch.GetBlockByHash(item.Hash)
+			if err != nil {
+				notFound = append(notFound, item)
+				continue
+			}
+			p.markKnown(item)
+			p.queue(CmdBlock, b.Serialize())
+		case InvTx:
+			tx, exists := n.bch.Mempool().Get(item.Hash)
+			if !exists {
+				notFound = append(notFound, item)
+				continue
+			}
+			p.markKnown(item)
+			p.queue(CmdTx, tx.Serialize())
+		default:
+			notFound = append(notFound, item)
+		}
+	}
+	if len(notFound) > 0 {
+		p.queue(CmdNotFound, encodeInv(notFound))
+	}
+}
+
+func (n *Node) handleBlock(p *Peer, payload []byte) {
+	r := bytes.NewReader(payload)
+	b, err := d.DeserializeBlock(r)
+	if err != nil || r.Len() != 0 {
+		n.misbehaving(p, scoreMalformed, ErrMalformedMessage)
+		return
+	}
+	for i := range b.Body.Transactions {
+		b.Body.Transactions[i].TxID = n.bch.HashTransaction(b.Body.Transactions[i])
+	}
+	hash := n.bch.CalculateHash(b)
+	p.markKnown(InvVect{Type: InvBlock, Hash: hash})
+	p.countBlock()
+
+	if handled, bad, err := n.sync.handleBlock(p, b, hash); handled {
+		if bad != nil {
+			n.misbehaving(bad, scoreInvalidBlock, err)
+		}
+		return
+	}
+
+	status, err := n.bch.ProcessBlock(b)
+	switch {
+	case err == nil:
+		if status != blockchain.BlockSideChain {
+			log.Printf("p2p: block %x from %s %s, height %d", hash[:8], p.Addr(), status, n.bch.Len())
+		}
+	case errors.Is(err, d.ErrDuplicateBlock):
+	case errors.Is(err, d.ErrOrphanBlock):
+		n.sync.requestHeaders(p)
+	default:
+		n.misbehaving(p, scoreInvalidBlock, err)
+	}
+}
+
+func (n *Node) handleTx(p *Peer, payload []byte) {
+	r := bytes.NewReader(payload)
+	tx, err := d.DeserializeTransaction(r)
+	if err != nil || r.Len() != 0 {
+		n.misbehaving(p, scoreMalformed, ErrMalformedMessage)
+		return
+	}
+	tx.TxID = n.bch.HashTransaction(tx)
+	p.markKnown(InvVect{Type: InvTx, Hash: tx.TxID})
+
+	err = n.bch.SubmitTransaction(tx)
+	switch {
+	case err == nil:
+	case errors.Is(err, d.ErrInvalidSignature), errors.Is(err, d.ErrInvalidSigHashType),
+		errors.Is(err, d.ErrInvalidTransaction), errors.Is(err, d.ErrEmptyTransaction):
+		n.misbehaving(p, scoreInvalidTx, err)
+	}
+}
+
+// handleUsers registers public keys unknown so far and passes them on to the other peers.
+// Addresses are derived from the keys, so a peer cannot register a key under someone else's address.
+func (n *Node) handleUsers(from *Peer, keys []d.PublicKey) {
+	known := make(map[d.PublicAddress]bool)
+	for _, user := range n.bch.Users() {
+		known[user.PublicAddress] = true
+	}
+	var fresh []d.User
+	var freshKeys []d.PublicKey
+	for _, key := range keys {
+		address := d.PublicAddress(c.GenerateAddress(key[:]))
+		if known[address] {
+			continue
+		}
+		known[address] = true
+		fresh = append(fresh, d.User{PublicKey: key, PublicAddress: address})
+		freshKeys = append(freshKeys, key)
+	}
+	if len(fresh) == 0 {
+		return
+	}
+	n.bch.RegisterUsers(fresh)
+	payload := encodePublicKeys(freshKeys)
+	for _, p := range n.peerList() {
+		if p != from {
+			p.queue(CmdUsers, payload)
+		}
+	}
+}
+
+// relayAddr tells the other peers about a newly connected peer's listen address.
+func (n *Node) relayAddr(from *Peer, addr string) {
+	payload := encodeAddrs([]string{addr})
+	for _, p := range n.peerList() {
+		if p != from {
+			p.queue(CmdAddr, payload)
+		}
+	}
+}
+
+// announceLoop announces a changed tip to every peer as soon as the chain connects a
+// block, unless the node is downloading blocks, and new mempool transactions and the
+// tip every AnnounceInterval.
+func (n *Node) announceLoop(ctx context.Context) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.AnnounceInterval)
+	defer ticker.Stop()
+	sub := n.bch.Subscribe(16, blockchain.EventBlockConnected)
+	defer func() { sub.Unsubscribe() }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-sub.Events():
+			if !ok {
+				sub = n.bch.Subscribe(16, blockchain.EventBlockConnected)
+			}
+			// While downloading, the ticker announces the tip often enough.
+			if !n.SyncStatus().Syncing {
+				n.announce()
+			}
+		case <-ticker.C:
+			n.announce()
+		}
+	}
+}
+
+func (n *Node) announce() {
+	var items []InvVect
+	if hash, err := n.bch.GetLatestBlockHash(); err == nil {
+		if hash != n.announcedTip {
+			n.announcedTip = hash
+			items = append(items, InvVect{Type: InvBlock, Hash: hash})
+		}
+	}
+
+	txs := n.bch.Mempool().Transactions(0)
+	pending := make(map[d.Hash32]struct{}, len(txs))
+	for _, tx := range txs {
+		pending[tx.TxID] = struct{}{}
+		if _, done := n.announcedTxs[tx.TxID]; !done {
+			items = append(items, InvVect{Type: InvTx, Hash: tx.TxID})
+		}
+	}
+	n.announcedTxs = pending
+	if len(items) == 0 {
+		return
+	}
+
+	for _, p := range n.peerList() {
+		var unknown []InvVect
+		for _, item := range items {
+			if p.markKnown(item) {
+				unknown = append(unknown, item)
+			}
+		}
+		for len(unknown) > 0 {
+			batch := unknown[:min(len(unknown), MaxInvPerMessage)]
+			unknown = unknown[len(batch):]
+			p.queue(CmdInv, encodeInv(batch))
+		}
+	}
+}
+
+// stallLoop disconnects peers that hold up the block download, which hands their requests to other peers.
+func (n *Node) stallLoop(ctx context.Context) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.StallTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, p := range n.sync.stalledPeers(n.cfg.StallTimeout) {
+				log.Printf("p2p: peer %s stalled the block download, disconnecting", p.Addr())
+				p.Disconnect()
+			}
+		}
+	}
+}
+
+// isSelf reports whether addr is known to lead back to this node.
+func (n *Node) isSelf(addr string) bool {
+	if addr == n.Addr() {
+		return true
+	}
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+	return n.selfAddrs[addr]
+}
+
+func (n *Node) peerList() []*Peer {
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+	peers := make([]*Peer, 0, len(n.peers))
+	for p := range n.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func (n *Node) countInbound() int {
+	count := 0
+	for _, p := range n.peerList() {
+		if p.inbound {
+			count++
+		}
+	}
+	return count
+}
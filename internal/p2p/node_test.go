@@ -0,0 +1,346 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.DialInterval = 100 * time.Millisecond
+	cfg.AnnounceInterval = 20 * time.Millisecond
+	return cfg
+}
+
+// setupNetwork creates one funded chain and count-1 empty chains that have to sync from the network.
+func setupNetwork(t testing.TB, count int) ([]*blockchain.Blockchain, []d.User) {
+	t.Helper()
+	hasher := c.NewArchasHasher()
+	signer := c.NewTransactionSigner()
+	users := blockchain.NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob", "Charlie", "Dave"}, 4)
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+
+	chains := []*blockchain.Blockchain{blockchain.InitBlockchainWithFunds(10000, 10000, users, cfg, hasher, signer)}
+	for i := 1; i < count; i++ {
+		chains = append(chains, blockchain.NewBlockchain(hasher, signer))
+	}
+	return chains, users
+}
+
+func startNode(t testing.TB, ctx context.Context, bch *blockchain.Blockchain, cfg Config) *Node {
+	t.Helper()
+	node := NewNode(bch, cfg)
+	if err := node.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	return node
+}
+
+func tipHash(bch *blockchain.Blockchain) d.Hash32 {
+	tip, err := bch.GetLatestBlock()
+	if err != nil {
+		return d.Hash32{}
+	}
+	return bch.CalculateHash(tip)
+}
+
+// waitFor polls cond until it holds or the timeout expires.
+func waitFor(t testing.TB, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func converged(chains []*blockchain.Blockchain) bool {
+	want := tipHash(chains[0])
+	for _, bch := range chains[1:] {
+		if tipHash(bch) != want {
+			return false
+		}
+	}
+	return !want.IsZero()
+}
+
+func TestNodes_ConvergeOnOneChain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chains, users := setupNetwork(t, 3)
+	if err := chains[0].MineBlocks(ctx, 3, 5, 1, 10, users, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	nodes := make([]*Node, len(chains))
+	for i, bch := range chains {
+		nodes[i] = startNode(t, ctx, bch, testConfig())
+	}
+	// A line topology: node 2 only learns about node 0 through node 1.
+	if err := nodes[1].Connect(nodes[0].Addr()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := nodes[2].Connect(nodes[1].Addr()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	waitFor(t, 10*time.Second, "initial sync", func() bool { return converged(chains) })
+	if len(chains[2].Users()) != len(users) {
+		t.Errorf("Synced node knows %d users, want %d", len(chains[2].Users()), len(users))
+	}
+
+	if err := chains[0].MineBlocks(ctx, 2, 5, 1, 10, users, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	waitFor(t, 10*time.Second, "mined blocks to propagate", func() bool { return converged(chains) })
+
+	// Two nodes mining at once produce competing blocks that must be resolved.
+	done := make(chan error, 2)
+	for _, bch := range chains[1:] {
+		go func(bch *blockchain.Blockchain) {
+			done <- bch.MineBlocks(ctx, 3, 5, 1, 10, users, 1, 1)
+		}(bch)
+	}
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Fatalf("MineBlocks() error = %v", err)
+		}
+	}
+	waitFor(t, 10*time.Second, "competing blocks to propagate", func() bool {
+		return chains[0].Len() >= 9 && chains[0].Len() == chains[1].Len() && chains[1].Len() == chains[2].Len()
+	})
+	if err := chains[0].MineBlocks(ctx, 1, 5, 1, 10, users, 1, 1); err != nil {
+		t.Fatalf("MineBlocks() error = %v", err)
+	}
+	waitFor(t, 10*time.Second, "nodes to converge after a fork", func() bool { return converged(chains) })
+
+	tx := signedTransfer(t, chains, users)
+	if err := chains[2].SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	waitFor(t, 10*time.Second, "transaction relay", func() bool {
+		_, ok := chains[0].Mempool().Get(tx.TxID)
+		return ok
+	})
+
+	if len(nodes[0].Peers()) < 2 {
+		waitFor(t, 5*time.Second, "address gossip to connect node 2 and node 0", func() bool {
+			return len(nodes[0].Peers()) >= 2
+		})
+	}
+}
+
+// signedTransfer spends an output that no mempool in chains spends yet, since
+// blocks disconnected by a reorganization return their transactions to the mempool.
+func signedTransfer(t *testing.T, chains []*blockchain.Blockchain, users []d.User) d.Transaction {
+	t.Helper()
+	hasher := c.NewArchasHasher()
+	bch := chains[len(chains)-1]
+	for i, from := range users {
+		for _, utxo := range bch.GetUTXOsForAddress(from.PublicAddress) {
+			spent := false
+			for _, other := range chains {
+				spent = spent || other.Mempool().IsSpent(utxo.Outpoint)
+			}
+			if spent {
+				continue
+			}
+			tx := d.Transaction{
+				Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+				Outputs: []d.TxOutput{{Value: utxo.Value, To: users[(i+1)%len(users)].PublicAddress}},
+			}
+			tx.TxID = bch.HashTransaction(tx)
+			if err := blockchain.SignInput(&tx, 0, utxo, d.SigHashAll, from.GetPrivateKeyObject(), c.NewTransactionSigner(), hasher); err != nil {
+				t.Fatalf("SignInput() error = %v", err)
+			}
+			return tx
+		}
+	}
+	t.Fatal("No output left to spend")
+	return d.Transaction{}
+}
+
+// rawPeer performs the handshake by hand so the test can send arbitrary messages.
+// It claims to have a chain of the given height.
+func rawPeer(t *testing.T, addr string, bch *blockchain.Blockchain, height uint32) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	genesis, _ := bch.GetBlock(0)
+	version := VersionMsg{Version: ProtocolVersion, Height: height, Genesis: bch.CalculateHash(genesis), Nonce: 1}
+	if err := writeMessage(conn, Message{Command: CmdVersion, Payload: version.encode()}); err != nil {
+		t.Fatalf("writeMessage() error = %v", err)
+	}
+	if err := writeMessage(conn, Message{Command: CmdVerAck}); err != nil {
+		t.Fatalf("writeMessage() error = %v", err)
+	}
+	for _, want := range []string{CmdVersion, CmdVerAck} {
+		msg, err := readMessage(conn)
+		if err != nil || msg.Command != want {
+			t.Fatalf("handshake: got %q, %v; want %q", msg.Command, err, want)
+		}
+	}
+	return conn
+}
+
+// rawPeerAddr is the listen address a node sees for a rawPeer, which does not listen.
+const rawPeerAddr = "127.0.0.1:0"
+
+func TestNode_BansPeerSendingInvalidBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chains, users := setupNetwork(t, 2)
+	bch := chains[0]
+	node := startNode(t, ctx, bch, testConfig())
+
+	// An honest node on the same host must stay connected when the raw peer is banned.
+	honest := startNode(t, ctx, chains[1], testConfig())
+	if err := honest.Connect(node.Addr()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	honestConnected := func() bool {
+		for _, info := range node.Peers() {
+			if info.ListenAddr == honest.Addr() {
+				return true
+			}
+		}
+		return false
+	}
+	waitFor(t, 5*time.Second, "honest peer to connect", honestConnected)
+
+	conn := rawPeer(t, node.Addr(), bch, 0)
+	defer conn.Close()
+
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: 1000000, To: users[0].PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	body := d.Body{Transactions: []d.Transaction{coinbase}}
+	block := d.Block{
+		Header: d.Header{
+			Version:    1,
+			Timestamp:  uint32(time.Now().Unix()),
+			PrevHash:   tipHash(bch),
+			MerkleRoot: blockchain.MerkleRootHash(body, c.NewArchasHasher()),
+			Difficulty: 60, // no nonce meets this difficulty
+		},
+		Body: body,
+	}
+	if err := writeMessage(conn, Message{Command: CmdBlock, Payload: block.Serialize()}); err != nil {
+		t.Fatalf("writeMessage() error = %v", err)
+	}
+
+	waitFor(t, 5*time.Second, "peer to be banned", func() bool { return node.IsBanned(rawPeerAddr) })
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := readMessage(conn); err != nil {
+			break
+		}
+	}
+	if bch.Len() != 1 {
+		t.Errorf("Invalid block must not be connected, chain length = %d", bch.Len())
+	}
+	if node.IsBanned(honest.Addr()) || !honestConnected() {
+		t.Error("Honest peer on the same host should stay connected")
+	}
+
+	again := rawPeer(t, node.Addr(), bch, 0)
+	defer again.Close()
+	_ = again.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := readMessage(again); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Error("Banned peer should not be able to reconnect")
+			}
+			break
+		}
+	}
+}
+
+func TestNode_RejectsDifferentGenesis(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first, _ := setupNetwork(t, 1)
+	second, _ := setupNetwork(t, 1)
+	a := startNode(t, ctx, first[0], testConfig())
+	b := startNode(t, ctx, second[0], testConfig())
+
+	if err := b.Connect(a.Addr()); err != ErrGenesisMismatch {
+		t.Errorf("Connect() error = %v, want %v", err, ErrGenesisMismatch)
+	}
+}
+
+func TestMessages_RoundTrip(t *testing.T) {
+	inv := []InvVect{{Type: InvBlock, Hash: d.Hash32{0x01}}, {Type: InvTx, Hash: d.Hash32{0x02}}}
+	gotInv, err := decodeInv(encodeInv(inv))
+	if err != nil || len(gotInv) != 2 || gotInv[1] != inv[1] {
+		t.Errorf("decodeInv() = %v, %v", gotInv, err)
+	}
+
+	addrs := []string{"127.0.0.1:9333", "[::1]:9444"}
+	gotAddrs, err := decodeAddrs(encodeAddrs(addrs))
+	if err != nil || len(gotAddrs) != 2 || gotAddrs[1] != addrs[1] {
+		t.Errorf("decodeAddrs() = %v, %v", gotAddrs, err)
+	}
+
+	version := VersionMsg{Version: ProtocolVersion, Height: 7, Genesis: d.Hash32{0x03}, ListenPort: 9333, Nonce: 42}
+	if got, err := decodeVersion(version.encode()); err != nil || got != version {
+		t.Errorf("decodeVersion() = %+v, %v", got, err)
+	}
+
+	if _, err := decodeInv([]byte{0xff, 0xff, 0xff, 0xff}); err == nil {
+		t.Error("Expected error for an oversized inventory count")
+	}
+}
+
+func TestPeer_DisconnectsPeerThatDoesNotRead(t *testing.T) {
+	chains, _ := setupNetwork(t, 1)
+	cfg := testConfig()
+	cfg.WriteTimeout = 50 * time.Millisecond
+	node := NewNode(chains[0], cfg)
+
+	// Queuing never waits for the peer: a full queue disconnects it.
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	full := newPeer(node, conn, true, VersionMsg{}, rawPeerAddr)
+	done := make(chan struct{})
+	go func() {
+		for range sendQueueSize + 1 {
+			full.queue(CmdGetAddr, nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queue waited for a peer that does not read")
+	}
+	select {
+	case <-full.quit:
+	default:
+		t.Error("Peer with a full send queue should be disconnected")
+	}
+
+	// A write the peer does not read times out.
+	conn, remote = net.Pipe()
+	defer remote.Close()
+	stalled := newPeer(node, conn, true, VersionMsg{}, rawPeerAddr)
+	go stalled.writeLoop()
+	stalled.queue(CmdGetAddr, nil)
+	select {
+	case <-stalled.quit:
+	case <-time.After(time.Second):
+		t.Error("Peer that does not read should be disconnected after WriteTimeout")
+	}
+}
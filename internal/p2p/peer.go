@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	sendQueueSize   = 1024
+	maxKnownInvSize = 10000
+)
+
+// PeerInfo is a snapshot of a connected peer.
+type PeerInfo struct {
+	Addr       string
+	ListenAddr string
+	Inbound    bool
+	Height     uint32
+	BanScore   int
+	// BlocksReceived counts the blocks the peer sent, whether relayed or downloaded during sync.
+	BlocksReceived int
+}
+
+// Peer is an established connection that completed the version handshake.
+type Peer struct {
+	node       *Node
+	conn       net.Conn
+	inbound    bool
+	listenAddr string
+	version    VersionMsg
+
+	send      chan Message
+	quit      chan struct{}
+	closeOnce *sync.Once
+
+	mutex          *sync.Mutex
+	banScore       int
+	blocksReceived int
+	knownInv       map[InvVect]struct{}
+}
+
+func newPeer(node *Node, conn net.Conn, inbound bool, version VersionMsg, listenAddr string) *Peer {
+	return &Peer{
+		node:       node,
+		conn:       conn,
+		inbound:    inbound,
+		listenAddr: listenAddr,
+		version:    version,
+		send:       make(chan Message, sendQueueSize),
+		quit:       make(chan struct{}),
+		closeOnce:  &sync.Once{},
+		mutex:      &sync.Mutex{},
+		knownInv:   make(map[InvVect]struct{}),
+	}
+}
+
+func (p *Peer) Addr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+// banKey returns the listen address of the peer, which is what bans apply to, so
+// that banning one node does not ban the other nodes on its host.
+func (p *Peer) banKey() string {
+	return p.listenAddr
+}
+
+func (p *Peer) Info() PeerInfo {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return PeerInfo{
+		Addr:           p.Addr(),
+		ListenAddr:     p.listenAddr,
+		Inbound:        p.inbound,
+		Height:         p.version.Height,
+		BanScore:       p.banScore,
+		BlocksReceived: p.blocksReceived,
+	}
+}
+
+// queue schedules a message for sending without waiting for the peer. A peer that
+// lets its send queue fill up is not reading and is disconnected.
+func (p *Peer) queue(command string, payload []byte) {
+	select {
+	case p.send <- Message{Command: command, Payload: payload}:
+	case <-p.quit:
+	default:
+		log.Printf("p2p: disconnecting %s: send queue full", p.Addr())
+		p.Disconnect()
+	}
+}
+
+// Disconnect closes the connection. It is safe to call more than once.
+func (p *Peer) Disconnect() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		_ = p.conn.Close()
+	})
+}
+
+// markKnown records that the peer has inv, returning false if it was already known.
+func (p *Peer) markKnown(inv InvVect) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, known := p.knownInv[inv]; known {
+		return false
+	}
+	if len(p.knownInv) >= maxKnownInvSize {
+		p.knownInv = make(map[InvVect]struct{})
+	}
+	p.knownInv[inv] = struct{}{}
+	return true
+}
+
+// addBanScore increases the misbehavior score and returns the new total.
+func (p *Peer) addBanScore(score int) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.banScore += score
+	return p.banScore
+}
+
+func (p *Peer) countBlock() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.blocksReceived++
+}
+
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case msg := <-p.send:
+			_ = p.conn.SetWriteDeadline(time.Now().Add(p.node.cfg.WriteTimeout))
+			if err := writeMessage(p.conn, msg); err != nil {
+				p.Disconnect()
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *Peer) readLoop() {
+	defer p.Disconnect()
+	for {
+		msg, err := readMessage(p.conn)
+		if err != nil {
+			select {
+			case <-p.quit:
+			default:
+				log.Printf("p2p: disconnecting %s: %v", p.Addr(), err)
+			}
+			return
+		}
+		p.node.handleMessage(p, msg)
+	}
+}
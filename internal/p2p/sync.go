@@ -0,0 +1,470 @@
+package p2p
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// SyncStatus is a snapshot of the headers-first block download.
+type SyncStatus struct {
+	Syncing  bool
+	SyncPeer string
+	// Headers is the number of validated headers in the current download, counted
+	// from the block the download branches off from. Connected is how many of
+	// them already have their block connected.
+	Headers   int
+	Connected int
+	InFlight  int
+	// Downloaded counts every block received through sync since the node started.
+	Downloaded int
+	Started    time.Time
+}
+
+type blockRequest struct {
+	peer *Peer
+	sent time.Time
+}
+
+type receivedBlock struct {
+	block d.Block
+	from  *Peer
+}
+
+type syncPeerState struct {
+	inFlight int
+	// limit is the first header position the peer answered with notfound.
+	// It is not asked for blocks from there on.
+	limit int
+}
+
+// syncManager downloads the chain headers first. One sync peer is asked for
+// headers, which must link up through PrevHash and meet their difficulty. The
+// block bodies are then requested from every connected peer in parallel and
+// connected strictly in header order. An interrupted download resumes: requests
+// of a peer that disconnects or stalls go to the remaining peers and header
+// download continues from the last validated header.
+type syncManager struct {
+	node  *Node
+	mutex *sync.Mutex
+
+	syncPeer     *Peer
+	headersAsked time.Time
+	headersDone  bool
+
+	headers []d.Header
+	hashes  []d.Hash32
+	index   map[d.Hash32]int
+	// next is the first header position whose block is not connected yet.
+	next int
+	// requestedUpTo is the first header position that was never requested.
+	requestedUpTo int
+	// retry holds positions whose request failed and has to be sent again.
+	retry    []int
+	inFlight map[d.Hash32]blockRequest
+	received map[d.Hash32]receivedBlock
+
+	peers map[*Peer]*syncPeerState
+	// announced holds the last unknown block each peer announced while the
+	// download was running, to follow up on once it finishes.
+	announced  map[*Peer]d.Hash32
+	started    time.Time
+	downloaded int
+}
+
+func newSyncManager(node *Node) *syncManager {
+	return &syncManager{
+		node:      node,
+		mutex:     &sync.Mutex{},
+		index:     make(map[d.Hash32]int),
+		inFlight:  make(map[d.Hash32]blockRequest),
+		received:  make(map[d.Hash32]receivedBlock),
+		peers:     make(map[*Peer]*syncPeerState),
+		announced: make(map[*Peer]d.Hash32),
+	}
+}
+
+func (s *syncManager) status() SyncStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st := SyncStatus{
+		Syncing:    s.syncPeer != nil || s.next < len(s.headers),
+		Headers:    len(s.headers),
+		Connected:  s.next,
+		InFlight:   len(s.inFlight),
+		Downloaded: s.downloaded,
+		Started:    s.started,
+	}
+	if s.syncPeer != nil {
+		st.SyncPeer = s.syncPeer.Addr()
+	}
+	return st
+}
+
+// blockAnnounced reports whether a download is running, in which case the
+// announced block is not fetched on its own but remembered for later.
+func (s *syncManager) blockAnnounced(p *Peer, hash d.Hash32) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.syncPeer == nil {
+		return false
+	}
+	s.announced[p] = hash
+	return true
+}
+
+// peerConnected starts a download from a peer that has a longer chain, or lets
+// the peer help with a download already in progress.
+func (s *syncManager) peerConnected(p *Peer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.peers[p] = &syncPeerState{limit: math.MaxInt}
+	if s.syncPeer == nil && p.version.Height > uint32(s.node.bch.Len()) {
+		s.startLocked(p)
+	}
+	s.scheduleLocked()
+}
+
+// peerDisconnected hands the peer's outstanding requests to the other peers and
+// picks a new sync peer if it was the one providing headers.
+func (s *syncManager) peerDisconnected(p *Peer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.peers, p)
+	delete(s.announced, p)
+	for hash, req := range s.inFlight {
+		if req.peer == p {
+			delete(s.inFlight, hash)
+			s.retry = append(s.retry, s.index[hash])
+		}
+	}
+	if s.syncPeer == p {
+		s.syncPeer = nil
+		if best := s.bestPeerLocked(nil); best != nil {
+			log.Printf("p2p: sync peer %s disconnected, resuming from %s", p.Addr(), best.Addr())
+			s.startLocked(best)
+		}
+	}
+	s.scheduleLocked()
+}
+
+// requestHeaders starts a download from p unless one is already running. It is
+// used when p relays a block whose parent is unknown.
+func (s *syncManager) requestHeaders(p *Peer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.syncPeer == nil {
+		s.startLocked(p)
+	}
+}
+
+func (s *syncManager) startLocked(p *Peer) {
+	if s.next >= len(s.headers) {
+		s.started = time.Now()
+	}
+	s.syncPeer = p
+	log.Printf("p2p: syncing headers from %s (peer height %d, ours %d)", p.Addr(), p.version.Height, s.node.bch.Len())
+	s.askHeadersLocked()
+}
+
+func (s *syncManager) askHeadersLocked() {
+	s.headersDone = false
+	s.headersAsked = time.Now()
+	s.syncPeer.queue(CmdGetHeaders, encodeHashes(s.locatorLocked()))
+}
+
+// locatorLocked extends the chain's block locator with the last validated
+// header, so that header download continues where it stopped.
+func (s *syncManager) locatorLocked() []d.Hash32 {
+	locator := s.node.bch.BlockLocator()
+	if s.next < len(s.headers) {
+		locator = append([]d.Hash32{s.hashes[len(s.hashes)-1]}, locator...)
+	}
+	return locator
+}
+
+// bestPeerLocked returns the peer that announced the longest chain, if it is
+// worth syncing from.
+func (s *syncManager) bestPeerLocked(exclude *Peer) *Peer {
+	var best *Peer
+	for p := range s.peers {
+		if p != exclude && (best == nil || p.version.Height > best.version.Height) {
+			best = p
+		}
+	}
+	if best == nil || (best.version.Height <= uint32(s.node.bch.Len()) && s.next >= len(s.headers)) {
+		return nil
+	}
+	return best
+}
+
+// handleHeaders validates a headers message from the sync peer and queues the
+// new headers for download. An error means the peer sent invalid headers.
+func (s *syncManager) handleHeaders(p *Peer, headers []d.Header) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if p != s.syncPeer {
+		return nil
+	}
+	s.headersAsked = time.Time{}
+	if len(headers) == 0 {
+		s.headersDone = true
+		s.finishIfDoneLocked(false)
+		return nil
+	}
+
+	bch := s.node.bch
+	prev := headers[0].PrevHash
+	if pos, ok := s.index[prev]; ok {
+		s.truncateLocked(pos + 1)
+	} else if bch.HaveBlock(prev) || (prev.IsZero() && bch.Len() == 0) {
+		s.truncateLocked(0)
+	} else {
+		return d.ErrOrphanBlock
+	}
+
+	for _, header := range headers {
+		if header.PrevHash != prev {
+			return d.ErrInvalidPrevHash
+		}
+		hash := bch.CalculateHash(d.Block{Header: header})
+		if !header.PrevHash.IsZero() && !bch.CheckProofOfWork(header) {
+			return d.ErrInvalidDifficulty
+		}
+		s.index[hash] = len(s.headers)
+		s.headers = append(s.headers, header)
+		s.hashes = append(s.hashes, hash)
+		prev = hash
+	}
+	log.Printf("p2p: received %d headers from %s, %d blocks left to download", len(headers), p.Addr(), len(s.headers)-s.next)
+
+	if len(headers) == MaxHeadersPerMessage {
+		s.askHeadersLocked()
+	} else {
+		s.headersDone = true
+	}
+	// Headers of blocks the chain already has, such as a branch with less work,
+	// need no download and must not cause another round of getheaders.
+	if _, err := s.connectLocked(); err != nil {
+		return err
+	}
+	s.finishIfDoneLocked(false)
+	s.scheduleLocked()
+	return nil
+}
+
+// truncateLocked drops the headers from position pos on, together with their requests.
+func (s *syncManager) truncateLocked(pos int) {
+	for _, hash := range s.hashes[pos:] {
+		delete(s.index, hash)
+		delete(s.received, hash)
+		if req, ok := s.inFlight[hash]; ok {
+			delete(s.inFlight, hash)
+			if st := s.peers[req.peer]; st != nil {
+				st.inFlight--
+			}
+		}
+	}
+	for _, st := range s.peers {
+		if st.limit >= pos {
+			st.limit = math.MaxInt
+		}
+	}
+	s.headers = s.headers[:pos]
+	s.hashes = s.hashes[:pos]
+	s.next = min(s.next, pos)
+	s.requestedUpTo = min(s.requestedUpTo, pos)
+	retry := s.retry[:0]
+	for _, p := range s.retry {
+		if p < pos {
+			retry = append(retry, p)
+		}
+	}
+	s.retry = retry
+}
+
+// handleBlock takes a block that belongs to the download and connects every
+// block that is now next in line. It reports false for blocks it does not
+// expect. If a block turns out invalid, the peer that sent it is returned with
+// the validation error and the download starts over.
+func (s *syncManager) handleBlock(p *Peer, b d.Block, hash d.Hash32) (bool, *Peer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pos, ok := s.index[hash]
+	if !ok {
+		return false, nil, nil
+	}
+	if req, ok := s.inFlight[hash]; ok {
+		delete(s.inFlight, hash)
+		if st := s.peers[req.peer]; st != nil {
+			st.inFlight--
+		}
+	}
+	if pos < s.next {
+		return true, nil, nil
+	}
+	s.received[hash] = receivedBlock{block: b, from: p}
+	s.downloaded++
+
+	if bad, err := s.connectLocked(); err != nil {
+		s.truncateLocked(0)
+		s.syncPeer = nil
+		if best := s.bestPeerLocked(bad); best != nil {
+			s.startLocked(best)
+		}
+		return true, bad, err
+	}
+	s.finishIfDoneLocked(true)
+	s.scheduleLocked()
+	return true, nil, nil
+}
+
+// connectLocked connects the received blocks that are next in header order and
+// skips blocks the chain already has. It returns the sender of an invalid block.
+func (s *syncManager) connectLocked() (*Peer, error) {
+	bch := s.node.bch
+	for s.next < len(s.headers) {
+		next := s.hashes[s.next]
+		rb, ok := s.received[next]
+		if !ok {
+			if !bch.HaveBlock(next) {
+				return nil, nil
+			}
+			s.next++
+			continue
+		}
+		delete(s.received, next)
+		if _, err := bch.ProcessBlock(rb.block); err != nil && !errors.Is(err, d.ErrDuplicateBlock) {
+			return rb.from, err
+		}
+		s.next++
+	}
+	return nil, nil
+}
+
+// finishIfDoneLocked ends the download once every header has its block. After
+// downloading blocks the sync peer is asked once more with askAgain, since it
+// may have found new blocks in the meantime.
+func (s *syncManager) finishIfDoneLocked(askAgain bool) {
+	if s.syncPeer == nil || s.next < len(s.headers) || !s.headersDone || !s.headersAsked.IsZero() {
+		return
+	}
+	s.truncateLocked(0)
+	if askAgain {
+		s.askHeadersLocked()
+		return
+	}
+	for p, hash := range s.announced {
+		delete(s.announced, p)
+		if p != s.syncPeer && !s.node.bch.HaveBlock(hash) {
+			s.startLocked(p)
+			return
+		}
+	}
+	log.Printf("p2p: sync finished at height %d after %s", s.node.bch.Len(), time.Since(s.started).Round(time.Millisecond))
+	s.syncPeer = nil
+}
+
+// handleNotFound reschedules blocks the peer does not have and stops asking it
+// for blocks from that height on.
+func (s *syncManager) handleNotFound(p *Peer, items []InvVect) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st := s.peers[p]
+	for _, item := range items {
+		req, ok := s.inFlight[item.Hash]
+		if item.Type != InvBlock || !ok || req.peer != p {
+			continue
+		}
+		delete(s.inFlight, item.Hash)
+		pos := s.index[item.Hash]
+		if st != nil {
+			st.inFlight--
+			st.limit = min(st.limit, pos)
+		}
+		s.retry = append(s.retry, pos)
+	}
+	s.scheduleLocked()
+}
+
+// scheduleLocked requests blocks inside the download window from the peers with
+// the fewest outstanding requests.
+func (s *syncManager) scheduleLocked() {
+	want := s.retry
+	s.retry = nil
+	for s.requestedUpTo < len(s.headers) && s.requestedUpTo < s.next+s.node.cfg.DownloadWindow {
+		want = append(want, s.requestedUpTo)
+		s.requestedUpTo++
+	}
+
+	now := time.Now()
+	batches := make(map[*Peer][]InvVect)
+	for i, pos := range want {
+		if pos < s.next {
+			continue
+		}
+		hash := s.hashes[pos]
+		if _, ok := s.inFlight[hash]; ok {
+			continue
+		}
+		if _, ok := s.received[hash]; ok {
+			continue
+		}
+		if s.node.bch.HaveBlock(hash) {
+			continue
+		}
+		p := s.pickPeerLocked(pos)
+		if p == nil {
+			s.retry = append(s.retry, want[i:]...)
+			break
+		}
+		s.inFlight[hash] = blockRequest{peer: p, sent: now}
+		s.peers[p].inFlight++
+		batches[p] = append(batches[p], InvVect{Type: InvBlock, Hash: hash})
+	}
+	for p, items := range batches {
+		p.queue(CmdGetData, encodeInv(items))
+	}
+	if len(s.inFlight) == 0 && len(s.retry) > 0 && len(s.peers) > 0 {
+		log.Printf("p2p: no peer can provide block %x, abandoning the download", s.hashes[s.retry[0]][:8])
+		s.truncateLocked(0)
+		s.syncPeer = nil
+	}
+}
+
+func (s *syncManager) pickPeerLocked(pos int) *Peer {
+	var best *Peer
+	for p, st := range s.peers {
+		if st.inFlight >= s.node.cfg.MaxBlocksInFlight || pos >= st.limit {
+			continue
+		}
+		if best == nil || st.inFlight < s.peers[best].inFlight {
+			best = p
+		}
+	}
+	return best
+}
+
+// stalledPeers returns the peers that left a sync request unanswered for longer than timeout.
+func (s *syncManager) stalledPeers(timeout time.Duration) []*Peer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stalled := make(map[*Peer]bool)
+	for _, req := range s.inFlight {
+		if time.Since(req.sent) > timeout {
+			stalled[req.peer] = true
+		}
+	}
+	if s.syncPeer != nil && !s.headersAsked.IsZero() && time.Since(s.headersAsked) > timeout {
+		stalled[s.syncPeer] = true
+	}
+	peers := make([]*Peer, 0, len(stalled))
+	for p := range stalled {
+		peers = append(peers, p)
+	}
+	return peers
+}
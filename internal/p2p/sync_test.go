@@ -0,0 +1,229 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// buildChain extends a funded genesis block with coinbase-only blocks until the chain has length blocks.
+func buildChain(t testing.TB, length int, difficulty uint32) *blockchain.Blockchain {
+	t.Helper()
+	chains, users := setupNetwork(t, 1)
+	bch := chains[0]
+	hasher := c.NewArchasHasher()
+	for bch.Len() < length {
+		tip, _ := bch.GetLatestBlock()
+		// The value makes every coinbase, and therefore every UTXO key, unique.
+		coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: uint32(bch.Len()), To: users[bch.Len()%len(users)].PublicAddress}}}
+		coinbase.TxID = bch.HashTransaction(coinbase)
+		body := d.Body{Transactions: []d.Transaction{coinbase}}
+		header := d.Header{
+			Version:    1,
+			Timestamp:  uint32(time.Now().Unix()),
+			PrevHash:   bch.CalculateHash(tip),
+			MerkleRoot: blockchain.MerkleRootHash(body, hasher),
+			Difficulty: difficulty,
+		}
+		if _, _, err := blockchain.FindValidNonce(context.Background(), &header, hasher); err != nil {
+			t.Fatalf("FindValidNonce() error = %v", err)
+		}
+		if err := bch.AddBlock(d.Block{Header: header, Body: body}); err != nil {
+			t.Fatalf("AddBlock() error = %v", err)
+		}
+	}
+	return bch
+}
+
+// copyChain returns an independent Blockchain holding the same blocks as src.
+func copyChain(t testing.TB, src *blockchain.Blockchain) *blockchain.Blockchain {
+	t.Helper()
+	dst := blockchain.NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner())
+	dst.RegisterUsers(src.Users())
+	for _, b := range src.Blocks() {
+		if _, err := dst.ProcessBlock(b); err != nil {
+			t.Fatalf("ProcessBlock() error = %v", err)
+		}
+	}
+	return dst
+}
+
+func peerByAddr(node *Node, addr string) (PeerInfo, bool) {
+	for _, info := range node.Peers() {
+		if info.ListenAddr == addr {
+			return info, true
+		}
+	}
+	return PeerInfo{}, false
+}
+
+func TestSync_DownloadsFromSeveralPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// More blocks than fit in one headers message.
+	length := MaxHeadersPerMessage + 300
+	chains := []*blockchain.Blockchain{buildChain(t, length, 1)}
+	chains = append(chains, copyChain(t, chains[0]), blockchain.NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner()))
+
+	a := startNode(t, ctx, chains[0], testConfig())
+	b := startNode(t, ctx, chains[1], testConfig())
+	syncing := startNode(t, ctx, chains[2], testConfig())
+	for _, source := range []*Node{a, b} {
+		if err := syncing.Connect(source.Addr()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+	}
+
+	waitFor(t, 20*time.Second, "initial block download", func() bool { return converged(chains) })
+	waitFor(t, 5*time.Second, "sync to finish", func() bool { return !syncing.SyncStatus().Syncing })
+	for _, source := range []*Node{a, b} {
+		info, ok := peerByAddr(syncing, source.Addr())
+		if !ok || info.BlocksReceived == 0 {
+			t.Errorf("Peer %s should have served part of the download, got %+v", source.Addr(), info)
+		}
+	}
+	if got := syncing.SyncStatus().Downloaded; got < length {
+		t.Errorf("SyncStatus().Downloaded = %d, want at least %d", got, length)
+	}
+}
+
+func TestSync_ResumesAfterInterruption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	length := MaxHeadersPerMessage + 300
+	source := buildChain(t, length, 1)
+	backup := copyChain(t, source)
+	bch := blockchain.NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner())
+
+	firstCtx, interrupt := context.WithCancel(ctx)
+	first := startNode(t, firstCtx, source, testConfig())
+	syncing := startNode(t, ctx, bch, testConfig())
+	if err := syncing.Connect(first.Addr()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	waitFor(t, 20*time.Second, "download to begin", func() bool { return bch.Len() >= length/4 })
+	interrupt()
+	first.Wait()
+	waitFor(t, 5*time.Second, "interrupted peer to disconnect", func() bool { return len(syncing.Peers()) == 0 })
+
+	done := bch.Len()
+	if done >= length {
+		t.Skip("Download finished before it could be interrupted")
+	}
+	if !syncing.SyncStatus().Syncing {
+		t.Error("Interrupted download should still be pending")
+	}
+
+	second := startNode(t, ctx, backup, testConfig())
+	if err := syncing.Connect(second.Addr()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	waitFor(t, 20*time.Second, "download to resume", func() bool {
+		return converged([]*blockchain.Blockchain{backup, bch})
+	})
+	info, _ := peerByAddr(syncing, second.Addr())
+	if info.BlocksReceived > length-done {
+		t.Errorf("Second peer served %d blocks, want at most the %d still missing", info.BlocksReceived, length-done)
+	}
+}
+
+func TestSync_BansPeerSendingInvalidHeaders(t *testing.T) {
+	bch := buildChain(t, 2, 1)
+	tip, _ := bch.GetLatestBlock()
+	valid := d.Header{Version: 1, Timestamp: uint32(time.Now().Unix()), PrevHash: bch.CalculateHash(tip), MerkleRoot: d.Hash32{0x01}, Difficulty: 1}
+	if _, _, err := blockchain.FindValidNonce(context.Background(), &valid, c.NewArchasHasher()); err != nil {
+		t.Fatalf("FindValidNonce() error = %v", err)
+	}
+
+	badWork := valid
+	badWork.PrevHash = bch.CalculateHash(d.Block{Header: valid})
+	badWork.Difficulty = 60 // no nonce meets this difficulty
+	noWork := badWork
+	noWork.Difficulty = 0 // every hash meets this difficulty, but it is below the chain's minimum
+	badLink := valid
+	badLink.PrevHash = d.Hash32{0x02}
+
+	tests := []struct {
+		name    string
+		headers []d.Header
+	}{
+		{"proof of work below difficulty", []d.Header{valid, badWork}},
+		{"difficulty below the minimum", []d.Header{valid, noWork}},
+		{"broken PrevHash linkage", []d.Header{valid, badLink}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			node := startNode(t, ctx, copyChain(t, bch), testConfig())
+
+			conn := rawPeer(t, node.Addr(), bch, 100)
+			defer conn.Close()
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for {
+				msg, err := readMessage(conn)
+				if err != nil {
+					t.Fatalf("Node did not ask for headers: %v", err)
+				}
+				if msg.Command == CmdGetHeaders {
+					break
+				}
+			}
+			if err := writeMessage(conn, Message{Command: CmdHeaders, Payload: encodeHeaders(tt.headers)}); err != nil {
+				t.Fatalf("writeMessage() error = %v", err)
+			}
+			waitFor(t, 5*time.Second, "peer to be banned", func() bool { return node.IsBanned(rawPeerAddr) })
+		})
+	}
+}
+
+// BenchmarkSync measures how long an empty node takes to download a chain of
+// simulated blocks from one and from three peers over loopback TCP. The chains
+// are mined at difficulty 1, so building the 100k block chain takes a while:
+//
+//	go test ./internal/p2p -run '^$' -bench Sync -benchtime 1x -timeout 30m
+func BenchmarkSync(b *testing.B) {
+	for _, length := range []int{1000, 10000, 100000} {
+		source := buildChain(b, length, 1)
+		for _, peers := range []int{1, 3} {
+			sources := []*blockchain.Blockchain{source}
+			for len(sources) < peers {
+				sources = append(sources, copyChain(b, source))
+			}
+			b.Run(fmt.Sprintf("blocks=%d/peers=%d", length, peers), func(b *testing.B) {
+				for range b.N {
+					benchmarkSync(b, sources)
+				}
+				b.ReportMetric(float64(length)*float64(b.N)/b.Elapsed().Seconds(), "blocks/s")
+			})
+		}
+	}
+}
+
+func benchmarkSync(b *testing.B, sources []*blockchain.Blockchain) {
+	b.StopTimer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var addrs []string
+	for _, source := range sources {
+		addrs = append(addrs, startNode(b, ctx, source, testConfig()).Addr())
+	}
+	bch := blockchain.NewBlockchain(c.NewArchasHasher(), c.NewTransactionSigner())
+	syncing := startNode(b, ctx, bch, testConfig())
+
+	b.StartTimer()
+	for _, addr := range addrs {
+		if err := syncing.Connect(addr); err != nil {
+			b.Fatalf("Connect() error = %v", err)
+		}
+	}
+	waitFor(b, time.Hour, "initial block download", func() bool { return bch.Len() == sources[0].Len() })
+	b.StopTimer()
+}
@@ -0,0 +1,281 @@
+// Package pool keeps the share accounting of a mining pool and splits the reward of
+// every block the pool finds between its workers' payout addresses, either by PPLNS
+// (pay per last N shares) or proportionally to the shares of the round.
+package pool
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+var (
+	ErrUnknownScheme = errors.New("pool: unknown payout scheme")
+	ErrInvalidConfig = errors.New("pool: invalid configuration")
+	ErrNoOperator    = errors.New("pool: no operator address")
+)
+
+// maxRounds bounds the rounds a Pool remembers.
+const maxRounds = 100
+
+// Scheme selects how a block reward is split between the shares.
+type Scheme int
+
+const (
+	// PPLNS pays the most recent shares worth Window blocks of work, no matter when
+	// the pool found its previous block, so hopping between pools does not pay off.
+	PPLNS Scheme = iota
+	// Proportional pays the shares of the round, those submitted since the pool's
+	// previous block, in proportion to their work.
+	Proportional
+)
+
+func (s Scheme) String() string {
+	switch s {
+	case PPLNS:
+		return "pplns"
+	case Proportional:
+		return "proportional"
+	}
+	return "unknown"
+}
+
+// ParseScheme returns the scheme called name: "pplns" or "proportional".
+func ParseScheme(name string) (Scheme, error) {
+	switch name {
+	case "pplns":
+		return PPLNS, nil
+	case "proportional", "prop":
+		return Proportional, nil
+	}
+	return 0, ErrUnknownScheme
+}
+
+type Config struct {
+	Scheme Scheme
+	// Window is N of PPLNS as a multiple of the expected work of a block: a reward is
+	// split between the last shares whose work adds up to Window blocks.
+	Window float64
+	// Fee is the fraction of every reward the pool keeps.
+	Fee float64
+	// Operator receives the fee and the rewards found while no share is known. New
+	// rejects the zero address, which nobody can spend from.
+	Operator d.PublicAddress
+}
+
+// DefaultConfig returns the default scheme, window and fee. It leaves Operator unset,
+// so the caller has to pick one.
+func DefaultConfig() Config {
+	return Config{
+		Scheme: PPLNS,
+		Window: 2,
+		Fee:    0.02,
+	}
+}
+
+// Share is a nonce a worker found at the share difficulty. Work is the expected
+// number of hashes behind it.
+type Share struct {
+	Worker  string
+	Address d.PublicAddress
+	Work    float64
+	At      time.Time
+}
+
+// Snapshot is the split of a reward between the shares known when it was taken.
+type Snapshot struct {
+	Payouts []d.TxOutput
+	// Shares and Work count the shares the reward was split between; a share cut by
+	// the PPLNS window counts fully in Shares and in part in Work.
+	Shares int
+	Work   float64
+	// seq is the number of shares submitted before the snapshot.
+	seq uint64
+}
+
+// Round is a block found by the pool and what its coinbase paid.
+type Round struct {
+	Height int
+	Snapshot
+}
+
+// Pool records shares and splits rewards between them. It is safe for concurrent use.
+type Pool struct {
+	cfg Config
+
+	mutex *sync.Mutex
+	// shares holds the shares that can still be paid, oldest first; seq is the
+	// sequence number of shares[0].
+	shares []Share
+	seq    uint64
+	work   float64
+	// blockWork is the block work of the last snapshot, which bounds the PPLNS window.
+	blockWork float64
+	rounds    []Round
+	paid      map[d.PublicAddress]uint64
+}
+
+func New(cfg Config) (*Pool, error) {
+	if cfg.Fee < 0 || cfg.Fee > 1 || (cfg.Scheme == PPLNS && cfg.Window <= 0) {
+		return nil, ErrInvalidConfig
+	}
+	if cfg.Scheme != PPLNS && cfg.Scheme != Proportional {
+		return nil, ErrUnknownScheme
+	}
+	if cfg.Operator == (d.PublicAddress{}) {
+		return nil, ErrNoOperator
+	}
+	return &Pool{
+		cfg:   cfg,
+		mutex: &sync.Mutex{},
+		paid:  make(map[d.PublicAddress]uint64),
+	}, nil
+}
+
+func (p *Pool) Config() Config {
+	return p.cfg
+}
+
+// AddShare records an accepted share.
+func (p *Pool) AddShare(s Share) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.shares = append(p.shares, s)
+	p.work += s.Work
+	if p.cfg.Scheme == PPLNS && p.blockWork > 0 {
+		p.trimLocked()
+	}
+}
+
+// trimLocked drops the oldest shares while the rest still fill the PPLNS window.
+func (p *Pool) trimLocked() {
+	window := p.cfg.Window * p.blockWork
+	n := 0
+	for work := p.work; n < len(p.shares) && work-p.shares[n].Work >= window; n++ {
+		work -= p.shares[n].Work
+	}
+	p.dropLocked(n)
+}
+
+// dropLocked drops the n oldest shares.
+func (p *Pool) dropLocked(n int) {
+	for _, s := range p.shares[:n] {
+		p.work -= s.Work
+	}
+	p.seq += uint64(n)
+	p.shares = p.shares[n:]
+}
+
+// Snapshot splits reward between the shares the scheme pays right now. blockWork is
+// the expected work of a block, which sizes the PPLNS window.
+func (p *Pool) Snapshot(reward uint32, blockWork float64) Snapshot {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	snap := Snapshot{seq: p.seq + uint64(len(p.shares))}
+	weights := make(map[d.PublicAddress]float64)
+	switch p.cfg.Scheme {
+	case PPLNS:
+		p.blockWork = blockWork
+		p.trimLocked()
+		window := p.cfg.Window * blockWork
+		for i := len(p.shares) - 1; i >= 0 && snap.Work < window; i-- {
+			work := min(p.shares[i].Work, window-snap.Work)
+			weights[p.shares[i].Address] += work
+			snap.Work += work
+			snap.Shares++
+		}
+	case Proportional:
+		for _, s := range p.shares {
+			weights[s.Address] += s.Work
+			snap.Work += s.Work
+			snap.Shares++
+		}
+	}
+	snap.Payouts = p.split(reward, weights, snap.Work)
+	return snap
+}
+
+// split pays the fee to the operator and the rest by weight. Rounding down leaves a
+// few units over, which go one each to the largest remainders, so that small workers
+// are not paid less than their weight. The outputs are sorted by address and never zero.
+func (p *Pool) split(reward uint32, weights map[d.PublicAddress]float64, total float64) []d.TxOutput {
+	values := make(map[d.PublicAddress]uint32)
+	var paid uint32
+	if total > 0 {
+		rest := reward - uint32(float64(reward)*p.cfg.Fee)
+		type remainder struct {
+			address  d.PublicAddress
+			fraction float64
+		}
+		remainders := make([]remainder, 0, len(weights))
+		for address, weight := range weights {
+			exact := float64(rest) * weight / total
+			v := uint32(exact)
+			values[address] = v
+			paid += v
+			remainders = append(remainders, remainder{address, exact - float64(v)})
+		}
+		sort.Slice(remainders, func(i, j int) bool {
+			if remainders[i].fraction != remainders[j].fraction {
+				return remainders[i].fraction > remainders[j].fraction
+			}
+			return bytes.Compare(remainders[i].address[:], remainders[j].address[:]) < 0
+		})
+		for i := 0; paid < rest && i < len(remainders); i++ {
+			values[remainders[i].address]++
+			paid++
+		}
+	}
+	values[p.cfg.Operator] += reward - paid
+
+	outputs := make([]d.TxOutput, 0, len(values))
+	for address, v := range values {
+		if v > 0 {
+			outputs = append(outputs, d.TxOutput{To: address, Value: v})
+		}
+	}
+	sort.Slice(outputs, func(i, j int) bool { return bytes.Compare(outputs[i].To[:], outputs[j].To[:]) < 0 })
+	return outputs
+}
+
+// BlockFound records that the block at height paid snap. A proportional pool starts
+// a new round with the shares submitted after the snapshot was taken.
+func (p *Pool) BlockFound(height int, snap Snapshot) Round {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cfg.Scheme == Proportional && snap.seq > p.seq {
+		p.dropLocked(min(int(snap.seq-p.seq), len(p.shares)))
+	}
+	for _, out := range snap.Payouts {
+		p.paid[out.To] += uint64(out.Value)
+	}
+	round := Round{Height: height, Snapshot: snap}
+	p.rounds = append(p.rounds, round)
+	if len(p.rounds) > maxRounds {
+		p.rounds = p.rounds[len(p.rounds)-maxRounds:]
+	}
+	return round
+}
+
+// Rounds returns the last blocks the pool found, oldest first.
+func (p *Pool) Rounds() []Round {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]Round(nil), p.rounds...)
+}
+
+// Paid returns the total every address was paid by the blocks the pool found.
+func (p *Pool) Paid() map[d.PublicAddress]uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	paid := make(map[d.PublicAddress]uint64, len(p.paid))
+	for address, v := range p.paid {
+		paid[address] = v
+	}
+	return paid
+}
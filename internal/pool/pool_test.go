@@ -0,0 +1,179 @@
+package pool
+
+import (
+	"reflect"
+	"testing"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+var (
+	operator = d.PublicAddress{0xff}
+	alice    = d.PublicAddress{1}
+	bob      = d.PublicAddress{2}
+)
+
+func newTestPool(t *testing.T, scheme Scheme, fee float64) *Pool {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Scheme = scheme
+	cfg.Fee = fee
+	cfg.Operator = operator
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return p
+}
+
+func addShares(p *Pool, address d.PublicAddress, n int, work float64) {
+	for range n {
+		p.AddShare(Share{Address: address, Work: work})
+	}
+}
+
+func TestParseScheme(t *testing.T) {
+	for _, scheme := range []Scheme{PPLNS, Proportional} {
+		got, err := ParseScheme(scheme.String())
+		if err != nil || got != scheme {
+			t.Errorf("ParseScheme(%q) = %v, %v", scheme, got, err)
+		}
+	}
+	if _, err := ParseScheme("pps"); err != ErrUnknownScheme {
+		t.Errorf("ParseScheme(pps) error = %v, want %v", err, ErrUnknownScheme)
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   error
+	}{
+		{"negative fee", func(c *Config) { c.Fee = -0.1 }, ErrInvalidConfig},
+		{"fee above one", func(c *Config) { c.Fee = 1.5 }, ErrInvalidConfig},
+		{"empty window", func(c *Config) { c.Window = 0 }, ErrInvalidConfig},
+		{"unknown scheme", func(c *Config) { c.Scheme = 7 }, ErrUnknownScheme},
+		{"no operator", func(c *Config) { c.Operator = d.PublicAddress{} }, ErrNoOperator},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Operator = operator
+		tt.modify(&cfg)
+		if _, err := New(cfg); err != tt.want {
+			t.Errorf("%s: New() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := New(DefaultConfig()); err != ErrNoOperator {
+		t.Errorf("New(DefaultConfig()) error = %v, want %v", err, ErrNoOperator)
+	}
+}
+
+func TestSnapshot_PPLNSPaysLastWindow(t *testing.T) {
+	p := newTestPool(t, PPLNS, 0)
+	addShares(p, alice, 10, 1)
+	addShares(p, bob, 4, 1)
+
+	// Window 2 of block work 4: the last 8 shares, four of each.
+	snap := p.Snapshot(50, 4)
+	want := []d.TxOutput{{To: alice, Value: 25}, {To: bob, Value: 25}}
+	if !reflect.DeepEqual(snap.Payouts, want) || snap.Shares != 8 || snap.Work != 8 {
+		t.Errorf("Snapshot() = %+v, want %v from 8 shares", snap, want)
+	}
+
+	// A block does not reset the window.
+	p.BlockFound(1, snap)
+	if again := p.Snapshot(50, 4); !reflect.DeepEqual(again.Payouts, want) {
+		t.Errorf("Snapshot() after a block = %v, want %v", again.Payouts, want)
+	}
+}
+
+func TestSnapshot_PPLNSCutsOldestShare(t *testing.T) {
+	p := newTestPool(t, PPLNS, 0)
+	addShares(p, alice, 1, 4)
+	addShares(p, bob, 3, 2)
+
+	// Window 2 of block work 4 is 8: bob's 6 and half of alice's share.
+	snap := p.Snapshot(40, 4)
+	want := []d.TxOutput{{To: alice, Value: 10}, {To: bob, Value: 30}}
+	if !reflect.DeepEqual(snap.Payouts, want) || snap.Shares != 4 || snap.Work != 8 {
+		t.Errorf("Snapshot() = %+v, want %v", snap, want)
+	}
+}
+
+func TestSnapshot_ProportionalRounds(t *testing.T) {
+	p := newTestPool(t, Proportional, 0)
+	addShares(p, alice, 3, 1)
+	addShares(p, bob, 1, 1)
+	snap := p.Snapshot(40, 100)
+	if want := []d.TxOutput{{To: alice, Value: 30}, {To: bob, Value: 10}}; !reflect.DeepEqual(snap.Payouts, want) {
+		t.Errorf("Snapshot() = %v, want %v", snap.Payouts, want)
+	}
+
+	// A share submitted after the snapshot belongs to the next round.
+	addShares(p, bob, 1, 1)
+	round := p.BlockFound(7, snap)
+	if round.Height != 7 || len(p.Rounds()) != 1 {
+		t.Errorf("BlockFound() = %+v, Rounds() = %v", round, p.Rounds())
+	}
+	if got, want := p.Snapshot(40, 100).Payouts, []d.TxOutput{{To: bob, Value: 40}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() of the next round = %v, want %v", got, want)
+	}
+	if paid := p.Paid(); paid[alice] != 30 || paid[bob] != 10 {
+		t.Errorf("Paid() = %v", paid)
+	}
+}
+
+func TestSnapshot_FeeAndRemainders(t *testing.T) {
+	p := newTestPool(t, Proportional, 0.1)
+	if got, want := p.Snapshot(10, 1).Payouts, []d.TxOutput{{To: operator, Value: 10}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() without shares = %v, want %v", got, want)
+	}
+
+	addShares(p, alice, 1, 1)
+	addShares(p, bob, 1, 1)
+	// A fee of 1 leaves 9: 4.5 each, and the unit left after rounding down goes to
+	// the tie's lower address.
+	want := []d.TxOutput{{To: alice, Value: 5}, {To: bob, Value: 4}, {To: operator, Value: 1}}
+	if got := p.Snapshot(10, 1).Payouts; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() = %v, want %v", got, want)
+	}
+}
+
+func TestSimulate_PaysByHashShare(t *testing.T) {
+	for _, scheme := range []Scheme{PPLNS, Proportional} {
+		cfg := DefaultSimConfig()
+		cfg.Pool.Scheme = scheme
+		cfg.Blocks = 2000
+		r, err := Simulate(cfg)
+		if err != nil {
+			t.Fatalf("%s: Simulate() error = %v", scheme, err)
+		}
+		for i, w := range r.PerWorker {
+			if diff := w.RevenueShare - w.HashShare; diff > 0.02 || diff < -0.02 {
+				t.Errorf("%s: worker %d earned %.3f of the revenue with %.3f of the hash rate", scheme, i, w.RevenueShare, w.HashShare)
+			}
+			if w.CV >= w.SoloCV {
+				t.Errorf("%s: worker %d has CV %.3f, no lower than solo mining's %.3f", scheme, i, w.CV, w.SoloCV)
+			}
+		}
+		again, _ := Simulate(cfg)
+		if !reflect.DeepEqual(r, again) {
+			t.Errorf("%s: the same seed gave different reports", scheme)
+		}
+	}
+}
+
+func TestSnapshot_RemaindersToLargestFractions(t *testing.T) {
+	p := newTestPool(t, Proportional, 0)
+	carol := d.PublicAddress{3}
+	addShares(p, alice, 6, 1)
+	addShares(p, bob, 3, 1)
+	addShares(p, carol, 1, 1)
+
+	// 7 split 6:3:1 is 4.2, 2.1 and 0.7: carol's 0.7 gets the unit left over.
+	want := []d.TxOutput{{To: alice, Value: 4}, {To: bob, Value: 2}, {To: carol, Value: 1}}
+	if got := p.Snapshot(7, 1).Payouts; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() = %v, want %v", got, want)
+	}
+}
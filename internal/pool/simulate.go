@@ -0,0 +1,171 @@
+package pool
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// SimConfig describes a pool whose workers submit shares of equal difficulty.
+type SimConfig struct {
+	Pool Config
+	// Seed drives which worker finds each share and which shares are blocks.
+	Seed int64
+	// HashShares are the relative hash rates of the workers.
+	HashShares []float64
+	Blocks     int
+	// SharesPerBlock is the expected number of shares per block: the block work
+	// divided by the share work.
+	SharesPerBlock float64
+	Reward         uint32
+}
+
+// simOperator is the operator of a simulated pool. Workers get addresses whose
+// first two bytes are their index, so the operator's last byte keeps it apart.
+var simOperator = d.PublicAddress{19: 0xff}
+
+func DefaultSimConfig() SimConfig {
+	cfg := DefaultConfig()
+	cfg.Operator = simOperator
+	return SimConfig{
+		Pool:           cfg,
+		Seed:           1,
+		HashShares:     []float64{50, 25, 15, 7, 3},
+		Blocks:         1000,
+		SharesPerBlock: 16,
+		Reward:         50,
+	}
+}
+
+// SimReport summarizes how fairly and how steadily a pool paid its workers.
+type SimReport struct {
+	Scheme Scheme
+	Seed   int64
+	Blocks int
+	Shares int
+	// FeePaid is what the operator received: the fee and the rounding remainders.
+	FeePaid   uint64
+	PerWorker []WorkerReport
+}
+
+type WorkerReport struct {
+	HashShare float64
+	Shares    int
+	Revenue   uint64
+	// RevenueShare is the worker's part of everything paid to workers; a fair scheme
+	// keeps it close to HashShare.
+	RevenueShare float64
+	// CV is the coefficient of variation of the worker's payout per block: its
+	// standard deviation over its mean. SoloCV is the same for mining alone with the
+	// same hash rate, where each block pays the whole reward or nothing.
+	CV     float64
+	SoloCV float64
+}
+
+// Simulate runs a pool share by share: each share goes to a worker in proportion to
+// its hash rate and completes a block with probability 1/SharesPerBlock. Every block
+// is paid as Pool.Snapshot splits it at that moment.
+func Simulate(cfg SimConfig) (SimReport, error) {
+	if len(cfg.HashShares) == 0 || cfg.Blocks <= 0 || cfg.SharesPerBlock < 1 || cfg.Reward == 0 {
+		return SimReport{}, ErrInvalidConfig
+	}
+	var totalHash float64
+	for _, h := range cfg.HashShares {
+		if h <= 0 {
+			return SimReport{}, ErrInvalidConfig
+		}
+		totalHash += h
+	}
+	p, err := New(cfg.Pool)
+	if err != nil {
+		return SimReport{}, err
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	addresses := make([]d.PublicAddress, len(cfg.HashShares))
+	index := make(map[d.PublicAddress]int, len(addresses))
+	for i := range addresses {
+		addresses[i][0] = byte(i + 1)
+		addresses[i][1] = byte((i + 1) >> 8)
+		index[addresses[i]] = i
+	}
+
+	r := SimReport{Scheme: cfg.Pool.Scheme, Seed: cfg.Seed, Blocks: cfg.Blocks, PerWorker: make([]WorkerReport, len(addresses))}
+	// sum and sumSq accumulate each worker's payout per block.
+	sum := make([]float64, len(addresses))
+	sumSq := make([]float64, len(addresses))
+	for found := 0; found < cfg.Blocks; {
+		worker := pick(rng, cfg.HashShares, totalHash)
+		p.AddShare(Share{Address: addresses[worker], Work: 1})
+		r.Shares++
+		r.PerWorker[worker].Shares++
+		if rng.Float64() >= 1/cfg.SharesPerBlock {
+			continue
+		}
+		snap := p.Snapshot(cfg.Reward, cfg.SharesPerBlock)
+		p.BlockFound(found, snap)
+		found++
+		for _, out := range snap.Payouts {
+			i, ok := index[out.To]
+			if !ok {
+				r.FeePaid += uint64(out.Value)
+				continue
+			}
+			v := float64(out.Value)
+			r.PerWorker[i].Revenue += uint64(out.Value)
+			sum[i] += v
+			sumSq[i] += v * v
+		}
+	}
+
+	var paidToWorkers uint64
+	for _, w := range r.PerWorker {
+		paidToWorkers += w.Revenue
+	}
+	n := float64(cfg.Blocks)
+	for i, h := range cfg.HashShares {
+		w := &r.PerWorker[i]
+		w.HashShare = h / totalHash
+		if paidToWorkers > 0 {
+			w.RevenueShare = float64(w.Revenue) / float64(paidToWorkers)
+		}
+		if mean := sum[i] / n; mean > 0 {
+			w.CV = math.Sqrt(max(sumSq[i]/n-mean*mean, 0)) / mean
+		}
+		w.SoloCV = math.Sqrt((1 - w.HashShare) / w.HashShare)
+	}
+	return r, nil
+}
+
+// pick returns a worker index with probability proportional to its hash share.
+func pick(rng *rand.Rand, shares []float64, total float64) int {
+	x := rng.Float64() * total
+	for i, h := range shares {
+		if x < h {
+			return i
+		}
+		x -= h
+	}
+	return len(shares) - 1
+}
+
+// Print writes the report as a human readable summary.
+func (r SimReport) Print(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	printf("Seed %d, %s payouts, %d blocks from %d shares\n", r.Seed, r.Scheme, r.Blocks, r.Shares)
+	printf("Operator received:  %d\n", r.FeePaid)
+	printf("Worker  Hash    Shares  Revenue  Revenue share  CV per block  Solo CV\n")
+	for i, wr := range r.PerWorker {
+		printf("%-6d  %5.1f%%  %6d  %7d  %12.2f%%  %12.3f  %7.3f\n",
+			i, 100*wr.HashShare, wr.Shares, wr.Revenue, 100*wr.RevenueShare, wr.CV, wr.SoloCV)
+	}
+	return err
+}
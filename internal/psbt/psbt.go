@@ -0,0 +1,263 @@
+// Package psbt implements a partially signed transaction container that lets a
+// transaction be built in one place and signed by its input owners elsewhere.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+var (
+	ErrInvalidFormat    = errors.New("invalid psbt encoding")
+	ErrInputMismatch    = errors.New("psbt inputs do not match the unsigned transaction")
+	ErrTxMismatch       = errors.New("psbts spend different transactions")
+	ErrSignatureClash   = errors.New("psbts carry different signatures for the same input")
+	ErrUTXOMismatch     = errors.New("psbts describe different outputs spent by the same input")
+	ErrIncomplete       = errors.New("psbt has unsigned inputs")
+	ErrNoMatchingInputs = errors.New("key does not own any input of the psbt")
+)
+
+var magic = [5]byte{'p', 's', 'b', 't', 0xff}
+
+// Input describes the output spent by one transaction input and the signature collected for it.
+type Input struct {
+	UTXO        d.UTXO
+	SigHashType d.SigHashType
+	Signature   []byte
+}
+
+// PSBT is an unsigned transaction together with the per-input data signers need.
+type PSBT struct {
+	Tx     d.Transaction
+	Inputs []Input
+}
+
+// New creates a PSBT that spends utxos and pays outputs. Every input requests SigHashAll.
+func New(utxos []d.UTXO, outputs []d.TxOutput, hasher c.Hasher) (*PSBT, error) {
+	if len(utxos) == 0 {
+		return nil, d.ErrInvalidTransaction
+	}
+	if len(outputs) == 0 {
+		return nil, d.ErrEmptyTransaction
+	}
+
+	var inputSum, outputSum uint64
+	tx := d.Transaction{Outputs: append([]d.TxOutput(nil), outputs...)}
+	inputs := make([]Input, 0, len(utxos))
+	for _, utxo := range utxos {
+		tx.Inputs = append(tx.Inputs, d.TxInput{Prev: utxo.Outpoint})
+		inputs = append(inputs, Input{UTXO: utxo, SigHashType: d.SigHashAll})
+		inputSum += uint64(utxo.Value)
+	}
+	for _, out := range outputs {
+		outputSum += uint64(out.Value)
+	}
+	if outputSum > inputSum {
+		return nil, d.ErrInsufficientFunds
+	}
+	tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+
+	return &PSBT{Tx: tx, Inputs: inputs}, nil
+}
+
+// Sign signs every unsigned input whose UTXO belongs to key and returns how many were signed.
+func (p *PSBT) Sign(key *secp256k1.PrivateKey, signer c.TransactionSigner, hasher c.Hasher) (int, error) {
+	if err := p.check(); err != nil {
+		return 0, err
+	}
+	address := d.PublicAddress(c.GenerateAddress(key.PubKey().SerializeCompressed()))
+
+	signed := 0
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if in.UTXO.To != address || len(in.Signature) > 0 {
+			continue
+		}
+		tx := p.unsignedTx()
+		if err := blockchain.SignInput(&tx, i, in.UTXO, in.SigHashType, key, signer, hasher); err != nil {
+			return signed, err
+		}
+		in.Signature = tx.Inputs[i].Sig
+		signed++
+	}
+	if signed == 0 {
+		return 0, ErrNoMatchingInputs
+	}
+	return signed, nil
+}
+
+// Combine merges the signatures of several PSBTs for the same transaction into a new PSBT.
+// Only signatures are taken from the other PSBTs; the spent outputs and sighash types
+// are those of the first, and the others must describe the same spent outputs.
+func Combine(psbts ...*PSBT) (*PSBT, error) {
+	if len(psbts) == 0 {
+		return nil, ErrInvalidFormat
+	}
+	base := psbts[0]
+	if err := base.check(); err != nil {
+		return nil, err
+	}
+	combined := &PSBT{Tx: base.unsignedTx(), Inputs: make([]Input, len(base.Inputs))}
+	copy(combined.Inputs, base.Inputs)
+	want := base.Tx.SerializeWithoutSignatures()
+
+	for _, other := range psbts[1:] {
+		if err := other.check(); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(other.Tx.SerializeWithoutSignatures(), want) {
+			return nil, ErrTxMismatch
+		}
+		for i, in := range other.Inputs {
+			if in.UTXO != combined.Inputs[i].UTXO {
+				return nil, ErrUTXOMismatch
+			}
+			if len(in.Signature) == 0 {
+				continue
+			}
+			existing := combined.Inputs[i].Signature
+			if len(existing) > 0 && !bytes.Equal(existing, in.Signature) {
+				return nil, ErrSignatureClash
+			}
+			combined.Inputs[i].Signature = in.Signature
+		}
+	}
+	return combined, nil
+}
+
+// IsComplete reports whether every input carries a signature.
+func (p *PSBT) IsComplete() bool {
+	for _, in := range p.Inputs {
+		if len(in.Signature) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Finalize returns the fully signed transaction ready for broadcast.
+func (p *PSBT) Finalize(hasher c.Hasher) (d.Transaction, error) {
+	if err := p.check(); err != nil {
+		return d.Transaction{}, err
+	}
+	if !p.IsComplete() {
+		return d.Transaction{}, ErrIncomplete
+	}
+	tx := p.unsignedTx()
+	for i, in := range p.Inputs {
+		tx.Inputs[i].Sig = append([]byte(nil), in.Signature...)
+	}
+	tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+	return tx, nil
+}
+
+// check verifies that the per-input data lines up with the unsigned transaction.
+func (p *PSBT) check() error {
+	if len(p.Inputs) != len(p.Tx.Inputs) {
+		return ErrInputMismatch
+	}
+	for i, in := range p.Inputs {
+		if in.UTXO.Outpoint != p.Tx.Inputs[i].Prev {
+			return ErrInputMismatch
+		}
+	}
+	return nil
+}
+
+// unsignedTx returns a copy of the transaction with all signatures stripped.
+func (p *PSBT) unsignedTx() d.Transaction {
+	tx := d.Transaction{
+		TxID:    p.Tx.TxID,
+		Inputs:  make([]d.TxInput, len(p.Tx.Inputs)),
+		Outputs: append([]d.TxOutput(nil), p.Tx.Outputs...),
+	}
+	for i, in := range p.Tx.Inputs {
+		tx.Inputs[i] = d.TxInput{Prev: in.Prev}
+	}
+	return tx
+}
+
+// Serialize encodes the PSBT as: magic, unsigned transaction, then for every
+// input its spent value, spent address, sighash type and signature.
+func (p *PSBT) Serialize() []byte {
+	var buf bytes.Buffer
+	buf.Write(magic[:])
+	tx := p.unsignedTx()
+	buf.Write(tx.Serialize())
+	for _, in := range p.Inputs {
+		_ = binary.Write(&buf, binary.LittleEndian, in.UTXO.Value)
+		buf.Write(in.UTXO.To[:])
+		buf.WriteByte(byte(in.SigHashType))
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(in.Signature)))
+		buf.Write(in.Signature)
+	}
+	return buf.Bytes()
+}
+
+// Deserialize decodes a PSBT produced by Serialize and recomputes its TxID.
+func Deserialize(data []byte, hasher c.Hasher) (*PSBT, error) {
+	r := bytes.NewReader(data)
+	var gotMagic [5]byte
+	if _, err := io.ReadFull(r, gotMagic[:]); err != nil || gotMagic != magic {
+		return nil, ErrInvalidFormat
+	}
+	tx, err := d.DeserializeTransaction(r)
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+
+	p := &PSBT{Tx: tx, Inputs: make([]Input, len(tx.Inputs))}
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		in.UTXO.Outpoint = tx.Inputs[i].Prev
+		if err := binary.Read(r, binary.LittleEndian, &in.UTXO.Value); err != nil {
+			return nil, ErrInvalidFormat
+		}
+		if _, err := io.ReadFull(r, in.UTXO.To[:]); err != nil {
+			return nil, ErrInvalidFormat
+		}
+		hashType, err := r.ReadByte()
+		if err != nil || !d.SigHashType(hashType).IsValid() {
+			return nil, ErrInvalidFormat
+		}
+		in.SigHashType = d.SigHashType(hashType)
+		var sigLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &sigLen); err != nil || int64(sigLen) > int64(r.Len()) {
+			return nil, ErrInvalidFormat
+		}
+		if sigLen > 0 {
+			in.Signature = make([]byte, sigLen)
+			if _, err := io.ReadFull(r, in.Signature); err != nil {
+				return nil, ErrInvalidFormat
+			}
+		}
+	}
+	if r.Len() != 0 {
+		return nil, ErrInvalidFormat
+	}
+	return p, nil
+}
+
+// Encode returns the base64 text form of the PSBT.
+func (p *PSBT) Encode() string {
+	return base64.StdEncoding.EncodeToString(p.Serialize())
+}
+
+// Decode parses the base64 text form of a PSBT.
+func Decode(s string, hasher c.Hasher) (*PSBT, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	return Deserialize(data, hasher)
+}
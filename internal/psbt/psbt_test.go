@@ -0,0 +1,147 @@
+package psbt_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/Quikmove/blockchain-uzd2/internal/psbt"
+)
+
+func setupChain(t *testing.T) (*blockchain.Blockchain, []d.User, *config.Config) {
+	t.Helper()
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	users := blockchain.NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	bch := blockchain.InitBlockchainWithFunds(1000, 1000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner())
+	return bch, users, cfg
+}
+
+func TestMultiPartyWorkflow(t *testing.T) {
+	bch, users, cfg := setupChain(t)
+	hasher := c.NewArchasHasher()
+	signer := c.NewTransactionSigner()
+
+	alice := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	bob := bch.GetUTXOsForAddress(users[1].PublicAddress)[0]
+	payment := d.TxOutput{Value: alice.Value + bob.Value, To: users[2].PublicAddress}
+
+	created, err := psbt.New([]d.UTXO{alice, bob}, []d.TxOutput{payment}, hasher)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	encoded := created.Encode()
+
+	// Each party signs its own copy, as if on separate machines
+	signedBy := func(user d.User) *psbt.PSBT {
+		p, err := psbt.Decode(encoded, hasher)
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		n, err := p.Sign(user.GetPrivateKeyObject(), signer, hasher)
+		if err != nil || n != 1 {
+			t.Fatalf("Sign() = %d, %v; want 1 input signed", n, err)
+		}
+		if p.IsComplete() {
+			t.Fatal("A single signer should not complete a two-party PSBT")
+		}
+		roundTrip, err := psbt.Decode(p.Encode(), hasher)
+		if err != nil {
+			t.Fatalf("Decode() of signed PSBT error = %v", err)
+		}
+		return roundTrip
+	}
+	fromAlice := signedBy(users[0])
+	fromBob := signedBy(users[1])
+
+	if _, err := fromAlice.Finalize(hasher); err != psbt.ErrIncomplete {
+		t.Errorf("Finalize() of partial PSBT error = %v, want %v", err, psbt.ErrIncomplete)
+	}
+
+	combined, err := psbt.Combine(fromAlice, fromBob)
+	if err != nil {
+		t.Fatalf("Combine() error = %v", err)
+	}
+	tx, err := combined.Finalize(hasher)
+	if err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	if tx.TxID != created.Tx.TxID {
+		t.Error("Finalized transaction should keep the TxID of the unsigned transaction")
+	}
+
+	if err := bch.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	block, err := bch.GenerateBlock(context.Background(), *d.NewBody([]d.Transaction{tx}), cfg.Version, cfg.Difficulty)
+	if err != nil {
+		t.Fatalf("GenerateBlock() error = %v", err)
+	}
+	if err := bch.AddBlock(block); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+	if got := bch.GetUserBalance(users[2].PublicAddress); got != 1000+payment.Value {
+		t.Errorf("Recipient balance = %d, want %d", got, 1000+payment.Value)
+	}
+}
+
+func TestSign_NoMatchingInputs(t *testing.T) {
+	bch, users, _ := setupChain(t)
+	hasher := c.NewArchasHasher()
+
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	p, err := psbt.New([]d.UTXO{utxo}, []d.TxOutput{{Value: utxo.Value, To: users[1].PublicAddress}}, hasher)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := p.Sign(users[1].GetPrivateKeyObject(), c.NewTransactionSigner(), hasher); err != psbt.ErrNoMatchingInputs {
+		t.Errorf("Sign() with foreign key error = %v, want %v", err, psbt.ErrNoMatchingInputs)
+	}
+}
+
+func TestCombine_DifferentTransactions(t *testing.T) {
+	bch, users, _ := setupChain(t)
+	hasher := c.NewArchasHasher()
+
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	a, _ := psbt.New([]d.UTXO{utxo}, []d.TxOutput{{Value: utxo.Value, To: users[1].PublicAddress}}, hasher)
+	b, _ := psbt.New([]d.UTXO{utxo}, []d.TxOutput{{Value: utxo.Value, To: users[2].PublicAddress}}, hasher)
+	if _, err := psbt.Combine(a, b); err != psbt.ErrTxMismatch {
+		t.Errorf("Combine() error = %v, want %v", err, psbt.ErrTxMismatch)
+	}
+}
+
+func TestCombine_DifferentUTXOs(t *testing.T) {
+	bch, users, _ := setupChain(t)
+	hasher := c.NewArchasHasher()
+
+	utxo := bch.GetUTXOsForAddress(users[0].PublicAddress)[0]
+	outputs := []d.TxOutput{{Value: utxo.Value, To: users[1].PublicAddress}}
+	a, _ := psbt.New([]d.UTXO{utxo}, outputs, hasher)
+	b, _ := psbt.New([]d.UTXO{utxo}, outputs, hasher)
+	if _, err := b.Sign(users[0].GetPrivateKeyObject(), c.NewTransactionSigner(), hasher); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	b.Inputs[0].UTXO.Value++
+	if _, err := psbt.Combine(a, b); err != psbt.ErrUTXOMismatch {
+		t.Errorf("Combine() error = %v, want %v", err, psbt.ErrUTXOMismatch)
+	}
+
+	b.Inputs[0].UTXO.Value--
+	b.Inputs[0].SigHashType = d.SigHashNone
+	combined, err := psbt.Combine(a, b)
+	if err != nil {
+		t.Fatalf("Combine() error = %v", err)
+	}
+	if combined.Inputs[0].SigHashType != a.Inputs[0].SigHashType || len(combined.Inputs[0].Signature) == 0 {
+		t.Errorf("combined input = %+v, want the base sighash type with the other's signature", combined.Inputs[0])
+	}
+}
+
+func TestDecode_RejectsGarbage(t *testing.T) {
+	if _, err := psbt.Decode("bm90IGEgcHNidA==", c.NewArchasHasher()); err != psbt.ErrInvalidFormat {
+		t.Errorf("Decode() error = %v, want %v", err, psbt.ErrInvalidFormat)
+	}
+}
@@ -0,0 +1,219 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/clock"
+)
+
+var (
+	ErrNoNodes       = errors.New("simulation: at least two nodes are required")
+	ErrNoStopTime    = errors.New("simulation: either Duration or Blocks must be set")
+	ErrInvalidConfig = errors.New("simulation: invalid configuration")
+	ErrBadPartition  = errors.New("simulation: malformed partition")
+)
+
+// Partition splits the network into groups of node indices between Start and End.
+// Messages between nodes of different groups are dropped while the partition lasts.
+// Nodes that are not listed in any group form one more group of their own.
+type Partition struct {
+	Start  time.Duration
+	End    time.Duration
+	Groups [][]int
+}
+
+type Config struct {
+	// Seed drives every random choice: mining times, topology, latency jitter, losses and transactions.
+	Seed  int64
+	Nodes int
+	// HashShares are the relative hash rates of the nodes. Nil gives every node the same share.
+	HashShares []float64
+	// Strategies are the strategies of the first nodes; the remaining nodes are Honest.
+	Strategies []Strategy
+	// WithholdingVictim is the node whose pool the Withholding miners join.
+	WithholdingVictim int
+	// Confirmations is how many blocks a merchant waits for before shipping to a
+	// DoubleSpend attacker, GiveUpDeficit how far the attacker's private chain may fall
+	// behind the public one before it gives up.
+	Confirmations int
+	GiveUpDeficit int
+	// Degree is the number of peers each node connects to; zero connects every pair of nodes.
+	Degree int
+	// BlockInterval is the expected time between blocks of the whole network.
+	BlockInterval time.Duration
+	// Duration and Blocks stop mining after that much simulated time or that many mined blocks,
+	// whichever comes first. Zero disables a limit.
+	Duration time.Duration
+	Blocks   int
+	// Latency is the one-way delay of every link; each message adds a uniform jitter below Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Bandwidth is the link capacity in bytes per second. Messages queue behind each other
+	// on a busy link. Zero means unlimited bandwidth.
+	Bandwidth int
+	// Loss is the probability that a message is dropped.
+	Loss       float64
+	Partitions []Partition
+	// TxRate is the number of transactions submitted to random nodes per simulated second.
+	TxRate        float64
+	MaxBlockTxs   int
+	Users         int
+	Funds         uint32
+	BlockReward   uint32
+	Version       uint32
+	Difficulty    uint32
+	FinalityDepth int
+	// Start is the timestamp of the genesis block; block timestamps advance with simulated time.
+	Start time.Time
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Seed:          1,
+		Nodes:         8,
+		Degree:        4,
+		BlockInterval: 10 * time.Second,
+		Blocks:        100,
+		Latency:       200 * time.Millisecond,
+		Jitter:        100 * time.Millisecond,
+		Bandwidth:     1 << 20,
+		TxRate:        2,
+		MaxBlockTxs:   100,
+		Users:         20,
+		Funds:         1000000,
+		BlockReward:   50,
+		Version:       1,
+		Difficulty:    0,
+		FinalityDepth: 6,
+		Confirmations: 6,
+		GiveUpDeficit: 6,
+		Start:         clock.Epoch,
+	}
+}
+
+func (cfg Config) validate() error {
+	if cfg.Nodes < 2 {
+		return ErrNoNodes
+	}
+	if cfg.Duration <= 0 && cfg.Blocks <= 0 {
+		return ErrNoStopTime
+	}
+	if cfg.BlockInterval <= 0 {
+		return fmt.Errorf("%w: BlockInterval must be positive", ErrInvalidConfig)
+	}
+	if cfg.HashShares != nil && len(cfg.HashShares) != cfg.Nodes {
+		return fmt.Errorf("%w: %d hash shares for %d nodes", ErrInvalidConfig, len(cfg.HashShares), cfg.Nodes)
+	}
+	var total float64
+	for _, share := range cfg.HashShares {
+		if share < 0 {
+			return fmt.Errorf("%w: negative hash share", ErrInvalidConfig)
+		}
+		total += share
+	}
+	if cfg.HashShares != nil && total == 0 {
+		return fmt.Errorf("%w: total hash share is zero", ErrInvalidConfig)
+	}
+	if cfg.Loss < 0 || cfg.Loss >= 1 {
+		return fmt.Errorf("%w: Loss must be in [0, 1)", ErrInvalidConfig)
+	}
+	if cfg.Latency < 0 || cfg.Jitter < 0 || cfg.Bandwidth < 0 || cfg.TxRate < 0 {
+		return fmt.Errorf("%w: negative latency, jitter, bandwidth or transaction rate", ErrInvalidConfig)
+	}
+	if cfg.Users < 2 {
+		return fmt.Errorf("%w: at least two users are required", ErrInvalidConfig)
+	}
+	if cfg.BlockReward == 0 {
+		return fmt.Errorf("%w: BlockReward must be positive", ErrInvalidConfig)
+	}
+	if len(cfg.Strategies) > cfg.Nodes {
+		return fmt.Errorf("%w: %d strategies for %d nodes", ErrInvalidConfig, len(cfg.Strategies), cfg.Nodes)
+	}
+	for i, strategy := range cfg.Strategies {
+		switch strategy {
+		case Honest, Selfish:
+		case Withholding:
+			victim := cfg.WithholdingVictim
+			if victim < 0 || victim >= cfg.Nodes || cfg.strategy(victim) != Honest {
+				return fmt.Errorf("%w: withholding miners need an honest victim, node %d is not one", ErrInvalidConfig, victim)
+			}
+		case DoubleSpend:
+			if cfg.Confirmations < 1 || cfg.GiveUpDeficit < 1 {
+				return fmt.Errorf("%w: double spending needs positive Confirmations and GiveUpDeficit", ErrInvalidConfig)
+			}
+		default:
+			return fmt.Errorf("%w: node %d has unknown strategy %d", ErrInvalidConfig, i, int(strategy))
+		}
+	}
+	for _, p := range cfg.Partitions {
+		if p.End <= p.Start {
+			return fmt.Errorf("%w: partition ends before it starts", ErrBadPartition)
+		}
+		for _, group := range p.Groups {
+			for _, id := range group {
+				if id < 0 || id >= cfg.Nodes {
+					return fmt.Errorf("%w: node %d does not exist", ErrBadPartition, id)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// strategy returns the strategy of node i.
+func (cfg Config) strategy(i int) Strategy {
+	if i < len(cfg.Strategies) {
+		return cfg.Strategies[i]
+	}
+	return Honest
+}
+
+// ParsePartition parses "<start>-<end>:<group>|<group>...", where the times are Go durations
+// and a group is a comma separated list of node indices, e.g. "1m-3m:0,1,2|3,4".
+func ParsePartition(s string) (Partition, error) {
+	span, groupList, ok := strings.Cut(s, ":")
+	if !ok {
+		return Partition{}, fmt.Errorf("%w: %q has no groups", ErrBadPartition, s)
+	}
+	startStr, endStr, ok := strings.Cut(span, "-")
+	if !ok {
+		return Partition{}, fmt.Errorf("%w: %q has no time range", ErrBadPartition, s)
+	}
+	start, err := time.ParseDuration(startStr)
+	if err != nil {
+		return Partition{}, fmt.Errorf("%w: %v", ErrBadPartition, err)
+	}
+	end, err := time.ParseDuration(endStr)
+	if err != nil {
+		return Partition{}, fmt.Errorf("%w: %v", ErrBadPartition, err)
+	}
+	p := Partition{Start: start, End: end}
+	for _, groupStr := range strings.Split(groupList, "|") {
+		var group []int
+		for _, idStr := range strings.Split(groupStr, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idStr))
+			if err != nil {
+				return Partition{}, fmt.Errorf("%w: node %q in %q", ErrBadPartition, idStr, s)
+			}
+			group = append(group, id)
+		}
+		p.Groups = append(p.Groups, group)
+	}
+	return p, nil
+}
+
+// group returns the index of the group node belongs to.
+func (p Partition) group(node int) int {
+	for i, group := range p.Groups {
+		for _, id := range group {
+			if id == node {
+				return i
+			}
+		}
+	}
+	return len(p.Groups)
+}
@@ -0,0 +1,152 @@
+package simulation
+
+import (
+	"container/heap"
+	"time"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// messageOverhead approximates the framing added to every message, like the p2p package's 24 byte header.
+const messageOverhead = 24
+
+// event is an action scheduled at a point of simulated time. Events at the same
+// time run in the order they were scheduled, which keeps runs reproducible.
+type event struct {
+	at  time.Duration
+	seq uint64
+	run func()
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+type messageKind int
+
+const (
+	msgBlock messageKind = iota
+	msgTx
+	msgGetBlock
+)
+
+// message is what travels over a virtual link. Blocks and transactions are pushed
+// to peers whole; msgGetBlock asks a peer for the parent of an orphan block.
+type message struct {
+	kind  messageKind
+	block d.Block
+	hash  d.Hash32
+	tx    d.Transaction
+}
+
+func (m message) size() int {
+	switch m.kind {
+	case msgBlock:
+		return messageOverhead + len(m.block.Serialize())
+	case msgTx:
+		return messageOverhead + len(m.tx.Serialize())
+	}
+	return messageOverhead + len(m.hash)
+}
+
+// link is one direction of a connection between two nodes. It delivers one
+// message at a time, so a large block delays everything queued behind it.
+type link struct {
+	busyUntil time.Duration
+}
+
+// schedule runs fn after delay of simulated time.
+func (s *Simulation) schedule(delay time.Duration, fn func()) {
+	s.seq++
+	heap.Push(&s.queue, &event{at: s.now + delay, seq: s.seq, run: fn})
+}
+
+// partitioned reports whether an active partition separates the two nodes.
+func (s *Simulation) partitioned(from, to int) bool {
+	if s.draining {
+		return false
+	}
+	for _, p := range s.cfg.Partitions {
+		if s.now >= p.Start && s.now < p.End && p.group(from) != p.group(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// send transmits msg from one node to another over their link, applying
+// partitions, loss, bandwidth and latency.
+func (s *Simulation) send(from, to int, msg message) {
+	s.stats.messages++
+	// The random numbers are drawn for every message so that a partition does not
+	// shift the random sequence seen by the rest of the run.
+	lost := s.rng.Float64() < s.cfg.Loss
+	var jitter time.Duration
+	if s.cfg.Jitter > 0 {
+		jitter = time.Duration(s.rng.Int63n(int64(s.cfg.Jitter)))
+	}
+	if s.partitioned(from, to) || (lost && !s.draining) {
+		s.stats.dropped++
+		return
+	}
+
+	l := s.links[[2]int{from, to}]
+	start := max(s.now, l.busyUntil)
+	var transmit time.Duration
+	if s.cfg.Bandwidth > 0 {
+		transmit = time.Duration(int64(msg.size()) * int64(time.Second) / int64(s.cfg.Bandwidth))
+	}
+	l.busyUntil = start + transmit
+	arrival := start + transmit + s.cfg.Latency + jitter
+	s.schedule(arrival-s.now, func() { s.nodes[to].receive(from, msg) })
+}
+
+// connect builds the topology: a ring that keeps the network connected plus random
+// links until every node has Degree peers. A zero Degree connects every pair.
+func (s *Simulation) connect() {
+	n := len(s.nodes)
+	linked := func(a, b int) bool {
+		_, ok := s.links[[2]int{a, b}]
+		return ok
+	}
+	add := func(a, b int) {
+		if a == b || linked(a, b) {
+			return
+		}
+		s.links[[2]int{a, b}] = &link{}
+		s.links[[2]int{b, a}] = &link{}
+		s.nodes[a].peers = append(s.nodes[a].peers, b)
+		s.nodes[b].peers = append(s.nodes[b].peers, a)
+	}
+
+	if s.cfg.Degree <= 0 || s.cfg.Degree >= n-1 {
+		for a := 0; a < n; a++ {
+			for b := a + 1; b < n; b++ {
+				add(a, b)
+			}
+		}
+		return
+	}
+	for a := 0; a < n; a++ {
+		add(a, (a+1)%n)
+	}
+	for a := 0; a < n; a++ {
+		for attempts := 0; len(s.nodes[a].peers) < s.cfg.Degree && attempts < 10*n; attempts++ {
+			add(a, s.rng.Intn(n))
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package simulation
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// Report summarizes a simulation run.
+type Report struct {
+	Seed  int64
+	Nodes int
+	// Elapsed is the simulated time at which mining stopped.
+	Elapsed     time.Duration
+	BlocksMined int
+	// StaleBlocks were mined but are not part of the final chain. OrphanRate is
+	// their share of all mined blocks.
+	StaleBlocks int
+	OrphanRate  float64
+	// OrphansReceived counts blocks that reached a node before their parent did.
+	OrphansReceived int
+	Reorgs          int
+	// ReorgDepths maps the number of blocks a reorganization disconnected to how often that happened.
+	ReorgDepths   map[int]int
+	MaxReorgDepth int
+	// The finality times measure how long after being mined a block of the final
+	// chain was buried under FinalityDepth blocks on every node.
+	FinalityDepth  int
+	FinalBlocks    int
+	MeanFinality   time.Duration
+	MedianFinality time.Duration
+	MaxFinality    time.Duration
+	Transactions   int
+	// DoubleSpendAttempts counts the payments of DoubleSpend attackers and
+	// DoubleSpends the payments they reversed after the merchant's confirmations.
+	DoubleSpendAttempts int
+	DoubleSpends        int
+	Messages            int
+	Dropped             int
+	// Converged reports whether every node ended on the same tip. Competing tips of
+	// equal work are not resolved once mining stops.
+	Converged bool
+	Height    int
+	PerNode   []NodeReport
+}
+
+type NodeReport struct {
+	Strategy  Strategy
+	HashShare float64
+	Mined     int
+	// Discarded counts the blocks a Withholding miner threw away.
+	Discarded int
+	// InChain is the number of the node's blocks in the final chain.
+	InChain int
+	Height  int
+	// Revenue is the block rewards the node earned in the final chain. Withholding
+	// miners share the victim pool's rewards by hash rate. RevenueShare is the
+	// node's part of all rewards, which honest mining keeps close to HashShare.
+	Revenue      float64
+	RevenueShare float64
+}
+
+// finalChain picks the main chain shared by the most nodes, preferring the longest
+// one and then the lowest node index.
+func (s *Simulation) finalChain() []d.Hash32 {
+	votes := make(map[d.Hash32]int)
+	best := s.nodes[0].main
+	for _, n := range s.nodes {
+		tip := n.main[len(n.main)-1]
+		votes[tip]++
+		bestTip := best[len(best)-1]
+		if len(n.main) > len(best) || (len(n.main) == len(best) && votes[tip] > votes[bestTip]) {
+			best = n.main
+		}
+	}
+	return best
+}
+
+func (s *Simulation) report() Report {
+	final := s.finalChain()
+	r := Report{
+		Seed:                s.cfg.Seed,
+		Nodes:               len(s.nodes),
+		Elapsed:             s.stopped,
+		BlocksMined:         len(s.mined),
+		OrphansReceived:     s.stats.orphans,
+		ReorgDepths:         s.stats.reorgDepths,
+		FinalityDepth:       s.cfg.FinalityDepth,
+		Transactions:        s.stats.transactions,
+		DoubleSpendAttempts: s.stats.doubleSpendAttempts,
+		DoubleSpends:        s.stats.doubleSpends,
+		Messages:            s.stats.messages,
+		Dropped:             s.stats.dropped,
+		Converged:           true,
+		Height:              len(final) - 1,
+	}
+
+	var totalShare float64
+	for i := range s.nodes {
+		totalShare += s.hashShare(i)
+	}
+	for i, n := range s.nodes {
+		r.PerNode = append(r.PerNode, NodeReport{
+			Strategy:  n.strategy,
+			HashShare: s.hashShare(i) / totalShare,
+			Mined:     n.mined,
+			Discarded: n.discarded,
+			Height:    len(n.main) - 1,
+		})
+		if n.main[len(n.main)-1] != final[len(final)-1] {
+			r.Converged = false
+		}
+	}
+	inChain := 0
+	for _, hash := range final {
+		if mb, ok := s.mined[hash]; ok {
+			r.PerNode[mb.miner].InChain++
+			inChain++
+		}
+	}
+	r.StaleBlocks = r.BlocksMined - inChain
+	s.splitRevenue(&r, inChain)
+	if r.BlocksMined > 0 {
+		r.OrphanRate = float64(r.StaleBlocks) / float64(r.BlocksMined)
+	}
+	for depth, count := range r.ReorgDepths {
+		r.Reorgs += count
+		r.MaxReorgDepth = max(r.MaxReorgDepth, depth)
+	}
+
+	var finality []time.Duration
+	for height := 1; height+s.cfg.FinalityDepth < len(final); height++ {
+		mb, ok := s.mined[final[height]]
+		if !ok {
+			continue
+		}
+		var buried time.Duration
+		agreed := true
+		for _, n := range s.nodes {
+			if height+s.cfg.FinalityDepth >= len(n.main) || n.main[height] != final[height] {
+				agreed = false
+				break
+			}
+			buried = max(buried, n.setAt[height], n.reachedAt[height+s.cfg.FinalityDepth])
+		}
+		if agreed {
+			finality = append(finality, buried-mb.at)
+		}
+	}
+	r.FinalBlocks = len(finality)
+	if len(finality) > 0 {
+		sort.Slice(finality, func(i, j int) bool { return finality[i] < finality[j] })
+		var total time.Duration
+		for _, f := range finality {
+			total += f
+		}
+		r.MeanFinality = total / time.Duration(len(finality))
+		r.MedianFinality = finality[len(finality)/2]
+		r.MaxFinality = finality[len(finality)-1]
+	}
+	return r
+}
+
+// splitRevenue credits every node with the rewards of its blocks in the final chain,
+// of which the victim of withholding miners pays them their part by hash rate.
+func (s *Simulation) splitRevenue(r *Report, inChain int) {
+	reward := float64(s.cfg.BlockReward)
+	for i := range r.PerNode {
+		r.PerNode[i].Revenue = float64(r.PerNode[i].InChain) * reward
+	}
+	victim := s.cfg.WithholdingVictim
+	poolShare := 0.0
+	for _, n := range r.PerNode {
+		if n.Strategy == Withholding {
+			poolShare += n.HashShare
+		}
+	}
+	if poolShare > 0 {
+		poolShare += r.PerNode[victim].HashShare
+		poolRevenue := r.PerNode[victim].Revenue
+		for i, n := range r.PerNode {
+			if n.Strategy == Withholding || i == victim {
+				r.PerNode[i].Revenue = poolRevenue * n.HashShare / poolShare
+			}
+		}
+	}
+	if inChain > 0 {
+		for i := range r.PerNode {
+			r.PerNode[i].RevenueShare = r.PerNode[i].Revenue / (float64(inChain) * reward)
+		}
+	}
+}
+
+// Print writes the report as a human readable summary.
+func (r Report) Print(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	printf("Seed %d, %d nodes, mining stopped after %v of simulated time\n", r.Seed, r.Nodes, r.Elapsed.Round(time.Millisecond))
+	printf("Blocks mined:       %d (final height %d)\n", r.BlocksMined, r.Height)
+	printf("Stale blocks:       %d (orphan rate %.2f%%)\n", r.StaleBlocks, 100*r.OrphanRate)
+	printf("Orphans received:   %d\n", r.OrphansReceived)
+	printf("Reorganizations:    %d (max depth %d)\n", r.Reorgs, r.MaxReorgDepth)
+	depths := make([]int, 0, len(r.ReorgDepths))
+	for depth := range r.ReorgDepths {
+		depths = append(depths, depth)
+	}
+	sort.Ints(depths)
+	for _, depth := range depths {
+		printf("  depth %-3d         %d\n", depth, r.ReorgDepths[depth])
+	}
+	printf("Time to finality:   mean %v, median %v, max %v (%d confirmations, %d blocks)\n",
+		r.MeanFinality.Round(time.Millisecond), r.MedianFinality.Round(time.Millisecond), r.MaxFinality.Round(time.Millisecond), r.FinalityDepth, r.FinalBlocks)
+	printf("Transactions:       %d\n", r.Transactions)
+	if r.DoubleSpendAttempts > 0 {
+		printf("Double spends:      %d of %d payments reversed\n", r.DoubleSpends, r.DoubleSpendAttempts)
+	}
+	printf("Messages:           %d (%d dropped)\n", r.Messages, r.Dropped)
+	printf("Converged:          %t\n", r.Converged)
+	printf("Node  Strategy      Share   Mined  In chain  Height  Revenue share\n")
+	for i, n := range r.PerNode {
+		printf("%-4d  %-12s  %5.1f%%  %5d  %8d  %6d  %12.1f%%\n", i, n.Strategy, 100*n.HashShare, n.Mined, n.InChain, n.Height, 100*n.RevenueShare)
+	}
+	return err
+}
@@ -0,0 +1,485 @@
+// Package simulation runs several independent blockchain nodes in one process and
+// connects them through a virtual network, so that forks, reorganizations and
+// finality can be studied without sockets. Time is simulated: every node mines as
+// a Poisson process proportional to its hash share and messages arrive after a
+// modeled delay, so a run is fully determined by its configuration and seed.
+package simulation
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	"github.com/Quikmove/blockchain-uzd2/internal/clock"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// minedBlock records who mined a block, when and at which height.
+type minedBlock struct {
+	miner  int
+	at     time.Duration
+	height int
+}
+
+type stats struct {
+	messages     int
+	dropped      int
+	orphans      int
+	invalid      int
+	transactions int
+	reorgDepths  map[int]int
+	// doubleSpendAttempts counts the payments DoubleSpend attackers made and
+	// doubleSpends those they reversed.
+	doubleSpendAttempts int
+	doubleSpends        int
+}
+
+// Simulation is a virtual network of nodes. Create it with New and execute it once with Run.
+type Simulation struct {
+	cfg    Config
+	rng    *rand.Rand
+	hasher c.Hasher
+	signer c.TransactionSigner
+	users  []d.User
+	nodes  []*node
+	links  map[[2]int]*link
+
+	queue    eventQueue
+	seq      uint64
+	now      time.Duration
+	mining   bool
+	draining bool
+	stopped  time.Duration
+	err      error
+
+	mined map[d.Hash32]minedBlock
+	stats stats
+}
+
+// New creates the nodes, their shared genesis block and the network between them.
+func New(cfg Config) (*Simulation, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	s := &Simulation{
+		cfg:    cfg,
+		rng:    rand.New(rand.NewSource(cfg.Seed)),
+		hasher: c.NewArchasHasher(),
+		signer: c.NewTransactionSigner(),
+		links:  make(map[[2]int]*link),
+		mined:  make(map[d.Hash32]minedBlock),
+		stats:  stats{reorgDepths: make(map[int]int)},
+	}
+
+	// Keys are derived from names and the seed, so that every run with the same seed
+	// produces byte-identical blocks.
+	keyGen := c.NewKeyGenerator()
+	names := make([]string, 0, cfg.Users+cfg.Nodes)
+	for i := 0; i < cfg.Users; i++ {
+		names = append(names, fmt.Sprintf("user-%d", i))
+	}
+	for i := 0; i < cfg.Nodes; i++ {
+		names = append(names, fmt.Sprintf("miner-%d", i))
+	}
+	for i, name := range names {
+		privateKey, publicKey, err := keyGen.GenerateKeyPair(fmt.Sprintf("simulation %d %s", cfg.Seed, name))
+		if err != nil {
+			return nil, err
+		}
+		s.users = append(s.users, *d.NewUser(uint32(i+1), name, publicKey, privateKey))
+	}
+
+	genesis, err := s.genesisBlock()
+	if err != nil {
+		return nil, err
+	}
+	var totalShare float64
+	for i := 0; i < cfg.Nodes; i++ {
+		totalShare += s.hashShare(i)
+	}
+	for i := 0; i < cfg.Nodes; i++ {
+		// The nodes validate timestamps against simulated time, not the wall clock.
+		bch := blockchain.NewBlockchain(s.hasher, s.signer, blockchain.WithClock(clock.Func(s.clockNow)), blockchain.WithMinDifficulty(s.cfg.Difficulty))
+		bch.RegisterUsers(s.users)
+		if _, err := bch.ProcessBlock(genesis); err != nil {
+			return nil, fmt.Errorf("genesis block rejected: %w", err)
+		}
+		n := &node{
+			sim:       s,
+			id:        i,
+			chain:     bch,
+			miner:     s.users[cfg.Users+i],
+			rate:      s.hashShare(i) / totalShare / cfg.BlockInterval.Seconds(),
+			strategy:  cfg.strategy(i),
+			orphans:   make(map[d.Hash32][]orphan),
+			orphanSet: make(map[d.Hash32]bool),
+			held:      make(map[d.Hash32]bool),
+		}
+		n.behavior = newBehavior(n.strategy)
+		n.extend(bch.CalculateHash(genesis))
+		s.nodes = append(s.nodes, n)
+	}
+	s.connect()
+	return s, nil
+}
+
+// clockNow is the simulated wall time: the genesis timestamp plus the simulated time elapsed.
+func (s *Simulation) clockNow() time.Time {
+	return s.cfg.Start.Add(s.now)
+}
+
+func (s *Simulation) hashShare(i int) float64 {
+	if s.cfg.HashShares == nil {
+		return 1
+	}
+	return s.cfg.HashShares[i]
+}
+
+// genesisBlock funds every user, miners included, with cfg.Funds.
+func (s *Simulation) genesisBlock() (d.Block, error) {
+	txs, err := blockchain.GenerateFundTransactionsForUsers(s.users, s.cfg.Funds, s.cfg.Funds, s.hasher, s.rng)
+	if err != nil {
+		return d.Block{}, err
+	}
+	body := d.NewBody(txs)
+	header := d.NewHeader(s.cfg.Version, uint32(s.cfg.Start.Unix()), d.Hash32{}, blockchain.MerkleRootHash(*body, s.hasher), s.cfg.Difficulty, 0)
+	if _, _, err := blockchain.FindValidNonce(context.Background(), header, s.hasher); err != nil {
+		return d.Block{}, err
+	}
+	return *d.NewBlock(*header, *body), nil
+}
+
+// Chain returns the blockchain of node i, for inspection after Run.
+func (s *Simulation) Chain(i int) *blockchain.Blockchain {
+	return s.nodes[i].chain
+}
+
+// Run mines until Duration or Blocks is reached, then lets the network drain without
+// losses or partitions so that the nodes can agree on a final chain, and reports the outcome.
+func (s *Simulation) Run(ctx context.Context) (Report, error) {
+	s.mining = true
+	for _, n := range s.nodes {
+		n.behavior.start(n)
+		n.scheduleMining()
+	}
+	s.scheduleTransaction()
+	for _, p := range s.cfg.Partitions {
+		// Healed nodes announce their tips, otherwise they would only notice the
+		// other side once its next block arrives.
+		s.schedule(p.End, s.announceTips)
+	}
+	if s.cfg.Duration > 0 {
+		s.schedule(s.cfg.Duration, s.stopMining)
+	}
+
+	for steps := 0; s.queue.Len() > 0; steps++ {
+		if steps%1024 == 0 && ctx.Err() != nil {
+			return Report{}, ctx.Err()
+		}
+		e := heap.Pop(&s.queue).(*event)
+		s.now = e.at
+		e.run()
+		if s.err != nil {
+			return Report{}, s.err
+		}
+	}
+	return s.report(), nil
+}
+
+func (s *Simulation) stopMining() {
+	if !s.mining {
+		return
+	}
+	s.mining = false
+	s.draining = true
+	s.stopped = s.now
+	for _, n := range s.nodes {
+		n.behavior.stop(n)
+	}
+	s.announceTips()
+}
+
+func (s *Simulation) announceTips() {
+	for _, n := range s.nodes {
+		tip, err := n.chain.GetLatestBlock()
+		if err != nil {
+			continue
+		}
+		n.relay(-1, message{kind: msgBlock, block: tip, hash: n.main[len(n.main)-1]})
+	}
+}
+
+// exponential draws the waiting time of a Poisson process with rate events per second.
+func (s *Simulation) exponential(rate float64) time.Duration {
+	return time.Duration(s.rng.ExpFloat64() / rate * float64(time.Second))
+}
+
+// scheduleTransaction submits a random transfer to a random node after an exponential delay.
+func (s *Simulation) scheduleTransaction() {
+	if s.cfg.TxRate <= 0 {
+		return
+	}
+	s.schedule(s.exponential(s.cfg.TxRate), func() {
+		if !s.mining {
+			return
+		}
+		n := s.nodes[s.rng.Intn(len(s.nodes))]
+		if tx, ok := n.newTransaction(); ok && n.chain.SubmitTransaction(tx) == nil {
+			s.stats.transactions++
+			n.relay(-1, message{kind: msgTx, tx: tx})
+		}
+		s.scheduleTransaction()
+	})
+}
+
+// orphan is a block whose parent has not arrived yet, with the peer it came from.
+type orphan struct {
+	block d.Block
+	hash  d.Hash32
+	from  int
+}
+
+type node struct {
+	sim   *Simulation
+	id    int
+	chain *blockchain.Blockchain
+	miner d.User
+	// rate is the expected number of blocks this node finds per second.
+	rate  float64
+	peers []int
+	mined int
+	// discarded counts the blocks a Withholding miner found and threw away.
+	discarded int
+
+	strategy Strategy
+	behavior behavior
+
+	orphans   map[d.Hash32][]orphan
+	orphanSet map[d.Hash32]bool
+	// held are blocks the node's strategy keeps back from its chain for now.
+	held map[d.Hash32]bool
+
+	// main mirrors the hashes of the node's main chain. setAt holds the time each
+	// height last changed and reachedAt the time the chain first grew past it.
+	main      []d.Hash32
+	setAt     []time.Duration
+	reachedAt []time.Duration
+}
+
+// scheduleMining draws the time of the node's next block. Mining is memoryless,
+// so a new tip does not have to reset the draw.
+func (n *node) scheduleMining() {
+	if n.rate <= 0 {
+		return
+	}
+	n.sim.schedule(n.sim.exponential(n.rate), n.mine)
+}
+
+func (n *node) mine() {
+	s := n.sim
+	if !s.mining {
+		return
+	}
+	block, err := n.newBlock()
+	if err != nil {
+		s.err = fmt.Errorf("node %d: %w", n.id, err)
+		return
+	}
+	n.behavior.found(n, block, n.chain.CalculateHash(block))
+	if s.err != nil {
+		return
+	}
+	if s.cfg.Blocks > 0 && len(s.mined) >= s.cfg.Blocks {
+		s.stopMining()
+		return
+	}
+	n.scheduleMining()
+}
+
+// record marks hash as mined by the node just now on top of its tip.
+func (n *node) record(hash d.Hash32) {
+	n.sim.mined[hash] = minedBlock{miner: n.id, at: n.sim.now, height: len(n.main)}
+	n.mined++
+}
+
+// newBlock builds a block on the node's tip from a coinbase and its mempool.
+func (n *node) newBlock() (d.Block, error) {
+	s := n.sim
+	tipHash, err := n.chain.GetLatestBlockHash()
+	if err != nil {
+		return d.Block{}, err
+	}
+	// Like BIP 34 the coinbase input commits to the block height, so that two
+	// coinbases of the same miner never share a transaction id.
+	coinbase := *d.NewCoinbase(uint32(len(n.main)), 0, []d.TxOutput{{Value: s.cfg.BlockReward, To: n.miner.PublicAddress}})
+	coinbase.TxID = n.chain.HashTransaction(coinbase)
+	txs := append([]d.Transaction{coinbase}, n.chain.Mempool().Transactions(s.cfg.MaxBlockTxs)...)
+
+	body := d.NewBody(txs)
+	timestamp := uint32(s.clockNow().Unix())
+	header := d.NewHeader(s.cfg.Version, timestamp, n.main[len(n.main)-1], blockchain.MerkleRootHash(*body, s.hasher), s.cfg.Difficulty, 0)
+	if header.PrevHash != tipHash {
+		return d.Block{}, fmt.Errorf("tip mirror out of date at height %d", len(n.main)-1)
+	}
+	if _, _, err := blockchain.FindValidNonce(context.Background(), header, s.hasher); err != nil {
+		return d.Block{}, err
+	}
+	return *d.NewBlock(*header, *body), nil
+}
+
+// newTransaction spends a random confirmed output of a random user that the mempool does not spend yet.
+func (n *node) newTransaction() (d.Transaction, bool) {
+	s := n.sim
+	senderIdx := s.rng.Intn(len(s.users))
+	recipientIdx := s.rng.Intn(len(s.users) - 1)
+	if recipientIdx >= senderIdx {
+		recipientIdx++
+	}
+	sender, recipient := s.users[senderIdx], s.users[recipientIdx]
+	if miner := senderIdx - s.cfg.Users; miner >= 0 && s.nodes[miner].strategy == DoubleSpend {
+		// The attacker's coins are reserved for its payments.
+		return d.Transaction{}, false
+	}
+
+	var utxos []d.UTXO
+	for _, utxo := range n.chain.GetUTXOsForAddress(sender.PublicAddress) {
+		if !n.chain.Mempool().IsSpent(utxo.Outpoint) {
+			utxos = append(utxos, utxo)
+		}
+	}
+	if len(utxos) == 0 {
+		return d.Transaction{}, false
+	}
+	utxo := utxos[s.rng.Intn(len(utxos))]
+	amount := 1 + uint32(s.rng.Int63n(int64(utxo.Value)))
+
+	tx := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+		Outputs: []d.TxOutput{{Value: amount, To: recipient.PublicAddress}},
+	}
+	if change := utxo.Value - amount; change > 0 {
+		tx.Outputs = append(tx.Outputs, d.TxOutput{Value: change, To: sender.PublicAddress})
+	}
+	tx.TxID = n.chain.HashTransaction(tx)
+	if err := blockchain.SignInput(&tx, 0, utxo, d.SigHashAll, sender.GetPrivateKeyObject(), s.signer, s.hasher); err != nil {
+		return d.Transaction{}, false
+	}
+	return tx, true
+}
+
+func (n *node) receive(from int, msg message) {
+	switch msg.kind {
+	case msgBlock:
+		n.receiveBlock(from, msg.block, msg.hash)
+	case msgTx:
+		if _, ok := n.chain.Mempool().Get(msg.tx.TxID); ok {
+			return
+		}
+		if n.chain.SubmitTransaction(msg.tx) == nil {
+			n.relay(from, msg)
+		}
+	case msgGetBlock:
+		if b, err := n.chain.GetBlockByHash(msg.hash); err == nil {
+			n.sim.send(n.id, from, message{kind: msgBlock, block: b, hash: msg.hash})
+		}
+	}
+}
+
+func (n *node) receiveBlock(from int, b d.Block, hash d.Hash32) {
+	if n.chain.HaveBlock(hash) || n.orphanSet[hash] || n.held[hash] {
+		return
+	}
+	if !n.chain.HaveBlock(b.Header.PrevHash) && !n.held[b.Header.PrevHash] {
+		n.sim.stats.orphans++
+		n.orphanSet[hash] = true
+		n.orphans[b.Header.PrevHash] = append(n.orphans[b.Header.PrevHash], orphan{block: b, hash: hash, from: from})
+		n.sim.send(n.id, from, message{kind: msgGetBlock, hash: b.Header.PrevHash})
+		return
+	}
+	n.behavior.received(n, from, b, hash)
+}
+
+// connect hands b to the node's chain, relays it and receives orphans waiting for it.
+// A from of -1 marks a block mined by the node itself.
+func (n *node) connect(from int, b d.Block, hash d.Hash32) {
+	if !n.process(from, b, hash) {
+		return
+	}
+	n.relay(from, message{kind: msgBlock, block: b, hash: hash})
+	n.receiveOrphans(hash)
+}
+
+// process hands b to the node's chain without relaying it and reports whether the
+// chain accepted it.
+func (n *node) process(from int, b d.Block, hash d.Hash32) bool {
+	status, err := n.chain.ProcessBlock(b)
+	if err != nil {
+		if from < 0 {
+			n.sim.err = fmt.Errorf("node %d rejected its own block: %w", n.id, err)
+		}
+		n.sim.stats.invalid++
+		return false
+	}
+	switch status {
+	case blockchain.BlockConnected:
+		n.extend(hash)
+	case blockchain.BlockReorganized:
+		n.reorganize()
+	}
+	return true
+}
+
+// receiveOrphans receives the orphans that were waiting for hash.
+func (n *node) receiveOrphans(hash d.Hash32) {
+	children := n.orphans[hash]
+	delete(n.orphans, hash)
+	for _, child := range children {
+		delete(n.orphanSet, child.hash)
+		n.behavior.received(n, child.from, child.block, child.hash)
+	}
+}
+
+// onMain reports whether hash is the block at height of the node's main chain.
+func (n *node) onMain(hash d.Hash32, height int) bool {
+	return height < len(n.main) && n.main[height] == hash
+}
+
+func (n *node) relay(from int, msg message) {
+	for _, peer := range n.peers {
+		if peer != from {
+			n.sim.send(n.id, peer, msg)
+		}
+	}
+}
+
+func (n *node) extend(hash d.Hash32) {
+	n.main = append(n.main, hash)
+	n.setAt = append(n.setAt, n.sim.now)
+	if len(n.reachedAt) < len(n.main) {
+		n.reachedAt = append(n.reachedAt, n.sim.now)
+	}
+}
+
+// reorganize finds where the chain forked from the mirrored main chain and records the reorg depth.
+func (n *node) reorganize() {
+	length := n.chain.Len()
+	fork := min(len(n.main), length)
+	for fork > 0 {
+		hash, err := n.chain.GetBlockHash(fork - 1)
+		if err == nil && hash == n.main[fork-1] {
+			break
+		}
+		fork--
+	}
+	n.sim.stats.reorgDepths[len(n.main)-fork]++
+	n.main = n.main[:fork]
+	n.setAt = n.setAt[:fork]
+	for height := fork; height < length; height++ {
+		hash, _ := n.chain.GetBlockHash(height)
+		n.extend(hash)
+	}
+}
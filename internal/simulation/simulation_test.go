@@ -0,0 +1,271 @@
+package simulation
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Nodes = 5
+	cfg.Degree = 2
+	cfg.Blocks = 30
+	cfg.TxRate = 0.5
+	cfg.Users = 6
+	return cfg
+}
+
+func run(t *testing.T, cfg Config) (*Simulation, Report) {
+	t.Helper()
+	sim, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	report, err := sim.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	return sim, report
+}
+
+func tip(t *testing.T, sim *Simulation, node int) d.Hash32 {
+	t.Helper()
+	block, err := sim.Chain(node).GetLatestBlock()
+	if err != nil {
+		t.Fatalf("GetLatestBlock() error = %v", err)
+	}
+	return sim.Chain(node).CalculateHash(block)
+}
+
+func TestSimulation_SameSeedSameRun(t *testing.T) {
+	cfg := testConfig()
+	cfg.Loss = 0.05
+	cfg.Latency = 2 * time.Second
+
+	firstSim, first := run(t, cfg)
+	secondSim, second := run(t, cfg)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Reports differ for the same seed:\n%+v\n%+v", first, second)
+	}
+	if tip(t, firstSim, 0) != tip(t, secondSim, 0) {
+		t.Error("Runs with the same seed ended on different tips")
+	}
+
+	cfg.Seed++
+	_, other := run(t, cfg)
+	if reflect.DeepEqual(first, other) {
+		t.Error("A different seed produced the same report")
+	}
+}
+
+func TestSimulation_InstantNetworkHasNoStaleBlocks(t *testing.T) {
+	cfg := testConfig()
+	cfg.Latency, cfg.Jitter, cfg.Bandwidth = 0, 0, 0
+
+	sim, report := run(t, cfg)
+	if report.BlocksMined != cfg.Blocks {
+		t.Errorf("BlocksMined = %d, want %d", report.BlocksMined, cfg.Blocks)
+	}
+	if report.StaleBlocks != 0 || report.Reorgs != 0 {
+		t.Errorf("Got %d stale blocks and %d reorgs without network delay", report.StaleBlocks, report.Reorgs)
+	}
+	if !report.Converged || report.Height != cfg.Blocks {
+		t.Errorf("Converged = %t, height = %d, want a converged chain of height %d", report.Converged, report.Height, cfg.Blocks)
+	}
+	if report.FinalBlocks != cfg.Blocks-cfg.FinalityDepth || report.MeanFinality <= 0 {
+		t.Errorf("Finality measured over %d blocks with mean %v", report.FinalBlocks, report.MeanFinality)
+	}
+	for i := 1; i < cfg.Nodes; i++ {
+		if tip(t, sim, i) != tip(t, sim, 0) {
+			t.Errorf("Node %d ended on a different tip", i)
+		}
+	}
+}
+
+func TestSimulation_PartitionForcesReorg(t *testing.T) {
+	cfg := testConfig()
+	cfg.Nodes = 6
+	cfg.Degree = 0
+	cfg.Blocks = 0
+	cfg.Duration = 10 * time.Minute
+	// The minority side has a third of the hash rate and loses its blocks once the partition heals.
+	cfg.Partitions = []Partition{{Start: 30 * time.Second, End: 5 * time.Minute, Groups: [][]int{{0, 1, 2, 3}, {4, 5}}}}
+
+	_, report := run(t, cfg)
+	if !report.Converged {
+		t.Fatal("Nodes did not converge after the partition healed")
+	}
+	if report.MaxReorgDepth < 3 {
+		t.Errorf("MaxReorgDepth = %d, want a deep reorganization after the partition", report.MaxReorgDepth)
+	}
+	if report.StaleBlocks < 3 || report.OrphanRate <= 0 {
+		t.Errorf("StaleBlocks = %d, OrphanRate = %v", report.StaleBlocks, report.OrphanRate)
+	}
+	if report.Dropped == 0 {
+		t.Error("The partition did not drop any message")
+	}
+	if report.MaxFinality < 4*time.Minute {
+		t.Errorf("MaxFinality = %v, blocks mined during the partition should take longer to finalize", report.MaxFinality)
+	}
+}
+
+func TestSimulation_HashShares(t *testing.T) {
+	cfg := testConfig()
+	cfg.HashShares = []float64{0, 1, 1, 1, 1}
+
+	_, report := run(t, cfg)
+	if report.PerNode[0].Mined != 0 || report.PerNode[0].HashShare != 0 {
+		t.Errorf("Node without hash rate mined %d blocks", report.PerNode[0].Mined)
+	}
+	mined := 0
+	for _, n := range report.PerNode {
+		mined += n.Mined
+	}
+	if mined != report.BlocksMined {
+		t.Errorf("Per node blocks sum to %d, want %d", mined, report.BlocksMined)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, strategy := range []Strategy{Honest, Selfish, Withholding, DoubleSpend} {
+		got, err := ParseStrategy(strategy.String())
+		if err != nil || got != strategy {
+			t.Errorf("ParseStrategy(%q) = %v, %v", strategy, got, err)
+		}
+	}
+	if _, err := ParseStrategy("lazy"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("ParseStrategy(lazy) error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
+
+func TestSimulation_SelfishMiningPaysMoreThanHashShare(t *testing.T) {
+	cfg := testConfig()
+	cfg.Blocks = 300
+	cfg.TxRate = 0
+	cfg.Strategies = []Strategy{Selfish}
+	cfg.HashShares = []float64{40, 15, 15, 15, 15}
+
+	_, report := run(t, cfg)
+	selfish := report.PerNode[0]
+	// With 40% of the hash rate and no tie wins selfish mining earns about 48%.
+	if selfish.RevenueShare < selfish.HashShare+0.03 {
+		t.Errorf("Selfish miner earned %.3f with %.3f of the hash rate", selfish.RevenueShare, selfish.HashShare)
+	}
+	if !report.Converged || report.StaleBlocks == 0 {
+		t.Errorf("Converged = %t with %d stale blocks", report.Converged, report.StaleBlocks)
+	}
+	var total float64
+	for _, n := range report.PerNode {
+		total += n.RevenueShare
+	}
+	if total < 0.999 || total > 1.001 {
+		t.Errorf("Revenue shares sum to %v", total)
+	}
+}
+
+func TestSimulation_WithholdingHurtsVictim(t *testing.T) {
+	cfg := testConfig()
+	cfg.Blocks = 200
+	cfg.TxRate = 0
+	cfg.Strategies = []Strategy{Withholding}
+	cfg.WithholdingVictim = 1
+	cfg.HashShares = []float64{10, 30, 20, 20, 20}
+
+	_, report := run(t, cfg)
+	withholder, victim := report.PerNode[0], report.PerNode[1]
+	if withholder.Mined != 0 || withholder.Discarded == 0 || withholder.InChain != 0 {
+		t.Errorf("Withholder mined %d, discarded %d, %d in chain", withholder.Mined, withholder.Discarded, withholder.InChain)
+	}
+	if withholder.Revenue == 0 {
+		t.Error("Withholder was not paid by the victim's pool")
+	}
+	if victim.RevenueShare >= victim.HashShare {
+		t.Errorf("Victim earned %.3f with %.3f of the hash rate", victim.RevenueShare, victim.HashShare)
+	}
+}
+
+func TestSimulation_MajorityDoubleSpends(t *testing.T) {
+	cfg := testConfig()
+	cfg.Blocks = 100
+	cfg.Strategies = []Strategy{DoubleSpend}
+	cfg.HashShares = []float64{60, 10, 10, 10, 10}
+	cfg.Confirmations = 2
+
+	sim, report := run(t, cfg)
+	if report.DoubleSpendAttempts == 0 || report.DoubleSpends == 0 {
+		t.Fatalf("%d of %d payments reversed", report.DoubleSpends, report.DoubleSpendAttempts)
+	}
+	// The last private block can tie with an honest one, so the nodes only have to
+	// agree on the height.
+	for i, n := range report.PerNode {
+		if n.Height != report.PerNode[0].Height {
+			t.Errorf("Node %d ended at height %d, node 0 at %d", i, n.Height, report.PerNode[0].Height)
+		}
+	}
+	// Reversed payments leave the merchants unpaid: all of the attacker's coins
+	// still belong to it on the final chain.
+	attacker := sim.users[cfg.Users]
+	if got := sim.Chain(1).GetUserBalance(attacker.PublicAddress); got < cfg.Funds {
+		t.Errorf("Attacker balance = %d, want at least its funds %d", got, cfg.Funds)
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   error
+	}{
+		{"one node", func(cfg *Config) { cfg.Nodes = 1 }, ErrNoNodes},
+		{"no stop condition", func(cfg *Config) { cfg.Blocks, cfg.Duration = 0, 0 }, ErrNoStopTime},
+		{"hash shares", func(cfg *Config) { cfg.HashShares = []float64{1} }, ErrInvalidConfig},
+		{"loss", func(cfg *Config) { cfg.Loss = 1 }, ErrInvalidConfig},
+		{"too many strategies", func(cfg *Config) { cfg.Strategies = make([]Strategy, 6) }, ErrInvalidConfig},
+		{"withholding victim not honest", func(cfg *Config) {
+			cfg.Strategies = []Strategy{Withholding, Selfish}
+			cfg.WithholdingVictim = 1
+		}, ErrInvalidConfig},
+		{"withholding from itself", func(cfg *Config) { cfg.Strategies = []Strategy{Withholding} }, ErrInvalidConfig},
+		{"double spend without confirmations", func(cfg *Config) {
+			cfg.Strategies = []Strategy{DoubleSpend}
+			cfg.Confirmations = 0
+		}, ErrInvalidConfig},
+		{"unknown node", func(cfg *Config) {
+			cfg.Partitions = []Partition{{Start: 0, End: time.Minute, Groups: [][]int{{0, 9}}}}
+		}, ErrBadPartition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			tt.modify(&cfg)
+			if _, err := New(cfg); !errors.Is(err, tt.want) {
+				t.Errorf("New() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePartition(t *testing.T) {
+	p, err := ParsePartition("1m-3m30s:0,1,2|3, 4")
+	if err != nil {
+		t.Fatalf("ParsePartition() error = %v", err)
+	}
+	want := Partition{Start: time.Minute, End: 3*time.Minute + 30*time.Second, Groups: [][]int{{0, 1, 2}, {3, 4}}}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("ParsePartition() = %+v, want %+v", p, want)
+	}
+	if p.group(4) != 1 || p.group(7) != 2 {
+		t.Errorf("group() = %d, %d, want 1, 2", p.group(4), p.group(7))
+	}
+
+	for _, bad := range []string{"1m-3m", "1m:0|1", "x-3m:0|1", "1m-3m:0,a"} {
+		if _, err := ParsePartition(bad); !errors.Is(err, ErrBadPartition) {
+			t.Errorf("ParsePartition(%q) error = %v, want %v", bad, err, ErrBadPartition)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package simulation
+
+import (
+	"fmt"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// Strategy is how a node's miner treats the blocks it finds and receives.
+type Strategy int
+
+const (
+	// Honest miners publish every block at once and mine on the chain with the most work.
+	Honest Strategy = iota
+	// Selfish miners keep the blocks they find private and publish them only to
+	// override blocks of the honest miners, as in Eyal and Sirer's selfish mining.
+	Selfish
+	// Withholding miners join the pool of the WithholdingVictim node, are paid by it
+	// for their hash rate and throw away every block they find.
+	Withholding
+	// DoubleSpend attackers pay a merchant, then mine a private chain in which the
+	// same coins go back to themselves and publish it once the payment has
+	// Confirmations blocks on top and the private chain has more work.
+	DoubleSpend
+)
+
+var strategyNames = map[Strategy]string{
+	Honest:      "honest",
+	Selfish:     "selfish",
+	Withholding: "withholding",
+	DoubleSpend: "double-spend",
+}
+
+func (s Strategy) String() string {
+	if name, ok := strategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// ParseStrategy returns the strategy called name: honest, selfish, withholding or double-spend.
+func ParseStrategy(name string) (Strategy, error) {
+	for s, n := range strategyNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown strategy %q", ErrInvalidConfig, name)
+}
+
+// behavior implements a Strategy for one node.
+type behavior interface {
+	// start is called when mining begins.
+	start(n *node)
+	// found handles a block the node mined on its tip.
+	found(n *node, b d.Block, hash d.Hash32)
+	// received handles a block from peer from whose parent the node knows.
+	received(n *node, from int, b d.Block, hash d.Hash32)
+	// stop publishes what the node still keeps back when mining stops.
+	stop(n *node)
+}
+
+func newBehavior(s Strategy) behavior {
+	switch s {
+	case Selfish:
+		return &selfish{}
+	case Withholding:
+		return withholding{}
+	case DoubleSpend:
+		return &doubleSpend{}
+	}
+	return honest{}
+}
+
+// heldBlock is a block a strategy keeps back, with the peer it came from.
+type heldBlock struct {
+	block  d.Block
+	hash   d.Hash32
+	height int
+	from   int
+}
+
+// publish relays blocks mined by n that its peers have not seen yet.
+func (n *node) publish(blocks []heldBlock) {
+	for _, hb := range blocks {
+		n.relay(-1, message{kind: msgBlock, block: hb.block, hash: hb.hash})
+	}
+}
+
+type honest struct{}
+
+func (honest) start(*node) {}
+
+func (honest) found(n *node, b d.Block, hash d.Hash32) {
+	n.record(hash)
+	n.connect(-1, b, hash)
+}
+
+func (honest) received(n *node, from int, b d.Block, hash d.Hash32) {
+	n.connect(from, b, hash)
+}
+
+func (honest) stop(*node) {}
+
+// withholding receives like an honest miner and never publishes a block.
+type withholding struct {
+	honest
+}
+
+func (withholding) found(n *node, _ d.Block, _ d.Hash32) {
+	n.discarded++
+}
+
+// selfish follows Eyal and Sirer: with a lead of two or more it answers every honest
+// block with one of its own, which keeps the honest block from ever winning. When the
+// honest miners close the lead to one it publishes everything and wins; when they
+// draw level it publishes everything and races for the tie.
+type selfish struct {
+	// withheld are the private blocks on top of the public chain, oldest first.
+	withheld []heldBlock
+	// publicHeight is the height of the best chain the other miners know of.
+	publicHeight int
+	// racing is set while a published private block ties with an honest one.
+	racing bool
+}
+
+func (s *selfish) start(*node) {}
+
+func (s *selfish) found(n *node, b d.Block, hash d.Hash32) {
+	height := len(n.main)
+	n.record(hash)
+	if !n.process(-1, b, hash) {
+		return
+	}
+	s.withheld = append(s.withheld, heldBlock{block: b, hash: hash, height: height, from: -1})
+	if s.racing {
+		// Winning the tie: the honest miners on the other branch switch to this one.
+		s.racing = false
+		s.publishUpTo(n, height)
+	}
+}
+
+func (s *selfish) received(n *node, from int, b d.Block, hash d.Hash32) {
+	n.connect(from, b, hash)
+	mb, ok := n.sim.mined[hash]
+	if !ok || mb.height <= s.publicHeight {
+		return
+	}
+	s.publicHeight = mb.height
+	s.racing = false
+	if len(s.withheld) == 0 {
+		return
+	}
+	if last := s.withheld[len(s.withheld)-1]; !n.onMain(last.hash, last.height) {
+		// The honest chain overtook the private one.
+		s.withheld = nil
+		return
+	}
+	lead := s.withheld[len(s.withheld)-1].height - s.publicHeight
+	switch {
+	case lead == 0:
+		s.racing = true
+		s.publishUpTo(n, s.publicHeight)
+	case lead == 1:
+		s.publishUpTo(n, s.publicHeight+1)
+	default:
+		s.publishUpTo(n, s.publicHeight)
+	}
+}
+
+// publishUpTo publishes the withheld blocks up to height.
+func (s *selfish) publishUpTo(n *node, height int) {
+	i := 0
+	for i < len(s.withheld) && s.withheld[i].height <= height {
+		i++
+	}
+	n.publish(s.withheld[:i])
+	s.withheld = s.withheld[i:]
+	s.publicHeight = max(s.publicHeight, height)
+}
+
+func (s *selfish) stop(n *node) {
+	if len(s.withheld) > 0 {
+		s.publishUpTo(n, s.withheld[len(s.withheld)-1].height)
+	}
+}
+
+// doubleSpend attacks in rounds. A round pays a merchant and mines on a private
+// fork that returns the coins; honest blocks are held back from the node's chain,
+// which would otherwise switch to them. The round ends in success once the private
+// chain is longer than the public one and the payment is confirmed there, or in
+// failure once the private chain falls GiveUpDeficit blocks behind.
+type doubleSpend struct {
+	attacking bool
+	payment   d.Transaction
+	// forkHeight is the height of the tip the private chain started from.
+	forkHeight int
+	private    []heldBlock
+	public     []heldBlock
+	// publicHeight is the height of the public chain and paymentHeight that of the
+	// public block including the payment, or -1.
+	publicHeight  int
+	paymentHeight int
+}
+
+func (a *doubleSpend) start(n *node) {
+	if a.attacking || !n.sim.mining {
+		return
+	}
+	s := n.sim
+	var utxo d.UTXO
+	found := false
+	for _, u := range n.chain.GetUTXOsForAddress(n.miner.PublicAddress) {
+		if !n.chain.Mempool().IsSpent(u.Outpoint) && u.Value > utxo.Value {
+			utxo, found = u, true
+		}
+	}
+	if !found {
+		return
+	}
+	merchant := s.users[s.rng.Intn(s.cfg.Users)]
+	payment, err := n.spend(utxo, merchant.PublicAddress)
+	if err != nil {
+		return
+	}
+	refund, err := n.spend(utxo, n.miner.PublicAddress)
+	if err != nil || n.chain.SubmitTransaction(refund) != nil {
+		return
+	}
+	s.stats.doubleSpendAttempts++
+	*a = doubleSpend{
+		attacking:     true,
+		payment:       payment,
+		forkHeight:    len(n.main) - 1,
+		publicHeight:  len(n.main) - 1,
+		paymentHeight: -1,
+	}
+	n.relay(-1, message{kind: msgTx, tx: payment})
+}
+
+// spend signs a transaction paying all of utxo to to.
+func (n *node) spend(utxo d.UTXO, to d.PublicAddress) (d.Transaction, error) {
+	tx := d.Transaction{
+		Inputs:  []d.TxInput{{Prev: utxo.Outpoint}},
+		Outputs: []d.TxOutput{{Value: utxo.Value, To: to}},
+	}
+	tx.TxID = n.chain.HashTransaction(tx)
+	err := blockchain.SignInput(&tx, 0, utxo, d.SigHashAll, n.miner.GetPrivateKeyObject(), n.sim.signer, n.sim.hasher)
+	return tx, err
+}
+
+func (a *doubleSpend) found(n *node, b d.Block, hash d.Hash32) {
+	height := len(n.main)
+	n.record(hash)
+	if !a.attacking {
+		n.connect(-1, b, hash)
+		a.start(n)
+		return
+	}
+	if !n.process(-1, b, hash) {
+		return
+	}
+	a.private = append(a.private, heldBlock{block: b, hash: hash, height: height, from: -1})
+	a.check(n)
+}
+
+func (a *doubleSpend) received(n *node, from int, b d.Block, hash d.Hash32) {
+	if !a.attacking {
+		n.connect(from, b, hash)
+		a.start(n)
+		return
+	}
+	height := n.sim.mined[hash].height
+	n.held[hash] = true
+	a.public = append(a.public, heldBlock{block: b, hash: hash, height: height, from: from})
+	a.publicHeight = max(a.publicHeight, height)
+	for _, tx := range b.Body.Transactions {
+		if tx.TxID == a.payment.TxID {
+			a.paymentHeight = height
+		}
+	}
+	n.relay(from, message{kind: msgBlock, block: b, hash: hash})
+	if a.paymentHeight < 0 {
+		// Peers that did not know the spent output yet rejected the payment; offer it again.
+		n.relay(-1, message{kind: msgTx, tx: a.payment})
+	}
+	n.receiveOrphans(hash)
+	a.check(n)
+}
+
+func (a *doubleSpend) check(n *node) {
+	if !a.attacking {
+		return
+	}
+	privateHeight := a.forkHeight + len(a.private)
+	confirmed := a.paymentHeight >= 0 && a.publicHeight-a.paymentHeight+1 >= n.sim.cfg.Confirmations
+	switch {
+	case confirmed && privateHeight > a.publicHeight:
+		n.sim.stats.doubleSpends++
+		a.end(n)
+	case a.publicHeight-privateHeight >= n.sim.cfg.GiveUpDeficit:
+		a.end(n)
+	}
+}
+
+// end publishes the private chain, lets the node's chain pick between it and the
+// held public blocks by work and starts the next round.
+func (a *doubleSpend) end(n *node) {
+	n.publish(a.private)
+	public := a.public
+	*a = doubleSpend{}
+	for _, hb := range public {
+		delete(n.held, hb.hash)
+	}
+	for _, hb := range public {
+		n.process(hb.from, hb.block, hb.hash)
+	}
+	a.start(n)
+}
+
+func (a *doubleSpend) stop(n *node) {
+	if a.attacking {
+		a.end(n)
+	}
+}
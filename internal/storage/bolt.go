@@ -0,0 +1,489 @@
+// Package storage keeps the chain state in an embedded bbolt database, a single file
+// that needs no server.
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+// FormatVersion is the version of the database layout, kept in the meta bucket.
+const FormatVersion uint32 = 1
+
+// ErrUnsupportedVersion is returned by OpenBolt for a database written in another layout.
+var ErrUnsupportedVersion = errors.New("storage: unsupported database version")
+
+// The buckets of the database:
+//
+//	meta       version, prune height and which indexes are kept
+//	headers    height (uint32 BE) -> block hash ‖ serialized header
+//	blocks     block hash -> serialized block
+//	undo       height (uint32 BE) -> outputs the block spent
+//	utxos      txid ‖ index (uint32 BE) -> address ‖ value (uint32 BE)
+//	txindex    txid -> block hash ‖ height ‖ position (uint32 BE)
+//	addrindex  height ‖ entry number (uint32 BE) -> address ‖ address entry
+//	users      address -> public key
+var (
+	metaBucket      = []byte("meta")
+	headersBucket   = []byte("headers")
+	blocksBucket    = []byte("blocks")
+	undoBucket      = []byte("undo")
+	utxosBucket     = []byte("utxos")
+	txIndexBucket   = []byte("txindex")
+	addrIndexBucket = []byte("addrindex")
+	usersBucket     = []byte("users")
+
+	versionKey   = []byte("version")
+	pruneKey     = []byte("prune")
+	txIndexKey   = []byte("txindex")
+	addrIndexKey = []byte("addrindex")
+)
+
+var buckets = [][]byte{metaBucket, headersBucket, blocksBucket, undoBucket, utxosBucket, txIndexBucket, addrIndexBucket, usersBucket}
+
+const (
+	hashSize         = len(d.Hash32{})
+	addressSize      = len(d.PublicAddress{})
+	headerSize       = 80
+	utxoValueSize    = addressSize + 4
+	undoEntrySize    = hashSize + 4 + utxoValueSize
+	txLocationSize   = hashSize + 8
+	addressEntrySize = addressSize + 4 + 2*hashSize + 3*8
+)
+
+// BoltStore is a blockchain.Store kept in a bbolt database. Every block update is one
+// bbolt transaction, which bbolt commits atomically and durably, so the database
+// always holds the chain as it was after some block.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var _ blockchain.Store = (*BoltStore)(nil)
+
+// OpenBolt opens the database at path, creating it if it does not exist. A database
+// held open by another process makes it fail after a second.
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("storage: open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(metaBucket)
+		if v := meta.Get(versionKey); v != nil {
+			if len(v) != 4 || binary.BigEndian.Uint32(v) != FormatVersion {
+				return ErrUnsupportedVersion
+			}
+			return nil
+		}
+		return meta.Put(versionKey, uint32Key(FormatVersion))
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the database.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// ConnectBlock writes u.Block as the new tip together with its undo data, UTXO changes
+// and index entries, and drops the bodies and undo data below u.PruneHeight.
+func (s *BoltStore) ConnectBlock(u blockchain.BlockUpdate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		headers := tx.Bucket(headersBucket)
+		if n := chainLen(headers); n != u.Height {
+			return fmt.Errorf("%w: connecting block %d on top of %d blocks", blockchain.ErrCorruptStore, u.Height, n)
+		}
+		height := uint32Key(uint32(u.Height))
+		if err := headers.Put(height, append(bytes.Clone(u.Hash[:]), u.Block.Header.Serialize()...)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(blocksBucket).Put(u.Hash[:], u.Block.Serialize()); err != nil {
+			return err
+		}
+		if err := tx.Bucket(undoBucket).Put(height, encodeUTXOs(u.Spent)); err != nil {
+			return err
+		}
+		if err := deleteUTXOs(tx, u.Spent); err != nil {
+			return err
+		}
+		if err := putUTXOs(tx, u.Created); err != nil {
+			return err
+		}
+		if err := s.connectIndexes(tx, u); err != nil {
+			return err
+		}
+		return prune(tx, u.PruneHeight)
+	})
+}
+
+// DisconnectBlock removes the tip u.Block and restores the outputs it spent.
+func (s *BoltStore) DisconnectBlock(u blockchain.BlockUpdate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		headers := tx.Bucket(headersBucket)
+		height := uint32Key(uint32(u.Height))
+		if n := chainLen(headers); n != u.Height+1 {
+			return fmt.Errorf("%w: disconnecting block %d from %d blocks", blockchain.ErrCorruptStore, u.Height, n)
+		}
+		if v := headers.Get(height); len(v) < hashSize || !bytes.Equal(v[:hashSize], u.Hash[:]) {
+			return fmt.Errorf("%w: block %d is not the stored tip", blockchain.ErrCorruptStore, u.Height)
+		}
+		if err := headers.Delete(height); err != nil {
+			return err
+		}
+		if err := tx.Bucket(blocksBucket).Delete(u.Hash[:]); err != nil {
+			return err
+		}
+		if err := tx.Bucket(undoBucket).Delete(height); err != nil {
+			return err
+		}
+		if err := deleteUTXOs(tx, u.Created); err != nil {
+			return err
+		}
+		if err := putUTXOs(tx, u.Spent); err != nil {
+			return err
+		}
+		return s.disconnectIndexes(tx, u)
+	})
+}
+
+// PutUsers registers users, replacing the public key of a known address.
+func (s *BoltStore) PutUsers(users []d.User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		for _, user := range users {
+			if err := bucket.Put(user.PublicAddress[:], user.PublicKey[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// connectIndexes writes the index entries of a connected block. An index the chain no
+// longer keeps is dropped, since it would miss the block; one the chain keeps is
+// started only with the genesis block, since it would otherwise miss older blocks.
+func (s *BoltStore) connectIndexes(tx *bolt.Tx, u blockchain.BlockUpdate) error {
+	meta := tx.Bucket(metaBucket)
+	if err := keepIndex(tx, txIndexKey, txIndexBucket, u.TxIndex, u.Height); err != nil {
+		return err
+	}
+	if err := keepIndex(tx, addrIndexKey, addrIndexBucket, u.AddressIndex, u.Height); err != nil {
+		return err
+	}
+	if meta.Get(txIndexKey) != nil {
+		bucket := tx.Bucket(txIndexBucket)
+		for i, t := range u.Block.Body.Transactions {
+			v := make([]byte, 0, txLocationSize)
+			v = append(v, u.Hash[:]...)
+			v = binary.BigEndian.AppendUint32(v, uint32(u.Height))
+			v = binary.BigEndian.AppendUint32(v, uint32(i))
+			if err := bucket.Put(t.TxID[:], v); err != nil {
+				return err
+			}
+		}
+	}
+	if meta.Get(addrIndexKey) != nil {
+		bucket := tx.Bucket(addrIndexBucket)
+		for i, r := range u.Addresses {
+			key := binary.BigEndian.AppendUint32(uint32Key(uint32(u.Height)), uint32(i))
+			if err := bucket.Put(key, encodeAddressRecord(r)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// disconnectIndexes removes the index entries of a disconnected block.
+func (s *BoltStore) disconnectIndexes(tx *bolt.Tx, u blockchain.BlockUpdate) error {
+	meta := tx.Bucket(metaBucket)
+	if err := keepIndex(tx, txIndexKey, txIndexBucket, u.TxIndex, u.Height); err != nil {
+		return err
+	}
+	if err := keepIndex(tx, addrIndexKey, addrIndexBucket, u.AddressIndex, u.Height); err != nil {
+		return err
+	}
+	if meta.Get(txIndexKey) != nil {
+		bucket := tx.Bucket(txIndexBucket)
+		for _, t := range u.Block.Body.Transactions {
+			if v := bucket.Get(t.TxID[:]); len(v) == txLocationSize && bytes.Equal(v[:hashSize], u.Hash[:]) {
+				if err := bucket.Delete(t.TxID[:]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if meta.Get(addrIndexKey) != nil {
+		prefix := uint32Key(uint32(u.Height))
+		c := tx.Bucket(addrIndexBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// keepIndex records that the index under key is kept when enabled and the update is
+// of the genesis block, and drops the index when it is not enabled.
+func keepIndex(tx *bolt.Tx, key, name []byte, enabled bool, height int) error {
+	meta := tx.Bucket(metaBucket)
+	switch {
+	case enabled && height == 0:
+		return meta.Put(key, []byte{1})
+	case !enabled && meta.Get(key) != nil:
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+		return meta.Delete(key)
+	}
+	return nil
+}
+
+// prune drops the bodies and undo data of the blocks from the stored prune height up
+// to height.
+func prune(tx *bolt.Tx, height int) error {
+	meta := tx.Bucket(metaBucket)
+	from := 0
+	if v := meta.Get(pruneKey); len(v) == 4 {
+		from = int(binary.BigEndian.Uint32(v))
+	}
+	if height <= from {
+		return nil
+	}
+	headers, blocks, undo := tx.Bucket(headersBucket), tx.Bucket(blocksBucket), tx.Bucket(undoBucket)
+	for h := from; h < height; h++ {
+		key := uint32Key(uint32(h))
+		if v := headers.Get(key); len(v) >= hashSize {
+			if err := blocks.Delete(v[:hashSize]); err != nil {
+				return err
+			}
+		}
+		if err := undo.Delete(key); err != nil {
+			return err
+		}
+	}
+	return meta.Put(pruneKey, uint32Key(uint32(height)))
+}
+
+// Load reads the stored chain.
+func (s *BoltStore) Load() (blockchain.StoredChain, error) {
+	var chain blockchain.StoredChain
+	err := s.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if v := meta.Get(pruneKey); len(v) == 4 {
+			chain.PruneHeight = int(binary.BigEndian.Uint32(v))
+		}
+
+		blocks, undo := tx.Bucket(blocksBucket), tx.Bucket(undoBucket)
+		err := tx.Bucket(headersBucket).ForEach(func(k, v []byte) error {
+			height := len(chain.Headers)
+			if len(k) != 4 || int(binary.BigEndian.Uint32(k)) != height || len(v) != hashSize+headerSize {
+				return fmt.Errorf("%w: bad header record at height %d", blockchain.ErrCorruptStore, height)
+			}
+			header, err := d.DeserializeHeader(bytes.NewReader(v[hashSize:]))
+			if err != nil {
+				return fmt.Errorf("%w: header %d: %v", blockchain.ErrCorruptStore, height, err)
+			}
+			chain.Headers = append(chain.Headers, header)
+			if height < chain.PruneHeight {
+				return nil
+			}
+			raw := blocks.Get(v[:hashSize])
+			if raw == nil {
+				return fmt.Errorf("%w: block %d is missing", blockchain.ErrCorruptStore, height)
+			}
+			block, err := d.DeserializeBlock(bytes.NewReader(raw))
+			if err != nil {
+				return fmt.Errorf("%w: block %d: %v", blockchain.ErrCorruptStore, height, err)
+			}
+			spent, err := decodeUTXOs(undo.Get(k))
+			if err != nil {
+				return fmt.Errorf("%w: undo data of block %d: %v", blockchain.ErrCorruptStore, height, err)
+			}
+			chain.Blocks = append(chain.Blocks, block)
+			chain.Undo = append(chain.Undo, spent)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(utxosBucket).ForEach(func(k, v []byte) error {
+			if len(k) != hashSize+4 || len(v) != utxoValueSize {
+				return fmt.Errorf("%w: bad UTXO record", blockchain.ErrCorruptStore)
+			}
+			var utxo d.UTXO
+			copy(utxo.Outpoint.TxID[:], k)
+			utxo.Outpoint.Index = binary.BigEndian.Uint32(k[hashSize:])
+			copy(utxo.To[:], v)
+			utxo.Value = binary.BigEndian.Uint32(v[addressSize:])
+			chain.UTXOs = append(chain.UTXOs, utxo)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var user d.User
+			if len(k) != addressSize || len(v) != len(user.PublicKey) {
+				return fmt.Errorf("%w: bad user record", blockchain.ErrCorruptStore)
+			}
+			copy(user.PublicAddress[:], k)
+			copy(user.PublicKey[:], v)
+			chain.Users = append(chain.Users, user)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if meta.Get(txIndexKey) != nil {
+			chain.TxIndex = make(map[d.Hash32]blockchain.TxLocation)
+			err = tx.Bucket(txIndexBucket).ForEach(func(k, v []byte) error {
+				if len(k) != hashSize || len(v) != txLocationSize {
+					return fmt.Errorf("%w: bad transaction index record", blockchain.ErrCorruptStore)
+				}
+				var loc blockchain.TxLocation
+				copy(loc.BlockHash[:], v)
+				loc.Height = int(binary.BigEndian.Uint32(v[hashSize:]))
+				loc.Position = int(binary.BigEndian.Uint32(v[hashSize+4:]))
+				chain.TxIndex[d.Hash32(k)] = loc
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if meta.Get(addrIndexKey) != nil {
+			chain.Addresses = []blockchain.AddressRecord{}
+			err = tx.Bucket(addrIndexBucket).ForEach(func(k, v []byte) error {
+				r, err := decodeAddressRecord(v)
+				if err != nil {
+					return err
+				}
+				chain.Addresses = append(chain.Addresses, r)
+				return nil
+			})
+		}
+		return err
+	})
+	if err != nil {
+		return blockchain.StoredChain{}, err
+	}
+	return chain, nil
+}
+
+// chainLen returns the number of stored main chain blocks.
+func chainLen(headers *bolt.Bucket) int {
+	k, _ := headers.Cursor().Last()
+	if len(k) != 4 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(k)) + 1
+}
+
+func uint32Key(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func outpointKey(o d.Outpoint) []byte {
+	return binary.BigEndian.AppendUint32(bytes.Clone(o.TxID[:]), o.Index)
+}
+
+func putUTXOs(tx *bolt.Tx, utxos []d.UTXO) error {
+	bucket := tx.Bucket(utxosBucket)
+	for _, utxo := range utxos {
+		v := binary.BigEndian.AppendUint32(bytes.Clone(utxo.To[:]), utxo.Value)
+		if err := bucket.Put(outpointKey(utxo.Outpoint), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteUTXOs(tx *bolt.Tx, utxos []d.UTXO) error {
+	bucket := tx.Bucket(utxosBucket)
+	for _, utxo := range utxos {
+		if err := bucket.Delete(outpointKey(utxo.Outpoint)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeUTXOs(utxos []d.UTXO) []byte {
+	buf := make([]byte, 0, len(utxos)*undoEntrySize)
+	for _, utxo := range utxos {
+		buf = append(buf, outpointKey(utxo.Outpoint)...)
+		buf = append(buf, utxo.To[:]...)
+		buf = binary.BigEndian.AppendUint32(buf, utxo.Value)
+	}
+	return buf
+}
+
+func decodeUTXOs(buf []byte) ([]d.UTXO, error) {
+	if len(buf)%undoEntrySize != 0 {
+		return nil, fmt.Errorf("length %d is not a multiple of %d", len(buf), undoEntrySize)
+	}
+	var utxos []d.UTXO
+	for ; len(buf) > 0; buf = buf[undoEntrySize:] {
+		var utxo d.UTXO
+		copy(utxo.Outpoint.TxID[:], buf)
+		utxo.Outpoint.Index = binary.BigEndian.Uint32(buf[hashSize:])
+		copy(utxo.To[:], buf[hashSize+4:])
+		utxo.Value = binary.BigEndian.Uint32(buf[hashSize+4+addressSize:])
+		utxos = append(utxos, utxo)
+	}
+	return utxos, nil
+}
+
+func encodeAddressRecord(r blockchain.AddressRecord) []byte {
+	e := r.Entry
+	buf := make([]byte, 0, addressEntrySize)
+	buf = append(buf, r.Address[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(e.Height))
+	buf = append(buf, e.BlockHash[:]...)
+	buf = append(buf, e.TxID[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, e.Credit)
+	buf = binary.BigEndian.AppendUint64(buf, e.Debit)
+	return binary.BigEndian.AppendUint64(buf, e.Balance)
+}
+
+func decodeAddressRecord(buf []byte) (blockchain.AddressRecord, error) {
+	var r blockchain.AddressRecord
+	if len(buf) != addressEntrySize {
+		return r, fmt.Errorf("%w: bad address index record", blockchain.ErrCorruptStore)
+	}
+	copy(r.Address[:], buf)
+	buf = buf[addressSize:]
+	r.Entry.Height = int(binary.BigEndian.Uint32(buf))
+	copy(r.Entry.BlockHash[:], buf[4:])
+	copy(r.Entry.TxID[:], buf[4+hashSize:])
+	buf = buf[4+2*hashSize:]
+	r.Entry.Credit = binary.BigEndian.Uint64(buf)
+	r.Entry.Debit = binary.BigEndian.Uint64(buf[8:])
+	r.Entry.Balance = binary.BigEndian.Uint64(buf[16:])
+	return r, nil
+}
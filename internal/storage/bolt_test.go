@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+func mineOnParent(t *testing.T, bch *blockchain.Blockchain, parent d.Block, txs ...d.Transaction) d.Block {
+	t.Helper()
+	hasher := c.NewArchasHasher()
+	body := d.Body{Transactions: txs}
+	header := d.Header{
+		Version:    1,
+		Timestamp:  uint32(time.Now().Unix()),
+		PrevHash:   bch.CalculateHash(parent),
+		MerkleRoot: blockchain.MerkleRootHash(body, hasher),
+		Difficulty: 1,
+	}
+	if _, _, err := blockchain.FindValidNonce(context.Background(), &header, hasher); err != nil {
+		t.Fatalf("FindValidNonce() error = %v", err)
+	}
+	return d.Block{Header: header, Body: body}
+}
+
+// coinbaseBlock mines a block on parent paying value to user.
+func coinbaseBlock(t *testing.T, bch *blockchain.Blockchain, parent d.Block, user d.User, value uint32) d.Block {
+	t.Helper()
+	coinbase := d.Transaction{Outputs: []d.TxOutput{{Value: value, To: user.PublicAddress}}}
+	coinbase.TxID = bch.HashTransaction(coinbase)
+	return mineOnParent(t, bch, parent, coinbase)
+}
+
+// signedTransfer returns a transaction paying value from the largest UTXO of from to
+// to, with the change going back to from.
+func signedTransfer(t *testing.T, bch *blockchain.Blockchain, from, to d.User, value uint32) d.Transaction {
+	t.Helper()
+	hasher := c.NewArchasHasher()
+	var utxo d.UTXO
+	for _, u := range bch.GetUTXOsForAddress(from.PublicAddress) {
+		if u.Value > utxo.Value {
+			utxo = u
+		}
+	}
+	if utxo.Value < value {
+		t.Fatalf("%s cannot pay %d", from.Name, value)
+	}
+	tx := d.Transaction{
+		Inputs: []d.TxInput{{Prev: utxo.Outpoint}},
+		Outputs: []d.TxOutput{
+			{Value: value, To: to.PublicAddress},
+			{Value: utxo.Value - value, To: from.PublicAddress},
+		},
+	}
+	tx.TxID = hasher.Hash(tx.SerializeWithoutSignatures())
+	if err := blockchain.SignInput(&tx, 0, utxo, d.SigHashAll, from.GetPrivateKeyObject(), c.NewTransactionSigner(), hasher); err != nil {
+		t.Fatalf("SignInput() error = %v", err)
+	}
+	return tx
+}
+
+func processBlock(t *testing.T, bch *blockchain.Blockchain, b d.Block, want blockchain.BlockStatus) {
+	t.Helper()
+	if status, err := bch.ProcessBlock(b); err != nil || status != want {
+		t.Fatalf("ProcessBlock() = %v, %v; want %v", status, err, want)
+	}
+}
+
+func openChain(t *testing.T, path string, opts ...blockchain.Option) (*blockchain.Blockchain, *BoltStore) {
+	t.Helper()
+	store, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt() error = %v", err)
+	}
+	bch, err := blockchain.OpenBlockchain(c.NewArchasHasher(), c.NewTransactionSigner(), append(opts, blockchain.WithStore(store))...)
+	if err != nil {
+		store.Close()
+		t.Fatalf("OpenBlockchain() error = %v", err)
+	}
+	return bch, store
+}
+
+func utxoCommitment(t *testing.T, bch *blockchain.Blockchain) d.Hash32 {
+	t.Helper()
+	snap, err := bch.DumpUTXOSet(io.Discard, bch.Len()-1)
+	if err != nil {
+		t.Fatalf("DumpUTXOSet() error = %v", err)
+	}
+	return snap.Commitment
+}
+
+func TestBoltStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	store, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt() error = %v", err)
+	}
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	users := blockchain.NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice", "Bob", "Charlie"}, 3)
+	opts := []blockchain.Option{blockchain.WithTxIndex(), blockchain.WithAddressIndex()}
+	bch := blockchain.InitBlockchainWithFunds(100000, 100000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), append(opts, blockchain.WithStore(store))...)
+
+	genesis, _ := bch.GetLatestBlock()
+	first := signedTransfer(t, bch, users[0], users[1], 10)
+	block1 := mineOnParent(t, bch, genesis, first)
+	processBlock(t, bch, block1, blockchain.BlockConnected)
+	processBlock(t, bch, mineOnParent(t, bch, block1, signedTransfer(t, bch, users[1], users[2], 20)), blockchain.BlockConnected)
+
+	// A longer branch from block 1 replaces block 2.
+	side := block1
+	for i := range 2 {
+		side = coinbaseBlock(t, bch, side, users[2], uint32(100+i))
+		status := blockchain.BlockSideChain
+		if i == 1 {
+			status = blockchain.BlockReorganized
+		}
+		processBlock(t, bch, side, status)
+	}
+	processBlock(t, bch, mineOnParent(t, bch, side, signedTransfer(t, bch, users[2], users[0], 30)), blockchain.BlockConnected)
+	if err := bch.StoreErr(); err != nil {
+		t.Fatalf("StoreErr() = %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, store := openChain(t, path, opts...)
+	defer store.Close()
+	if reopened.Len() != bch.Len() || reopened.ChainWork().Cmp(bch.ChainWork()) != 0 {
+		t.Fatalf("reopened chain has %d blocks and work %v, want %d and %v", reopened.Len(), reopened.ChainWork(), bch.Len(), bch.ChainWork())
+	}
+	want := bch.Blocks()
+	for i, b := range reopened.Blocks() {
+		if !bytes.Equal(b.Serialize(), want[i].Serialize()) {
+			t.Errorf("block %d differs after reopening", i)
+		}
+		for j, tx := range b.Body.Transactions {
+			if tx.TxID != want[i].Body.Transactions[j].TxID {
+				t.Errorf("TxID of transaction %d of block %d = %s, want %s", j, i, tx.TxID.String(), want[i].Body.Transactions[j].TxID.String())
+			}
+		}
+	}
+	if utxoCommitment(t, reopened) != utxoCommitment(t, bch) {
+		t.Error("UTXO set differs after reopening")
+	}
+	if len(reopened.Users()) != len(users) {
+		t.Errorf("Users() = %d users, want %d", len(reopened.Users()), len(users))
+	}
+	for _, u := range users {
+		if got, wantBalance := reopened.GetUserBalance(u.PublicAddress), bch.GetUserBalance(u.PublicAddress); got != wantBalance {
+			t.Errorf("balance of %s = %d, want %d", u.Name, got, wantBalance)
+		}
+		got, total, err := reopened.AddressHistory(u.PublicAddress, 0, 0)
+		wantHistory, wantTotal, _ := bch.AddressHistory(u.PublicAddress, 0, 0)
+		if err != nil || total != wantTotal || len(got) != len(wantHistory) {
+			t.Errorf("AddressHistory(%s) = %d of %d entries, %v; want %d", u.Name, len(got), total, err, wantTotal)
+			continue
+		}
+		for i := range got {
+			if got[i] != wantHistory[i] {
+				t.Errorf("AddressHistory(%s)[%d] = %+v, want %+v", u.Name, i, got[i], wantHistory[i])
+			}
+		}
+	}
+	status, err := reopened.GetTransaction(first.TxID)
+	if err != nil || status.Block == nil || status.Block.Height != 1 {
+		t.Errorf("GetTransaction() = %+v, %v; want confirmed at height 1", status.Block, err)
+	}
+
+	// The reopened chain goes on writing to the store.
+	tip, _ := reopened.GetLatestBlock()
+	processBlock(t, reopened, coinbaseBlock(t, reopened, tip, users[0], 500), blockchain.BlockConnected)
+	length := reopened.Len()
+	store.Close()
+	again, store := openChain(t, path, opts...)
+	defer store.Close()
+	if again.Len() != length {
+		t.Errorf("chain reopened a second time has %d blocks, want %d", again.Len(), length)
+	}
+}
+
+func TestBoltStore_Prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	bch, store := openChain(t, path, blockchain.WithPrune(2))
+	if bch.Len() != 0 {
+		t.Fatalf("new store holds %d blocks", bch.Len())
+	}
+	store.Close()
+
+	store, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt() error = %v", err)
+	}
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	users := blockchain.NewUserGeneratorService(c.NewKeyGenerator()).GenerateUsers([]string{"Alice"}, 1)
+	bch = blockchain.InitBlockchainWithFunds(1000, 1000, users, cfg, c.NewArchasHasher(), c.NewTransactionSigner(), blockchain.WithPrune(2), blockchain.WithStore(store))
+	for i := range 4 {
+		tip, _ := bch.GetLatestBlock()
+		processBlock(t, bch, coinbaseBlock(t, bch, tip, users[0], uint32(i+1)), blockchain.BlockConnected)
+	}
+	store.Close()
+
+	if store, err = OpenBolt(path); err != nil {
+		t.Fatalf("OpenBolt() error = %v", err)
+	}
+	if _, err := blockchain.OpenBlockchain(c.NewArchasHasher(), c.NewTransactionSigner(), blockchain.WithTxIndex(), blockchain.WithStore(store)); !errors.Is(err, blockchain.ErrStoreIndexMissing) {
+		t.Errorf("OpenBlockchain() with an index the store lacks error = %v, want %v", err, blockchain.ErrStoreIndexMissing)
+	}
+	store.Close()
+
+	reopened, store := openChain(t, path, blockchain.WithPrune(2))
+	defer store.Close()
+	if reopened.Len() != 5 || reopened.PruneHeight() != bch.PruneHeight() {
+		t.Fatalf("reopened chain has %d blocks pruned below %d, want 5 below %d", reopened.Len(), reopened.PruneHeight(), bch.PruneHeight())
+	}
+	if _, err := reopened.GetBlock(1); !errors.Is(err, d.ErrBlockPruned) {
+		t.Errorf("GetBlock(1) error = %v, want %v", err, d.ErrBlockPruned)
+	}
+	if b, err := reopened.GetBlock(4); err != nil || len(b.Body.Transactions) != 1 {
+		t.Errorf("GetBlock(4) = %d transactions, %v; want the kept body", len(b.Body.Transactions), err)
+	}
+	if utxoCommitment(t, reopened) != utxoCommitment(t, bch) {
+		t.Error("UTXO set differs after reopening")
+	}
+	err = store.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(blocksBucket).Stats().KeyN; n != 2 {
+			t.Errorf("store keeps %d block bodies, want 2", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,267 @@
+package stratum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+)
+
+// MinerStats counts the work of a Miner.
+type MinerStats struct {
+	Jobs     int
+	Accepted int
+	Rejected int
+	Hashes   uint64
+}
+
+// Miner is an external mining process: it connects to a Server, searches the nonces
+// of the jobs it is sent and submits every share it finds.
+type Miner struct {
+	addr    string
+	name    string
+	hasher  c.Hasher
+	threads int
+	logger  *slog.Logger
+
+	conn       net.Conn
+	codec      *codec
+	writeMutex *sync.Mutex
+
+	mutex    *sync.Mutex
+	nextID   uint64
+	pending  map[uint64]chan Response
+	stats    MinerStats
+	stopJob  context.CancelFunc
+	hashes   *atomic.Uint64
+	searchWG *sync.WaitGroup
+}
+
+// NewMiner creates a miner that authorizes as name on the server at addr and
+// searches every job with threads goroutines.
+func NewMiner(addr, name string, hasher c.Hasher, threads int) *Miner {
+	if threads < 1 {
+		threads = 1
+	}
+	return &Miner{
+		addr:       addr,
+		name:       name,
+		hasher:     hasher,
+		threads:    threads,
+		logger:     slog.Default(),
+		writeMutex: &sync.Mutex{},
+		mutex:      &sync.Mutex{},
+		pending:    make(map[uint64]chan Response),
+		hashes:     &atomic.Uint64{},
+		searchWG:   &sync.WaitGroup{},
+	}
+}
+
+// SetLogger replaces the logger the miner reports malformed jobs to, slog.Default()
+// unless set. It must be called before Run.
+func (m *Miner) SetLogger(l *slog.Logger) {
+	m.logger = l
+}
+
+// Stats returns the miner's counters so far.
+func (m *Miner) Stats() MinerStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := m.stats
+	stats.Hashes = m.hashes.Load()
+	return stats
+}
+
+// Run connects, subscribes, authorizes and mines until ctx is cancelled or the
+// server closes the connection.
+func (m *Miner) Run(ctx context.Context) error {
+	conn, err := net.DialTimeout("tcp", m.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+	m.codec = newCodec(conn)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- m.readLoop(ctx) }()
+
+	if _, err := m.call(ctx, MethodSubscribe, "blockchain-uzd2"); err != nil {
+		cancel()
+		<-done
+		return err
+	}
+	if _, err := m.call(ctx, MethodAuthorize, m.name); err != nil {
+		cancel()
+		<-done
+		return err
+	}
+
+	err = <-done
+	m.mutex.Lock()
+	if m.stopJob != nil {
+		m.stopJob()
+	}
+	m.mutex.Unlock()
+	m.searchWG.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// call sends a request and waits for its response.
+func (m *Miner) call(ctx context.Context, method string, params ...any) (json.RawMessage, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan Response, 1)
+	m.mutex.Lock()
+	m.nextID++
+	id := m.nextID
+	m.pending[id] = ch
+	m.mutex.Unlock()
+
+	if err := m.write(Request{ID: &id, Method: method, Params: raw}); err != nil {
+		return nil, err
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errors.New("stratum: connection closed")
+		}
+		if err := responseError(resp.Error); err != nil {
+			return nil, err
+		}
+		return resp.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *Miner) write(v any) error {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	return m.codec.write(v)
+}
+
+// readLoop dispatches responses to waiting calls and handles the server's notifications.
+func (m *Miner) readLoop(ctx context.Context) error {
+	defer func() {
+		m.mutex.Lock()
+		for id, ch := range m.pending {
+			close(ch)
+			delete(m.pending, id)
+		}
+		m.mutex.Unlock()
+	}()
+	for {
+		line, err := m.codec.read()
+		if err != nil {
+			return err
+		}
+		var req Request
+		if err := json.Unmarshal(line, &req); err == nil && req.Method != "" {
+			m.handleNotification(ctx, req)
+			continue
+		}
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return err
+		}
+		m.mutex.Lock()
+		ch, ok := m.pending[resp.ID]
+		delete(m.pending, resp.ID)
+		m.mutex.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+func (m *Miner) handleNotification(ctx context.Context, req Request) {
+	switch req.Method {
+	case MethodNotify:
+		var params []Job
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params) != 1 {
+			m.logger.Warn("stratum: malformed job", "worker", m.name, "err", err)
+			return
+		}
+		m.startJob(ctx, params[0])
+	case MethodSetDifficulty:
+		// Every job carries its share difficulty; nothing to remember.
+	}
+}
+
+// startJob stops searching the previous job and splits the nonce range of j
+// between the miner's threads.
+func (m *Miner) startJob(ctx context.Context, j Job) {
+	m.mutex.Lock()
+	if m.stopJob != nil {
+		m.stopJob()
+	}
+	jobCtx, stop := context.WithCancel(ctx)
+	m.stopJob = stop
+	m.stats.Jobs++
+	m.mutex.Unlock()
+
+	span := (uint64(1) << 32) / uint64(m.threads)
+	for i := range m.threads {
+		start := uint64(i) * span
+		end := start + span
+		if i == m.threads-1 {
+			end = uint64(1) << 32
+		}
+		m.searchWG.Add(1)
+		go func() {
+			defer m.searchWG.Done()
+			m.search(jobCtx, ctx, j, start, end)
+		}()
+	}
+}
+
+// search hashes the nonces in [start, end) until jobCtx is cancelled and submits the
+// shares among them. Submissions outlive the job, so they wait on runCtx instead.
+func (m *Miner) search(jobCtx, runCtx context.Context, j Job, start, end uint64) {
+	header := j.Header(0)
+	hash := blockchain.NonceHasher(blockchain.NewPoW(j.Version, m.hasher), &header)
+	const batch = 1024
+	for nonce := start; nonce < end; nonce++ {
+		if nonce%batch == 0 {
+			if jobCtx.Err() != nil {
+				return
+			}
+			m.hashes.Add(batch)
+		}
+		if blockchain.IsHashValid(hash(uint32(nonce)), j.ShareDifficulty) {
+			go m.submit(runCtx, j, uint32(nonce))
+		}
+	}
+}
+
+func (m *Miner) submit(ctx context.Context, j Job, nonce uint32) {
+	_, err := m.call(ctx, MethodSubmit, m.name, j.ID, nonce)
+	if ctx.Err() != nil && err != nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err != nil {
+		m.stats.Rejected++
+		return
+	}
+	m.stats.Accepted++
+}
@@ -0,0 +1,147 @@
+// Package stratum distributes mining jobs to external miners over TCP. The protocol
+// follows Stratum v1: newline separated JSON-RPC messages with the methods
+// mining.subscribe, mining.authorize, mining.set_difficulty, mining.notify and
+// mining.submit. Unlike Stratum v1 the node builds the coinbase itself: every job
+// carries a unique extra nonce and a ready Merkle root, so a miner only searches
+// header nonces, as in the standard channels of Stratum v2.
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+)
+
+// Methods of the protocol. Miners call subscribe, authorize and submit; the node
+// sends set_difficulty and notify as notifications without an id.
+const (
+	MethodSubscribe     = "mining.subscribe"
+	MethodAuthorize     = "mining.authorize"
+	MethodSubmit        = "mining.submit"
+	MethodSetDifficulty = "mining.set_difficulty"
+	MethodNotify        = "mining.notify"
+)
+
+// maxLineSize bounds one JSON-RPC message.
+const maxLineSize = 64 << 10
+
+var (
+	ErrNotSubscribed  = errors.New("stratum: not subscribed")
+	ErrUnauthorized   = errors.New("stratum: unauthorized worker")
+	ErrStaleJob       = errors.New("stratum: job not found or stale")
+	ErrDuplicateShare = errors.New("stratum: duplicate share")
+	ErrLowDifficulty  = errors.New("stratum: share above target")
+	ErrBadParams      = errors.New("stratum: invalid parameters")
+	ErrUnknownMethod  = errors.New("stratum: unknown method")
+)
+
+// Error codes as used by Stratum v1 pools.
+var errorCodes = map[error]int{
+	ErrStaleJob:       21,
+	ErrDuplicateShare: 22,
+	ErrLowDifficulty:  23,
+	ErrUnauthorized:   24,
+	ErrNotSubscribed:  25,
+}
+
+// Request is a call from a miner, or a notification from the node when ID is nil.
+type Request struct {
+	ID     *uint64         `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Response answers a Request with the same ID. Error is nil on success and
+// otherwise [code, message, null] as in Stratum v1.
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  []any           `json:"error"`
+}
+
+// Job is a block header to search nonces for. A share is a nonce whose hash meets
+// ShareDifficulty; a share that also meets Difficulty completes the block.
+type Job struct {
+	ID              string   `json:"job_id"`
+	Height          int      `json:"height"`
+	Version         uint32   `json:"version"`
+	PrevHash        d.Hash32 `json:"prev_hash"`
+	MerkleRoot      d.Hash32 `json:"merkle_root"`
+	Timestamp       uint32   `json:"timestamp"`
+	Difficulty      uint32   `json:"difficulty"`
+	ShareDifficulty uint32   `json:"share_difficulty"`
+	// Clean is set when the job builds on a new tip and earlier jobs became stale.
+	Clean bool `json:"clean"`
+}
+
+// Header returns the job's block header with the given nonce.
+func (j Job) Header(nonce uint32) d.Header {
+	return *d.NewHeader(j.Version, j.Timestamp, j.PrevHash, j.MerkleRoot, j.Difficulty, nonce)
+}
+
+// errorFor encodes err as a Stratum error triple.
+func errorFor(err error) []any {
+	for known, code := range errorCodes {
+		if errors.Is(err, known) {
+			return []any{code, err.Error(), nil}
+		}
+	}
+	return []any{20, err.Error(), nil}
+}
+
+// responseError turns the error triple of a response back into an error.
+func responseError(triple []any) error {
+	if triple == nil {
+		return nil
+	}
+	if len(triple) >= 2 {
+		if code, ok := triple[0].(float64); ok {
+			for known, c := range errorCodes {
+				if c == int(code) {
+					return known
+				}
+			}
+		}
+		return fmt.Errorf("stratum: %v", triple[1])
+	}
+	return fmt.Errorf("stratum: %v", triple)
+}
+
+// codec reads and writes newline separated JSON messages.
+type codec struct {
+	scanner *bufio.Scanner
+	w       io.Writer
+}
+
+func newCodec(rw io.ReadWriter) *codec {
+	scanner := bufio.NewScanner(rw)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	return &codec{scanner: scanner, w: rw}
+}
+
+// read returns the next line. Blank lines are skipped.
+func (c *codec) read() ([]byte, error) {
+	for c.scanner.Scan() {
+		if line := c.scanner.Bytes(); len(line) > 0 {
+			return line, nil
+		}
+	}
+	if err := c.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// write sends v as one line. Callers serialize writes.
+func (c *codec) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,481 @@
+package stratum
+
+import (
+	"context"
+	"log/slog"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/Quikmove/blockchain-uzd2/internal/pool"
+)
+
+// maxJobsPerSession bounds the jobs a session can still submit shares for at the same tip.
+const maxJobsPerSession = 8
+
+type Config struct {
+	// ListenAddr is the TCP address to accept miners on, e.g. ":3333" or "127.0.0.1:0".
+	ListenAddr string
+	// Payout receives the block reward of every block the miners find, unless Pool is set.
+	Payout d.PublicAddress
+	// Pool, when set, records every accepted share and splits the reward in the coinbase
+	// of each job as the pool's scheme pays at the time the job is issued. A worker
+	// named "<address>" or "<address>.<rig>" is paid to that hex address, any other
+	// worker's shares to Payout.
+	Pool       *pool.Pool
+	Version    uint32
+	Difficulty uint32
+	// ShareDifficulty is the target a nonce has to meet to count as a share. It is
+	// lowered to Difficulty when higher, so that every block is also a share.
+	ShareDifficulty uint32
+	// MaxBlockTxs is how many mempool transactions a block template includes.
+	MaxBlockTxs int
+	// PollInterval is how often the server checks whether JobInterval passed. New tips
+	// are picked up as soon as the chain connects them.
+	PollInterval time.Duration
+	// JobInterval is how often a new template picks up mempool transactions at the same tip.
+	JobInterval time.Duration
+	// HashrateWindow is the period over which accepted shares estimate a worker's hashrate.
+	HashrateWindow time.Duration
+	// WriteTimeout is how long a miner may take to read a message before it is
+	// disconnected. Zero means DefaultConfig's.
+	WriteTimeout time.Duration
+	// Logger receives connections, found blocks and errors. Nil means slog.Default().
+	Logger *slog.Logger
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:      ":3333",
+		Version:         1,
+		Difficulty:      3,
+		ShareDifficulty: 2,
+		MaxBlockTxs:     100,
+		PollInterval:    250 * time.Millisecond,
+		JobInterval:     30 * time.Second,
+		HashrateWindow:  5 * time.Minute,
+		WriteTimeout:    10 * time.Second,
+	}
+}
+
+// WorkerStats is a snapshot of one worker name's shares.
+type WorkerStats struct {
+	Name string
+	Addr string
+	// Connections counts the sessions currently authorized as this worker.
+	Connections int
+	Accepted    int
+	// Rejected counts stale, duplicate and low difficulty shares.
+	Rejected int
+	Stale    int
+	Blocks   int
+	// Hashrate estimates hashes per second from the work of the shares accepted
+	// during the last HashrateWindow.
+	Hashrate  float64
+	LastShare time.Time
+}
+
+type share struct {
+	at   time.Time
+	work float64
+}
+
+type worker struct {
+	stats    WorkerStats
+	address  d.PublicAddress
+	since    time.Time
+	shares   []share
+	sessions int
+}
+
+// hashrate sums the work of the shares within window before now and divides it by the
+// window, or by the time since the worker was first authorized when that is shorter.
+func (w *worker) hashrate(now time.Time, window time.Duration) float64 {
+	cutoff := now.Add(-window)
+	kept := w.shares[:0]
+	var work float64
+	for _, s := range w.shares {
+		if s.at.After(cutoff) {
+			kept = append(kept, s)
+			work += s.work
+		}
+	}
+	w.shares = kept
+	span := window
+	if elapsed := now.Sub(w.since); elapsed < span {
+		span = elapsed
+	}
+	if span <= 0 {
+		return 0
+	}
+	return work / span.Seconds()
+}
+
+// job is a Job together with the block it completes and the pool payouts of its coinbase.
+type job struct {
+	Job
+	block    d.Block
+	snapshot pool.Snapshot
+	shares   map[uint32]bool
+}
+
+// Server hands out jobs built from one Blockchain and assembles the blocks its miners find.
+type Server struct {
+	cfg      Config
+	bch      *blockchain.Blockchain
+	hasher   c.Hasher
+	listener net.Listener
+
+	// renewMutex serializes template renewals with connecting found blocks, so that no
+	// template is built on a new tip before the pool closed the round of its block.
+	renewMutex  *sync.Mutex
+	mutex       *sync.Mutex
+	template    *d.Block
+	snapshot    pool.Snapshot
+	height      int
+	templateAt  time.Time
+	tip         d.Hash32
+	extraNonce  uint32
+	nextJob     uint64
+	nextSession uint64
+	sessions    map[*session]struct{}
+	workers     map[string]*worker
+
+	wg *sync.WaitGroup
+}
+
+func NewServer(bch *blockchain.Blockchain, hasher c.Hasher, cfg Config) *Server {
+	if cfg.ShareDifficulty > cfg.Difficulty {
+		cfg.ShareDifficulty = cfg.Difficulty
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = DefaultConfig().WriteTimeout
+	}
+	return &Server{
+		cfg:        cfg,
+		bch:        bch,
+		hasher:     hasher,
+		renewMutex: &sync.Mutex{},
+		mutex:      &sync.Mutex{},
+		sessions:   make(map[*session]struct{}),
+		workers:    make(map[string]*worker),
+		wg:         &sync.WaitGroup{},
+	}
+}
+
+// Start begins accepting miners and keeps their jobs on the current tip until ctx is cancelled.
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.wg.Add(2)
+	go s.acceptLoop()
+	go s.pollLoop(ctx)
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+		s.mutex.Lock()
+		for sess := range s.sessions {
+			_ = sess.conn.Close()
+		}
+		s.mutex.Unlock()
+	}()
+	return nil
+}
+
+// Wait blocks until the server's background loops exit after its context is cancelled.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// Addr returns the address the server accepts miners on.
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.cfg.ListenAddr
+	}
+	return s.listener.Addr().String()
+}
+
+// Workers returns the share statistics of every worker name seen, sorted by name.
+func (s *Server) Workers() []WorkerStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	stats := make([]WorkerStats, 0, len(s.workers))
+	for _, w := range s.workers {
+		st := w.stats
+		st.Connections = w.sessions
+		st.Hashrate = w.hashrate(now, s.cfg.HashrateWindow)
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.nextSession++
+		sess := newSession(s, conn, strconv.FormatUint(s.nextSession, 16))
+		s.sessions[sess] = struct{}{}
+		s.mutex.Unlock()
+		s.cfg.Logger.Info("stratum: miner connected", "remote", conn.RemoteAddr().String(), "session", sess.id)
+		go sess.writeLoop()
+		go func() {
+			sess.readLoop()
+			close(sess.done)
+			s.removeSession(sess)
+			s.cfg.Logger.Info("stratum: miner disconnected", "remote", conn.RemoteAddr().String(), "session", sess.id)
+		}()
+	}
+}
+
+func (s *Server) removeSession(sess *session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, sess)
+	for name := range sess.workers {
+		s.workers[name].sessions--
+	}
+}
+
+// pollLoop renews the template when the chain connects a block or JobInterval passes.
+func (s *Server) pollLoop(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+	sub := s.bch.Subscribe(16, blockchain.EventBlockConnected)
+	defer func() { sub.Unsubscribe() }()
+	for {
+		tipHash, err := s.bch.GetLatestBlockHash()
+		if err == nil {
+			s.mutex.Lock()
+			newTip := tipHash != s.tip
+			expired := time.Since(s.templateAt) >= s.cfg.JobInterval
+			s.mutex.Unlock()
+			if newTip || expired {
+				s.renewTemplate(newTip)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-sub.Events():
+			if !ok {
+				// Fell behind; the tip is compared above anyway.
+				sub = s.bch.Subscribe(16, blockchain.EventBlockConnected)
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// renewTemplate builds a template on the current tip and sends every authorized
+// session a job for it. With clean set the earlier jobs are dropped.
+func (s *Server) renewTemplate(clean bool) {
+	s.renewMutex.Lock()
+	defer s.renewMutex.Unlock()
+	s.renewTemplateLocked(clean)
+}
+
+// renewTemplateLocked is renewTemplate for callers holding renewMutex.
+func (s *Server) renewTemplateLocked(clean bool) {
+	height := s.bch.Len()
+	snapshot := pool.Snapshot{Payouts: []d.TxOutput{{To: s.cfg.Payout, Value: s.bch.BlockReward()}}}
+	if s.cfg.Pool != nil {
+		work, _ := new(big.Float).SetInt(blockchain.NewPoW(s.cfg.Version, s.hasher).Work(s.cfg.Difficulty)).Float64()
+		snapshot = s.cfg.Pool.Snapshot(s.bch.BlockReward(), work)
+	}
+	template, err := s.bch.BlockTemplate(snapshot.Payouts, s.cfg.MaxBlockTxs, s.cfg.Version, s.cfg.Difficulty)
+	if err != nil {
+		s.cfg.Logger.Error("stratum: could not build a block template", "err", err)
+		return
+	}
+
+	s.mutex.Lock()
+	s.template = &template
+	s.snapshot = snapshot
+	s.height = height
+	s.templateAt = time.Now()
+	s.tip = template.Header.PrevHash
+	notify := make(map[*session]Job)
+	for sess := range s.sessions {
+		if len(sess.workers) > 0 {
+			notify[sess] = s.newJobLocked(sess, clean)
+		}
+	}
+	s.mutex.Unlock()
+
+	for sess, j := range notify {
+		sess.notify(MethodNotify, j)
+	}
+}
+
+// newJobLocked derives a job for sess from the current template with an extra nonce
+// no other job used, so that no two miners ever search the same header.
+func (s *Server) newJobLocked(sess *session, clean bool) Job {
+	extraNonce := s.extraNonce
+	s.extraNonce++
+	s.nextJob++
+
+	txs := append([]d.Transaction(nil), s.template.Body.Transactions...)
+	coinbase := txs[0]
+	coinbase.Inputs = append([]d.TxInput(nil), coinbase.Inputs...)
+	coinbase.SetExtraNonce(extraNonce)
+	coinbase.TxID = s.bch.HashTransaction(coinbase)
+	txs[0] = coinbase
+	block := d.Block{Header: s.template.Header, Body: *d.NewBody(txs)}
+	block.Header.MerkleRoot = blockchain.MerkleRootHash(block.Body, s.hasher)
+
+	j := &job{
+		Job: Job{
+			ID:              strconv.FormatUint(s.nextJob, 16),
+			Height:          s.height,
+			Version:         block.Header.Version,
+			PrevHash:        block.Header.PrevHash,
+			MerkleRoot:      block.Header.MerkleRoot,
+			Timestamp:       block.Header.Timestamp,
+			Difficulty:      block.Header.Difficulty,
+			ShareDifficulty: s.cfg.ShareDifficulty,
+			Clean:           clean,
+		},
+		block:    block,
+		snapshot: s.snapshot,
+		shares:   make(map[uint32]bool),
+	}
+	if clean {
+		sess.jobs = make(map[string]*job)
+		sess.jobOrder = nil
+	}
+	sess.jobs[j.ID] = j
+	sess.jobOrder = append(sess.jobOrder, j.ID)
+	if len(sess.jobOrder) > maxJobsPerSession {
+		delete(sess.jobs, sess.jobOrder[0])
+		sess.jobOrder = sess.jobOrder[1:]
+	}
+	return j.Job
+}
+
+// authorize registers name on sess and returns the share difficulty and a first
+// job, if the chain has a tip to build on yet.
+func (s *Server) authorize(sess *session, name string) (uint32, *Job) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := sess.workers[name]; !ok {
+		w, exists := s.workers[name]
+		if !exists {
+			w = &worker{stats: WorkerStats{Name: name}, address: s.cfg.Payout, since: time.Now()}
+			if prefix, _, _ := strings.Cut(name, "."); prefix != "" {
+				if address, err := d.ParsePublicAddress(prefix); err == nil {
+					w.address = address
+				}
+			}
+			s.workers[name] = w
+		}
+		w.stats.Addr = sess.conn.RemoteAddr().String()
+		w.sessions++
+		sess.workers[name] = w
+	}
+	if s.template == nil {
+		return s.cfg.ShareDifficulty, nil
+	}
+	j := s.newJobLocked(sess, true)
+	return s.cfg.ShareDifficulty, &j
+}
+
+// submit checks a share and connects the block when the share also meets the block difficulty.
+func (s *Server) submit(sess *session, name, jobID string, nonce uint32) error {
+	s.mutex.Lock()
+	w, ok := sess.workers[name]
+	if !ok {
+		s.mutex.Unlock()
+		return ErrUnauthorized
+	}
+	j, ok := sess.jobs[jobID]
+	if !ok {
+		w.stats.Rejected++
+		w.stats.Stale++
+		s.mutex.Unlock()
+		return ErrStaleJob
+	}
+	if j.shares[nonce] {
+		w.stats.Rejected++
+		s.mutex.Unlock()
+		return ErrDuplicateShare
+	}
+	s.mutex.Unlock()
+
+	// The proof-of-work hash can be slow, argon2id in particular, so it is computed
+	// without holding the mutex every miner needs.
+	header := j.Header(nonce)
+	pow := blockchain.NewPoW(header.Version, s.hasher)
+	hash := pow.Hash(&header)
+
+	s.mutex.Lock()
+	if sess.jobs[jobID] != j {
+		w.stats.Rejected++
+		w.stats.Stale++
+		s.mutex.Unlock()
+		return ErrStaleJob
+	}
+	if j.shares[nonce] {
+		w.stats.Rejected++
+		s.mutex.Unlock()
+		return ErrDuplicateShare
+	}
+	if !blockchain.IsHashValid(hash, j.ShareDifficulty) {
+		w.stats.Rejected++
+		s.mutex.Unlock()
+		return ErrLowDifficulty
+	}
+	now := time.Now()
+	j.shares[nonce] = true
+	w.stats.Accepted++
+	w.stats.LastShare = now
+	work, _ := new(big.Float).SetInt(pow.Work(j.ShareDifficulty)).Float64()
+	w.shares = append(w.shares, share{at: now, work: work})
+	if s.cfg.Pool != nil {
+		s.cfg.Pool.AddShare(pool.Share{Worker: name, Address: w.address, Work: work, At: now})
+	}
+	isBlock := blockchain.IsHashValid(hash, header.Difficulty)
+	block := j.block
+	s.mutex.Unlock()
+
+	if !isBlock {
+		return nil
+	}
+	block.Header = header
+	s.renewMutex.Lock()
+	defer s.renewMutex.Unlock()
+	if err := s.bch.AddBlock(block); err != nil {
+		s.cfg.Logger.Warn("stratum: block rejected", "worker", name, "height", j.Height, "nonce", nonce, "err", err)
+		return nil
+	}
+	s.mutex.Lock()
+	w.stats.Blocks++
+	s.mutex.Unlock()
+	if s.cfg.Pool != nil {
+		s.cfg.Pool.BlockFound(j.Height, j.snapshot)
+	}
+	s.cfg.Logger.Info("stratum: block found", "worker", name, "height", j.Height, "nonce", nonce, "txs", len(block.Body.Transactions))
+	s.renewTemplateLocked(true)
+	return nil
+}
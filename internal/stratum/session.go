@@ -0,0 +1,162 @@
+package stratum
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+// notificationQueue bounds the notifications waiting to be written to one miner. A
+// miner that lets it fill up is not reading and is disconnected.
+const notificationQueue = 16
+
+// session is one miner connection. Its workers and jobs are guarded by the server mutex.
+type session struct {
+	server     *Server
+	conn       net.Conn
+	codec      *codec
+	id         string
+	writeMutex *sync.Mutex
+	// notifications are written by writeLoop, so that no one queuing them waits for
+	// the miner to read. done is closed when the session ends.
+	notifications chan Request
+	done          chan struct{}
+
+	subscribed bool
+	workers    map[string]*worker
+	jobs       map[string]*job
+	jobOrder   []string
+}
+
+func newSession(server *Server, conn net.Conn, id string) *session {
+	return &session{
+		server:     server,
+		conn:       conn,
+		codec:      newCodec(conn),
+		id:         id,
+		writeMutex: &sync.Mutex{},
+		workers:    make(map[string]*worker),
+		jobs:       make(map[string]*job),
+
+		notifications: make(chan Request, notificationQueue),
+		done:          make(chan struct{}),
+	}
+}
+
+// send writes v to the miner, closing the connection when the miner does not take it
+// within WriteTimeout.
+func (sess *session) send(v any) {
+	sess.writeMutex.Lock()
+	defer sess.writeMutex.Unlock()
+	_ = sess.conn.SetWriteDeadline(time.Now().Add(sess.server.cfg.WriteTimeout))
+	if err := sess.codec.write(v); err != nil {
+		_ = sess.conn.Close()
+	}
+}
+
+// notify queues a notification for writeLoop without waiting for the miner. A miner
+// whose queue is full is disconnected.
+func (sess *session) notify(method string, params ...any) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	select {
+	case sess.notifications <- Request{Method: method, Params: raw}:
+	case <-sess.done:
+	default:
+		sess.server.cfg.Logger.Warn("stratum: miner is not reading its jobs", "remote", sess.conn.RemoteAddr().String(), "session", sess.id)
+		_ = sess.conn.Close()
+	}
+}
+
+// writeLoop writes the queued notifications until the session ends.
+func (sess *session) writeLoop() {
+	for {
+		select {
+		case req := <-sess.notifications:
+			sess.send(req)
+		case <-sess.done:
+			return
+		}
+	}
+}
+
+func (sess *session) reply(id uint64, result any, err error) {
+	resp := Response{ID: id}
+	if err != nil {
+		resp.Error = errorFor(err)
+	} else if raw, merr := json.Marshal(result); merr == nil {
+		resp.Result = raw
+	}
+	sess.send(resp)
+}
+
+func (sess *session) readLoop() {
+	defer sess.conn.Close()
+	for {
+		line, err := sess.codec.read()
+		if err != nil {
+			return
+		}
+		var req Request
+		if err := json.Unmarshal(line, &req); err != nil {
+			sess.server.cfg.Logger.Warn("stratum: malformed message", "remote", sess.conn.RemoteAddr().String(), "session", sess.id, "err", err)
+			return
+		}
+		if req.ID == nil {
+			continue
+		}
+		sess.handle(*req.ID, req.Method, req.Params)
+	}
+}
+
+func (sess *session) handle(id uint64, method string, params json.RawMessage) {
+	s := sess.server
+	switch method {
+	case MethodSubscribe:
+		s.mutex.Lock()
+		sess.subscribed = true
+		s.mutex.Unlock()
+		sess.reply(id, []string{sess.id}, nil)
+
+	case MethodAuthorize:
+		var args []string
+		if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 || args[0] == "" {
+			sess.reply(id, nil, ErrBadParams)
+			return
+		}
+		s.mutex.Lock()
+		subscribed := sess.subscribed
+		s.mutex.Unlock()
+		if !subscribed {
+			sess.reply(id, nil, ErrNotSubscribed)
+			return
+		}
+		shareDifficulty, j := s.authorize(sess, args[0])
+		sess.reply(id, true, nil)
+		sess.notify(MethodSetDifficulty, shareDifficulty)
+		if j != nil {
+			sess.notify(MethodNotify, *j)
+		}
+
+	case MethodSubmit:
+		var args []json.RawMessage
+		var name, jobID string
+		var nonce uint32
+		if err := json.Unmarshal(params, &args); err != nil || len(args) != 3 ||
+			json.Unmarshal(args[0], &name) != nil || json.Unmarshal(args[1], &jobID) != nil || json.Unmarshal(args[2], &nonce) != nil {
+			sess.reply(id, nil, ErrBadParams)
+			return
+		}
+		if err := s.submit(sess, name, jobID, nonce); err != nil {
+			sess.reply(id, nil, err)
+			return
+		}
+		sess.reply(id, true, nil)
+
+	default:
+		sess.reply(id, nil, ErrUnknownMethod)
+	}
+}
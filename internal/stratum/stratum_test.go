@@ -0,0 +1,302 @@
+package stratum
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Quikmove/blockchain-uzd2/internal/blockchain"
+	"github.com/Quikmove/blockchain-uzd2/internal/config"
+	c "github.com/Quikmove/blockchain-uzd2/internal/crypto"
+	d "github.com/Quikmove/blockchain-uzd2/internal/domain"
+	"github.com/Quikmove/blockchain-uzd2/internal/pool"
+)
+
+func testServer(t *testing.T, ctx context.Context, difficulty, shareDifficulty uint32, p *pool.Pool) (*Server, *blockchain.Blockchain) {
+	t.Helper()
+	hasher := c.NewArchasHasher()
+	users := blockchain.NewUserGeneratorService(c.NewKeyGenerator(), blockchain.WithSeed(1)).GenerateUsers([]string{"Alice", "Bob"}, 2)
+	cfg := &config.Config{Version: 1, Difficulty: 1}
+	bch := blockchain.InitBlockchainWithFunds(1000, 1000, users, cfg, hasher, c.NewTransactionSigner(), blockchain.WithSeed(1))
+
+	serverCfg := DefaultConfig()
+	serverCfg.ListenAddr = "127.0.0.1:0"
+	serverCfg.Payout = users[0].PublicAddress
+	serverCfg.Difficulty = difficulty
+	serverCfg.ShareDifficulty = shareDifficulty
+	serverCfg.PollInterval = 20 * time.Millisecond
+	serverCfg.Pool = p
+	server := NewServer(bch, hasher, serverCfg)
+	if err := server.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	return server, bch
+}
+
+func TestServer_MinerFindsBlocks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server, bch := testServer(t, ctx, 2, 1, nil)
+	start := bch.Len()
+
+	miner := NewMiner(server.Addr(), "rig1", c.NewArchasHasher(), 2)
+	done := make(chan error, 1)
+	go func() { done <- miner.Run(ctx) }()
+
+	for bch.Len() < start+2 {
+		if ctx.Err() != nil {
+			t.Fatal("Timed out waiting for the miner to find two blocks")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	tip, _ := bch.GetLatestBlock()
+	if got := tip.Body.Transactions[0].Outputs[0]; got.To != server.cfg.Payout || got.Value != bch.BlockReward() {
+		t.Errorf("Coinbase output = %+v, want %d to the payout address", got, bch.BlockReward())
+	}
+	workers := server.Workers()
+	if len(workers) != 1 || workers[0].Name != "rig1" {
+		t.Fatalf("Workers() = %+v, want rig1 only", workers)
+	}
+	w := workers[0]
+	if w.Accepted == 0 || w.Blocks < 2 || w.Hashrate <= 0 {
+		t.Errorf("Workers()[0] = %+v, want accepted shares, 2 blocks and a hashrate", w)
+	}
+	if st := miner.Stats(); st.Jobs < 2 || st.Accepted == 0 || st.Hashes == 0 {
+		t.Errorf("Miner.Stats() = %+v", st)
+	}
+}
+
+func TestServer_PoolSplitsReward(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	poolCfg := pool.DefaultConfig()
+	poolCfg.Scheme = pool.Proportional
+	poolCfg.Operator = d.PublicAddress{0xee}
+	p, err := pool.New(poolCfg)
+	if err != nil {
+		t.Fatalf("pool.New() error = %v", err)
+	}
+	server, bch := testServer(t, ctx, 2, 1, p)
+	start := bch.Len()
+
+	rigs := []d.PublicAddress{{0xaa}, {0xbb}}
+	done := make(chan error, len(rigs))
+	for _, address := range rigs {
+		miner := NewMiner(server.Addr(), hex.EncodeToString(address[:])+".rig", c.NewArchasHasher(), 1)
+		go func() { done <- miner.Run(ctx) }()
+	}
+	// A rig that connects late may miss the first rounds; wait until both were paid.
+	for len(p.Rounds()) < 3 || p.Paid()[rigs[0]] == 0 || p.Paid()[rigs[1]] == 0 {
+		if ctx.Err() != nil {
+			t.Fatal("Timed out waiting for the pool to pay both rigs in three blocks")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	for range rigs {
+		<-done
+	}
+
+	// Every round but the first pays the shares of the round before, which both rigs submit.
+	paid := make(map[d.PublicAddress]uint32)
+	for _, round := range p.Rounds() {
+		block, err := bch.GetBlock(round.Height)
+		if err != nil || round.Height < start {
+			t.Fatalf("GetBlock(%d) error = %v", round.Height, err)
+		}
+		coinbase := block.Body.Transactions[0]
+		var total uint32
+		for i, out := range coinbase.Outputs {
+			if out != round.Payouts[i] {
+				t.Errorf("Block %d coinbase output %d = %+v, want %+v", round.Height, i, out, round.Payouts[i])
+			}
+			total += out.Value
+			paid[out.To] += out.Value
+		}
+		if total != bch.BlockReward() {
+			t.Errorf("Block %d coinbase pays %d, want %d", round.Height, total, bch.BlockReward())
+		}
+	}
+	for _, address := range rigs {
+		if paid[address] == 0 || uint64(paid[address]) != p.Paid()[address] {
+			t.Errorf("Rig %x paid %d, pool accounted %d", address[:1], paid[address], p.Paid()[address])
+		}
+	}
+}
+
+// rawClient speaks the protocol by hand.
+type rawClient struct {
+	t      *testing.T
+	codec  *codec
+	nextID uint64
+}
+
+func dialRaw(t *testing.T, addr string) *rawClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &rawClient{t: t, codec: newCodec(conn)}
+}
+
+// call sends a request and returns its response, collecting notifications on the way.
+func (rc *rawClient) call(method string, params ...any) (Response, []Request) {
+	rc.t.Helper()
+	rc.nextID++
+	id := rc.nextID
+	raw, _ := json.Marshal(params)
+	if err := rc.codec.write(Request{ID: &id, Method: method, Params: raw}); err != nil {
+		rc.t.Fatalf("write error = %v", err)
+	}
+	var notifications []Request
+	for {
+		line, err := rc.codec.read()
+		if err != nil {
+			rc.t.Fatalf("read error = %v", err)
+		}
+		var req Request
+		if json.Unmarshal(line, &req) == nil && req.Method != "" {
+			notifications = append(notifications, req)
+			continue
+		}
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil || resp.ID != id {
+			rc.t.Fatalf("unexpected message %s", line)
+		}
+		return resp, notifications
+	}
+}
+
+func (rc *rawClient) readJob() Job {
+	rc.t.Helper()
+	for {
+		line, err := rc.codec.read()
+		if err != nil {
+			rc.t.Fatalf("read error = %v", err)
+		}
+		var req Request
+		if json.Unmarshal(line, &req) == nil && req.Method == MethodNotify {
+			var params []Job
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				rc.t.Fatalf("malformed job: %v", err)
+			}
+			return params[0]
+		}
+	}
+}
+
+func TestServer_RejectsBadShares(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, _ := testServer(t, ctx, 8, 1, nil)
+	rc := dialRaw(t, server.Addr())
+
+	if resp, _ := rc.call(MethodAuthorize, "rig1"); responseError(resp.Error) != ErrNotSubscribed {
+		t.Errorf("authorize before subscribe error = %v, want %v", resp.Error, ErrNotSubscribed)
+	}
+	if resp, _ := rc.call(MethodSubscribe, "test"); resp.Error != nil {
+		t.Fatalf("subscribe error = %v", resp.Error)
+	}
+	if resp, _ := rc.call(MethodAuthorize, "rig1"); resp.Error != nil {
+		t.Fatalf("authorize error = %v", resp.Error)
+	}
+	j := rc.readJob()
+	if j.ShareDifficulty != 1 || j.Difficulty != 8 {
+		t.Fatalf("job = %+v, want share difficulty 1 and difficulty 8", j)
+	}
+
+	pow := blockchain.NewPoW(j.Version, c.NewArchasHasher())
+	var good, bad uint32
+	foundGood, foundBad := false, false
+	for nonce := uint32(0); !foundGood || !foundBad; nonce++ {
+		header := j.Header(nonce)
+		hash := pow.Hash(&header)
+		if blockchain.IsHashValid(hash, j.ShareDifficulty) {
+			if !foundGood {
+				good, foundGood = nonce, true
+			}
+		} else if !foundBad {
+			bad, foundBad = nonce, true
+		}
+	}
+
+	tests := []struct {
+		name    string
+		worker  string
+		jobID   string
+		nonce   uint32
+		wantErr error
+	}{
+		{"share", "rig1", j.ID, good, nil},
+		{"duplicate", "rig1", j.ID, good, ErrDuplicateShare},
+		{"low difficulty", "rig1", j.ID, bad, ErrLowDifficulty},
+		{"stale job", "rig1", "ffff", good, ErrStaleJob},
+		{"unauthorized", "rig2", j.ID, good, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		resp, _ := rc.call(MethodSubmit, tt.worker, tt.jobID, tt.nonce)
+		if err := responseError(resp.Error); err != tt.wantErr {
+			t.Errorf("%s: submit error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if resp, _ := rc.call("mining.unknown"); responseError(resp.Error) == nil {
+		t.Error("Unknown method accepted")
+	}
+
+	w := server.Workers()[0]
+	if w.Accepted != 1 || w.Rejected != 3 || w.Stale != 1 || w.Blocks != 0 {
+		t.Errorf("Workers()[0] = %+v, want 1 accepted, 3 rejected, 1 stale", w)
+	}
+}
+
+func TestSession_NotifyDoesNotWaitForMiner(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WriteTimeout = 50 * time.Millisecond
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := NewServer(nil, c.NewArchasHasher(), cfg)
+	conn, miner := net.Pipe()
+	defer miner.Close()
+	sess := newSession(server, conn, "1")
+	go sess.writeLoop()
+	defer close(sess.done)
+
+	// The miner never reads, so the queue fills up behind the first notification.
+	queued := make(chan struct{})
+	go func() {
+		for range 2 * notificationQueue {
+			sess.notify(MethodNotify, Job{ID: "1"})
+		}
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatal("notify waited for a miner that does not read")
+	}
+
+	// The miner is disconnected.
+	_ = miner.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	for {
+		_, err := miner.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("miner read error = %v, want %v", err, io.EOF)
+		}
+	}
+}